@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS ix_task_queued_at ON task(queued_at);
@@ -0,0 +1 @@
+ALTER TABLE task ADD COLUMN IF NOT EXISTS ara_estimate JSONB;
@@ -0,0 +1 @@
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS gpu_type varchar;
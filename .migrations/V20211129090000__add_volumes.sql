@@ -0,0 +1,3 @@
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS volumes JSONB;
+ALTER TABLE template ADD COLUMN IF NOT EXISTS volumes JSONB;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS volumes JSONB;
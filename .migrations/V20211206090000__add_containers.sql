@@ -0,0 +1,6 @@
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS init_containers JSONB;
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS sidecars JSONB;
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS use_image_entrypoint BOOLEAN;
+ALTER TABLE template ADD COLUMN IF NOT EXISTS init_containers JSONB;
+ALTER TABLE template ADD COLUMN IF NOT EXISTS sidecars JSONB;
+ALTER TABLE template ADD COLUMN IF NOT EXISTS use_image_entrypoint BOOLEAN;
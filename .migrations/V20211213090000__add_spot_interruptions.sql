@@ -0,0 +1 @@
+ALTER TABLE task ADD COLUMN IF NOT EXISTS spot_interruptions INTEGER;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS dispatch_state (
+  id integer PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+  paused boolean NOT NULL DEFAULT false,
+  reason character varying,
+  updated_by character varying,
+  updated_at timestamp with time zone
+);
+
+INSERT INTO dispatch_state (id, paused) VALUES (1, false) ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS run_idempotency_key (
+  executable_id varchar NOT NULL,
+  idempotency_key varchar NOT NULL,
+  run_id varchar NOT NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT now(),
+  PRIMARY KEY (executable_id, idempotency_key)
+);
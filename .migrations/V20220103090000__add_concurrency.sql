@@ -0,0 +1,2 @@
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS concurrency JSONB;
+ALTER TABLE template ADD COLUMN IF NOT EXISTS concurrency JSONB;
@@ -0,0 +1,12 @@
+ALTER TABLE task ADD COLUMN IF NOT EXISTS exit_category character varying;
+
+CREATE TABLE IF NOT EXISTS exit_rule (
+  rule_id character varying PRIMARY KEY,
+  pattern character varying,
+  exit_code integer,
+  category character varying NOT NULL,
+  reason character varying NOT NULL,
+  priority integer NOT NULL DEFAULT 0,
+  group_name character varying,
+  created_at timestamp with time zone
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS run_exception (
+  run_id character varying NOT NULL,
+  fingerprint character varying NOT NULL,
+  kind character varying NOT NULL,
+  exception_type character varying NOT NULL,
+  message text,
+  trace text,
+  created_at timestamp with time zone,
+  PRIMARY KEY (run_id, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS ix_run_exception_fingerprint ON run_exception(fingerprint, created_at);
+CREATE INDEX IF NOT EXISTS ix_run_exception_created_at ON run_exception(created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE task_def ADD COLUMN IF NOT EXISTS service_account character varying;
+ALTER TABLE template ADD COLUMN IF NOT EXISTS service_account character varying;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS service_account character varying;
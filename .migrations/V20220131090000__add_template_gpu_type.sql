@@ -0,0 +1 @@
+ALTER TABLE template ADD COLUMN IF NOT EXISTS gpu_type varchar;
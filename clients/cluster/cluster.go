@@ -19,15 +19,17 @@ type Client interface {
 	Initialize(conf config.Config) error
 	CanBeRun(clusterName string, executableResources state.ExecutableResources) (bool, error)
 	ListClusters() ([]string, error)
+	Route(run state.Run, gpu bool) string
+	Clusters() ([]Cluster, error)
 }
 
 //
-// NewClusterClient returns a cluster client
+// NewClusterClient returns a cluster client routing with the given registry
 //
-func NewClusterClient(conf config.Config, name string) (Client, error) {
+func NewClusterClient(conf config.Config, name string, registry *Registry) (Client, error) {
 	switch name {
 	case "eks":
-		eksc := &EKSClusterClient{}
+		eksc := &EKSClusterClient{registry: registry}
 		if err := eksc.Initialize(conf); err != nil {
 			return nil, errors.Wrap(err, "problem initializing EKSClusterClient")
 		}
@@ -9,13 +9,24 @@ import (
 // EKSClusterClient is the cluster client for EKS
 // [NOTE] This client assumes the EKS cluster is capable is running a mixed varieties of jobs.
 //
-type EKSClusterClient struct{}
+type EKSClusterClient struct {
+	registry *Registry
+}
 
 func (EKSClusterClient) Name() string {
 	return ""
 }
 
-func (EKSClusterClient) Initialize(conf config.Config) error {
+// Initialize reads the registry from conf unless the client was given one.
+func (ecc *EKSClusterClient) Initialize(conf config.Config) error {
+	if ecc.registry != nil {
+		return nil
+	}
+	registry, err := NewRegistry(conf)
+	if err != nil {
+		return err
+	}
+	ecc.registry = registry
 	return nil
 }
 
@@ -24,7 +35,17 @@ func (EKSClusterClient) CanBeRun(clusterName string, executableResources state.E
 	return true, nil
 }
 
-// Since it is a single cluster environment for EKS, slice of clusters is empty.
-func (EKSClusterClient) ListClusters() ([]string, error) {
-	return []string{}, nil
+// ListClusters returns the names of the clusters in the registry.
+func (ecc *EKSClusterClient) ListClusters() ([]string, error) {
+	return ecc.registry.Names(), nil
+}
+
+// Route picks the cluster a new run is submitted to.
+func (ecc *EKSClusterClient) Route(run state.Run, gpu bool) string {
+	return ecc.registry.Route(run, gpu)
+}
+
+// Clusters returns the registered clusters, their labels and health.
+func (ecc *EKSClusterClient) Clusters() ([]Cluster, error) {
+	return ecc.registry.Clusters(), nil
 }
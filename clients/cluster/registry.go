@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// Cluster is an execution cluster with the labels runs are routed by
+// (e.g. region, gpu, capacity) and its health as seen by this replica
+//
+type Cluster struct {
+	Name                string            `json:"name"`
+	Labels              map[string]string `json:"labels"`
+	Healthy             bool              `json:"healthy"`
+	ConsecutiveFailures int               `json:"consecutive_failures"`
+	LastError           string            `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time        `json:"last_failure_at,omitempty"`
+	LastSuccessAt       *time.Time        `json:"last_success_at,omitempty"`
+}
+
+//
+// RoutingRule sends runs matching all of its set conditions to clusters
+// carrying all of its labels; the first matching rule wins
+//
+type RoutingRule struct {
+	Gpu           *bool             `json:"gpu,omitempty"`
+	NodeLifecycle *string           `json:"node_lifecycle,omitempty"`
+	GroupName     *string           `json:"group_name,omitempty"`
+	Labels        map[string]string `json:"labels"`
+}
+
+func (r RoutingRule) matches(run state.Run, gpu bool) bool {
+	if r.Gpu != nil && *r.Gpu != gpu {
+		return false
+	}
+	if r.NodeLifecycle != nil && (run.NodeLifecycle == nil || *r.NodeLifecycle != *run.NodeLifecycle) {
+		return false
+	}
+	if r.GroupName != nil && *r.GroupName != run.GroupName {
+		return false
+	}
+	return true
+}
+
+//
+// Registry holds the clusters runs may be routed to, the routing rules and
+// per cluster health. A cluster is unhealthy after FailureThreshold
+// consecutive retryable submit errors and is tried again after Cooldown.
+// Health is kept in memory from the submits of one replica, replicas don't
+// share it; the API and the workers of a replica share it by being given
+// the same Registry.
+//
+type Registry struct {
+	mu               sync.RWMutex
+	order            []string
+	clusters         map[string]*Cluster
+	rules            []RoutingRule
+	override         string
+	gpuOverride      string
+	failureThreshold int
+	cooldown         time.Duration
+}
+
+//
+// NewRegistry reads clusters and their labels from `eks_cluster_registry`,
+// falling back to the names in `eks_clusters`, and routing rules from the
+// JSON list in `eks_cluster_routing_rules`
+//
+func NewRegistry(conf config.Config) (*Registry, error) {
+	r := &Registry{
+		clusters:         make(map[string]*Cluster),
+		failureThreshold: 3,
+		cooldown:         5 * time.Minute,
+		override:         firstString(conf, "eks_cluster_override"),
+		gpuOverride:      firstString(conf, "eks_gpu_cluster_override"),
+	}
+
+	if conf.IsSet("eks_cluster_registry") {
+		registered := conf.GetStringMapString("eks_cluster_registry")
+		// Sorted, so that every process routes to the same default cluster.
+		var names []string
+		for name := range registered {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			labels := make(map[string]string)
+			if raw := registered[name]; len(raw) > 0 {
+				if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+					return r, errors.Wrapf(err, "invalid labels for cluster [%s]", name)
+				}
+			}
+			r.add(name, labels)
+		}
+	} else {
+		for _, name := range strings.Split(conf.GetString("eks_clusters"), ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				r.add(name, map[string]string{})
+			}
+		}
+	}
+	for _, name := range []string{r.override, r.gpuOverride} {
+		if _, ok := r.clusters[name]; len(name) > 0 && !ok {
+			r.add(name, map[string]string{})
+		}
+	}
+
+	if conf.IsSet("eks_cluster_routing_rules") {
+		if err := json.Unmarshal([]byte(conf.GetString("eks_cluster_routing_rules")), &r.rules); err != nil {
+			return r, errors.Wrap(err, "invalid eks_cluster_routing_rules")
+		}
+	}
+	if conf.IsSet("eks_cluster_failure_threshold") {
+		r.failureThreshold = conf.GetInt("eks_cluster_failure_threshold")
+	}
+	if conf.IsSet("eks_cluster_cooldown") {
+		cooldown, err := time.ParseDuration(conf.GetString("eks_cluster_cooldown"))
+		if err != nil {
+			return r, errors.Wrap(err, "invalid eks_cluster_cooldown")
+		}
+		r.cooldown = cooldown
+	}
+	return r, nil
+}
+
+func (r *Registry) add(name string, labels map[string]string) {
+	r.clusters[name] = &Cluster{Name: name, Labels: labels, Healthy: true}
+	r.order = append(r.order, name)
+}
+
+// Names returns the names of the registered clusters.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string{}, r.order...)
+}
+
+// Clusters returns a snapshot of the registered clusters and their health.
+func (r *Registry) Clusters() []Cluster {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var clusters []Cluster
+	for _, name := range r.order {
+		c := *r.clusters[name]
+		c.Healthy = r.healthy(r.clusters[name])
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// Route returns the cluster a run should be submitted to, or an empty
+// string when no cluster is registered.
+func (r *Registry) Route(run state.Run, gpu bool) string {
+	candidates := r.Candidates(run, gpu)
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+// Failover returns the next healthy candidate for a run other than its
+// current cluster.
+func (r *Registry) Failover(run state.Run, gpu bool) (string, bool) {
+	for _, name := range r.Candidates(run, gpu) {
+		if name != run.ClusterName && r.IsHealthy(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+//
+// Candidates orders the clusters a run may use: clusters carrying the labels
+// of the first matching rule (all clusters if none match), the legacy
+// override first, healthy clusters before unhealthy ones
+//
+func (r *Registry) Candidates(run state.Run, gpu bool) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var selector map[string]string
+	for _, rule := range r.rules {
+		if rule.matches(run, gpu) {
+			selector = rule.Labels
+			break
+		}
+	}
+
+	preferred := r.override
+	if gpu && len(r.gpuOverride) > 0 {
+		preferred = r.gpuOverride
+	}
+
+	var healthy, unhealthy []string
+	for _, name := range r.order {
+		c := r.clusters[name]
+		if !hasLabels(c, selector) {
+			continue
+		}
+		if !r.healthy(c) {
+			unhealthy = append(unhealthy, name)
+		} else if name == preferred {
+			healthy = append([]string{name}, healthy...)
+		} else {
+			healthy = append(healthy, name)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// IsHealthy is true for unknown clusters, so that runs routed before a
+// cluster left the registry still fail loudly rather than bounce.
+func (r *Registry) IsHealthy(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.clusters[name]
+	return !ok || r.healthy(c)
+}
+
+// RecordSuccess resets a cluster's failure count.
+func (r *Registry) RecordSuccess(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.clusters[name]; ok {
+		now := time.Now()
+		c.ConsecutiveFailures = 0
+		c.LastSuccessAt = &now
+	}
+}
+
+// RecordFailure counts a retryable submit error against a cluster.
+func (r *Registry) RecordFailure(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.clusters[name]; ok {
+		now := time.Now()
+		c.ConsecutiveFailures++
+		c.LastFailureAt = &now
+		if err != nil {
+			c.LastError = err.Error()
+		}
+	}
+}
+
+func (r *Registry) healthy(c *Cluster) bool {
+	if c.ConsecutiveFailures < r.failureThreshold {
+		return true
+	}
+	return c.LastFailureAt != nil && time.Since(*c.LastFailureAt) > r.cooldown
+}
+
+// firstString reads keys that are set either as a string or as a list.
+func firstString(conf config.Config, key string) string {
+	if values := conf.GetStringSlice(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func hasLabels(c *Cluster, selector map[string]string) bool {
+	for k, v := range selector {
+		if c.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
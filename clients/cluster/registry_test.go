@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+func setUpRegistry(t *testing.T) *Registry {
+	os.Setenv("EKS_CLUSTER_REGISTRY", `{"cpu-a": "{\"pool\": \"cpu\"}", "cpu-b": "{\"pool\": \"cpu\"}", "gpu-a": "{\"pool\": \"gpu\"}"}`)
+	os.Setenv("EKS_CLUSTER_ROUTING_RULES", `[{"gpu": true, "labels": {"pool": "gpu"}}, {"labels": {"pool": "cpu"}}]`)
+	os.Setenv("EKS_CLUSTER_FAILURE_THRESHOLD", "2")
+	defer os.Unsetenv("EKS_CLUSTER_REGISTRY")
+	defer os.Unsetenv("EKS_CLUSTER_ROUTING_RULES")
+	defer os.Unsetenv("EKS_CLUSTER_FAILURE_THRESHOLD")
+	c, _ := config.NewConfig(nil)
+
+	r, err := NewRegistry(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return r
+}
+
+func TestRegistry_Route(t *testing.T) {
+	r := setUpRegistry(t)
+	if len(r.Names()) != 3 {
+		t.Fatalf("Expected 3 clusters but got %v", r.Names())
+	}
+
+	if name := r.Route(state.Run{}, true); name != "gpu-a" {
+		t.Errorf("Expected gpu run routed to gpu-a but got [%s]", name)
+	}
+	if name := r.Route(state.Run{}, false); name != "cpu-a" {
+		t.Errorf("Expected cpu run routed to the first cpu cluster, cpu-a, but got [%s]", name)
+	}
+	if names := r.Names(); names[0] != "cpu-a" || names[1] != "cpu-b" || names[2] != "gpu-a" {
+		t.Errorf("Expected the clusters in name order but got %v", names)
+	}
+}
+
+func TestRegistry_Failover(t *testing.T) {
+	r := setUpRegistry(t)
+	run := state.Run{ClusterName: r.Route(state.Run{}, false)}
+	other := "cpu-a"
+	if run.ClusterName == "cpu-a" {
+		other = "cpu-b"
+	}
+
+	r.RecordFailure(run.ClusterName, errors.New("connection refused"))
+	if !r.IsHealthy(run.ClusterName) {
+		t.Errorf("Expected [%s] to stay healthy below the failure threshold", run.ClusterName)
+	}
+	r.RecordFailure(run.ClusterName, errors.New("connection refused"))
+	if r.IsHealthy(run.ClusterName) {
+		t.Errorf("Expected [%s] to be unhealthy at the failure threshold", run.ClusterName)
+	}
+
+	next, ok := r.Failover(run, false)
+	if !ok || next != other {
+		t.Errorf("Expected failover to [%s] but got [%s]", other, next)
+	}
+	if name := r.Route(state.Run{}, false); name != other {
+		t.Errorf("Expected new runs routed to [%s] but got [%s]", other, name)
+	}
+	if _, ok := r.Failover(state.Run{ClusterName: "gpu-a"}, true); ok {
+		t.Errorf("Expected no failover for gpu runs with a single gpu cluster")
+	}
+
+	r.RecordSuccess(run.ClusterName)
+	if !r.IsHealthy(run.ClusterName) {
+		t.Errorf("Expected [%s] to be healthy after a successful submit", run.ClusterName)
+	}
+}
+
+func TestRegistry_Override(t *testing.T) {
+	os.Setenv("EKS_CLUSTERS", "clusta,clustb")
+	os.Setenv("EKS_CLUSTER_OVERRIDE", "clustb")
+	defer os.Unsetenv("EKS_CLUSTERS")
+	defer os.Unsetenv("EKS_CLUSTER_OVERRIDE")
+	c, _ := config.NewConfig(nil)
+
+	r, err := NewRegistry(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if name := r.Route(state.Run{}, false); name != "clustb" {
+		t.Errorf("Expected override cluster clustb but got [%s]", name)
+	}
+}
+
+func TestNewClusterClient_SharesRegistry(t *testing.T) {
+	r := setUpRegistry(t)
+	c, _ := config.NewConfig(nil)
+	client, err := NewClusterClient(c, "eks", r)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	r.RecordFailure("gpu-a", errors.New("throttled"))
+	r.RecordFailure("gpu-a", errors.New("throttled"))
+
+	clusters, _ := client.Clusters()
+	for _, cl := range clusters {
+		if cl.Name == "gpu-a" && cl.Healthy {
+			t.Errorf("Expected the client to see the health recorded on its registry")
+		}
+	}
+	if len(clusters) != 3 {
+		t.Errorf("Expected the client to use the given registry, got %v", clusters)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/state"
@@ -28,6 +29,7 @@ type EKSCloudWatchLogsClient struct {
 	logNamespace       string
 	logsClient         logsClient
 	logger             *log.Logger
+	secrets            secrets.Client
 }
 
 type EKSCloudWatchLog struct {
@@ -81,6 +83,12 @@ func (lc *EKSCloudWatchLogsClient) Initialize(conf config.Config) error {
 	}
 	lc.logger = log.New(os.Stderr, "[cloudwatchlogs] ",
 		log.Ldate|log.Ltime|log.Lshortfile)
+
+	secretsClient, err := secrets.NewSecretsClient(conf)
+	if err != nil {
+		return err
+	}
+	lc.secrets = secretsClient
 	return lc.createNamespaceIfNotExists()
 }
 
@@ -124,8 +132,12 @@ func (lc *EKSCloudWatchLogsClient) Logs(executable state.Executable, run state.R
 		return "", result.NextForwardToken, nil
 	}
 
+	masker, err := secrets.NewMasker(lc.secrets, executable, run)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "problem masking secrets in logs")
+	}
 	message := lc.logsToMessage(result.Events)
-	return message, result.NextForwardToken, nil
+	return masker.String(message), result.NextForwardToken, nil
 }
 
 // This method doesn't return log string, it is a placeholder only.
@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/state"
 	"io"
@@ -34,6 +35,7 @@ type EKSS3LogsClient struct {
 	logger             *log.Logger
 	emrS3LogsBucket    string
 	emrS3LogsBasePath  string
+	secrets            secrets.Client
 }
 
 type s3Log struct {
@@ -92,6 +94,12 @@ func (lc *EKSS3LogsClient) Initialize(conf config.Config) error {
 
 	lc.logger = log.New(os.Stderr, "[s3logs] ",
 		log.Ldate|log.Ltime|log.Lshortfile)
+
+	secretsClient, err := secrets.NewSecretsClient(conf)
+	if err != nil {
+		return err
+	}
+	lc.secrets = secretsClient
 	return nil
 }
 
@@ -189,12 +197,43 @@ func (lc *EKSS3LogsClient) emrDriverLogsPath(run state.Run) (string, error) {
 	return "", errors.New("couldn't construct s3 path.")
 }
 
+//
+// Logs returns the logs of the run since lastSeen with its secret values masked
+//
 func (lc *EKSS3LogsClient) Logs(executable state.Executable, run state.Run, lastSeen *string, role *string, facility *string) (string, *string, error) {
-	if *run.Engine == state.EKSSparkEngine {
-		return lc.emrLogsToMessageString(run, lastSeen, role, facility)
+	masker, err := secrets.NewMasker(lc.secrets, executable, run)
+	if err != nil {
+		return "", aws.String(""), errors.Wrap(err, "problem masking secrets in logs")
+	}
+
+	var logs string
+	var newLastSeen *string
+	switch {
+	case run.Engine != nil && *run.Engine == state.EKSSparkEngine:
+		logs, newLastSeen, err = lc.emrLogsToMessageString(run, lastSeen, role, facility)
+	case run.Engine != nil && *run.Engine == state.EKSSparkNativeEngine:
+		logs, newLastSeen, err = lc.sparkNativeLogsToMessageString(run, lastSeen, role)
+	default:
+		result, getErr := lc.getS3Object(run)
+		logs, newLastSeen, err = lc.s3ObjectToMessageString(result, getErr, lastSeen)
 	}
+	return masker.String(logs), newLastSeen, err
+}
 
-	result, err := lc.getS3Object(run)
+//
+// sparkNativeLogsToMessageString reads the latest log of the run's driver or
+// executors, stored like the logs of EKS pods; the pods are named after the run
+//
+func (lc *EKSS3LogsClient) sparkNativeLogsToMessageString(run state.Run, lastSeen *string, role *string) (string, *string, error) {
+	podPrefix := fmt.Sprintf("%s-driver", run.RunID)
+	if role != nil && *role == "executor" {
+		podPrefix = fmt.Sprintf("%s-exec", run.RunID)
+	}
+	result, err := lc.getS3ObjectMatching(run, podPrefix)
+	return lc.s3ObjectToMessageString(result, err, lastSeen)
+}
+
+func (lc *EKSS3LogsClient) s3ObjectToMessageString(result *s3.GetObjectOutput, err error, lastSeen *string) (string, *string, error) {
 	startPosition := int64(0)
 	if lastSeen != nil {
 		parsed, err := strconv.ParseInt(*lastSeen, 10, 64)
@@ -213,18 +252,29 @@ func (lc *EKSS3LogsClient) Logs(executable state.Executable, run state.Run, last
 }
 
 //
-// Logs returns all logs from the log stream identified by handle since lastSeen
+// LogsText writes all logs of the run with its secret values masked
 //
 func (lc *EKSS3LogsClient) LogsText(executable state.Executable, run state.Run, w http.ResponseWriter) error {
-	if run.Engine == nil || *run.Engine == state.EKSEngine {
-		result, err := lc.getS3Object(run)
+	masker, err := secrets.NewMasker(lc.secrets, executable, run)
+	if err != nil {
+		return errors.Wrap(err, "problem masking secrets in logs")
+	}
+	if !masker.Empty() {
+		w = &maskingWriter{ResponseWriter: w, masker: masker}
+	}
 
-		if result != nil && err == nil {
-			return lc.logsToMessage(result, w)
-		}
+	var result *s3.GetObjectOutput
+	switch {
+	case run.Engine == nil || *run.Engine == state.EKSEngine:
+		result, err = lc.getS3Object(run)
+	case *run.Engine == state.EKSSparkEngine:
+		return lc.logsEMR(run, w)
+	case *run.Engine == state.EKSSparkNativeEngine:
+		// The driver log has the output of the run's application.
+		result, err = lc.getS3ObjectMatching(run, fmt.Sprintf("%s-driver", run.RunID))
 	}
-	if *run.Engine == state.EKSSparkEngine {
-		return lc.logsEMR(w)
+	if result != nil && err == nil {
+		return lc.logsToMessage(result, w)
 	}
 	return nil
 }
@@ -233,6 +283,13 @@ func (lc *EKSS3LogsClient) LogsText(executable state.Executable, run state.Run,
 // Fetch S3Object associated with the pod's log.
 //
 func (lc *EKSS3LogsClient) getS3Object(run state.Run) (*s3.GetObjectOutput, error) {
+	return lc.getS3ObjectMatching(run, run.RunID)
+}
+
+//
+// Fetch the latest S3Object of the run whose key contains match.
+//
+func (lc *EKSS3LogsClient) getS3ObjectMatching(run state.Run, match string) (*s3.GetObjectOutput, error) {
 	//Pod isn't there yet - dont return a 404
 	//if run.PodName == nil {
 	//	return nil, errors.New("no pod associated with the run.")
@@ -257,7 +314,7 @@ func (lc *EKSS3LogsClient) getS3Object(run state.Run) (*s3.GetObjectOutput, erro
 
 	//Find latest log file (could have multiple log files per pod - due to pod retries)
 	for _, content := range result.Contents {
-		if strings.Contains(*content.Key, run.RunID) && lastModified.Before(*content.LastModified) {
+		if strings.Contains(*content.Key, match) && lastModified.Before(*content.LastModified) {
 			if content != nil && *content.Size < int64(10000000) {
 				key = content.Key
 				lastModified = content.LastModified
@@ -316,9 +373,17 @@ func (lc *EKSS3LogsClient) logsToMessage(result *s3.GetObjectOutput, w http.Resp
 
 }
 
-func (lc *EKSS3LogsClient) logsEMR(w http.ResponseWriter) error {
-	_, _ = io.WriteString(w, "todo!!!")
-	return nil
+// logsEMR writes the driver's stderr, where the run's exceptions end up.
+func (lc *EKSS3LogsClient) logsEMR(run state.Run, w http.ResponseWriter) error {
+	if run.SparkExtension == nil {
+		return nil
+	}
+	logs, _, err := lc.emrLogsToMessageString(run, nil, aws.String("driver"), aws.String("stderr"))
+	if err != nil {
+		return nil
+	}
+	_, err = io.WriteString(w, logs)
+	return err
 }
 
 //
@@ -372,3 +437,18 @@ func (lc *EKSS3LogsClient) logsToMessageString(result *s3.GetObjectOutput, start
 	_ = result.Body.Close()
 	return acc, currentPosition, nil
 }
+
+//
+// maskingWriter masks secret values in each line of logs written through it
+//
+type maskingWriter struct {
+	http.ResponseWriter
+	masker secrets.Masker
+}
+
+func (mw *maskingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(mw.ResponseWriter, mw.masker.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
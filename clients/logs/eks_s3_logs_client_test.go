@@ -0,0 +1,128 @@
+package logs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+// fakeS3 serves the objects of one bucket by key.
+type fakeS3 map[string]string
+
+func (f fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/logs/")
+	if prefix := r.URL.Query().Get("prefix"); key == "" || key == "/logs" {
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprint(w, `<ListBucketResult><Name>logs</Name>`)
+		for k, body := range f {
+			if strings.HasPrefix(k, prefix) {
+				_, _ = fmt.Fprintf(w, `<Contents><Key>%s</Key><LastModified>2022-01-01T00:00:00.000Z</LastModified><Size>%d</Size></Contents>`, k, len(body))
+			}
+		}
+		_, _ = fmt.Fprint(w, `</ListBucketResult>`)
+		return
+	}
+	body, ok := f[key]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = fmt.Fprint(w, body)
+}
+
+type recordingWriter struct {
+	header http.Header
+	body   strings.Builder
+}
+
+func (rw *recordingWriter) Header() http.Header         { return rw.header }
+func (rw *recordingWriter) WriteHeader(statusCode int)  {}
+func (rw *recordingWriter) Write(p []byte) (int, error) { return rw.body.Write(p) }
+
+func setUpS3LogsTest(t *testing.T, objects fakeS3) *EKSS3LogsClient {
+	dir, _ := ioutil.TempDir("", "secrets")
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "secrets.json")
+	ioutil.WriteFile(path, []byte(`{"team/db": {"password": "hunter2"}}`), 0600)
+	os.Setenv("SECRETS_BACKEND", "file")
+	os.Setenv("SECRETS_FILE_PATH", path)
+	defer os.Unsetenv("SECRETS_BACKEND")
+	defer os.Unsetenv("SECRETS_FILE_PATH")
+	c, _ := config.NewConfig(nil)
+	secretsClient, err := secrets.NewSecretsClient(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	srv := httptest.NewServer(objects)
+	t.Cleanup(srv.Close)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return &EKSS3LogsClient{
+		s3Client:        s3.New(sess),
+		s3Bucket:        "logs",
+		s3BucketRootDir: "eks",
+		secrets:         secretsClient,
+	}
+}
+
+func TestEKSS3LogsClient_MasksSecrets(t *testing.T) {
+	lc := setUpS3LogsTest(t, fakeS3{
+		"eks/eks-a/eks-a-x1.log": `{"log": "connecting with hunter2\n"}` + "\n" + `{"log": "done\n"}` + "\n",
+	})
+	engine := state.EKSEngine
+	definition := state.Definition{ExecutableResources: state.ExecutableResources{
+		Env: &state.EnvList{{Name: "DB_PASS", SecretRef: "team/db#password"}},
+	}}
+	run := state.Run{RunID: "eks-a", Engine: &engine, GroupName: "team"}
+
+	w := &recordingWriter{header: http.Header{}}
+	if err := lc.LogsText(definition, run, w); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if w.body.String() != "connecting with ****\ndone\n" {
+		t.Errorf("Expected secret values masked in text logs, got %q", w.body.String())
+	}
+
+	logs, _, err := lc.Logs(definition, run, nil, nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if strings.Contains(logs, "hunter2") || !strings.Contains(logs, "connecting with ****") {
+		t.Errorf("Expected secret values masked in logs, got %q", logs)
+	}
+}
+
+func TestEKSS3LogsClient_LogsTextSparkNative(t *testing.T) {
+	lc := setUpS3LogsTest(t, fakeS3{
+		"eks/native-a/native-a-driver.log": `{"log": "driver output\n"}` + "\n",
+		"eks/native-a/native-a-exec-1.log": `{"log": "executor output\n"}` + "\n",
+	})
+	engine := state.EKSSparkNativeEngine
+	run := state.Run{RunID: "native-a", Engine: &engine}
+
+	w := &recordingWriter{header: http.Header{}}
+	if err := lc.LogsText(state.Definition{}, run, w); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if w.body.String() != "driver output\n" {
+		t.Errorf("Expected the driver logs of the run, got %q", w.body.String())
+	}
+}
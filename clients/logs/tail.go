@@ -0,0 +1,50 @@
+package logs
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+//
+// tailWriter is a http.ResponseWriter that keeps the last max bytes written
+//
+type tailWriter struct {
+	header http.Header
+	buf    []byte
+	max    int
+}
+
+func (tw *tailWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *tailWriter) WriteHeader(statusCode int) {}
+
+func (tw *tailWriter) Write(p []byte) (int, error) {
+	tw.buf = append(tw.buf, p...)
+	if len(tw.buf) > 2*tw.max {
+		tw.buf = append(tw.buf[:0], tw.buf[len(tw.buf)-tw.max:]...)
+	}
+	return len(p), nil
+}
+
+//
+// Tail returns the last maxBytes of a run's logs, starting at a line; the
+// logs are read once through LogsText
+//
+func Tail(lc Client, executable state.Executable, run state.Run, maxBytes int) (string, error) {
+	tw := &tailWriter{header: http.Header{}, max: maxBytes}
+	if err := lc.LogsText(executable, run, tw); err != nil {
+		return "", err
+	}
+	tail := tw.buf
+	if len(tail) > maxBytes {
+		tail = tail[len(tail)-maxBytes:]
+		if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+	}
+	return string(tail), nil
+}
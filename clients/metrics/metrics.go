@@ -23,6 +23,10 @@ const (
 	EngineEKSTerminate Metric = "engine.eks.terminate"
 	// Metric associated to termination of jobs via the API.
 	EngineEMRTerminate Metric = "engine.emr.terminate"
+	// Metric associated to submission of jobs as SparkApplications
+	EngineSparkNativeExecute Metric = "engine.spark_native.execute"
+	// Metric associated to termination of SparkApplications via the API.
+	EngineSparkNativeTerminate Metric = "engine.spark_native.terminate"
 	// Metric associated to termination of pods hopping between hosts.
 	EngineEKSRunPodnameChange Metric = "engine.eks.run_podname_changed"
 	// Metric associated to pod events where there was a Cluster Autoscale event.
@@ -45,6 +49,14 @@ const (
 	StatusWorkerGetEvents Metric = "status_worker.get_events"
 	// Timing for get job
 	StatusWorkerGetJob Metric = "status_worker.get_job"
+	// Runs resubmitted after a spot interruption
+	StatusWorkerSpotResubmit Metric = "status_worker.spot_resubmit"
+	// Timing for describe emr job run
+	StatusWorkerDescribeJobRun Metric = "status_worker.describe_job_run"
+	// Silent EMR runs reconciled
+	StatusWorkerEMRReconcile Metric = "status_worker.emr_reconcile"
+	// Failed runs with exceptions extracted from their logs
+	StatusWorkerExtractedExceptions Metric = "status_worker.extracted_exceptions"
 	// Engine update run
 	EngineUpdateRun Metric = "engine.update_run"
 )
@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//
+// FileSecretsClient is a stand-in secret store for development, read from
+// the JSON file at `secrets_file_path`: {"team/db": {"password": "..."}}
+//
+type FileSecretsClient struct {
+	secrets map[string]map[string]string
+	policy  state.SecretPolicy
+}
+
+// Name of the client
+func (fsc *FileSecretsClient) Name() string {
+	return "file"
+}
+
+// Initialize loads the secrets file
+func (fsc *FileSecretsClient) Initialize(conf config.Config) error {
+	path := conf.GetString("secrets_file_path")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "problem reading secrets file [%s]", path)
+	}
+	fsc.policy = state.NewSecretPolicy(conf)
+	return json.Unmarshal(b, &fsc.secrets)
+}
+
+// Resolve returns the secret key as a literal env var
+func (fsc *FileSecretsClient) Resolve(name string, groupName string, ref state.SecretRef) (corev1.EnvVar, error) {
+	if err := fsc.policy.Check(ref, groupName); err != nil {
+		return corev1.EnvVar{}, err
+	}
+	value, ok := fsc.secrets[ref.Path][ref.Key]
+	if !ok {
+		return corev1.EnvVar{}, fmt.Errorf("secret [%s] not found", ref)
+	}
+	return corev1.EnvVar{Name: name, Value: value}, nil
+}
@@ -0,0 +1,48 @@
+package secrets
+
+import (
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//
+// KubernetesSecretsClient maps references to Kubernetes Secrets in the job
+// namespace; values never leave the cluster. A reference "team/db#password"
+// becomes key "password" of the secret "<prefix>team-db".
+//
+type KubernetesSecretsClient struct {
+	prefix string
+	policy state.SecretPolicy
+}
+
+// Name of the client
+func (ksc *KubernetesSecretsClient) Name() string {
+	return "kubernetes"
+}
+
+// Initialize the client
+func (ksc *KubernetesSecretsClient) Initialize(conf config.Config) error {
+	ksc.prefix = conf.GetString("secrets_kubernetes_prefix")
+	ksc.policy = state.NewSecretPolicy(conf)
+	return nil
+}
+
+// Resolve returns an env var sourced from a SecretKeyRef
+func (ksc *KubernetesSecretsClient) Resolve(name string, groupName string, ref state.SecretRef) (corev1.EnvVar, error) {
+	if err := ksc.policy.Check(ref, groupName); err != nil {
+		return corev1.EnvVar{}, err
+	}
+	secretName := strings.ToLower(ksc.prefix + strings.Replace(ref.Path, "/", "-", -1))
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  ref.Key,
+			},
+		},
+	}, nil
+}
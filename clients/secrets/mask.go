@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//
+// Masker replaces the values of the secrets a run resolves wherever they
+// would leave the process: stored manifests and logs. Secrets passed to pods
+// as Kubernetes SecretKeyRefs have no value here and can't be masked in logs.
+//
+type Masker struct {
+	values map[string]bool
+	sorted []string
+}
+
+//
+// NewMasker resolves the secret references of the run and of its
+// executable, its init containers and sidecars
+//
+func NewMasker(client Client, executable state.Executable, run state.Run) (Masker, error) {
+	envs := []*state.EnvList{run.Env}
+	if executable != nil {
+		envs = append(envs, executable.GetExecutableResources().EnvLists()...)
+	}
+
+	m := Masker{values: make(map[string]bool)}
+	for _, env := range envs {
+		if env == nil {
+			continue
+		}
+		for _, ev := range *env {
+			if len(ev.SecretRef) == 0 {
+				continue
+			}
+			ref, err := state.ParseSecretRef(ev.SecretRef)
+			if err != nil {
+				return m, err
+			}
+			resolved, err := client.Resolve(ev.Name, run.GroupName, ref)
+			if err != nil {
+				return m, err
+			}
+			m.Add(resolved)
+		}
+	}
+	return m, nil
+}
+
+// Add masks the value of a resolved secret env var.
+func (m *Masker) Add(ev corev1.EnvVar) {
+	if ev.ValueFrom != nil || len(ev.Value) == 0 || m.values[ev.Value] {
+		return
+	}
+	if m.values == nil {
+		m.values = make(map[string]bool)
+	}
+	m.values[ev.Value] = true
+	m.sorted = append(m.sorted, ev.Value)
+	// Longest first, so a secret containing another is masked whole.
+	sort.Slice(m.sorted, func(i, j int) bool { return len(m.sorted[i]) > len(m.sorted[j]) })
+}
+
+// Empty is true when the run resolved no secret values.
+func (m Masker) Empty() bool {
+	return len(m.sorted) == 0
+}
+
+// IsSecret is true for the values of the run's secrets.
+func (m Masker) IsSecret(value string) bool {
+	return m.values[value]
+}
+
+// String masks every occurrence of a secret value, e.g. in a line of logs.
+func (m Masker) String(s string) string {
+	for _, value := range m.sorted {
+		s = strings.Replace(s, value, state.MaskedValue, -1)
+	}
+	return s
+}
+
+// PodSpec masks the env vars holding secret values in every container and
+// init container of the pod.
+func (m Masker) PodSpec(spec *corev1.PodSpec) {
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			for j := range containers[i].Env {
+				if ev := &containers[i].Env[j]; ev.ValueFrom == nil && m.IsSecret(ev.Value) {
+					ev.Value = state.MaskedValue
+				}
+			}
+		}
+	}
+}
+
+// Unstructured masks the env vars holding secret values anywhere in an
+// unstructured object, e.g. the pod templates of a SparkApplication.
+func (m Masker) Unstructured(obj interface{}) {
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		if value, ok := o["value"].(string); ok && o["valueFrom"] == nil && m.IsSecret(value) {
+			if _, named := o["name"]; named {
+				o["value"] = state.MaskedValue
+			}
+		}
+		for _, v := range o {
+			m.Unstructured(v)
+		}
+	case []interface{}:
+		for _, v := range o {
+			m.Unstructured(v)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//
+// Client resolves secret references in a run's environment into container
+// environment variables; a run may only resolve the secrets its group is
+// permitted by the SecretPolicy
+//
+type Client interface {
+	Name() string
+	Initialize(conf config.Config) error
+	Resolve(name string, groupName string, ref state.SecretRef) (corev1.EnvVar, error)
+}
+
+//
+// NewSecretsClient creates and initializes the secrets client named by
+// `secrets_backend` (kubernetes, vault or file)
+//
+func NewSecretsClient(conf config.Config) (Client, error) {
+	name := "kubernetes"
+	if conf.IsSet("secrets_backend") {
+		name = conf.GetString("secrets_backend")
+	}
+
+	var client Client
+	switch name {
+	case "kubernetes":
+		client = &KubernetesSecretsClient{}
+	case "vault":
+		client = &VaultSecretsClient{}
+	case "file":
+		client = &FileSecretsClient{}
+	default:
+		return nil, fmt.Errorf("No Client named [%s] was found", name)
+	}
+	if err := client.Initialize(conf); err != nil {
+		return nil, errors.Wrapf(err, "problem initializing secrets client [%s]", name)
+	}
+	return client, nil
+}
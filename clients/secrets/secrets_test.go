@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestKubernetesSecretsClient_Resolve(t *testing.T) {
+	os.Setenv("SECRETS_KUBERNETES_PREFIX", "flotilla-")
+	defer os.Unsetenv("SECRETS_KUBERNETES_PREFIX")
+	c, _ := config.NewConfig(nil)
+
+	client, err := NewSecretsClient(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ref, _ := state.ParseSecretRef("Team/db#password")
+	ev, err := client.Resolve("DB_PASS", "Team", ref)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(ev.Value) > 0 || ev.ValueFrom == nil || ev.ValueFrom.SecretKeyRef == nil {
+		t.Fatalf("Expected a SecretKeyRef but got %+v", ev)
+	}
+	if ev.ValueFrom.SecretKeyRef.Name != "flotilla-team-db" || ev.ValueFrom.SecretKeyRef.Key != "password" {
+		t.Errorf("Expected flotilla-team-db#password but got %s#%s",
+			ev.ValueFrom.SecretKeyRef.Name, ev.ValueFrom.SecretKeyRef.Key)
+	}
+
+	other, _ := state.ParseSecretRef("other-team/db#password")
+	if _, err = client.Resolve("DB_PASS", "Team", other); err == nil {
+		t.Errorf("Expected a secret of another group to be rejected")
+	}
+}
+
+func TestFileSecretsClient_Resolve(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "secrets")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.json")
+	ioutil.WriteFile(path, []byte(`{"team/db": {"password": "hunter2"}}`), 0600)
+
+	os.Setenv("SECRETS_BACKEND", "file")
+	os.Setenv("SECRETS_FILE_PATH", path)
+	defer os.Unsetenv("SECRETS_BACKEND")
+	defer os.Unsetenv("SECRETS_FILE_PATH")
+	c, _ := config.NewConfig(nil)
+
+	client, err := NewSecretsClient(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ev, err := client.Resolve("DB_PASS", "team", state.SecretRef{Path: "team/db", Key: "password"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ev.Name != "DB_PASS" || ev.Value != "hunter2" {
+		t.Errorf("Expected DB_PASS=hunter2 but got %s=%s", ev.Name, ev.Value)
+	}
+
+	if _, err = client.Resolve("DB_USER", "team", state.SecretRef{Path: "team/db", Key: "user"}); err == nil {
+		t.Errorf("Expected error for missing secret key")
+	}
+	if _, err = client.Resolve("DB_PASS", "other-team", state.SecretRef{Path: "team/db", Key: "password"}); err == nil {
+		t.Errorf("Expected the secret of another group to be rejected")
+	}
+}
+
+func TestMasker(t *testing.T) {
+	client := &FileSecretsClient{secrets: map[string]map[string]string{
+		"team/db":  {"password": "hunter2", "user": "dbuser"},
+		"team/api": {"token": "hunter2-token"},
+	}}
+	definition := state.Definition{ExecutableResources: state.ExecutableResources{
+		Env: &state.EnvList{{Name: "DB_PASS", SecretRef: "team/db#password"}, {Name: "MODE", Value: "batch"}},
+		InitContainers: &state.ContainerList{
+			{Name: "fetch", Env: &state.EnvList{{Name: "DB_USER", SecretRef: "team/db#user"}}},
+		},
+	}}
+	run := state.Run{GroupName: "team", Env: &state.EnvList{{Name: "API_TOKEN", SecretRef: "team/api#token"}}}
+
+	masker, err := NewMasker(client, definition, run)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if masked := masker.String("token=hunter2-token pass=hunter2 user=dbuser"); masked != "token=**** pass=**** user=****" {
+		t.Errorf("Expected every secret value to be masked, got %s", masked)
+	}
+
+	spec := corev1.PodSpec{
+		InitContainers: []corev1.Container{{Env: []corev1.EnvVar{{Name: "DB_USER", Value: "dbuser"}}}},
+		Containers: []corev1.Container{
+			{Env: []corev1.EnvVar{{Name: "DB_PASS", Value: "hunter2"}, {Name: "MODE", Value: "batch"}}},
+			{Env: []corev1.EnvVar{{Name: "SIDECAR_TOKEN", Value: "hunter2-token"}}},
+		},
+	}
+	masker.PodSpec(&spec)
+	for _, ev := range []corev1.EnvVar{spec.InitContainers[0].Env[0], spec.Containers[0].Env[0], spec.Containers[1].Env[0]} {
+		if ev.Value != state.MaskedValue {
+			t.Errorf("Expected %s to be masked, got %s", ev.Name, ev.Value)
+		}
+	}
+	if spec.Containers[0].Env[1].Value != "batch" {
+		t.Errorf("Expected plain env to be left alone, got %s", spec.Containers[0].Env[1].Value)
+	}
+
+	app := map[string]interface{}{"spec": map[string]interface{}{"driver": map[string]interface{}{
+		"env": []interface{}{map[string]interface{}{"name": "DB_PASS", "value": "hunter2"}},
+	}}}
+	masker.Unstructured(app)
+	env := app["spec"].(map[string]interface{})["driver"].(map[string]interface{})["env"].([]interface{})
+	if value := env[0].(map[string]interface{})["value"]; value != state.MaskedValue {
+		t.Errorf("Expected unstructured env to be masked, got %v", value)
+	}
+}
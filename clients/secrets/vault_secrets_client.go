@@ -0,0 +1,70 @@
+package secrets
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/httpclient"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//
+// VaultSecretsClient reads references from a Vault KV version 2 mount
+//
+type VaultSecretsClient struct {
+	client *httpclient.Client
+	token  string
+	mount  string
+	policy state.SecretPolicy
+}
+
+type vaultKVResponse struct {
+	Data struct {
+		Data map[string]string `json:"data"`
+	} `json:"data"`
+}
+
+// Name of the client
+func (vsc *VaultSecretsClient) Name() string {
+	return "vault"
+}
+
+// Initialize the client from `secrets_vault_address`, `secrets_vault_token`
+// and `secrets_vault_mount` (defaults to "secret")
+func (vsc *VaultSecretsClient) Initialize(conf config.Config) error {
+	if !conf.IsSet("secrets_vault_address") {
+		return errors.New("secrets_vault_address must be set")
+	}
+	vsc.client = &httpclient.Client{
+		Host:       conf.GetString("secrets_vault_address"),
+		Timeout:    5 * time.Second,
+		RetryCount: 3,
+	}
+	vsc.token = conf.GetString("secrets_vault_token")
+	vsc.mount = "secret"
+	if conf.IsSet("secrets_vault_mount") {
+		vsc.mount = conf.GetString("secrets_vault_mount")
+	}
+	vsc.policy = state.NewSecretPolicy(conf)
+	return nil
+}
+
+// Resolve reads the secret and returns its key as a literal env var
+func (vsc *VaultSecretsClient) Resolve(name string, groupName string, ref state.SecretRef) (corev1.EnvVar, error) {
+	if err := vsc.policy.Check(ref, groupName); err != nil {
+		return corev1.EnvVar{}, err
+	}
+	var res vaultKVResponse
+	path := fmt.Sprintf("/v1/%s/data/%s", vsc.mount, ref.Path)
+	if err := vsc.client.Get(path, map[string]string{"X-Vault-Token": vsc.token}, &res); err != nil {
+		return corev1.EnvVar{}, errors.Wrapf(err, "problem reading secret [%s]", ref.Path)
+	}
+	value, ok := res.Data.Data[ref.Key]
+	if !ok {
+		return corev1.EnvVar{}, fmt.Errorf("secret [%s] has no key [%s]", ref.Path, ref.Key)
+	}
+	return corev1.EnvVar{Name: name, Value: value}, nil
+}
@@ -0,0 +1,130 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Environment variables of the checkpoint contract.
+const (
+	CheckpointURIEnv  = "FLOTILLA_CHECKPOINT_URI"
+	AttemptEnv        = "FLOTILLA_ATTEMPT"
+	PriorAttemptIDEnv = "FLOTILLA_PRIOR_ATTEMPT_ID"
+)
+
+const defaultGracePeriod = int64(120)
+
+// signalForwarder runs the run's command, its first argument, in a child
+// shell of its own process group and forwards SIGTERM to the whole group, so
+// that the processes the command starts and not only the wrapper get the
+// grace period; it exits with the command's status.
+const signalForwarder = `set -m
+bash -l -cex "$1" &
+child=$!
+trap 'kill -TERM -- -"$child" 2>/dev/null' TERM
+wait "$child"
+status=$?
+while kill -0 "$child" 2>/dev/null; do
+  wait "$child"
+  status=$?
+done
+exit "$status"`
+
+// signalForwardingWrapper replaces the bash wrapper of runs under the
+// checkpoint contract; "flotilla" is the forwarder's $0.
+var signalForwardingWrapper = []string{"bash", "-c", signalForwarder, "flotilla"}
+
+// checkpointContract lets long running jobs resume after an interruption: a
+// run keeps its checkpoint location across resubmissions, learns which
+// attempt was interrupted, and gets a grace period between SIGTERM and
+// eviction to write a checkpoint.
+type checkpointContract struct {
+	baseURI     string
+	gracePeriod int64
+}
+
+// newCheckpointContract reads the contract from config; it is disabled
+// unless `eks_checkpoint_base_uri` is set, and the grace period defaults to
+// the two minutes of a spot interruption notice.
+func newCheckpointContract(conf config.Config) checkpointContract {
+	contract := checkpointContract{
+		baseURI:     strings.TrimRight(conf.GetString("eks_checkpoint_base_uri"), "/"),
+		gracePeriod: defaultGracePeriod,
+	}
+	if conf.IsSet("eks_checkpoint_grace_period_seconds") {
+		contract.gracePeriod = int64(conf.GetInt("eks_checkpoint_grace_period_seconds"))
+	}
+	return contract
+}
+
+func (c checkpointContract) enabled() bool {
+	return len(c.baseURI) > 0
+}
+
+// env returns the contract's environment for a run; the attempt is one more
+// than the run's spot interruptions.
+func (c checkpointContract) env(run state.Run) []corev1.EnvVar {
+	if !c.enabled() {
+		return nil
+	}
+	attempt := int64(1)
+	if run.SpotInterruptions != nil {
+		attempt += *run.SpotInterruptions
+	}
+	env := []corev1.EnvVar{
+		{Name: CheckpointURIEnv, Value: fmt.Sprintf("%s/%s/", c.baseURI, run.RunID)},
+		{Name: AttemptEnv, Value: fmt.Sprintf("%d", attempt)},
+	}
+	if prior, ok := priorAttemptID(run); ok {
+		env = append(env, corev1.EnvVar{Name: PriorAttemptIDEnv, Value: prior})
+	}
+	return env
+}
+
+// apply sets the contract's environment on the run's container, replacing
+// user set values, and the pod's termination grace period. A bash wrapped
+// command is run under the signal forwarder; an image's entrypoint has to
+// handle SIGTERM itself.
+func (c checkpointContract) apply(run state.Run, container *corev1.Container, spec *corev1.PodSpec) {
+	if !c.enabled() {
+		return
+	}
+	if wrapped(container.Command, bashWrapper) {
+		container.Command = append(
+			append([]string{}, signalForwardingWrapper...), container.Command[len(bashWrapper):]...)
+	}
+	contractEnv := c.env(run)
+	var env []corev1.EnvVar
+	for _, ev := range container.Env {
+		reserved := false
+		for _, cev := range contractEnv {
+			if ev.Name == cev.Name {
+				reserved = true
+			}
+		}
+		if !reserved {
+			env = append(env, ev)
+		}
+	}
+	container.Env = append(env, contractEnv...)
+	gracePeriod := c.gracePeriod
+	spec.TerminationGracePeriodSeconds = &gracePeriod
+}
+
+// priorAttemptID is the pod of the most recent interrupted attempt.
+func priorAttemptID(run state.Run) (string, bool) {
+	if run.PodEvents == nil {
+		return "", false
+	}
+	events := *run.PodEvents
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Reason == state.SpotResubmittedReason {
+			return events[i].SourceObject, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package adapter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckpointContract_Apply(t *testing.T) {
+	c := checkpointContract{baseURI: "s3://bucket/checkpoints", gracePeriod: 90}
+	interruptions := int64(1)
+	run := state.Run{
+		RunID:             "eks-abc",
+		SpotInterruptions: &interruptions,
+		PodEvents: &state.PodEvents{
+			{Reason: "SpotInterruption", SourceObject: "eks-abc-x1"},
+			{Reason: state.SpotResubmittedReason, SourceObject: "eks-abc-x1"},
+		},
+	}
+	container := corev1.Container{
+		Command: append(append([]string{}, bashWrapper...), "python train.py"),
+		Env: []corev1.EnvVar{
+			{Name: "A", Value: "1"},
+			{Name: CheckpointURIEnv, Value: "s3://elsewhere"},
+		},
+	}
+	spec := corev1.PodSpec{}
+	c.apply(run, &container, &spec)
+
+	expected := map[string]string{
+		"A":               "1",
+		CheckpointURIEnv:  "s3://bucket/checkpoints/eks-abc/",
+		AttemptEnv:        "2",
+		PriorAttemptIDEnv: "eks-abc-x1",
+	}
+	if len(container.Env) != len(expected) {
+		t.Errorf("Expected %d env vars, got %v", len(expected), container.Env)
+	}
+	for _, ev := range container.Env {
+		if expected[ev.Name] != ev.Value {
+			t.Errorf("Expected %s=%s, got %s", ev.Name, expected[ev.Name], ev.Value)
+		}
+	}
+	if spec.TerminationGracePeriodSeconds == nil || *spec.TerminationGracePeriodSeconds != 90 {
+		t.Errorf("Expected a 90 second grace period, got %v", spec.TerminationGracePeriodSeconds)
+	}
+
+	expectedCmd := []string{"bash", "-c", signalForwarder, "flotilla", "python train.py"}
+	if !reflect.DeepEqual(container.Command, expectedCmd) {
+		t.Errorf("Expected the command to run under the signal forwarder, got %v", container.Command)
+	}
+	if cmd, ok := commandFromContainer(container); !ok || cmd != "python train.py" {
+		t.Errorf("Expected the run's command to be recovered from the forwarder, got %s", cmd)
+	}
+	entrypoint := corev1.Container{Args: []string{"serve"}}
+	c.apply(run, &entrypoint, &spec)
+	if len(entrypoint.Command) > 0 {
+		t.Errorf("Expected an image's entrypoint to be left alone, got %v", entrypoint.Command)
+	}
+
+	disabled := corev1.Container{Command: append(append([]string{}, bashWrapper...), "python train.py")}
+	checkpointContract{}.apply(state.Run{RunID: "eks-abc"}, &disabled, &spec)
+	if len(disabled.Env) > 0 || disabled.Command[1] != "-l" {
+		t.Errorf("Expected no checkpoint env or forwarder without a base uri, got %v", disabled)
+	}
+}
+
+func TestSignalForwarder(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+	dir := t.TempDir()
+	received, ready := filepath.Join(dir, "received"), filepath.Join(dir, "ready")
+	// The trapping shell is a grandchild of the wrapper, started by the
+	// shell running the command.
+	command := fmt.Sprintf(`echo starting
+sh -c 'trap "echo TERM > %s; exit 3" TERM; touch %s; while true; do sleep 0.1; done'
+echo done`, received, ready)
+
+	wrapper := exec.Command(signalForwardingWrapper[0], append(append([]string{}, signalForwardingWrapper[1:]...), command)...)
+	if err := wrapper.Start(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	done := make(chan error, 1)
+	go func() { done <- wrapper.Wait() }()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			wrapper.Process.Kill()
+			t.Fatalf("Expected the command to start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	wrapper.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		wrapper.Process.Kill()
+		t.Fatalf("Expected the wrapper to exit after SIGTERM")
+	}
+	if b, err := ioutil.ReadFile(received); err != nil || strings.TrimSpace(string(b)) != "TERM" {
+		t.Errorf("Expected the command's grandchild to receive SIGTERM, got %q (%v)", b, err)
+	}
+}
@@ -0,0 +1,76 @@
+package adapter
+
+import (
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// bashWrapper is prepended to the run's command unless the executable uses
+// its image's entrypoint.
+var bashWrapper = []string{"bash", "-l", "-cex"}
+
+// Resources of init containers and sidecars that don't set them, in
+// millicores and MB.
+var (
+	defaultContainerCpu    = int64(100)
+	defaultContainerMemory = int64(128)
+)
+
+// MainContainer returns the container running the run's command; it is
+// named after the run, sidecars are not.
+func MainContainer(pod *corev1.Pod, run state.Run) (corev1.Container, bool) {
+	if pod == nil || len(pod.Spec.Containers) == 0 {
+		return corev1.Container{}, false
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == run.RunID {
+			return c, true
+		}
+	}
+	return pod.Spec.Containers[0], true
+}
+
+// mainContainerStatus returns the status of the main container, so that
+// sidecars don't decide the outcome of a run.
+func mainContainerStatus(pod *corev1.Pod, run state.Run) (corev1.ContainerStatus, bool) {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return corev1.ContainerStatus{}, false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == run.RunID {
+			return cs, true
+		}
+	}
+	return pod.Status.ContainerStatuses[len(pod.Status.ContainerStatuses)-1], true
+}
+
+// commandFromContainer recovers the run's command from the main container,
+// stripping the bash wrapper, or the signal forwarder, if it was injected.
+func commandFromContainer(container corev1.Container) (string, bool) {
+	if wrapped(container.Command, bashWrapper) {
+		return strings.Join(container.Command[len(bashWrapper):], "\n"), true
+	}
+	if wrapped(container.Command, signalForwardingWrapper) {
+		return strings.Join(container.Command[len(signalForwardingWrapper):], "\n"), true
+	}
+	if len(container.Command) == 0 && len(container.Args) > 0 {
+		return strings.Join(container.Args, "\n"), true
+	}
+	return "", false
+}
+
+// wrapped tells whether the command is the wrapper followed by the run's
+// command.
+func wrapped(command []string, wrapper []string) bool {
+	if len(command) <= len(wrapper) {
+		return false
+	}
+	for i, arg := range wrapper {
+		if command[i] != arg {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/state"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAdaptJobToFlotillaRun_Sidecars(t *testing.T) {
+	a := eksAdapter{}
+	run := state.Run{RunID: "eks-abc", Status: state.StatusRunning}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "eks-abc", Command: []string{"bash", "-l", "-cex", "echo hi"}},
+			{Name: "proxy"},
+		}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "eks-abc", State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 3, Reason: "Error"}}},
+			{Name: "proxy", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}},
+	}
+	job := &batchv1.Job{Status: batchv1.JobStatus{Active: 1}}
+
+	updated, err := a.AdaptJobToFlotillaRun(job, run, pod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if updated.Status != state.StatusStopped || updated.ExitCode == nil || *updated.ExitCode != 3 {
+		t.Errorf("Expected run to stop with the main container's exit code, got %s %v", updated.Status, updated.ExitCode)
+	}
+	if updated.Command == nil || *updated.Command != "echo hi" {
+		t.Errorf("Expected command [echo hi], got %v", updated.Command)
+	}
+}
+
+func TestCommandFromContainer(t *testing.T) {
+	cmd, ok := commandFromContainer(corev1.Container{Args: []string{"serve", "--port=80"}})
+	if !ok || cmd != "serve\n--port=80" {
+		t.Errorf("Expected args to be used as the command, got %s", cmd)
+	}
+	if _, ok = commandFromContainer(corev1.Container{Command: []string{"/entrypoint.sh"}}); ok {
+		t.Errorf("Expected no command for an unwrapped entrypoint")
+	}
+}
@@ -3,6 +3,8 @@ package adapter
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
+	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/state"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -14,16 +16,43 @@ import (
 
 type EKSAdapter interface {
 	AdaptJobToFlotillaRun(job *batchv1.Job, run state.Run, pod *corev1.Pod) (state.Run, error)
-	AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, manager state.Manager, araEnabled bool) (batchv1.Job, error)
+	AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, manager state.Manager, araEnabled bool) (batchv1.Job, state.Run, error)
+}
+type eksAdapter struct {
+	araPolicies state.ARAPolicies
+	gpus        state.GPUCatalog
+	secrets     secrets.Client
+	scratchPath string
+	checkpoint  checkpointContract
 }
-type eksAdapter struct{}
 
 //
 // NewEKSAdapter configures and returns an eks adapter for translating
 // from EKS api specific objects to our representation
 //
-func NewEKSAdapter() (EKSAdapter, error) {
-	adapter := eksAdapter{}
+func NewEKSAdapter(conf config.Config) (EKSAdapter, error) {
+	araPolicies, err := state.NewARAPolicies(conf)
+	if err != nil {
+		return nil, err
+	}
+	gpus, err := state.NewGPUCatalog(conf)
+	if err != nil {
+		return nil, err
+	}
+	secretsClient, err := secrets.NewSecretsClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	adapter := eksAdapter{
+		araPolicies: araPolicies,
+		gpus:        gpus,
+		secrets:     secretsClient,
+		scratchPath: "/scratch",
+		checkpoint:  newCheckpointContract(conf),
+	}
+	if conf.IsSet("eks_scratch_mount_path") {
+		adapter.scratchPath = conf.GetString("eks_scratch_mount_path")
+	}
 	return &adapter, nil
 }
 
@@ -53,8 +82,7 @@ func (a *eksAdapter) AdaptJobToFlotillaRun(job *batchv1.Job, run state.Run, pod
 		var exitCode int64 = 1
 		updated.Status = state.StatusStopped
 		if pod != nil {
-			if pod.Status.ContainerStatuses != nil && len(pod.Status.ContainerStatuses) > 0 {
-				containerStatus := pod.Status.ContainerStatuses[len(pod.Status.ContainerStatuses)-1]
+			if containerStatus, ok := mainContainerStatus(pod, run); ok {
 				if containerStatus.State.Terminated != nil {
 					updated.ExitReason = &containerStatus.State.Terminated.Reason
 					exitCode = int64(containerStatus.State.Terminated.ExitCode)
@@ -64,12 +92,25 @@ func (a *eksAdapter) AdaptJobToFlotillaRun(job *batchv1.Job, run state.Run, pod
 		updated.ExitCode = &exitCode
 	}
 
-	if pod != nil && len(pod.Spec.Containers) > 0 {
-		container := pod.Spec.Containers[0]
-		//First three lines are injected by Flotilla, strip those out.
-		if len(container.Command) > 3 {
-			cmd := strings.Join(container.Command[3:], "\n")
-			updated.Command = &cmd
+	// Sidecars keep the job active after the main container exits.
+	if updated.Status != state.StatusStopped && pod != nil && len(pod.Spec.Containers) > 1 {
+		if containerStatus, ok := mainContainerStatus(pod, run); ok && containerStatus.State.Terminated != nil {
+			exitCode := int64(containerStatus.State.Terminated.ExitCode)
+			exitReason := containerStatus.State.Terminated.Reason
+			if exitCode == 0 {
+				exitReason = fmt.Sprintf("Pod %s Exited Successfully", pod.Name)
+			}
+			updated.Status = state.StatusStopped
+			updated.ExitCode = &exitCode
+			updated.ExitReason = &exitReason
+		}
+	}
+
+	if pod != nil {
+		if container, ok := MainContainer(pod, run); ok {
+			if cmd, ok := commandFromContainer(container); ok {
+				updated.Command = &cmd
+			}
 		}
 	}
 
@@ -96,37 +137,65 @@ func (a *eksAdapter) AdaptJobToFlotillaRun(job *batchv1.Job, run state.Run, pod
 // 4. Port mappings.
 // 5. Node lifecycle.
 // 6. Node affinity and anti-affinity
+// 7. Node selectors, tolerations and topology spread from the run's placement.
+// 8. Volumes: shared memory for GPUs, scratch and the run's volumes.
+// 9. Init containers and sidecars declared on the executable.
+// 10. The checkpoint contract: checkpoint location, attempt and grace period.
+// 11. The service account: the run's, or sa when the run has none.
+// The run is returned with the ARA estimate the job was sized with.
 //
-func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, manager state.Manager, araEnabled bool) (batchv1.Job, error) {
+func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executable, run state.Run, sa string, schedulerName string, manager state.Manager, araEnabled bool) (batchv1.Job, state.Run, error) {
 	cmd := ""
 
 	if run.Command != nil && len(*run.Command) > 0 {
 		cmd = *run.Command
 	}
 
-	cmdSlice := a.constructCmdSlice(cmd)
-	cmd = strings.Join(cmdSlice[3:], "\n")
 	run.Command = &cmd
 	resourceRequirements, run := a.constructResourceRequirements(executable, run, manager, araEnabled)
 
 	volumeMounts, volumes := a.constructVolumeMounts(executable, run, manager, araEnabled)
 
+	env, err := a.envOverrides(executable, run)
+	if err != nil {
+		return batchv1.Job{}, run, err
+	}
+
 	container := corev1.Container{
 		Name:      run.RunID,
 		Image:     run.Image,
-		Command:   cmdSlice,
 		Resources: resourceRequirements,
-		Env:       a.envOverrides(executable, run),
+		Env:       env,
 		Ports:     a.constructContainerPorts(executable),
 	}
 
+	executableResources := executable.GetExecutableResources()
+	if executableResources.UseImageEntrypoint != nil && *executableResources.UseImageEntrypoint {
+		container.Args = a.constructArgs(cmd)
+	} else {
+		container.Command = a.constructCmdSlice(cmd)
+	}
+
 	if volumeMounts != nil {
 		container.VolumeMounts = volumeMounts
 	}
 
+	initContainers, err := a.constructContainers(executableResources.InitContainers, volumeMounts, run)
+	if err != nil {
+		return batchv1.Job{}, run, err
+	}
+	sidecars, err := a.constructContainers(executableResources.Sidecars, volumeMounts, run)
+	if err != nil {
+		return batchv1.Job{}, run, err
+	}
+
 	affinity := a.constructAffinity(executable, run, manager)
 	annotations := map[string]string{"cluster-autoscaler.kubernetes.io/safe-to-evict": "false"}
 
+	if run.ServiceAccount != nil && len(*run.ServiceAccount) > 0 {
+		sa = *run.ServiceAccount
+	}
+
 	jobSpec := batchv1.JobSpec{
 		TTLSecondsAfterFinished: &state.TTLSecondsAfterFinished,
 		ActiveDeadlineSeconds:   run.ActiveDeadlineSeconds,
@@ -138,7 +207,8 @@ func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executa
 			},
 			Spec: corev1.PodSpec{
 				SchedulerName:      schedulerName,
-				Containers:         []corev1.Container{container},
+				InitContainers:     initContainers,
+				Containers:         append([]corev1.Container{container}, sidecars...),
 				RestartPolicy:      corev1.RestartPolicyNever,
 				ServiceAccountName: sa,
 				Affinity:           affinity,
@@ -150,6 +220,9 @@ func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executa
 		jobSpec.Template.Spec.Volumes = volumes
 	}
 
+	a.constructPlacement(executable, run, &jobSpec.Template)
+	a.checkpoint.apply(run, &jobSpec.Template.Spec.Containers[0], &jobSpec.Template.Spec)
+
 	eksJob := batchv1.Job{
 		Spec: jobSpec,
 		ObjectMeta: v1.ObjectMeta{
@@ -157,7 +230,7 @@ func (a *eksAdapter) AdaptFlotillaDefinitionAndRunToJob(executable state.Executa
 		},
 	}
 
-	return eksJob, nil
+	return eksJob, run, nil
 }
 
 func (a *eksAdapter) constructContainerPorts(executable state.Executable) []corev1.ContainerPort {
@@ -178,7 +251,7 @@ func (a *eksAdapter) constructAffinity(executable state.Executable, run state.Ru
 	executableResources := executable.GetExecutableResources()
 	var requiredMatch []corev1.NodeSelectorRequirement
 
-	gpuNodeTypes := []string{"p3.2xlarge", "p3.8xlarge", "p3.16xlarge"}
+	gpuNodeTypes := a.gpus.InstanceTypes()
 
 	var nodeLifecycle []string
 	if run.NodeLifecycle != nil && *run.NodeLifecycle == state.OndemandLifecycle {
@@ -188,11 +261,13 @@ func (a *eksAdapter) constructAffinity(executable state.Executable, run state.Ru
 	}
 
 	if (executableResources.Gpu == nil || *executableResources.Gpu <= 0) && (run.Gpu == nil || *run.Gpu <= 0) {
-		requiredMatch = append(requiredMatch, corev1.NodeSelectorRequirement{
-			Key:      "beta.kubernetes.io/instance-type",
-			Operator: corev1.NodeSelectorOpNotIn,
-			Values:   gpuNodeTypes,
-		})
+		if len(gpuNodeTypes) > 0 {
+			requiredMatch = append(requiredMatch, corev1.NodeSelectorRequirement{
+				Key:      "beta.kubernetes.io/instance-type",
+				Operator: corev1.NodeSelectorOpNotIn,
+				Values:   gpuNodeTypes,
+			})
+		}
 
 		nodeList, err := manager.ListFailingNodes()
 
@@ -205,6 +280,14 @@ func (a *eksAdapter) constructAffinity(executable state.Executable, run state.Ru
 		}
 	}
 
+	if gpuType, ok := a.gpuType(executable, run); ok && len(gpuType.InstanceTypes) > 0 {
+		requiredMatch = append(requiredMatch, corev1.NodeSelectorRequirement{
+			Key:      "beta.kubernetes.io/instance-type",
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   gpuType.InstanceTypes,
+		})
+	}
+
 	requiredMatch = append(requiredMatch, corev1.NodeSelectorRequirement{
 		Key:      "node.kubernetes.io/lifecycle",
 		Operator: corev1.NodeSelectorOpIn,
@@ -226,10 +309,37 @@ func (a *eksAdapter) constructAffinity(executable state.Executable, run state.Ru
 	return affinity
 }
 
+// gpuType returns the catalog entry for runs that request GPUs.
+func (a *eksAdapter) gpuType(executable state.Executable, run state.Run) (state.GPUType, bool) {
+	return GPUType(a.gpus, executable, run)
+}
+
+// constructPlacement merges the run's placement with the node selector and
+// tolerations the gpu catalog requires.
+func (a *eksAdapter) constructPlacement(executable state.Executable, run state.Run, template *corev1.PodTemplateSpec) {
+	var platformSelector map[string]string
+	var platformTolerations, placementTolerations []state.Toleration
+	if gpuType, ok := a.gpuType(executable, run); ok {
+		platformSelector = gpuType.NodeSelector
+		platformTolerations = gpuType.Tolerations
+	}
+	if run.Placement != nil {
+		placementTolerations = run.Placement.Tolerations
+	}
+
+	template.Spec.NodeSelector = NodeSelector(platformSelector, run.Placement)
+	template.Spec.Tolerations = Tolerations(platformTolerations, placementTolerations)
+	if spreads := TopologySpreadConstraints(run.Placement, SpreadGroup(executable)); len(spreads) > 0 {
+		template.Spec.TopologySpreadConstraints = spreads
+		template.ObjectMeta.Labels = SpreadLabels(template.ObjectMeta.Labels, SpreadGroup(executable))
+	}
+}
+
 func (a *eksAdapter) constructResourceRequirements(executable state.Executable, run state.Run, manager state.Manager, araEnabled bool) (corev1.ResourceRequirements, state.Run) {
 	limits := make(corev1.ResourceList)
 	requests := make(corev1.ResourceList)
-	cpuLimit, memLimit, cpuRequest, memRequest := a.adaptiveResources(executable, run, manager, araEnabled)
+	cpuLimit, memLimit, cpuRequest, memRequest, estimate := a.adaptiveResources(executable, run, manager, araEnabled)
+	run.AraEstimate = &estimate
 
 	cpuLimitQuantity := resource.MustParse(fmt.Sprintf("%dm", cpuLimit))
 	cpuRequestQuantity := resource.MustParse(fmt.Sprintf("%dm", cpuRequest))
@@ -254,6 +364,10 @@ func (a *eksAdapter) constructResourceRequirements(executable state.Executable,
 		run.NodeLifecycle = &state.OndemandLifecycle
 	}
 
+	if run.EphemeralStorage != nil && *run.EphemeralStorage > 0 {
+		requests[corev1.ResourceEphemeralStorage] = resource.MustParse(fmt.Sprintf("%dM", *run.EphemeralStorage))
+	}
+
 	run.Memory = aws.Int64(memRequestQuantity.ScaledValue(resource.Mega))
 	run.Cpu = aws.Int64(cpuRequestQuantity.ScaledValue(resource.Milli))
 	run.MemoryLimit = aws.Int64(memLimitQuantity.ScaledValue(resource.Mega))
@@ -277,17 +391,65 @@ func (a *eksAdapter) constructVolumeMounts(executable state.Executable, run stat
 		emptyDir := corev1.EmptyDirVolumeSource{Medium: "Memory", SizeLimit: &sharedLimit}
 		volumes[0] = corev1.Volume{Name: "shared-memory", VolumeSource: corev1.VolumeSource{EmptyDir: &emptyDir}}
 	}
+	// Requested ephemeral storage is mounted as scratch space.
+	if run.EphemeralStorage != nil && *run.EphemeralStorage > 0 {
+		mounts = append(mounts, corev1.VolumeMount{Name: "scratch", MountPath: a.scratchPath})
+		scratchLimit := resource.MustParse(fmt.Sprintf("%dM", *run.EphemeralStorage))
+		emptyDir := corev1.EmptyDirVolumeSource{SizeLimit: &scratchLimit}
+		volumes = append(volumes, corev1.Volume{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &emptyDir}})
+	}
+	runMounts, runVolumes := Volumes(run)
+	mounts = append(mounts, runMounts...)
+	volumes = append(volumes, runVolumes...)
 	return mounts, volumes
 }
 
-func (a *eksAdapter) adaptiveResources(executable state.Executable, run state.Run, manager state.Manager, araEnabled bool) (int64, int64, int64, int64) {
-	cpuLimit, memLimit := a.getResourceDefaults(run, executable)
-	cpuRequest, memRequest := a.getResourceDefaults(run, executable)
+func (a *eksAdapter) adaptiveResources(executable state.Executable, run state.Run, manager state.Manager, araEnabled bool) (int64, int64, int64, int64, state.ARAEstimate) {
+	policy := a.araPolicies.For(run.GroupName)
+	cpuLimit, memLimit, largeMemory := a.getResourceDefaults(run, executable, policy)
+	cpuRequest, memRequest := cpuLimit, memLimit
+
+	estimate := state.ARAEstimate{
+		Policy:        policy.Name,
+		Source:        state.ARASourceDefaults,
+		Reason:        "no resource usage history for this command",
+		DefaultCpu:    cpuLimit,
+		DefaultMemory: memLimit,
+	}
+	// A run adapted before, e.g. when retried, no longer holds its request.
+	if run.AraEstimate != nil {
+		estimate.RequestedCpu = run.AraEstimate.RequestedCpu
+		estimate.RequestedMemory = run.AraEstimate.RequestedMemory
+	} else {
+		if run.Cpu != nil && *run.Cpu != 0 {
+			estimate.RequestedCpu = aws.Int64(*run.Cpu)
+		}
+		if run.Memory != nil && *run.Memory != 0 {
+			estimate.RequestedMemory = aws.Int64(*run.Memory)
+		}
+	}
+	if largeMemory {
+		estimate.Source = state.ARASourceLargeMemory
+		estimate.Reason = fmt.Sprintf("memory within [%d, %d) MB, cpu raised to memory/%d",
+			policy.LargeMemoryMin, policy.LargeMemoryMax, policy.LargeMemoryCpuRatio)
+	}
 
-	estimatedResources, err := manager.EstimateRunResources(*executable.GetExecutableID(), run.RunID)
-	if err == nil {
-		cpuRequest = estimatedResources.Cpu
-		memRequest = estimatedResources.Memory
+	if !araEnabled || !policy.Enabled {
+		estimate.Reason = "adaptive resource allocation is disabled"
+	} else {
+		estimatedResources, err := manager.EstimateRunResources(*executable.GetExecutableID(), run.RunID, policy)
+		if err == nil {
+			cpuRequest = estimatedResources.Cpu
+			memRequest = estimatedResources.Memory
+			estimate.Source = state.ARASourceHistory
+			estimate.Samples = estimatedResources.Samples
+			estimate.EstimatedCpu = estimatedResources.Cpu
+			estimate.EstimatedMemory = estimatedResources.Memory
+			estimate.Reason = fmt.Sprintf("p%g memory x%g and p%g cpu x%g of %d runs in the last %d days",
+				policy.MemoryPercentile*100, policy.MemoryMultiplier,
+				policy.CpuPercentile*100, policy.CpuMultiplier,
+				estimatedResources.Samples, policy.LookbackDays)
+		}
 	}
 
 	if cpuRequest > cpuLimit {
@@ -301,14 +463,16 @@ func (a *eksAdapter) adaptiveResources(executable state.Executable, run state.Ru
 	cpuRequest, memRequest = a.checkResourceBounds(cpuRequest, memRequest)
 	cpuLimit, memLimit = a.checkResourceBounds(cpuLimit, memLimit)
 
-	//mapping to p3 instance types.
-	if run.Gpu != nil && *run.Gpu > 0 {
-		cpuLimit = *run.Gpu * 7500
-		cpuRequest = *run.Gpu * 6000
-		memLimit = *run.Gpu * 60000
-		memRequest = *run.Gpu * 50000
+	//mapping to the per gpu resources of the gpu type.
+	if gpuType, ok := a.gpuType(executable, run); ok && run.Gpu != nil && *run.Gpu > 0 {
+		cpuLimit = *run.Gpu * gpuType.CpuLimitPerGpu
+		cpuRequest = *run.Gpu * gpuType.CpuRequestPerGpu
+		memLimit = *run.Gpu * gpuType.MemoryLimitPerGpu
+		memRequest = *run.Gpu * gpuType.MemoryRequestPerGpu
+		estimate.Source = state.ARASourceGpu
+		estimate.Reason = fmt.Sprintf("resources fixed for %d %s gpus", *run.Gpu, gpuType.Name)
 	}
-	return cpuLimit, memLimit, cpuRequest, memRequest
+	return cpuLimit, memLimit, cpuRequest, memRequest, estimate
 }
 
 func (a *eksAdapter) checkResourceBounds(cpu int64, mem int64) (int64, int64) {
@@ -327,7 +491,7 @@ func (a *eksAdapter) checkResourceBounds(cpu int64, mem int64) (int64, int64) {
 	return cpu, mem
 }
 
-func (a *eksAdapter) getResourceDefaults(run state.Run, executable state.Executable) (int64, int64) {
+func (a *eksAdapter) getResourceDefaults(run state.Run, executable state.Executable, policy state.ARAPolicy) (int64, int64, bool) {
 	// 1. Init with the global defaults
 	cpu := state.MinCPU
 	mem := state.MinMem
@@ -351,15 +515,17 @@ func (a *eksAdapter) getResourceDefaults(run state.Run, executable state.Executa
 	}
 	// 4. Override for very large memory requests.
 	// Remove after migration.
-	if mem >= 36864 && mem < 131072 && (executableResources.Gpu == nil || *executableResources.Gpu == 0) {
-		// using the 8x ratios between cpu and memory ~ r5 class of instances
-		cpuOverride := mem / 8
+	overridden := false
+	if mem >= policy.LargeMemoryMin && mem < policy.LargeMemoryMax && (executableResources.Gpu == nil || *executableResources.Gpu == 0) {
+		// using the policy ratio between cpu and memory (8x ~ r5 class of instances)
+		cpuOverride := mem / policy.LargeMemoryCpuRatio
 		if cpuOverride > cpu {
 			cpu = cpuOverride
+			overridden = true
 		}
 	}
 
-	return cpu, mem
+	return cpu, mem, overridden
 }
 
 func (a *eksAdapter) getLastRun(manager state.Manager, run state.Run) state.Run {
@@ -379,42 +545,117 @@ func (a *eksAdapter) getLastRun(manager state.Manager, run state.Run) state.Run
 }
 
 func (a *eksAdapter) constructCmdSlice(cmdString string) []string {
-	bashCmd := "bash"
-	optLogin := "-l"
-	optStr := "-cex"
-	return []string{bashCmd, optLogin, optStr, cmdString}
+	return append(append([]string{}, bashWrapper...), cmdString)
+}
+
+// constructArgs passes each non empty line of the command as an argument to
+// the image's entrypoint.
+func (a *eksAdapter) constructArgs(cmdString string) []string {
+	var args []string
+	for _, line := range strings.Split(cmdString, "\n") {
+		if len(strings.TrimSpace(line)) > 0 {
+			args = append(args, line)
+		}
+	}
+	return args
 }
 
-func (a *eksAdapter) envOverrides(executable state.Executable, run state.Run) []corev1.EnvVar {
-	pairs := make(map[string]string)
+// constructContainers builds init containers or sidecars; they mount the
+// main container's volumes by name.
+func (a *eksAdapter) constructContainers(containers *state.ContainerList, mounts []corev1.VolumeMount, run state.Run) ([]corev1.Container, error) {
+	var res []corev1.Container
+	if containers == nil {
+		return res, nil
+	}
+	for _, c := range *containers {
+		var env []corev1.EnvVar
+		if c.Env != nil {
+			for _, ev := range *c.Env {
+				resolved, err := a.resolveEnvVar(a.sanitizeEnvVar(ev.Name), ev, run)
+				if err != nil {
+					return nil, err
+				}
+				env = append(env, resolved)
+			}
+		}
+
+		cpu, mem := defaultContainerCpu, defaultContainerMemory
+		if c.Cpu != nil {
+			cpu = *c.Cpu
+		}
+		if c.Memory != nil {
+			mem = *c.Memory
+		}
+		resources := corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(fmt.Sprintf("%dm", cpu)),
+			corev1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dM", mem)),
+		}
+
+		var volumeMounts []corev1.VolumeMount
+		for _, name := range c.Volumes {
+			for _, mount := range mounts {
+				if mount.Name == name {
+					volumeMounts = append(volumeMounts, mount)
+				}
+			}
+		}
+
+		res = append(res, corev1.Container{
+			Name:         c.Name,
+			Image:        c.Image,
+			Command:      c.Command,
+			Args:         c.Args,
+			Env:          env,
+			Resources:    corev1.ResourceRequirements{Limits: resources, Requests: resources},
+			VolumeMounts: volumeMounts,
+		})
+	}
+	return res, nil
+}
+
+func (a *eksAdapter) envOverrides(executable state.Executable, run state.Run) ([]corev1.EnvVar, error) {
+	pairs := make(map[string]state.EnvVar)
 	resources := executable.GetExecutableResources()
 
 	if resources.Env != nil && len(*resources.Env) > 0 {
 		for _, ev := range *resources.Env {
 			name := a.sanitizeEnvVar(ev.Name)
-			value := ev.Value
-			pairs[name] = value
+			pairs[name] = ev
 		}
 	}
 
 	if run.Env != nil && len(*run.Env) > 0 {
 		for _, ev := range *run.Env {
 			name := a.sanitizeEnvVar(ev.Name)
-			value := ev.Value
-			pairs[name] = value
+			pairs[name] = ev
 		}
 	}
 
 	var res []corev1.EnvVar
-	for key := range pairs {
-		if len(key) > 0 {
-			res = append(res, corev1.EnvVar{
-				Name:  key,
-				Value: pairs[key],
-			})
+	for key, ev := range pairs {
+		if len(key) == 0 {
+			continue
 		}
+		resolved, err := a.resolveEnvVar(key, ev, run)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resolved)
+	}
+	return res, nil
+}
+
+// resolveEnvVar resolves secret references at submit time, as the run's
+// group; they are never stored.
+func (a *eksAdapter) resolveEnvVar(name string, ev state.EnvVar, run state.Run) (corev1.EnvVar, error) {
+	if len(ev.SecretRef) == 0 {
+		return corev1.EnvVar{Name: name, Value: ev.Value}, nil
+	}
+	ref, err := state.ParseSecretRef(ev.SecretRef)
+	if err != nil {
+		return corev1.EnvVar{}, err
 	}
-	return res
+	return a.secrets.Resolve(name, run.GroupName, ref)
 }
 
 func (a *eksAdapter) sanitizeEnvVar(key string) string {
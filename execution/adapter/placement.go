@@ -0,0 +1,109 @@
+package adapter
+
+import (
+	"crypto/md5"
+	"fmt"
+
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SpreadGroupLabel labels pods of the same executable so topology spread
+// constraints can select them.
+const SpreadGroupLabel = "flotilla-spread-group"
+
+// SpreadGroup returns the SpreadGroupLabel value for an executable; it is
+// hashed since executable ids may not be valid label values.
+func SpreadGroup(executable state.Executable) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(*executable.GetExecutableID())))
+}
+
+// GPUType returns the catalog entry of a run that requests GPUs, itself or
+// through its executable.
+func GPUType(gpus state.GPUCatalog, executable state.Executable, run state.Run) (state.GPUType, bool) {
+	executableResources := executable.GetExecutableResources()
+	if (executableResources.Gpu == nil || *executableResources.Gpu <= 0) && (run.Gpu == nil || *run.Gpu <= 0) {
+		return state.GPUType{}, false
+	}
+	return gpus.Get(executableResources.GpuType)
+}
+
+// NodeSelector merges the platform's node selector with the placement's;
+// platform keys win.
+func NodeSelector(platform map[string]string, placement *state.Placement) map[string]string {
+	if len(platform) == 0 && placement.Empty() {
+		return nil
+	}
+	selector := make(map[string]string)
+	if placement != nil {
+		for k, v := range placement.NodeSelector {
+			selector[k] = v
+		}
+	}
+	for k, v := range platform {
+		selector[k] = v
+	}
+	if len(selector) == 0 {
+		return nil
+	}
+	return selector
+}
+
+// Tolerations converts flotilla tolerations to Kubernetes tolerations.
+func Tolerations(tolerations ...[]state.Toleration) []corev1.Toleration {
+	var converted []corev1.Toleration
+	for _, ts := range tolerations {
+		for _, t := range ts {
+			operator := corev1.TolerationOpEqual
+			if len(t.Operator) > 0 {
+				operator = corev1.TolerationOperator(t.Operator)
+			}
+			converted = append(converted, corev1.Toleration{
+				Key:      t.Key,
+				Operator: operator,
+				Value:    t.Value,
+				Effect:   corev1.TaintEffect(t.Effect),
+			})
+		}
+	}
+	return converted
+}
+
+// SpreadLabels adds the SpreadGroupLabel to a pod's labels, keeping the
+// labels it already has.
+func SpreadLabels(labels map[string]string, spreadGroup string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[SpreadGroupLabel] = spreadGroup
+	return labels
+}
+
+// TopologySpreadConstraints converts the placement's spreads to constraints
+// over pods labelled with spreadGroup.
+func TopologySpreadConstraints(placement *state.Placement, spreadGroup string) []corev1.TopologySpreadConstraint {
+	if placement == nil {
+		return nil
+	}
+	var constraints []corev1.TopologySpreadConstraint
+	for _, s := range placement.TopologySpread {
+		maxSkew := s.MaxSkew
+		if maxSkew <= 0 {
+			maxSkew = 1
+		}
+		whenUnsatisfiable := corev1.ScheduleAnyway
+		if len(s.WhenUnsatisfiable) > 0 {
+			whenUnsatisfiable = corev1.UnsatisfiableConstraintAction(s.WhenUnsatisfiable)
+		}
+		constraints = append(constraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           maxSkew,
+			TopologyKey:       s.TopologyKey,
+			WhenUnsatisfiable: whenUnsatisfiable,
+			LabelSelector: &v1.LabelSelector{
+				MatchLabels: map[string]string{SpreadGroupLabel: spreadGroup},
+			},
+		})
+	}
+	return constraints
+}
@@ -0,0 +1,78 @@
+package adapter
+
+import (
+	"fmt"
+
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConfigMapName returns the name of the ConfigMap holding a volume's
+// config files for a run.
+func ConfigMapName(runID string, volume state.Volume) string {
+	return fmt.Sprintf("%s-%s", runID, volume.Name)
+}
+
+// ConfigMaps returns the ConfigMaps that must exist before the run's pods
+// can mount its config file volumes.
+func ConfigMaps(run state.Run) []corev1.ConfigMap {
+	var configMaps []corev1.ConfigMap
+	if run.Volumes == nil {
+		return configMaps
+	}
+	for _, volume := range *run.Volumes {
+		if len(volume.ConfigFiles) == 0 {
+			continue
+		}
+		data := make(map[string]string)
+		for name, contents := range volume.ConfigFiles {
+			data[name] = contents
+		}
+		configMaps = append(configMaps, corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: ConfigMapName(run.RunID, volume)},
+			Data:       data,
+		})
+	}
+	return configMaps
+}
+
+// Volumes converts the run's volumes to Kubernetes volumes and the mounts
+// for its container.
+func Volumes(run state.Run) ([]corev1.VolumeMount, []corev1.Volume) {
+	var mounts []corev1.VolumeMount
+	var volumes []corev1.Volume
+	if run.Volumes == nil {
+		return mounts, volumes
+	}
+	for _, volume := range *run.Volumes {
+		source := corev1.VolumeSource{}
+		switch {
+		case volume.EmptyDir != nil:
+			sizeLimit := resource.MustParse(fmt.Sprintf("%dM", volume.EmptyDir.SizeLimit))
+			source.EmptyDir = &corev1.EmptyDirVolumeSource{
+				Medium:    corev1.StorageMedium(volume.EmptyDir.Medium),
+				SizeLimit: &sizeLimit,
+			}
+		case volume.Claim != nil:
+			source.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: *volume.Claim,
+				ReadOnly:  volume.ReadOnly,
+			}
+		case len(volume.ConfigFiles) > 0:
+			source.ConfigMap = &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: ConfigMapName(run.RunID, volume)},
+			}
+		default:
+			continue
+		}
+		volumes = append(volumes, corev1.Volume{Name: volume.Name, VolumeSource: source})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: volume.MountPath,
+			ReadOnly:  volume.ReadOnly || len(volume.ConfigFiles) > 0,
+		})
+	}
+	return mounts, volumes
+}
@@ -10,12 +10,15 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/cluster"
 	"github.com/stitchfix/flotilla-os/clients/metrics"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/adapter"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/queue"
 	"github.com/stitchfix/flotilla-os/state"
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -45,17 +48,27 @@ type EKSExecutionEngine struct {
 	s3Bucket        string
 	s3BucketRootDir string
 	statusQueue     string
+	clusters        *cluster.Registry
+	secrets         secrets.Client
+	serviceAccounts state.ServiceAccountPolicy
 }
 
 //
 // Initialize configures the EKSExecutionEngine and initializes internal clients
 //
 func (ee *EKSExecutionEngine) Initialize(conf config.Config) error {
-	clusters := strings.Split(conf.GetString("eks_clusters"), ",")
+	var err error
+	if ee.clusters == nil {
+		ee.clusters, err = cluster.NewRegistry(conf)
+		if err != nil {
+			return err
+		}
+	}
 	ee.kClients = make(map[string]kubernetes.Clientset)
 	ee.metricsClients = make(map[string]metricsv.Clientset)
 
-	for _, clusterName := range clusters {
+	// Every cluster runs may be routed or failed over to needs a client.
+	for _, clusterName := range ee.clusters.Names() {
 		filename := fmt.Sprintf("%s/%s", conf.GetString("eks_kubeconfig_basepath"), clusterName)
 		clientConf, err := clientcmd.BuildConfigFromFlags("", filename)
 		if err != nil {
@@ -83,9 +96,12 @@ func (ee *EKSExecutionEngine) Initialize(conf config.Config) error {
 	ee.jobTtl = conf.GetInt("eks_job_ttl")
 	ee.jobSA = conf.GetString("eks_service_account")
 	ee.jobARAEnabled = true
+	ee.serviceAccounts, err = state.NewServiceAccountPolicy(conf)
+	if err != nil {
+		return err
+	}
 
-
-	adapt, err := adapter.NewEKSAdapter()
+	adapt, err := adapter.NewEKSAdapter(conf)
 
 	if err != nil {
 		return err
@@ -107,42 +123,83 @@ func (ee *EKSExecutionEngine) Initialize(conf config.Config) error {
 	ee.s3BucketRootDir = conf.GetString("eks_manifest_storage_options_s3_bucket_root_dir")
 
 	ee.adapter = adapt
+
+	ee.secrets, err = secrets.NewSecretsClient(conf)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (ee *EKSExecutionEngine) Execute(executable state.Executable, run state.Run, manager state.Manager) (state.Run, bool, error) {
-	job, err := ee.adapter.AdaptFlotillaDefinitionAndRunToJob(executable, run, ee.jobSA, ee.schedulerName, manager, ee.jobARAEnabled)
-
-	kClient, err := ee.getKClient(run)
+	// The group may have lost the run's service account since it was queued.
+	if reasons := ee.serviceAccounts.Validate(run.ServiceAccount, run.GroupName); len(reasons) > 0 {
+		exitReason := strings.Join(reasons, "\n")
+		run.ExitReason = &exitReason
+		return run, false, errors.New(exitReason)
+	}
+	job, adapted, err := ee.adapter.AdaptFlotillaDefinitionAndRunToJob(executable, run, ee.jobSA, ee.schedulerName, manager, ee.jobARAEnabled)
 	if err != nil {
-		exitReason := fmt.Sprintf("Invalid cluster name - %s", run.ClusterName)
+		// Job can't be built (e.g. an unresolvable secret), don't retry.
+		exitReason := err.Error()
 		run.ExitReason = &exitReason
 		return run, false, err
 	}
+	run.AraEstimate = adapted.AraEstimate
 
-	result, err := kClient.BatchV1().Jobs(ee.jobNamespace).Create(&job)
+	// Runs queued for a cluster that has since become unhealthy go elsewhere.
+	gpu := ee.usesGpu(executable, run)
+	if !ee.clusters.IsHealthy(run.ClusterName) {
+		if next, ok := ee.clusters.Failover(run, gpu); ok {
+			run.ClusterName = next
+		}
+	}
 
-	if err != nil {
-		// Job is already submitted, don't retry
-		if strings.Contains(strings.ToLower(err.Error()), "already exists") {
-			return run, false, nil
+	var result *batchv1.Job
+	tried := make(map[string]bool)
+	for {
+		var retryable bool
+		result, retryable, err = ee.submit(&run, &job)
+		if err == nil {
+			ee.clusters.RecordSuccess(run.ClusterName)
+			break
+		}
+		if !retryable || err == errJobDeleting {
+			return run, retryable, err
 		}
 
-		// Job spec is invalid, don't retry.
-		if strings.Contains(strings.ToLower(err.Error()), "is invalid") {
-			exitReason := err.Error()
-			run.ExitReason = &exitReason
-			return run, false, err
+		// Legitimate submit error, fail over to the next healthy cluster.
+		ee.clusters.RecordFailure(run.ClusterName, err)
+		tried[run.ClusterName] = true
+		next := ""
+		for _, name := range ee.clusters.Candidates(run, gpu) {
+			if !tried[name] && ee.clusters.IsHealthy(name) {
+				next = name
+				break
+			}
+		}
+		if len(next) == 0 {
+			_ = metrics.Increment(metrics.EngineEKSExecute, []string{string(metrics.StatusFailure)}, 1)
+			return run, true, err
 		}
+		_ = ee.log.Log("message", "failing over run", "run_id", run.RunID, "from", run.ClusterName, "to", next, "error", err.Error())
+		run.ClusterName = next
+	}
 
-		// Legitimate submit error, retryable.
-		_ = metrics.Increment(metrics.EngineEKSExecute, []string{string(metrics.StatusFailure)}, 1)
-		return run, true, err
+	// Job is already submitted, don't retry
+	if result == nil {
+		return run, false, nil
 	}
 
 	var b0 bytes.Buffer
-	err = ee.serializer.Encode(result, &b0)
+	masker, err := secrets.NewMasker(ee.secrets, executable, run)
 	if err == nil {
+		err = ee.serializer.Encode(maskSecrets(masker, result), &b0)
+	}
+	if err != nil {
+		_ = ee.log.Log("message", "not storing job manifest", "run_id", run.RunID, "error", err.Error())
+	} else {
 		putObject := s3.PutObjectInput{
 			Bucket:      aws.String(ee.s3Bucket),
 			Body:        bytes.NewReader(b0.Bytes()),
@@ -157,6 +214,10 @@ func (ee *EKSExecutionEngine) Execute(executable state.Executable, run state.Run
 	}
 	_ = metrics.Increment(metrics.EngineEKSExecute, []string{string(metrics.StatusSuccess)}, 1)
 
+	if kClient, err := ee.getKClient(run); err == nil {
+		ee.ownConfigMaps(kClient, run, result)
+	}
+
 	run, _ = ee.getPodName(run)
 	adaptedRun, err := ee.adapter.AdaptJobToFlotillaRun(result, run, nil)
 
@@ -169,6 +230,92 @@ func (ee *EKSExecutionEngine) Execute(executable state.Executable, run state.Run
 	return adaptedRun, false, nil
 }
 
+var errJobDeleting = errors.New("previous job for the run is still being deleted")
+
+// submit creates the job, and the config maps it mounts, on the run's cluster.
+func (ee *EKSExecutionEngine) submit(run *state.Run, job *batchv1.Job) (*batchv1.Job, bool, error) {
+	kClient, err := ee.getKClient(*run)
+	if err != nil {
+		exitReason := fmt.Sprintf("Invalid cluster name - %s", run.ClusterName)
+		run.ExitReason = &exitReason
+		return nil, false, err
+	}
+
+	if err = ee.createConfigMaps(kClient, *run); err != nil {
+		// Retryable, nothing references the config maps yet.
+		return nil, true, err
+	}
+
+	result, err := kClient.BatchV1().Jobs(ee.jobNamespace).Create(job)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "already exists") {
+			// The job of a resubmitted run is still being deleted, retry.
+			existing, getErr := kClient.BatchV1().Jobs(ee.jobNamespace).Get(job.Name, metav1.GetOptions{})
+			if getErr == nil && existing.DeletionTimestamp != nil {
+				return nil, true, errJobDeleting
+			}
+			// Job is already submitted, don't retry
+			return nil, false, nil
+		}
+
+		// Job spec is invalid, don't retry.
+		if strings.Contains(strings.ToLower(err.Error()), "is invalid") {
+			exitReason := err.Error()
+			run.ExitReason = &exitReason
+			return nil, false, err
+		}
+
+		// Legitimate submit error, retryable.
+		return nil, true, err
+	}
+	return result, false, nil
+}
+
+// usesGpu is true when the run is routed to GPU capable clusters.
+func (ee *EKSExecutionEngine) usesGpu(executable state.Executable, run state.Run) bool {
+	resources := executable.GetExecutableResources()
+	return (run.Gpu != nil && *run.Gpu > 0) || (resources.Gpu != nil && *resources.Gpu > 0)
+}
+
+// createConfigMaps creates the config maps backing the run's config file
+// volumes; they must exist before the job's pods are scheduled.
+func (ee *EKSExecutionEngine) createConfigMaps(kClient kubernetes.Clientset, run state.Run) error {
+	for _, configMap := range adapter.ConfigMaps(run) {
+		_, err := kClient.CoreV1().ConfigMaps(ee.jobNamespace).Create(&configMap)
+		if err != nil && !strings.Contains(strings.ToLower(err.Error()), "already exists") {
+			return errors.Wrapf(err, "issue creating config map [%s]", configMap.Name)
+		}
+	}
+	return nil
+}
+
+// ownConfigMaps makes the job the owner of the run's config maps so they are
+// garbage collected with it.
+func (ee *EKSExecutionEngine) ownConfigMaps(kClient kubernetes.Clientset, run state.Run, job *batchv1.Job) {
+	for _, configMap := range adapter.ConfigMaps(run) {
+		configMap.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Name:       job.Name,
+			UID:        job.UID,
+		}}
+		if _, err := kClient.CoreV1().ConfigMaps(ee.jobNamespace).Update(&configMap); err != nil {
+			_ = ee.log.Log("message", "unable to set config map owner", "configmap", configMap.Name, "error", err.Error())
+		}
+	}
+}
+
+// maskSecrets returns a copy of the job with the values of the run's secrets
+// masked in every container, so the stored manifest doesn't leak them.
+func maskSecrets(masker secrets.Masker, job *batchv1.Job) *batchv1.Job {
+	if masker.Empty() {
+		return job
+	}
+	masked := job.DeepCopy()
+	masker.PodSpec(&masked.Spec.Template.Spec)
+	return masked
+}
+
 func (ee *EKSExecutionEngine) getPodName(run state.Run) (state.Run, error) {
 	podList, err := ee.getPodList(run)
 
@@ -180,8 +327,7 @@ func (ee *EKSExecutionEngine) getPodName(run state.Run) (state.Run, error) {
 		pod := podList.Items[len(podList.Items)-1]
 		run.PodName = &pod.Name
 		run.Namespace = &pod.Namespace
-		if pod.Spec.Containers != nil && len(pod.Spec.Containers) > 0 {
-			container := pod.Spec.Containers[len(pod.Spec.Containers)-1]
+		if container, ok := adapter.MainContainer(&pod, run); ok {
 			cpu := container.Resources.Requests.Cpu().ScaledValue(resource.Milli)
 			cpuLimit := container.Resources.Limits.Cpu().ScaledValue(resource.Milli)
 			run.Cpu = &cpu
@@ -215,11 +361,11 @@ func (ee *EKSExecutionEngine) getPodList(run state.Run) (*v1.PodList, error) {
 			return &v1.PodList{Items: []v1.Pod{*pod}}, err
 		}
 	} else {
-		if run.QueuedAt == nil {
+		submittedAt := run.SubmittedAt()
+		if submittedAt == nil {
 			return &v1.PodList{}, err
 		}
-		queuedAt := *run.QueuedAt
-		if time.Now().After(queuedAt.Add(time.Minute * time.Duration(5))) {
+		if time.Now().After(submittedAt.Add(time.Minute * time.Duration(5))) {
 			podList, err := kClient.CoreV1().Pods(ee.jobNamespace).List(metav1.ListOptions{
 				LabelSelector: fmt.Sprintf("job-name=%s", run.RunID),
 			})
@@ -406,7 +552,13 @@ func (ee *EKSExecutionEngine) FetchPodMetrics(run state.Run) (state.Run, error)
 			return run, err
 		}
 		if len(podMetrics.Containers) > 0 {
+			// Only the main container counts, sidecars are sized separately.
 			containerMetrics := podMetrics.Containers[0]
+			for _, c := range podMetrics.Containers {
+				if c.Name == run.RunID {
+					containerMetrics = c
+				}
+			}
 			mem := containerMetrics.Usage.Memory().ScaledValue(resource.Mega)
 			if run.MaxMemoryUsed == nil || *run.MaxMemoryUsed == 0 || *run.MaxMemoryUsed < mem {
 				run.MaxMemoryUsed = &mem
@@ -469,8 +621,7 @@ func (ee *EKSExecutionEngine) FetchUpdateStatus(run state.Run) (state.Run, error
 			run = ee.getInstanceDetails(*mostRecentPod, run)
 		}
 
-		if mostRecentPod != nil && mostRecentPod.Spec.Containers != nil && len(mostRecentPod.Spec.Containers) > 0 {
-			container := mostRecentPod.Spec.Containers[len(mostRecentPod.Spec.Containers)-1]
+		if container, ok := adapter.MainContainer(mostRecentPod, run); ok {
 			cpu := container.Resources.Requests.Cpu().ScaledValue(resource.Milli)
 			run.Cpu = &cpu
 			mem := container.Resources.Requests.Memory().ScaledValue(resource.Mega)
@@ -512,6 +663,25 @@ func (ee *EKSExecutionEngine) FetchUpdateStatus(run state.Run) (state.Run, error
 		}
 	}
 
+	// A pod that lost its node is recorded as interrupted; the node may be
+	// gone before its events are.
+	if mostRecentPod != nil && state.IsSpotInterruption(mostRecentPod.Status.Reason, mostRecentPod.Status.Message) &&
+		!run.PodEvents.SpotInterrupted(mostRecentPod.Name) {
+		now := time.Now()
+		var events state.PodEvents
+		if run.PodEvents != nil {
+			events = *run.PodEvents
+		}
+		events = append(events, state.PodEvent{
+			Timestamp:    &now,
+			EventType:    "Warning",
+			Reason:       mostRecentPod.Status.Reason,
+			SourceObject: mostRecentPod.Name,
+			Message:      mostRecentPod.Status.Message,
+		})
+		run.PodEvents = &events
+	}
+
 	if run.PodEvents != nil {
 		attemptCount := int64(0)
 		for _, podEvent := range *run.PodEvents {
@@ -524,7 +694,7 @@ func (ee *EKSExecutionEngine) FetchUpdateStatus(run state.Run) (state.Run, error
 
 	// Handle edge case for dangling jobs.
 	// Run used to have a pod and now it is not there, job is older than 24 hours. Terminate it.
-	if err == nil && podList != nil && podList.Items != nil && len(podList.Items) == 0 && run.PodName != nil && run.QueuedAt.Before(hoursBack) {
+	if err == nil && podList != nil && podList.Items != nil && len(podList.Items) == 0 && run.PodName != nil && run.SubmittedAt().Before(hoursBack) {
 		err = ee.Terminate(run)
 		if err == nil {
 			job.Status.Failed = 1
@@ -532,5 +702,10 @@ func (ee *EKSExecutionEngine) FetchUpdateStatus(run state.Run) (state.Run, error
 		}
 	}
 
-	return ee.adapter.AdaptJobToFlotillaRun(job, run, mostRecentPod)
+	updated, err := ee.adapter.AdaptJobToFlotillaRun(job, run, mostRecentPod)
+	// The main container exited but sidecars are keeping the job active.
+	if err == nil && updated.Status == state.StatusStopped && job.Status.Active > 0 {
+		_ = ee.Terminate(updated)
+	}
+	return updated, err
 }
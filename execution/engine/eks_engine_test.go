@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gklog "github.com/go-kit/kit/log"
+	"github.com/stitchfix/flotilla-os/clients/cluster"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/execution/adapter"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// estimatingManager estimates every run from its history and knows of no
+// failing nodes; the adapter needs nothing else of the state manager.
+type estimatingManager struct {
+	state.Manager
+}
+
+func (m estimatingManager) EstimateRunResources(executableID string, runID string, policy state.ARAPolicy) (state.TaskResources, error) {
+	return state.TaskResources{Cpu: 1000, Memory: 2048, Samples: 5}, nil
+}
+
+func (m estimatingManager) ListFailingNodes() (state.NodeList, error) {
+	return state.NodeList{}, nil
+}
+
+// submittedJobs stands in for a cluster that already has the job of every
+// run, as for a run submitted twice.
+func submittedJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/jobs") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"AlreadyExists","code":409,"message":"job already exists"}`)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func setUpEKSEngineTest(t *testing.T, handler http.HandlerFunc) *EKSExecutionEngine {
+	confDir := "../../conf"
+	c, _ := config.NewConfig(&confDir)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	kClient, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	adapt, err := adapter.NewEKSAdapter(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	clusters, err := cluster.NewRegistry(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return &EKSExecutionEngine{
+		kClients:       map[string]kubernetes.Clientset{"cluster-a": *kClient},
+		metricsClients: map[string]metricsv.Clientset{},
+		adapter:        adapt,
+		log:            flotillaLog.NewLogger(gklog.NewNopLogger(), nil),
+		jobNamespace:   "flotilla",
+		jobARAEnabled:  true,
+		schedulerName:  "default-scheduler",
+		clusters:       clusters,
+	}
+}
+
+func TestEKSExecutionEngine_ExecuteRecordsAraEstimate(t *testing.T) {
+	ee := setUpEKSEngineTest(t, submittedJobs)
+	definition := state.Definition{
+		DefinitionID: "A",
+		GroupName:    "group-a",
+		ExecutableResources: state.ExecutableResources{
+			Image: "image:a",
+		},
+	}
+	cmd := "echo hello"
+	run := state.Run{
+		RunID:       "eks-run-a",
+		GroupName:   "group-a",
+		ClusterName: "cluster-a",
+		Image:       "image:a",
+		Command:     &cmd,
+	}
+
+	executed, retryable, err := ee.Execute(definition, run, estimatingManager{})
+	if err != nil {
+		t.Fatalf("Expected run to be executed, got %v (retryable %v)", err, retryable)
+	}
+	if executed.AraEstimate == nil {
+		t.Fatalf("Expected the ARA estimate to be recorded on the run")
+	}
+	estimate := *executed.AraEstimate
+	if estimate.Source != state.ARASourceHistory || estimate.Samples != 5 || estimate.EstimatedMemory != 2048 {
+		t.Errorf("Expected the estimate from 5 runs of history, got %+v", estimate)
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	var masker secrets.Masker
+	masker.Add(corev1.EnvVar{Name: "DB_PASS", Value: "hunter2"})
+	job := &batchv1.Job{}
+	job.Spec.Template.Spec.InitContainers = []corev1.Container{
+		{Name: "init", Env: []corev1.EnvVar{{Name: "SEED_PASS", Value: "hunter2"}}},
+	}
+	job.Spec.Template.Spec.Containers = []corev1.Container{
+		{Name: "main", Env: []corev1.EnvVar{{Name: "DB_PASS", Value: "hunter2"}, {Name: "MODE", Value: "batch"}}},
+		{Name: "proxy", Env: []corev1.EnvVar{{Name: "PASSWORD", Value: "hunter2"}}},
+	}
+
+	masked := maskSecrets(masker, job)
+	spec := masked.Spec.Template.Spec
+	for _, ev := range []corev1.EnvVar{spec.InitContainers[0].Env[0], spec.Containers[0].Env[0], spec.Containers[1].Env[0]} {
+		if ev.Value != state.MaskedValue {
+			t.Errorf("Expected %s to be masked, got %s", ev.Name, ev.Value)
+		}
+	}
+	if spec.Containers[0].Env[1].Value != "batch" {
+		t.Errorf("Expected MODE to be left alone, got %s", spec.Containers[0].Env[1].Value)
+	}
+	if job.Spec.Template.Spec.Containers[1].Env[0].Value != "hunter2" {
+		t.Errorf("Expected the submitted job to keep its secret values")
+	}
+}
+
+func TestEKSExecutionEngine_GetPodNameSidecar(t *testing.T) {
+	ee := setUpEKSEngineTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/pods/eks-run-a-x1") {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"eks-run-a-x1","namespace":"flotilla"},
+				"spec":{"containers":[
+					{"name":"eks-run-a","resources":{"requests":{"cpu":"2","memory":"4000M"},"limits":{"cpu":"4","memory":"8000M"}}},
+					{"name":"proxy","resources":{"requests":{"cpu":"100m","memory":"128M"}}}]}}`)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	podName := "eks-run-a-x1"
+	run, err := ee.getPodName(state.Run{RunID: "eks-run-a", ClusterName: "cluster-a", PodName: &podName})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if run.Cpu == nil || *run.Cpu != 2000 || run.Memory == nil || *run.Memory != 4000 || *run.MemoryLimit != 8000 {
+		t.Errorf("Expected the resources of the run's container, not the sidecar's, got cpu %v memory %v", run.Cpu, run.Memory)
+	}
+}
+
+func TestEKSExecutionEngine_ExecuteChecksServiceAccount(t *testing.T) {
+	ee := setUpEKSEngineTest(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected nothing to be submitted, got %s %s", r.Method, r.URL.Path)
+	})
+	ee.serviceAccounts = state.ServiceAccountPolicy{Accounts: map[string]state.ServiceAccount{
+		"etl": {Name: "etl", Groups: []string{"data"}},
+	}}
+	etl := "etl"
+	run := state.Run{RunID: "eks-run-sa", GroupName: "web", ClusterName: "cluster-a", ServiceAccount: &etl}
+
+	executed, retryable, err := ee.Execute(state.Definition{DefinitionID: "A", GroupName: "web"}, run, estimatingManager{})
+	if err == nil || retryable {
+		t.Fatalf("Expected a run of a group no longer permitted the account to fail for good, got %v (retryable %v)", err, retryable)
+	}
+	if executed.ExitReason == nil || !strings.Contains(*executed.ExitReason, "not permitted for group [web]") {
+		t.Errorf("Expected the reason as exit reason, got %v", executed.ExitReason)
+	}
+}
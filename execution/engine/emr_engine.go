@@ -10,20 +10,28 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
 	"github.com/stitchfix/flotilla-os/clients/metrics"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
 	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/execution/adapter"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/queue"
 	"github.com/stitchfix/flotilla-os/state"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	_ "k8s.io/apimachinery/pkg/apis/meta/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8sJson "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 )
 
 //
@@ -35,7 +43,7 @@ type EMRExecutionEngine struct {
 	emrJobQueue         string
 	emrJobNamespace     string
 	emrJobRoleArn       string
-	emrJobSA            string
+	serviceAccounts     state.ServiceAccountPolicy
 	emrVirtualCluster   string
 	emrContainersClient *emrcontainers.EMRContainers
 	schedulerName       string
@@ -47,8 +55,23 @@ type EMRExecutionEngine struct {
 	s3ManifestBucket    string
 	s3ManifestBasePath  string
 	serializer          *k8sJson.Serializer
+	gpus                state.GPUCatalog
+	secrets             secrets.Client
+	kClient             *kubernetes.Clientset
+	metricsClient       *metricsv.Clientset
+	emrHistoryServer    string
+	sizing              sparkSizing
 }
 
+// Labels EMR on EKS sets on the pods of a job run.
+const (
+	emrJobIDLabel      = "emr-containers.amazonaws.com/job.id"
+	sparkAppLabel      = "spark-app-selector"
+	sparkRoleLabel     = "spark-role"
+	sparkOOMExitCode   = int32(137)
+	emrEventSourceKind = "Pod"
+)
+
 //
 // Initialize configures the EMRExecutionEngine and initializes internal clients
 //
@@ -64,14 +87,40 @@ func (emr *EMRExecutionEngine) Initialize(conf config.Config) error {
 	emr.s3EventLogPath = conf.GetString("emr_log_event_log_path")
 	emr.s3ManifestBucket = conf.GetString("emr_manifest_bucket")
 	emr.s3ManifestBasePath = conf.GetString("emr_manifest_base_path")
-	emr.emrJobSA = conf.GetString("eks_service_account")
 	emr.schedulerName = conf.GetString("eks_scheduler_name")
+	emr.emrHistoryServer = conf.GetString("emr_history_server_uri")
+
+	gpus, err := state.NewGPUCatalog(conf)
+	if err != nil {
+		return err
+	}
+	emr.gpus = gpus
+
+	emr.serviceAccounts, err = state.NewServiceAccountPolicy(conf)
+	if err != nil {
+		return err
+	}
+
+	emr.sizing, err = newSparkSizing(conf)
+	if err != nil {
+		return err
+	}
+
+	emr.secrets, err = secrets.NewSecretsClient(conf)
+	if err != nil {
+		return err
+	}
 
 	awsConfig := &aws.Config{Region: aws.String(emr.awsRegion)}
 	sess := session.Must(session.NewSessionWithOptions(session.Options{Config: *awsConfig}))
 	emr.s3Client = s3.New(sess, aws.NewConfig().WithRegion(emr.awsRegion))
 	emr.emrContainersClient = emrcontainers.New(sess, aws.NewConfig().WithRegion(emr.awsRegion))
 
+	// Job runs are still tracked through DescribeJobRun without pod access.
+	if err = emr.initializeKClient(conf); err != nil {
+		_ = emr.log.Log("message", "unable to track EMR job pods", "error", err.Error())
+	}
+
 	emr.serializer = k8sJson.NewSerializerWithOptions(
 		k8sJson.SimpleMetaFactory{}, nil, nil,
 		k8sJson.SerializerOptions{
@@ -86,7 +135,29 @@ func (emr *EMRExecutionEngine) Initialize(conf config.Config) error {
 func (emr *EMRExecutionEngine) Execute(executable state.Executable, run state.Run, manager state.Manager) (state.Run, bool, error) {
 	run = emr.estimateExecutorCount(run, manager)
 	run = emr.estimateMemoryResources(run, manager)
-	startJobRunInput := emr.generateEMRStartJobRunInput(executable, run, manager)
+	env, secret, err := emr.envOverrides(executable, run)
+	if err == nil {
+		_, err = emr.jobServiceAccount(run)
+	}
+	if err == nil {
+		err = emr.createRunSecret(secret)
+	}
+	if err == nil {
+		err = emr.createConfigMaps(run)
+	}
+	if err != nil {
+		emr.deleteRunObjects(run)
+		run.ExitReason = aws.String(fmt.Sprintf("%v", err))
+		run.ExitCode = aws.Int64(-1)
+		run.StartedAt = run.QueuedAt
+		run.FinishedAt = run.QueuedAt
+		run.Status = state.StatusStopped
+		return run, false, err
+	}
+	startJobRunInput := emr.generateEMRStartJobRunInput(executable, run, manager, env, secretMasker(secret))
+	if run.SparkExtension.SizingReport != nil {
+		run.SparkExtension.SizingReport.SparkSubmitParams = startJobRunInput.JobDriver.SparkSubmitJobDriver.SparkSubmitParameters
+	}
 	emrJobManifest := aws.String(fmt.Sprintf("%s/%s/%s.json", emr.s3ManifestBasePath, run.RunID, "start-job-run-input"))
 	obj, err := json.MarshalIndent(startJobRunInput, "", "\t")
 	if err == nil {
@@ -108,15 +179,16 @@ func (emr *EMRExecutionEngine) Execute(executable state.Executable, run state.Ru
 		run.Status = state.StatusStopped
 		_ = emr.log.Log("EMR job submission error", "error", err.Error())
 		_ = metrics.Increment(metrics.EngineEKSExecute, []string{string(metrics.StatusFailure)}, 1)
+		emr.deleteRunObjects(run)
 		return run, false, err
 	}
 	return run, false, nil
 }
 
-func (emr *EMRExecutionEngine) generateApplicationConf(executable state.Executable, run state.Run, manager state.Manager) []*emrcontainers.Configuration {
+func (emr *EMRExecutionEngine) generateApplicationConf(executable state.Executable, run state.Run, manager state.Manager, env []v1.EnvVar, masker secrets.Masker) []*emrcontainers.Configuration {
 	sparkDefaults := map[string]*string{
-		"spark.kubernetes.driver.podTemplateFile":   emr.driverPodTemplate(executable, run, manager),
-		"spark.kubernetes.executor.podTemplateFile": emr.executorPodTemplate(executable, run, manager),
+		"spark.kubernetes.driver.podTemplateFile":   emr.driverPodTemplate(executable, run, manager, env, masker),
+		"spark.kubernetes.executor.podTemplateFile": emr.executorPodTemplate(executable, run, manager, env, masker),
 		"spark.kubernetes.container.image":          &run.Image,
 		"spark.eventLog.dir":                        aws.String(fmt.Sprintf("s3a://%s/%s", emr.s3LogsBucket, emr.s3EventLogPath)),
 		"spark.history.fs.logDirectory":             aws.String(fmt.Sprintf("s3a://%s/%s", emr.s3LogsBucket, emr.s3EventLogPath)),
@@ -147,7 +219,24 @@ func (emr *EMRExecutionEngine) generateApplicationConf(executable state.Executab
 	}
 }
 
-func (emr *EMRExecutionEngine) generateEMRStartJobRunInput(executable state.Executable, run state.Run, manager state.Manager) emrcontainers.StartJobRunInput {
+//
+// jobServiceAccount is the service account of a run and the IAM role its
+// job assumes; runs without one use the default account and role. The run's
+// group must still be permitted to use the account.
+//
+func (emr *EMRExecutionEngine) jobServiceAccount(run state.Run) (state.ServiceAccount, error) {
+	if reasons := emr.serviceAccounts.Validate(run.ServiceAccount, run.GroupName); len(reasons) > 0 {
+		return state.ServiceAccount{}, errors.New(strings.Join(reasons, "\n"))
+	}
+	sa, _ := emr.serviceAccounts.Get(run.ServiceAccount)
+	if len(sa.RoleArn) == 0 {
+		sa.RoleArn = emr.emrJobRoleArn
+	}
+	return sa, nil
+}
+
+func (emr *EMRExecutionEngine) generateEMRStartJobRunInput(executable state.Executable, run state.Run, manager state.Manager, env []v1.EnvVar, masker secrets.Masker) emrcontainers.StartJobRunInput {
+	sa, _ := emr.jobServiceAccount(run)
 
 	startJobRunInput := emrcontainers.StartJobRunInput{
 		ClientToken: &run.RunID,
@@ -158,9 +247,9 @@ func (emr *EMRExecutionEngine) generateEMRStartJobRunInput(executable state.Exec
 					LogUri: aws.String(fmt.Sprintf("s3://%s/%s", emr.s3LogsBucket, emr.s3LogsBasePath)),
 				},
 			},
-			ApplicationConfiguration: emr.generateApplicationConf(executable, run, manager),
+			ApplicationConfiguration: emr.generateApplicationConf(executable, run, manager, env, masker),
 		},
-		ExecutionRoleArn: &emr.emrJobRoleArn,
+		ExecutionRoleArn: &sa.RoleArn,
 		JobDriver: &emrcontainers.JobDriver{
 			SparkSubmitJobDriver: &emrcontainers.SparkSubmitJobDriver{
 				EntryPoint:            run.SparkExtension.SparkSubmitJobDriver.EntryPoint,
@@ -180,7 +269,7 @@ func (emr *EMRExecutionEngine) generateTags(run state.Run) map[string]*string {
 		for _, ev := range *run.Env {
 			name := emr.sanitizeEnvVar(ev.Name)
 			space := regexp.MustCompile(`\s+`)
-			if len(ev.Value) < 256 && len(name) < 128 {
+			if len(ev.SecretRef) == 0 && len(ev.Value) < 256 && len(name) < 128 {
 				tags[name] = aws.String(space.ReplaceAllString(ev.Value, ""))
 			}
 		}
@@ -188,7 +277,16 @@ func (emr *EMRExecutionEngine) generateTags(run state.Run) map[string]*string {
 	return tags
 }
 
-func (emr *EMRExecutionEngine) driverPodTemplate(executable state.Executable, run state.Run, manager state.Manager) *string {
+func (emr *EMRExecutionEngine) driverPodTemplate(executable state.Executable, run state.Run, manager state.Manager, env []v1.EnvVar, masker secrets.Masker) *string {
+	pod := emr.driverPod(executable, run, manager, env)
+	key := aws.String(fmt.Sprintf("%s/%s/%s.yaml", emr.s3ManifestBasePath, run.RunID, "driver-template"))
+	return emr.writeK8ObjToS3(&pod, key, masker)
+}
+
+//
+// driverPod is the template of the run's Spark driver pod.
+//
+func (emr *EMRExecutionEngine) driverPod(executable state.Executable, run state.Run, manager state.Manager, env []v1.EnvVar) v1.Pod {
 	// Override driver pods to always be on ondemand nodetypes.
 	run.NodeLifecycle = &state.OndemandLifecycle
 	workingDir := "/var/lib/app"
@@ -213,7 +311,7 @@ func (emr *EMRExecutionEngine) driverPodTemplate(executable state.Executable, ru
 			Containers: []v1.Container{
 				{
 					Name: "spark-kubernetes-driver",
-					Env:  emr.envOverrides(executable, run),
+					Env:  env,
 					VolumeMounts: []v1.VolumeMount{
 						{
 							Name:      "shared-lib-volume",
@@ -226,7 +324,7 @@ func (emr *EMRExecutionEngine) driverPodTemplate(executable state.Executable, ru
 			InitContainers: []v1.Container{{
 				Name:  fmt.Sprintf("init-driver-%s", run.RunID),
 				Image: run.Image,
-				Env:   emr.envOverrides(executable, run),
+				Env:   env,
 				VolumeMounts: []v1.VolumeMount{
 					{
 						Name:      "shared-lib-volume",
@@ -240,11 +338,22 @@ func (emr *EMRExecutionEngine) driverPodTemplate(executable state.Executable, ru
 		},
 	}
 
-	key := aws.String(fmt.Sprintf("%s/%s/%s.yaml", emr.s3ManifestBasePath, run.RunID, "driver-template"))
-	return emr.writeK8ObjToS3(&pod, key)
+	emr.applyPlacement(executable, run, &pod)
+	emr.applyVolumes(run, &pod)
+	emr.applyServiceAccount(run, &pod)
+	return pod
+}
+
+func (emr *EMRExecutionEngine) executorPodTemplate(executable state.Executable, run state.Run, manager state.Manager, env []v1.EnvVar, masker secrets.Masker) *string {
+	pod := emr.executorPod(executable, run, manager, env)
+	key := aws.String(fmt.Sprintf("%s/%s/%s.yaml", emr.s3ManifestBasePath, run.RunID, "executor-template"))
+	return emr.writeK8ObjToS3(&pod, key, masker)
 }
 
-func (emr *EMRExecutionEngine) executorPodTemplate(executable state.Executable, run state.Run, manager state.Manager) *string {
+//
+// executorPod is the template of the run's Spark executor pods.
+//
+func (emr *EMRExecutionEngine) executorPod(executable state.Executable, run state.Run, manager state.Manager, env []v1.EnvVar) v1.Pod {
 	workingDir := "/var/lib/app"
 	if run.SparkExtension != nil && run.SparkExtension.SparkSubmitJobDriver != nil && run.SparkExtension.SparkSubmitJobDriver.WorkingDir != nil {
 		workingDir = *run.SparkExtension.SparkSubmitJobDriver.WorkingDir
@@ -268,7 +377,7 @@ func (emr *EMRExecutionEngine) executorPodTemplate(executable state.Executable,
 			Containers: []v1.Container{
 				{
 					Name: "spark-kubernetes-executor",
-					Env:  emr.envOverrides(executable, run),
+					Env:  env,
 					VolumeMounts: []v1.VolumeMount{
 						{
 							Name:      "shared-lib-volume",
@@ -281,7 +390,7 @@ func (emr *EMRExecutionEngine) executorPodTemplate(executable state.Executable,
 			InitContainers: []v1.Container{{
 				Name:  fmt.Sprintf("init-executor-%s", run.RunID),
 				Image: run.Image,
-				Env:   emr.envOverrides(executable, run),
+				Env:   env,
 				VolumeMounts: []v1.VolumeMount{
 					{
 						Name:      "shared-lib-volume",
@@ -294,11 +403,69 @@ func (emr *EMRExecutionEngine) executorPodTemplate(executable state.Executable,
 			Affinity:      emr.constructAffinity(executable, run, manager),
 		},
 	}
-	key := aws.String(fmt.Sprintf("%s/%s/%s.yaml", emr.s3ManifestBasePath, run.RunID, "executor-template"))
-	return emr.writeK8ObjToS3(&pod, key)
+	emr.applyPlacement(executable, run, &pod)
+	emr.applyVolumes(run, &pod)
+	emr.applyServiceAccount(run, &pod)
+	return pod
 }
 
-func (emr *EMRExecutionEngine) writeK8ObjToS3(obj runtime.Object, key *string) *string {
+//
+// applyServiceAccount runs the pod as the run's service account; pods of
+// runs without one are left to the job's.
+//
+func (emr *EMRExecutionEngine) applyServiceAccount(run state.Run, pod *v1.Pod) {
+	if run.ServiceAccount != nil && len(*run.ServiceAccount) > 0 {
+		pod.Spec.ServiceAccountName = *run.ServiceAccount
+	}
+}
+
+// applyVolumes mounts the run's volumes into every container of the pod.
+func (emr *EMRExecutionEngine) applyVolumes(run state.Run, pod *v1.Pod) {
+	mounts, volumes := adapter.Volumes(run)
+	if len(volumes) == 0 {
+		return
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, volumes...)
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, mounts...)
+	}
+}
+
+// applyPlacement adds the node selector and tolerations the gpu catalog
+// requires, and the run's node selectors, tolerations and topology spread,
+// to a spark pod template.
+func (emr *EMRExecutionEngine) applyPlacement(executable state.Executable, run state.Run, pod *v1.Pod) {
+	var platformSelector map[string]string
+	var platformTolerations, placementTolerations []state.Toleration
+	if gpuType, ok := adapter.GPUType(emr.gpus, executable, run); ok {
+		platformSelector = gpuType.NodeSelector
+		platformTolerations = gpuType.Tolerations
+	}
+	if run.Placement != nil {
+		placementTolerations = run.Placement.Tolerations
+	}
+	pod.Spec.NodeSelector = adapter.NodeSelector(platformSelector, run.Placement)
+	pod.Spec.Tolerations = adapter.Tolerations(platformTolerations, placementTolerations)
+	if spreads := adapter.TopologySpreadConstraints(run.Placement, adapter.SpreadGroup(executable)); len(spreads) > 0 {
+		pod.Spec.TopologySpreadConstraints = spreads
+		pod.ObjectMeta.Labels = adapter.SpreadLabels(pod.ObjectMeta.Labels, adapter.SpreadGroup(executable))
+	}
+}
+
+//
+// writeK8ObjToS3 stores a manifest of the run with its secret values masked.
+//
+func (emr *EMRExecutionEngine) writeK8ObjToS3(obj runtime.Object, key *string, masker secrets.Masker) *string {
+	switch o := obj.(type) {
+	case *v1.Pod:
+		masked := o.DeepCopy()
+		masker.PodSpec(&masked.Spec)
+		obj = masked
+	case *unstructured.Unstructured:
+		masked := o.DeepCopy()
+		masker.Unstructured(masked.Object)
+		obj = masked
+	}
 	var b0 bytes.Buffer
 	err := emr.serializer.Encode(obj, &b0)
 	payload := bytes.ReplaceAll(b0.Bytes(), []byte("status: {}"), []byte(""))
@@ -342,7 +509,7 @@ func (emr *EMRExecutionEngine) constructAffinity(executable state.Executable, ru
 	executableResources := executable.GetExecutableResources()
 	var requiredMatch []v1.NodeSelectorRequirement
 
-	gpuNodeTypes := []string{"p3.2xlarge", "p3.8xlarge", "p3.16xlarge"}
+	gpuNodeTypes := emr.gpus.InstanceTypes()
 
 	var nodeLifecycle []string
 	if run.NodeLifecycle != nil && *run.NodeLifecycle == state.OndemandLifecycle {
@@ -352,11 +519,13 @@ func (emr *EMRExecutionEngine) constructAffinity(executable state.Executable, ru
 	}
 
 	if (executableResources.Gpu == nil || *executableResources.Gpu <= 0) && (run.Gpu == nil || *run.Gpu <= 0) {
-		requiredMatch = append(requiredMatch, v1.NodeSelectorRequirement{
-			Key:      "beta.kubernetes.io/instance-type",
-			Operator: v1.NodeSelectorOpNotIn,
-			Values:   gpuNodeTypes,
-		})
+		if len(gpuNodeTypes) > 0 {
+			requiredMatch = append(requiredMatch, v1.NodeSelectorRequirement{
+				Key:      "beta.kubernetes.io/instance-type",
+				Operator: v1.NodeSelectorOpNotIn,
+				Values:   gpuNodeTypes,
+			})
+		}
 
 		nodeList, err := manager.ListFailingNodes()
 
@@ -402,56 +571,43 @@ func Max(x, y int64) int64 {
 	return x
 }
 
-func (emr *EMRExecutionEngine) estimateExecutorCount(run state.Run, manager state.Manager) state.Run {
+func (emr *EMRExecutionEngine) estimateMemoryResources(run state.Run, manager state.Manager) state.Run {
 	if run.CommandHash == nil {
 		return run
 	}
-	numExecutors, err := manager.EstimateExecutorCount(run.DefinitionID, *run.CommandHash)
-	if err != nil {
-		numExecutors = 25
-	}
-	if numExecutors > 0 {
-		run.SparkExtension.SparkSubmitJobDriver.NumExecutors = aws.Int64(numExecutors)
-		var applicationConf []state.Conf
-		for _, k := range run.SparkExtension.ApplicationConf {
-			if *k.Name == "spark.dynamicAllocation.maxExecutors" && k.Value != nil {
-				passedInValue, err := strconv.Atoi(*k.Value)
-				if err == nil {
-					numExecutors = Max(numExecutors, int64(passedInValue))
-				}
-				k.Value = aws.String(strconv.FormatInt(numExecutors, 10))
-			}
-			applicationConf = append(applicationConf, state.Conf{Name: k.Name, Value: k.Value})
+	stats, err := manager.SparkExecutorStats(run.DefinitionID, *run.CommandHash)
+	recorded := err == nil && stats.Runs > 0
+	if !recorded {
+		// Runs without executor records only tell whether a pod was OOM killed.
+		stats = state.SparkExecutorStats{}
+		if executorOOM, _ := manager.ExecutorOOM(run.DefinitionID, *run.CommandHash); executorOOM {
+			stats.ExecutorOOMs = 1
+		}
+		if driverOOM, _ := manager.DriverOOM(run.DefinitionID, *run.CommandHash); driverOOM {
+			stats.DriverOOMs = 1
 		}
-		run.SparkExtension.ApplicationConf = applicationConf
-	}
-	return run
-}
-
-func (emr *EMRExecutionEngine) estimateMemoryResources(run state.Run, manager state.Manager) state.Run {
-	if run.CommandHash == nil {
-		return run
 	}
-	executorOOM, _ := manager.ExecutorOOM(run.DefinitionID, *run.CommandHash)
-	driverOOM, _ := manager.DriverOOM(run.DefinitionID, *run.CommandHash)
 
 	var sparkSubmitConf []state.Conf
 	for _, k := range run.SparkExtension.SparkSubmitJobDriver.SparkSubmitConf {
-		if executorOOM {
-			//Bump up executors by 2x, jvm memory strings
-			if *k.Name == "spark.executor.memory" && k.Value != nil {
-				quantity := resource.MustParse(strings.ToUpper(*k.Value))
-				quantity.Set(quantity.Value() * 2)
-				k.Value = aws.String(strings.ToLower(quantity.String()))
+		requested := k.Value
+		//Bump up executors by the peak memory seen, or 2x without executor records, jvm memory strings
+		if *k.Name == "spark.executor.memory" && k.Value != nil {
+			if recorded {
+				k.Value = aws.String(sparkMemory(*k.Value, stats.ExecutorOOMs, stats.ExecutorPeakMemory))
+			} else if stats.ExecutorOOMs > 0 {
+				k.Value = aws.String(scaleSparkMemory(*k.Value, 2))
 			}
+			decideMemory(run.SparkExtension.SizingReport, *k.Name, requested, *k.Value, stats.ExecutorOOMs, stats.ExecutorPeakMemory)
 		}
-		if driverOOM {
-			//Bump up driver by 3x, jvm memory strings
-			if *k.Name == "spark.driver.memory" && k.Value != nil {
-				quantity := resource.MustParse(strings.ToUpper(*k.Value))
-				quantity.Set(quantity.Value() * 3)
-				k.Value = aws.String(strings.ToLower(quantity.String()))
+		//Bump up driver by the peak memory seen, or 3x without executor records, jvm memory strings
+		if *k.Name == "spark.driver.memory" && k.Value != nil {
+			if recorded {
+				k.Value = aws.String(sparkMemory(*k.Value, stats.DriverOOMs, stats.DriverPeakMemory))
+			} else if stats.DriverOOMs > 0 {
+				k.Value = aws.String(scaleSparkMemory(*k.Value, 3))
 			}
+			decideMemory(run.SparkExtension.SizingReport, *k.Name, requested, *k.Value, stats.DriverOOMs, stats.DriverPeakMemory)
 		}
 		sparkSubmitConf = append(sparkSubmitConf, state.Conf{Name: k.Name, Value: k.Value})
 	}
@@ -506,6 +662,7 @@ func (emr *EMRExecutionEngine) Terminate(run state.Run) error {
 	}
 
 	_, err = emr.emrContainersClient.CancelJobRun(&cancelJobRunInput)
+	emr.deleteRunObjects(run)
 	if err != nil {
 		_ = metrics.Increment(metrics.EngineEMRTerminate, []string{string(metrics.StatusFailure)}, 1)
 		_ = emr.log.Log("EMR job termination error", "error", err.Error())
@@ -575,49 +732,291 @@ func (emr *EMRExecutionEngine) Deregister(definition state.Definition) error {
 	return errors.Errorf("EMRExecutionEngine does not allow for deregistering of task definitions.")
 }
 
+//
+// sparkClusterConfig returns the client config of the EKS cluster Spark runs
+// on, `emr_eks_cluster` or else the first of `eks_cluster_override`
+//
+func sparkClusterConfig(conf config.Config) (*rest.Config, error) {
+	clusterName := conf.GetString("emr_eks_cluster")
+	if len(clusterName) == 0 {
+		if overrides := conf.GetStringSlice("eks_cluster_override"); len(overrides) > 0 {
+			clusterName = overrides[0]
+		}
+	}
+	if len(clusterName) == 0 {
+		return nil, errors.New("no EKS cluster configured for EMR")
+	}
+	filename := fmt.Sprintf("%s/%s", conf.GetString("eks_kubeconfig_basepath"), clusterName)
+	return clientcmd.BuildConfigFromFlags("", filename)
+}
+
+//
+// initializeKClient connects to the EKS cluster of the virtual cluster
+//
+func (emr *EMRExecutionEngine) initializeKClient(conf config.Config) error {
+	clientConf, err := sparkClusterConfig(conf)
+	if err != nil {
+		return err
+	}
+	if emr.kClient, err = kubernetes.NewForConfig(clientConf); err != nil {
+		return err
+	}
+	emr.metricsClient, err = metricsv.NewForConfig(clientConf)
+	return err
+}
+
+//
+// Get updates the run from its job run's state in DescribeJobRun
+//
 func (emr *EMRExecutionEngine) Get(run state.Run) (state.Run, error) {
+	if run.SparkExtension == nil || run.SparkExtension.EMRJobId == nil {
+		return run, nil
+	}
+	virtualClusterId := run.SparkExtension.VirtualClusterId
+	if virtualClusterId == nil {
+		virtualClusterId = &emr.emrVirtualCluster
+	}
+
+	start := time.Now()
+	output, err := emr.emrContainersClient.DescribeJobRun(&emrcontainers.DescribeJobRunInput{
+		Id:               run.SparkExtension.EMRJobId,
+		VirtualClusterId: virtualClusterId,
+	})
+	_ = metrics.Timing(metrics.StatusWorkerDescribeJobRun, time.Since(start), []string{}, 1)
+	if err != nil {
+		return run, errors.Wrapf(err, "problem describing emr job [%s]", *run.SparkExtension.EMRJobId)
+	}
+	if output.JobRun == nil || output.JobRun.State == nil {
+		return run, nil
+	}
+
+	at := time.Now()
+	if output.JobRun.FinishedAt != nil {
+		at = *output.JobRun.FinishedAt
+	}
+	run.ApplyEMRJobState(*output.JobRun.State, output.JobRun.StateDetails, output.JobRun.FailureReason, at)
 	return run, nil
 }
 
+//
+// jobPods lists the driver and executor pods of the run's job run or
+// SparkApplication
+//
+func (emr *EMRExecutionEngine) jobPods(run state.Run) ([]v1.Pod, error) {
+	if run.SparkExtension == nil {
+		return nil, nil
+	}
+	var selector string
+	switch {
+	case run.SparkExtension.EMRJobId != nil:
+		selector = fmt.Sprintf("%s=%s", emrJobIDLabel, *run.SparkExtension.EMRJobId)
+	case run.SparkExtension.SparkApplication != nil:
+		selector = fmt.Sprintf("%s=%s", sparkOperatorAppLabel, *run.SparkExtension.SparkApplication)
+	default:
+		return nil, nil
+	}
+	if emr.kClient == nil {
+		return nil, errors.New("no kubernetes client for the EMR cluster")
+	}
+	start := time.Now()
+	podList, err := emr.kClient.CoreV1().Pods(emr.emrJobNamespace).List(metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	_ = metrics.Timing(metrics.StatusWorkerGetPodList, time.Since(start), []string{}, 1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem listing pods [%s]", selector)
+	}
+	return podList.Items, nil
+}
+
 func (emr *EMRExecutionEngine) GetEvents(run state.Run) (state.PodEventList, error) {
-	return state.PodEventList{}, nil
+	pods, err := emr.jobPods(run)
+	if err != nil {
+		return state.PodEventList{}, err
+	}
+
+	var podEvents []state.PodEvent
+	for _, pod := range pods {
+		eventList, err := emr.kClient.CoreV1().Events(emr.emrJobNamespace).List(metav1.ListOptions{
+			FieldSelector: fmt.Sprintf("involvedObject.kind==%s,involvedObject.name==%s", emrEventSourceKind, pod.Name),
+		})
+		if err != nil {
+			return state.PodEventList{}, errors.Errorf("error getting kubernetes event for flotilla run %s", err)
+		}
+		for _, e := range eventList.Items {
+			eTime := e.FirstTimestamp.Time
+			podEvents = append(podEvents, state.PodEvent{
+				Message:      e.Message,
+				Timestamp:    &eTime,
+				EventType:    e.Type,
+				Reason:       e.Reason,
+				SourceObject: pod.Name,
+			})
+		}
+	}
+	return state.PodEventList{Total: len(podEvents), PodEvents: podEvents}, nil
 }
 
+//
+// FetchPodMetrics records the largest memory and cpu used by any of the
+// job run's driver and executor pods
+//
 func (emr *EMRExecutionEngine) FetchPodMetrics(run state.Run) (state.Run, error) {
+	pods, err := emr.jobPods(run)
+	if err != nil {
+		return run, err
+	}
+	if len(pods) == 0 {
+		return run, errors.New("no pod associated with the run.")
+	}
+	if emr.metricsClient == nil {
+		return run, errors.New("Metrics client not defined.")
+	}
+
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		start := time.Now()
+		podMetrics, err := emr.metricsClient.MetricsV1beta1().PodMetricses(emr.emrJobNamespace).Get(pod.Name, metav1.GetOptions{})
+		_ = metrics.Timing(metrics.StatusWorkerFetchMetrics, time.Since(start), []string{}, 1)
+		if err != nil {
+			continue
+		}
+		podMemory := int64(0)
+		for _, c := range podMetrics.Containers {
+			mem := c.Usage.Memory().ScaledValue(resource.Mega)
+			podMemory += c.Usage.Memory().Value()
+			if run.MaxMemoryUsed == nil || *run.MaxMemoryUsed < mem {
+				run.MaxMemoryUsed = &mem
+			}
+			cpu := c.Usage.Cpu().MilliValue()
+			if run.MaxCpuUsed == nil || *run.MaxCpuUsed < cpu {
+				run.MaxCpuUsed = &cpu
+			}
+		}
+		if record, ok := SparkExecutorRecord(&pod); ok {
+			record.PeakMemory = aws.Int64(podMemory >> 20)
+			run.SparkExtension.RecordExecutor(record)
+		}
+	}
 	return run, nil
 }
 
+//
+// FetchUpdateStatus reconciles the run with its job run: OOM kills and the
+// Spark application come from the job run's pods, status from DescribeJobRun.
+// Runs that haven't been submitted to EMR yet are returned unchanged.
+//
 func (emr *EMRExecutionEngine) FetchUpdateStatus(run state.Run) (state.Run, error) {
-	return run, nil
+	if run.SparkExtension == nil || run.SparkExtension.EMRJobId == nil {
+		return run, nil
+	}
+	pods, err := emr.jobPods(run)
+	if err != nil {
+		_ = emr.log.Log("message", "unable to list EMR job pods", "run_id", run.RunID, "error", err.Error())
+	}
+	run = emr.applyPods(run, pods)
+	run, err = emr.Get(run)
+	if err != nil {
+		return run, err
+	}
+	if run.Status == state.StatusStopped {
+		emr.deleteRunObjects(run)
+	}
+	return emr.applyStageSummary(run), nil
 }
-func (emr *EMRExecutionEngine) envOverrides(executable state.Executable, run state.Run) []v1.EnvVar {
-	pairs := make(map[string]string)
+
+//
+// applyPods records OOM killed containers and the Spark application of the
+// job run's pods on the run.
+//
+func (emr *EMRExecutionEngine) applyPods(run state.Run, pods []v1.Pod) state.Run {
+	for _, pod := range pods {
+		if record, ok := SparkExecutorRecord(&pod); ok {
+			run.SparkExtension.RecordExecutor(record)
+		}
+		if appId, ok := pod.Labels[sparkAppLabel]; ok && run.SparkExtension.SparkAppId == nil {
+			run.SparkExtension.SparkAppId = aws.String(appId)
+			if len(emr.emrHistoryServer) > 0 {
+				run.SparkExtension.HistoryUri = aws.String(fmt.Sprintf("%s/%s/jobs/", emr.emrHistoryServer, appId))
+			}
+		}
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.State.Terminated == nil || containerStatus.State.Terminated.ExitCode != sparkOOMExitCode {
+				continue
+			}
+			if pod.Labels[sparkRoleLabel] == state.SparkDriverRole || strings.Contains(containerStatus.Name, "driver") {
+				run.SparkExtension.DriverOOM = aws.Bool(true)
+			} else {
+				run.SparkExtension.ExecutorOOM = aws.Bool(true)
+			}
+		}
+	}
+	return run
+}
+
+//
+// envOverrides resolves the env of the run's pods. Secret values resolved
+// from the secret store go to the run's Secret, which the env references, so
+// the pod templates EMR reads from S3 hold none; the Secret is nil without any.
+//
+func (emr *EMRExecutionEngine) envOverrides(executable state.Executable, run state.Run) ([]v1.EnvVar, *v1.Secret, error) {
+	pairs := make(map[string]state.EnvVar)
 	resources := executable.GetExecutableResources()
 
 	if resources.Env != nil && len(*resources.Env) > 0 {
 		for _, ev := range *resources.Env {
 			name := emr.sanitizeEnvVar(ev.Name)
-			value := ev.Value
-			pairs[name] = value
+			pairs[name] = ev
 		}
 	}
 
 	if run.Env != nil && len(*run.Env) > 0 {
 		for _, ev := range *run.Env {
 			name := emr.sanitizeEnvVar(ev.Name)
-			value := ev.Value
-			pairs[name] = value
+			pairs[name] = ev
 		}
 	}
 
 	var res []v1.EnvVar
-	for key := range pairs {
-		if len(key) > 0 {
-			res = append(res, v1.EnvVar{
-				Name:  key,
-				Value: pairs[key],
-			})
+	var secret *v1.Secret
+	for key, ev := range pairs {
+		if len(key) == 0 {
+			continue
+		}
+		// Secret references are resolved at submit time, never stored.
+		if len(ev.SecretRef) > 0 {
+			ref, err := state.ParseSecretRef(ev.SecretRef)
+			if err != nil {
+				return nil, nil, err
+			}
+			resolved, err := emr.secrets.Resolve(key, run.GroupName, ref)
+			if err != nil {
+				return nil, nil, err
+			}
+			if resolved.ValueFrom == nil {
+				if secret == nil {
+					secret = runSecret(run)
+				}
+				secret.StringData[key] = resolved.Value
+				resolved = v1.EnvVar{
+					Name: key,
+					ValueFrom: &v1.EnvVarSource{
+						SecretKeyRef: &v1.SecretKeySelector{
+							LocalObjectReference: v1.LocalObjectReference{Name: secret.Name},
+							Key:                  key,
+						},
+					},
+				}
+			}
+			res = append(res, resolved)
+			continue
 		}
+		res = append(res, v1.EnvVar{
+			Name:  key,
+			Value: ev.Value,
+		})
 	}
 
 	res = append(res, v1.EnvVar{
@@ -629,7 +1028,99 @@ func (emr *EMRExecutionEngine) envOverrides(executable state.Executable, run sta
 			},
 		},
 	})
-	return res
+	return res, secret, nil
+}
+
+// runSecret is the Secret of the run's secret values; its pods reference it.
+func runSecret(run state.Run) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("%s-secrets", run.RunID),
+			Labels: map[string]string{"flotilla-run-id": run.RunID},
+		},
+		StringData: make(map[string]string),
+	}
+}
+
+// secretMasker masks the values of the run's Secret.
+func secretMasker(secret *v1.Secret) secrets.Masker {
+	var masker secrets.Masker
+	if secret != nil {
+		for name, value := range secret.StringData {
+			masker.Add(v1.EnvVar{Name: name, Value: value})
+		}
+	}
+	return masker
+}
+
+//
+// createRunSecret creates or, for a resubmitted run, replaces the run's
+// Secret in the job namespace
+//
+func (emr *EMRExecutionEngine) createRunSecret(secret *v1.Secret) error {
+	if secret == nil {
+		return nil
+	}
+	if emr.kClient == nil {
+		return errors.New("secrets resolved from the secret store need a kubernetes client for the EMR cluster")
+	}
+	_, err := emr.kClient.CoreV1().Secrets(emr.emrJobNamespace).Create(secret)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = emr.kClient.CoreV1().Secrets(emr.emrJobNamespace).Update(secret)
+	}
+	return errors.Wrapf(err, "issue creating secret [%s]", secret.Name)
+}
+
+//
+// deleteRunSecret deletes the run's Secret once its pods are done with it
+//
+func (emr *EMRExecutionEngine) deleteRunSecret(run state.Run) {
+	if emr.kClient == nil {
+		return
+	}
+	name := runSecret(run).Name
+	err := emr.kClient.CoreV1().Secrets(emr.emrJobNamespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		_ = emr.log.Log("message", "unable to delete run secret", "run_id", run.RunID, "error", err.Error())
+	}
+}
+
+//
+// createConfigMaps creates or, for a resubmitted run, replaces the config
+// maps backing the run's config file volumes in the job namespace
+//
+func (emr *EMRExecutionEngine) createConfigMaps(run state.Run) error {
+	for _, configMap := range adapter.ConfigMaps(run) {
+		if emr.kClient == nil {
+			return errors.New("config file volumes need a kubernetes client for the EMR cluster")
+		}
+		configMap.Labels = map[string]string{"flotilla-run-id": run.RunID}
+		_, err := emr.kClient.CoreV1().ConfigMaps(emr.emrJobNamespace).Create(&configMap)
+		if apierrors.IsAlreadyExists(err) {
+			_, err = emr.kClient.CoreV1().ConfigMaps(emr.emrJobNamespace).Update(&configMap)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "issue creating config map [%s]", configMap.Name)
+		}
+	}
+	return nil
+}
+
+//
+// deleteRunObjects deletes the run's Secret and config maps once its pods
+// are done with them
+//
+func (emr *EMRExecutionEngine) deleteRunObjects(run state.Run) {
+	emr.deleteRunSecret(run)
+	if emr.kClient == nil {
+		return
+	}
+	for _, configMap := range adapter.ConfigMaps(run) {
+		err := emr.kClient.CoreV1().ConfigMaps(emr.emrJobNamespace).Delete(configMap.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			_ = emr.log.Log("message", "unable to delete config map", "run_id", run.RunID, "configmap", configMap.Name, "error", err.Error())
+		}
+	}
 }
 
 func (emr *EMRExecutionEngine) sanitizeEnvVar(key string) string {
@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/execution/adapter"
+	"github.com/stitchfix/flotilla-os/state"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestEMRExecutionEngine_EnvOverridesSecrets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "secrets")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.json")
+	ioutil.WriteFile(path, []byte(`{"team/db": {"password": "hunter2"}}`), 0600)
+	os.Setenv("SECRETS_BACKEND", "file")
+	os.Setenv("SECRETS_FILE_PATH", path)
+	defer os.Unsetenv("SECRETS_BACKEND")
+	defer os.Unsetenv("SECRETS_FILE_PATH")
+	c, _ := config.NewConfig(nil)
+	client, err := secrets.NewSecretsClient(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	emr := &EMRExecutionEngine{secrets: client}
+	definition := state.Definition{ExecutableResources: state.ExecutableResources{
+		Env: &state.EnvList{{Name: "DB_PASS", SecretRef: "team/db#password"}, {Name: "MODE", Value: "batch"}},
+	}}
+	run := state.Run{RunID: "eks-spark-a", GroupName: "team"}
+
+	env, secret, err := emr.envOverrides(definition, run)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if secret == nil || secret.Name != "eks-spark-a-secrets" || secret.StringData["DB_PASS"] != "hunter2" {
+		t.Fatalf("Expected the secret value in the run's Secret, got %+v", secret)
+	}
+	for _, ev := range env {
+		switch ev.Name {
+		case "DB_PASS":
+			if len(ev.Value) > 0 || ev.ValueFrom == nil || ev.ValueFrom.SecretKeyRef == nil ||
+				ev.ValueFrom.SecretKeyRef.Name != secret.Name || ev.ValueFrom.SecretKeyRef.Key != "DB_PASS" {
+				t.Errorf("Expected DB_PASS to reference the run's Secret, got %+v", ev)
+			}
+		case "MODE":
+			if ev.Value != "batch" {
+				t.Errorf("Expected MODE=batch, got %s", ev.Value)
+			}
+		}
+	}
+	if masker := secretMasker(secret); masker.String("pass=hunter2") != "pass=****" {
+		t.Errorf("Expected the run's secret values to be masked")
+	}
+	if err = emr.createRunSecret(secret); err == nil {
+		t.Errorf("Expected secret values without a kubernetes client to result in error")
+	}
+}
+
+func TestEMRExecutionEngine_ConfigFileVolumes(t *testing.T) {
+	emr := &EMRExecutionEngine{}
+	run := state.Run{RunID: "eks-spark-a", SparkExtension: &state.SparkExtension{}, Volumes: &state.VolumeList{
+		{Name: "conf", MountPath: "/etc/app", ConfigFiles: map[string]string{"app.yaml": "a: 1"}},
+	}}
+	driver := emr.driverPod(state.Definition{}, run, estimatingManager{}, nil)
+	executor := emr.executorPod(state.Definition{}, run, estimatingManager{}, nil)
+	for _, pod := range []v1.Pod{driver, executor} {
+		mounted := false
+		for _, volume := range pod.Spec.Volumes {
+			if volume.ConfigMap != nil && volume.ConfigMap.Name == "eks-spark-a-conf" {
+				mounted = true
+			}
+		}
+		if !mounted {
+			t.Errorf("Expected the run's config map in the pod template, got %+v", pod.Spec.Volumes)
+		}
+	}
+	if err := emr.createConfigMaps(run); err == nil {
+		t.Errorf("Expected config files without a kubernetes client to result in error")
+	}
+	if err := emr.createConfigMaps(state.Run{RunID: "eks-spark-b"}); err != nil {
+		t.Errorf("Expected a run without config files to need no config maps, got %v", err)
+	}
+}
+
+type sparkStatsManager struct {
+	state.Manager
+	stats state.SparkExecutorStats
+}
+
+func (m sparkStatsManager) SparkExecutorStats(executableID string, commandHash string) (state.SparkExecutorStats, error) {
+	return m.stats, nil
+}
+
+func (m sparkStatsManager) ExecutorOOM(executableID string, commandHash string) (bool, error) {
+	return true, nil
+}
+
+func (m sparkStatsManager) DriverOOM(executableID string, commandHash string) (bool, error) {
+	return false, nil
+}
+
+func TestEMRExecutionEngine_EstimateMemoryResources(t *testing.T) {
+	memory := func(stats state.SparkExecutorStats) string {
+		hash := "h"
+		run := state.Run{CommandHash: &hash, SparkExtension: &state.SparkExtension{
+			SparkSubmitJobDriver: &state.SparkSubmitJobDriver{SparkSubmitConf: []state.Conf{
+				{Name: aws.String("spark.executor.memory"), Value: aws.String("4g")},
+			}},
+		}}
+		emr := &EMRExecutionEngine{}
+		run = emr.estimateMemoryResources(run, sparkStatsManager{stats: stats})
+		return *run.SparkExtension.SparkSubmitJobDriver.SparkSubmitConf[0].Value
+	}
+
+	if value := memory(state.SparkExecutorStats{Runs: 3, ExecutorOOMs: 2, ExecutorPeakMemory: aws.Int64(5000)}); value != "7500m" {
+		t.Errorf("Expected 1.5x the peak of 5000MiB, got %s", value)
+	}
+	if value := memory(state.SparkExecutorStats{Runs: 3, ExecutorOOMs: 2, ExecutorPeakMemory: aws.Int64(1000)}); value != "6144m" {
+		t.Errorf("Expected 1.5x the 4096MiB of 4g, got %s", value)
+	}
+	if value := memory(state.SparkExecutorStats{Runs: 3, ExecutorOOMs: 2}); value != "4g" {
+		t.Errorf("Expected the memory to be kept without a recorded peak, got %s", value)
+	}
+	if value := memory(state.SparkExecutorStats{}); value != "8192m" {
+		t.Errorf("Expected 2x the memory of an OOM killed run without executor records, got %s", value)
+	}
+}
+
+func TestEMRExecutionEngine_ApplyPlacementLabels(t *testing.T) {
+	emr := &EMRExecutionEngine{}
+	definition := state.Definition{DefinitionID: "A"}
+	run := state.Run{
+		RunID:     "emr-a",
+		Placement: &state.Placement{TopologySpread: []state.TopologySpread{{TopologyKey: "topology.kubernetes.io/zone"}}},
+	}
+	pod := v1.Pod{}
+	pod.ObjectMeta.Labels = map[string]string{"flotilla-run-id": run.RunID}
+
+	emr.applyPlacement(definition, run, &pod)
+
+	if pod.Labels["flotilla-run-id"] != run.RunID {
+		t.Errorf("Expected the existing labels to be kept, got %v", pod.Labels)
+	}
+	if pod.Labels[adapter.SpreadGroupLabel] != adapter.SpreadGroup(definition) {
+		t.Errorf("Expected the spread group label, got %v", pod.Labels)
+	}
+}
+
+func TestEMRExecutionEngine_JobServiceAccount(t *testing.T) {
+	emr := &EMRExecutionEngine{
+		emrJobRoleArn: "arn:aws:iam::123456789012:role/flotilla-emr",
+		serviceAccounts: state.ServiceAccountPolicy{
+			Default: state.ServiceAccount{Name: "flotilla-jobs", Groups: []string{state.AllGroups}},
+			Accounts: map[string]state.ServiceAccount{
+				"etl": {Name: "etl", RoleArn: "arn:aws:iam::123456789012:role/flotilla-etl", Groups: []string{"data"}},
+			},
+		},
+	}
+	etl := "etl"
+
+	sa, err := emr.jobServiceAccount(state.Run{GroupName: "data", ServiceAccount: &etl})
+	if err != nil || sa.RoleArn != "arn:aws:iam::123456789012:role/flotilla-etl" {
+		t.Errorf("Expected the role of etl, got %v and %v", sa.RoleArn, err)
+	}
+	if _, err = emr.jobServiceAccount(state.Run{GroupName: "web", ServiceAccount: &etl}); err == nil {
+		t.Errorf("Expected etl to be rejected for a group it isn't permitted to")
+	}
+	if sa, err = emr.jobServiceAccount(state.Run{GroupName: "web"}); err != nil || sa.RoleArn != emr.emrJobRoleArn {
+		t.Errorf("Expected the default role, got %v and %v", sa.RoleArn, err)
+	}
+}
+
+func TestNewSparkSizing_InvalidPolicy(t *testing.T) {
+	for _, policies := range []string{
+		`{"default": "{\"min_executors\": 50, \"max_executors\": 10}"}`,
+		`{"etl": "{\"headroom\": 0}"}`,
+		`{"etl": "{\"max_executors\": -1}"}`,
+	} {
+		os.Setenv("SPARK_SIZING_POLICIES", policies)
+		c, _ := config.NewConfig(nil)
+		if _, err := newSparkSizing(c); err == nil {
+			t.Errorf("Expected spark sizing policies %s to be rejected", policies)
+		}
+	}
+	os.Setenv("SPARK_SIZING_POLICIES", `{"etl": "{\"max_executors\": 200}"}`)
+	defer os.Unsetenv("SPARK_SIZING_POLICIES")
+	c, _ := config.NewConfig(nil)
+	if _, err := newSparkSizing(c); err != nil {
+		t.Errorf("Expected a valid policy to be read, got %v", err)
+	}
+}
+
+func TestEMRExecutionEngine_GPUPlacement(t *testing.T) {
+	emr := &EMRExecutionEngine{gpus: state.GPUCatalog{
+		DefaultType: "a100",
+		Types: map[string]state.GPUType{
+			"a100": {
+				Name:          "a100",
+				InstanceTypes: []string{"p4d.24xlarge"},
+				NodeSelector:  map[string]string{"gpu-type": "a100"},
+				Tolerations:   []state.Toleration{{Key: "nvidia.com/gpu", Operator: "Exists", Effect: "NoSchedule"}},
+			},
+		},
+	}}
+	gpu := int64(1)
+	definition := state.Definition{DefinitionID: "A", ExecutableResources: state.ExecutableResources{Gpu: &gpu}}
+	run := state.Run{
+		RunID:          "emr-gpu",
+		SparkExtension: &state.SparkExtension{},
+		Placement: &state.Placement{
+			NodeSelector: map[string]string{"team": "ml"},
+			Tolerations:  []state.Toleration{{Key: "team", Value: "ml", Effect: "NoSchedule"}},
+		},
+	}
+
+	for role, pod := range map[string]v1.Pod{
+		"driver":   emr.driverPod(definition, run, estimatingManager{}, nil),
+		"executor": emr.executorPod(definition, run, estimatingManager{}, nil),
+	} {
+		if pod.Spec.NodeSelector["gpu-type"] != "a100" || pod.Spec.NodeSelector["team"] != "ml" {
+			t.Errorf("Expected the %s to select a100 nodes of the team, got %v", role, pod.Spec.NodeSelector)
+		}
+		if len(pod.Spec.Tolerations) != 2 || pod.Spec.Tolerations[0].Key != "nvidia.com/gpu" || pod.Spec.Tolerations[0].Operator != v1.TolerationOpExists {
+			t.Errorf("Expected the %s to tolerate gpu and team taints, got %v", role, pod.Spec.Tolerations)
+		}
+	}
+
+	cpuOnly := emr.executorPod(state.Definition{DefinitionID: "A"}, state.Run{RunID: "emr-cpu", SparkExtension: &state.SparkExtension{}}, estimatingManager{}, nil)
+	if len(cpuOnly.Spec.NodeSelector) != 0 || len(cpuOnly.Spec.Tolerations) != 0 {
+		t.Errorf("Expected no gpu placement for a run without gpus, got %v and %v", cpuOnly.Spec.NodeSelector, cpuOnly.Spec.Tolerations)
+	}
+}
@@ -3,6 +3,7 @@ package engine
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/cluster"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/log"
 	"github.com/stitchfix/flotilla-os/queue"
@@ -36,20 +37,21 @@ type RunReceipt struct {
 //
 // NewExecutionEngine initializes and returns a new Engine
 //
-func NewExecutionEngine(conf config.Config, qm queue.Manager, name string, logger log.Logger) (Engine, error) {
+func NewExecutionEngine(conf config.Config, qm queue.Manager, name string, logger log.Logger, clusters *cluster.Registry) (Engine, error) {
 	switch name {
 	case state.EKSEngine:
-		eksEng := &EKSExecutionEngine{qm: qm, log: logger}
+		eksEng := &EKSExecutionEngine{qm: qm, log: logger, clusters: clusters}
 		if err := eksEng.Initialize(conf); err != nil {
 			return nil, errors.Wrap(err, "problem initializing EKSExecutionEngine")
 		}
 		return eksEng, nil
-	case state.EKSSparkEngine:
+	case state.EKSSparkEngine, state.EKSSparkNativeEngine:
 		emrEng := &EMRExecutionEngine{sqsQueueManager: qm, log: logger}
-		if err := emrEng.Initialize(conf); err != nil {
+		sparkEng := &sparkEngines{emr: emrEng, native: &SparkNativeExecutionEngine{EMRExecutionEngine: emrEng}}
+		if err := sparkEng.Initialize(conf); err != nil {
 			return nil, errors.Wrap(err, "problem initializing EMRExecutionEngine")
 		}
-		return emrEng, nil
+		return sparkEng, nil
 	default:
 		return nil, fmt.Errorf("no Engine named [%s] was found", name)
 	}
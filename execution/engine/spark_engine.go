@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+// sparkEngines runs each Spark run on the engine it asked for, EMR on EKS or
+// the cluster's spark-operator; both share the Spark queue.
+type sparkEngines struct {
+	emr    *EMRExecutionEngine
+	native *SparkNativeExecutionEngine
+}
+
+func (se *sparkEngines) engineFor(run state.Run) Engine {
+	if run.Engine != nil && *run.Engine == state.EKSSparkNativeEngine {
+		return se.native
+	}
+	return se.emr
+}
+
+// Initialize initializes the EMR engine; the native engine is optional and
+// fails its runs when the spark-operator can't be reached.
+func (se *sparkEngines) Initialize(conf config.Config) error {
+	if err := se.emr.Initialize(conf); err != nil {
+		return err
+	}
+	if err := se.native.Initialize(conf); err != nil {
+		_ = se.emr.log.Log("message", "unable to initialize the native spark engine", "error", err.Error())
+	}
+	return nil
+}
+
+func (se *sparkEngines) Execute(executable state.Executable, run state.Run, manager state.Manager) (state.Run, bool, error) {
+	return se.engineFor(run).Execute(executable, run, manager)
+}
+
+func (se *sparkEngines) Terminate(run state.Run) error {
+	return se.engineFor(run).Terminate(run)
+}
+
+func (se *sparkEngines) Enqueue(run state.Run) error {
+	return se.emr.Enqueue(run)
+}
+
+func (se *sparkEngines) PollRuns() ([]RunReceipt, error) {
+	return se.emr.PollRuns()
+}
+
+func (se *sparkEngines) PollRunStatus() (state.Run, error) {
+	return se.emr.PollRunStatus()
+}
+
+func (se *sparkEngines) PollStatus() (RunReceipt, error) {
+	return se.emr.PollStatus()
+}
+
+func (se *sparkEngines) GetEvents(run state.Run) (state.PodEventList, error) {
+	return se.engineFor(run).GetEvents(run)
+}
+
+func (se *sparkEngines) FetchUpdateStatus(run state.Run) (state.Run, error) {
+	return se.engineFor(run).FetchUpdateStatus(run)
+}
+
+func (se *sparkEngines) FetchPodMetrics(run state.Run) (state.Run, error) {
+	return se.engineFor(run).FetchPodMetrics(run)
+}
+
+func (se *sparkEngines) Define(definition state.Definition) (state.Definition, error) {
+	return se.emr.Define(definition)
+}
+
+func (se *sparkEngines) Deregister(definition state.Definition) error {
+	return se.emr.Deregister(definition)
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stitchfix/flotilla-os/state"
+	v1 "k8s.io/api/core/v1"
+)
+
+// SparkExecutorRecord builds the executor record of a Spark driver or
+// executor pod; it is false for pods without a Spark role.
+func SparkExecutorRecord(pod *v1.Pod) (state.SparkExecutor, bool) {
+	role, ok := pod.Labels[sparkRoleLabel]
+	if !ok {
+		return state.SparkExecutor{}, false
+	}
+	record := state.SparkExecutor{PodName: pod.Name, Role: role}
+	if len(pod.Spec.NodeName) > 0 {
+		record.NodeName = aws.String(pod.Spec.NodeName)
+	}
+	if pod.Status.StartTime != nil {
+		startedAt := pod.Status.StartTime.Time
+		record.StartedAt = &startedAt
+	}
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		terminated := containerStatus.State.Terminated
+		if terminated == nil || !strings.Contains(containerStatus.Name, role) {
+			continue
+		}
+		exitCode := terminated.ExitCode
+		finishedAt := terminated.FinishedAt.Time
+		record.ExitCode = &exitCode
+		record.FinishedAt = &finishedAt
+		if len(terminated.Reason) > 0 {
+			record.ExitReason = aws.String(terminated.Reason)
+		}
+	}
+	return record, true
+}
+
+// sparkMemory returns the jvm memory string to request after OOMs: 1.5x the
+// larger of the recorded peak (in MiB) and the current value. Without a
+// peak the value is kept, the executor records can't tell what is needed.
+func sparkMemory(value string, ooms int64, peak *int64) string {
+	if ooms == 0 || peak == nil {
+		return value
+	}
+	current, ok := jvmMemory(value)
+	if !ok {
+		return value
+	}
+	return fmt.Sprintf("%dm", Max(current, *peak)*3/2)
+}
+
+// scaleSparkMemory multiplies a jvm memory string by factor, for runs
+// without executor records that only tell whether a pod was OOM killed.
+func scaleSparkMemory(value string, factor int64) string {
+	current, ok := jvmMemory(value)
+	if !ok {
+		return value
+	}
+	return fmt.Sprintf("%dm", current*factor)
+}
+
+// jvmMemory reads a jvm memory string, e.g. 512m or 4g, in MiB; the jvm's
+// k, m, g and t suffixes are binary and a bare number is bytes.
+func jvmMemory(value string) (int64, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	shift := uint(0)
+	if len(value) > 0 {
+		switch value[len(value)-1] {
+		case 'k':
+			shift = 10
+		case 'm':
+			shift = 20
+		case 'g':
+			shift = 30
+		case 't':
+			shift = 40
+		}
+	}
+	if shift > 0 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return (n << shift) >> 20, true
+}
@@ -0,0 +1,343 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/metrics"
+	"github.com/stitchfix/flotilla-os/clients/secrets"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// SparkNativeExecutionEngine submits Spark runs to the EKS cluster as
+// spark-operator SparkApplications, without an EMR virtual cluster. It shares
+// the EMR engine's pod templates, executor estimation, logs and queue.
+type SparkNativeExecutionEngine struct {
+	*EMRExecutionEngine
+	dynamicClient dynamic.Interface
+	sparkVersion  string
+}
+
+var sparkApplicationResource = schema.GroupVersionResource{
+	Group:    "sparkoperator.k8s.io",
+	Version:  "v1beta2",
+	Resource: "sparkapplications",
+}
+
+// Label the spark-operator sets on the pods of a SparkApplication.
+const sparkOperatorAppLabel = "sparkoperator.k8s.io/app-name"
+
+// Initialize connects to the spark-operator of the cluster; the EMR engine
+// it shares is initialized separately.
+func (sn *SparkNativeExecutionEngine) Initialize(conf config.Config) error {
+	sn.sparkVersion = "3.1.1"
+	if conf.IsSet("eks_spark_version") {
+		sn.sparkVersion = conf.GetString("eks_spark_version")
+	}
+	clientConf, err := sparkClusterConfig(conf)
+	if err != nil {
+		return err
+	}
+	sn.dynamicClient, err = dynamic.NewForConfig(clientConf)
+	return err
+}
+
+func (sn *SparkNativeExecutionEngine) Execute(executable state.Executable, run state.Run, manager state.Manager) (state.Run, bool, error) {
+	if sn.dynamicClient == nil {
+		return sn.stopUnsubmitted(run, errors.New("no kubernetes client for SparkApplications"))
+	}
+	run = sn.estimateExecutorCount(run, manager)
+	run = sn.estimateMemoryResources(run, manager)
+	env, secret, err := sn.envOverrides(executable, run)
+	if err != nil {
+		return sn.stopUnsubmitted(run, err)
+	}
+	// The stored manifest is only a record of the SparkApplication, so every
+	// resolved secret of the run is masked in it.
+	masker, err := secrets.NewMasker(sn.secrets, executable, run)
+	if err != nil {
+		return sn.stopUnsubmitted(run, err)
+	}
+
+	app, err := sn.sparkApplication(executable, run, manager, env)
+	if err == nil {
+		err = sn.createRunSecret(secret)
+	}
+	if err == nil {
+		err = sn.createConfigMaps(run)
+	}
+	if err != nil {
+		sn.deleteRunObjects(run)
+		return sn.stopUnsubmitted(run, err)
+	}
+	sn.writeK8ObjToS3(app, aws.String(fmt.Sprintf("%s/%s/%s.yaml", sn.s3ManifestBasePath, run.RunID, "spark-application")), masker)
+
+	_, err = sn.dynamicClient.Resource(sparkApplicationResource).Namespace(sn.emrJobNamespace).Create(app, metav1.CreateOptions{})
+	// A run resubmitted after the worker died mid submit is already there.
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		_ = sn.log.Log("SparkApplication submission error", "error", err.Error())
+		_ = metrics.Increment(metrics.EngineSparkNativeExecute, []string{string(metrics.StatusFailure)}, 1)
+		if transientSubmitError(err) {
+			// The Secret and ConfigMaps are updated in place by the retry.
+			return run, true, err
+		}
+		sn.deleteRunObjects(run)
+		return sn.stopUnsubmitted(run, err)
+	}
+	run.SparkExtension.SparkApplication = aws.String(app.GetName())
+	run.Status = state.StatusQueued
+	_ = metrics.Increment(metrics.EngineSparkNativeExecute, []string{string(metrics.StatusSuccess)}, 1)
+	return run, false, nil
+}
+
+// transientSubmitError is true for errors a later submit may not run into:
+// timeouts, throttling, server errors and an unreachable API server.
+func transientSubmitError(err error) bool {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok {
+		return true
+	}
+	code := status.Status().Code
+	return code >= 500 || code == 429 || apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err)
+}
+
+func (sn *SparkNativeExecutionEngine) stopUnsubmitted(run state.Run, err error) (state.Run, bool, error) {
+	run.ExitReason = aws.String(fmt.Sprintf("%v", err))
+	run.ExitCode = aws.Int64(-1)
+	run.StartedAt = run.QueuedAt
+	run.FinishedAt = run.QueuedAt
+	run.Status = state.StatusStopped
+	return run, false, err
+}
+
+// sparkApplication builds the run's SparkApplication from its
+// SparkSubmitJobDriver and the EMR engine's driver and executor pod templates
+func (sn *SparkNativeExecutionEngine) sparkApplication(executable state.Executable, run state.Run, manager state.Manager, env []v1.EnvVar) (*unstructured.Unstructured, error) {
+	sa, err := sn.jobServiceAccount(run)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := podTemplate(sn.driverPod(executable, run, manager, env))
+	if err != nil {
+		return nil, err
+	}
+	executor, err := podTemplate(sn.executorPod(executable, run, manager, env))
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]interface{}{"flotilla-run-id": run.RunID}
+	eventLogDir := fmt.Sprintf("s3a://%s/%s", sn.s3LogsBucket, sn.s3EventLogPath)
+	sparkConf := map[string]interface{}{
+		"spark.eventLog.enabled":                  "true",
+		"spark.eventLog.dir":                      eventLogDir,
+		"spark.history.fs.logDirectory":           eventLogDir,
+		"spark.kubernetes.driver.pod.name":        fmt.Sprintf("%s-driver", run.RunID),
+		"spark.kubernetes.executor.podNamePrefix": run.RunID,
+	}
+	for _, k := range run.SparkExtension.ApplicationConf {
+		if k.Name != nil && k.Value != nil {
+			sparkConf[*k.Name] = *k.Value
+		}
+	}
+	for _, k := range run.SparkExtension.HiveConf {
+		if k.Name != nil && k.Value != nil {
+			sparkConf[fmt.Sprintf("spark.hadoop.%s", *k.Name)] = *k.Value
+		}
+	}
+
+	driverSpec := map[string]interface{}{"labels": labels, "template": driver}
+	if len(sa.Name) > 0 {
+		driverSpec["serviceAccount"] = sa.Name
+	}
+	executorSpec := map[string]interface{}{"labels": labels, "template": executor}
+	spec := map[string]interface{}{
+		"mode":          "cluster",
+		"image":         run.Image,
+		"sparkVersion":  sn.sparkVersion,
+		"restartPolicy": map[string]interface{}{"type": "Never"},
+		"driver":        driverSpec,
+		"executor":      executorSpec,
+		"sparkConf":     sparkConf,
+	}
+
+	if job := run.SparkExtension.SparkSubmitJobDriver; job != nil {
+		for _, k := range job.SparkSubmitConf {
+			if k.Name != nil && k.Value != nil {
+				sparkConf[*k.Name] = *k.Value
+			}
+		}
+		if job.EntryPoint != nil {
+			spec["mainApplicationFile"] = *job.EntryPoint
+			spec["type"] = sparkApplicationType(*job.EntryPoint)
+		}
+		if job.Class != nil {
+			spec["mainClass"] = *job.Class
+		}
+		var arguments []interface{}
+		for _, arg := range job.EntryPointArguments {
+			if arg != nil {
+				arguments = append(arguments, *arg)
+			}
+		}
+		if len(arguments) > 0 {
+			spec["arguments"] = arguments
+		}
+		deps := map[string]interface{}{}
+		for name, values := range map[string][]string{"files": job.Files, "pyFiles": job.PyFiles, "jars": job.Jars} {
+			if len(values) > 0 {
+				var list []interface{}
+				for _, v := range values {
+					list = append(list, v)
+				}
+				deps[name] = list
+			}
+		}
+		if len(deps) > 0 {
+			spec["deps"] = deps
+		}
+		if job.NumExecutors != nil {
+			executorSpec["instances"] = *job.NumExecutors
+		}
+	}
+
+	app := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	app.SetAPIVersion(fmt.Sprintf("%s/%s", sparkApplicationResource.Group, sparkApplicationResource.Version))
+	app.SetKind("SparkApplication")
+	app.SetName(run.RunID)
+	app.SetNamespace(sn.emrJobNamespace)
+	app.SetLabels(map[string]string{"flotilla-run-id": run.RunID})
+	return app, nil
+}
+
+// podTemplate converts a pod to the template of a SparkApplication's driver
+// or executor.
+func podTemplate(pod v1.Pod) (map[string]interface{}, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pod)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem converting spark pod template")
+	}
+	return map[string]interface{}{"metadata": obj["metadata"], "spec": obj["spec"]}, nil
+}
+
+func sparkApplicationType(entryPoint string) string {
+	switch {
+	case strings.HasSuffix(entryPoint, ".py"):
+		return "Python"
+	case strings.HasSuffix(entryPoint, ".R"):
+		return "R"
+	default:
+		return "Scala"
+	}
+}
+
+// sparkApplicationState maps the state of a SparkApplication onto the EMR job
+// run states runs are updated from; it is empty while the state is unknown
+func sparkApplicationState(app *unstructured.Unstructured) (string, *string, time.Time) {
+	at := time.Now()
+	if terminated, found, _ := unstructured.NestedString(app.Object, "status", "terminationTime"); found {
+		if t, err := time.Parse(time.RFC3339, terminated); err == nil {
+			at = t
+		}
+	}
+	var details *string
+	if message, found, _ := unstructured.NestedString(app.Object, "status", "applicationState", "errorMessage"); found && len(message) > 0 {
+		details = aws.String(message)
+	}
+
+	appState, _, _ := unstructured.NestedString(app.Object, "status", "applicationState", "state")
+	switch appState {
+	case "COMPLETED":
+		return state.EMRJobCompleted, nil, at
+	case "FAILED", "SUBMISSION_FAILED":
+		return state.EMRJobFailed, details, at
+	case "RUNNING", "SUCCEEDING", "FAILING":
+		return state.EMRJobRunning, nil, at
+	case "SUBMITTED", "PENDING_RERUN", "INVALIDATING":
+		return state.EMRJobSubmitted, nil, at
+	default:
+		return "", nil, at
+	}
+}
+
+// Get updates the run from the status of its SparkApplication
+func (sn *SparkNativeExecutionEngine) Get(run state.Run) (state.Run, error) {
+	if run.SparkExtension == nil || run.SparkExtension.SparkApplication == nil {
+		return run, nil
+	}
+	if sn.dynamicClient == nil {
+		return run, errors.New("no kubernetes client for SparkApplications")
+	}
+	name := *run.SparkExtension.SparkApplication
+	app, err := sn.dynamicClient.Resource(sparkApplicationResource).Namespace(sn.emrJobNamespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		run.ApplyEMRJobState(state.EMRJobCancelled, aws.String("SparkApplication was deleted"), nil, time.Now())
+		return run, nil
+	}
+	if err != nil {
+		return run, errors.Wrapf(err, "problem getting SparkApplication [%s]", name)
+	}
+
+	if appID, found, _ := unstructured.NestedString(app.Object, "status", "sparkApplicationId"); found && len(appID) > 0 && run.SparkExtension.SparkAppId == nil {
+		run.SparkExtension.SparkAppId = aws.String(appID)
+		if len(sn.emrHistoryServer) > 0 {
+			run.SparkExtension.HistoryUri = aws.String(fmt.Sprintf("%s/%s/jobs/", sn.emrHistoryServer, appID))
+		}
+	}
+	if jobState, details, at := sparkApplicationState(app); len(jobState) > 0 {
+		run.ApplyEMRJobState(jobState, details, nil, at)
+	}
+	return run, nil
+}
+
+// FetchUpdateStatus reconciles the run with its SparkApplication and pods
+func (sn *SparkNativeExecutionEngine) FetchUpdateStatus(run state.Run) (state.Run, error) {
+	if run.SparkExtension == nil || run.SparkExtension.SparkApplication == nil {
+		return run, nil
+	}
+	pods, err := sn.jobPods(run)
+	if err != nil {
+		_ = sn.log.Log("message", "unable to list SparkApplication pods", "run_id", run.RunID, "error", err.Error())
+	}
+	run = sn.applyPods(run, pods)
+	run, err = sn.Get(run)
+	if err != nil {
+		return run, err
+	}
+	if run.Status == state.StatusStopped {
+		sn.deleteRunObjects(run)
+	}
+	return sn.applyStageSummary(run), nil
+}
+
+func (sn *SparkNativeExecutionEngine) Terminate(run state.Run) error {
+	if run.Status == state.StatusStopped {
+		return errors.New("Run is already in a stopped state.")
+	}
+	if run.SparkExtension == nil || run.SparkExtension.SparkApplication == nil {
+		return nil
+	}
+	if sn.dynamicClient == nil {
+		return errors.New("no kubernetes client for SparkApplications")
+	}
+	err := sn.dynamicClient.Resource(sparkApplicationResource).Namespace(sn.emrJobNamespace).Delete(
+		*run.SparkExtension.SparkApplication, &metav1.DeleteOptions{})
+	sn.deleteRunObjects(run)
+	if err != nil && !apierrors.IsNotFound(err) {
+		_ = metrics.Increment(metrics.EngineSparkNativeTerminate, []string{string(metrics.StatusFailure)}, 1)
+		_ = sn.log.Log("SparkApplication termination error", "error", err.Error())
+		return err
+	}
+	_ = metrics.Increment(metrics.EngineSparkNativeTerminate, []string{string(metrics.StatusSuccess)}, 1)
+	return nil
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/clients/secrets"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSparkNativeExecutionEngine_MasksManifest(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "secrets")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.json")
+	ioutil.WriteFile(path, []byte(`{"team/db": {"password": "hunter2"}}`), 0600)
+	os.Setenv("SECRETS_BACKEND", "file")
+	os.Setenv("SECRETS_FILE_PATH", path)
+	defer os.Unsetenv("SECRETS_BACKEND")
+	defer os.Unsetenv("SECRETS_FILE_PATH")
+	c, _ := config.NewConfig(nil)
+	client, err := secrets.NewSecretsClient(c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	definition := state.Definition{ExecutableResources: state.ExecutableResources{
+		Sidecars: &state.ContainerList{{Name: "proxy", Env: &state.EnvList{{Name: "PROXY_PASS", SecretRef: "team/db#password"}}}},
+	}}
+	masker, err := secrets.NewMasker(client, definition, state.Run{RunID: "native-a", GroupName: "team"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	driver, err := podTemplate(v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{
+		{Name: "spark-kubernetes-driver", Env: []v1.EnvVar{{Name: "MODE", Value: "batch"}}},
+		{Name: "proxy", Env: []v1.EnvVar{{Name: "PROXY_PASS", Value: "hunter2"}}},
+	}}})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	app := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"driver": map[string]interface{}{"template": driver}},
+	}}
+
+	masker.Unstructured(app.Object)
+	manifest := fmt.Sprintf("%v", app.Object)
+	if strings.Contains(manifest, "hunter2") || !strings.Contains(manifest, state.MaskedValue) {
+		t.Errorf("Expected the sidecar's secret masked in the SparkApplication, got %s", manifest)
+	}
+	if !strings.Contains(manifest, "batch") {
+		t.Errorf("Expected plain env values to be left alone, got %s", manifest)
+	}
+}
+
+func TestSparkNativeExecutionEngine_ExecuteWithoutClient(t *testing.T) {
+	sn := &SparkNativeExecutionEngine{EMRExecutionEngine: &EMRExecutionEngine{}}
+	run, retryable, err := sn.Execute(state.Definition{}, state.Run{RunID: "native-b"}, nil)
+	if err == nil || retryable || run.Status != state.StatusStopped {
+		t.Errorf("Expected the run to be stopped without a spark-operator client, got %s (retryable %v)", run.Status, retryable)
+	}
+}
+
+func TestTransientSubmitError(t *testing.T) {
+	resource := schema.GroupResource{Group: "sparkoperator.k8s.io", Resource: "sparkapplications"}
+	for _, err := range []error{
+		apierrors.NewServerTimeout(resource, "create", 1),
+		apierrors.NewInternalError(errors.New("etcd unavailable")),
+		apierrors.NewTooManyRequests("throttled", 1),
+		apierrors.NewTimeoutError("timeout", 1),
+		errors.New("dial tcp: connection refused"),
+	} {
+		if !transientSubmitError(err) {
+			t.Errorf("Expected [%v] to be retried", err)
+		}
+	}
+	for _, err := range []error{
+		apierrors.NewBadRequest("invalid spec"),
+		apierrors.NewForbidden(resource, "native-b", errors.New("denied")),
+	} {
+		if transientSubmitError(err) {
+			t.Errorf("Expected [%v] to stop the run", err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/httpclient"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+// Spark confs set by the sizing policy.
+const (
+	sparkMaxExecutorsConf     = "spark.dynamicAllocation.maxExecutors"
+	sparkMinExecutorsConf     = "spark.dynamicAllocation.minExecutors"
+	sparkShuffleTrackingConf  = "spark.dynamicAllocation.shuffleTracking.enabled"
+	sparkShufflePartitionConf = "spark.sql.shuffle.partitions"
+	sparkExecutorCoresConf    = "spark.executor.cores"
+	sparkHistoryTimeLayout    = "2006-01-02T15:04:05.000GMT"
+	defaultSparkSizingPolicy  = "default"
+)
+
+// sparkSizing holds the sizing policies of Spark runs and the History Server
+// their stages are read from.
+type sparkSizing struct {
+	policies   map[string]state.SparkSizingPolicy
+	historyAPI *httpclient.Client
+}
+
+// sparkStage is a stage of the History Server's REST API.
+type sparkStage struct {
+	Status            string `json:"status"`
+	NumTasks          int64  `json:"numTasks"`
+	ExecutorRunTime   int64  `json:"executorRunTime"`
+	SubmissionTime    string `json:"submissionTime"`
+	CompletionTime    string `json:"completionTime"`
+	InputBytes        int64  `json:"inputBytes"`
+	ShuffleReadBytes  int64  `json:"shuffleReadBytes"`
+	ShuffleWriteBytes int64  `json:"shuffleWriteBytes"`
+	MemoryBytesSpill  int64  `json:"memoryBytesSpilled"`
+	DiskBytesSpilled  int64  `json:"diskBytesSpilled"`
+}
+
+//
+// newSparkSizing reads the policies of groups from the hash-map of group name
+// and JSON policy in `spark_sizing_policies`; each overrides the fields of
+// the `default` policy, which overrides state.DefaultSparkSizingPolicy.
+// Stages are read from the History Server at `spark_history_api_uri`.
+//
+func newSparkSizing(conf config.Config) (sparkSizing, error) {
+	sizing := sparkSizing{policies: map[string]state.SparkSizingPolicy{
+		defaultSparkSizingPolicy: state.DefaultSparkSizingPolicy,
+	}}
+	if conf.IsSet("spark_sizing_policies") {
+		raw := conf.GetStringMapString("spark_sizing_policies")
+		if policy, ok := raw[defaultSparkSizingPolicy]; ok {
+			defaults := state.DefaultSparkSizingPolicy
+			if err := json.Unmarshal([]byte(policy), &defaults); err != nil {
+				return sizing, errors.Wrap(err, "invalid default spark sizing policy")
+			}
+			if err := defaults.Validate(); err != nil {
+				return sizing, errors.Wrap(err, "invalid default spark sizing policy")
+			}
+			sizing.policies[defaultSparkSizingPolicy] = defaults
+		}
+		for group, policy := range raw {
+			if group == defaultSparkSizingPolicy {
+				continue
+			}
+			p := sizing.policies[defaultSparkSizingPolicy]
+			if err := json.Unmarshal([]byte(policy), &p); err != nil {
+				return sizing, errors.Wrapf(err, "invalid spark sizing policy of group [%s]", group)
+			}
+			if err := p.Validate(); err != nil {
+				return sizing, errors.Wrapf(err, "invalid spark sizing policy of group [%s]", group)
+			}
+			sizing.policies[group] = p
+		}
+	}
+	if conf.IsSet("spark_history_api_uri") {
+		sizing.historyAPI = &httpclient.Client{
+			Host:       conf.GetString("spark_history_api_uri"),
+			Timeout:    10 * time.Second,
+			RetryCount: 1,
+		}
+	}
+	return sizing, nil
+}
+
+// policy returns the name and sizing policy of a group.
+func (sz sparkSizing) policy(group string) (string, state.SparkSizingPolicy) {
+	if p, ok := sz.policies[group]; ok {
+		return group, p
+	}
+	return defaultSparkSizingPolicy, sz.policies[defaultSparkSizingPolicy]
+}
+
+//
+// estimateExecutorCount sizes the executors of the run with the sizing policy
+// of its group and the executor and stage summaries of recent runs, and
+// starts the run's sizing report
+//
+func (emr *EMRExecutionEngine) estimateExecutorCount(run state.Run, manager state.Manager) state.Run {
+	if run.CommandHash == nil {
+		return run
+	}
+	name, policy := emr.sizing.policy(run.GroupName)
+	stats, err := manager.SparkSizingStats(run.DefinitionID, *run.CommandHash)
+	if err != nil {
+		stats = state.SparkSizingStats{}
+	}
+	report := &state.SparkSizingReport{Policy: name, Stats: &stats}
+	se := run.SparkExtension
+
+	cores := int64(1)
+	if value := sparkConfValue(se, sparkExecutorCoresConf); value != nil {
+		if parsed, err := strconv.ParseInt(*value, 10, 64); err == nil {
+			cores = parsed
+		}
+	}
+	requested := sparkConfValue(se, sparkMaxExecutorsConf)
+	var requestedExecutors *int64
+	if requested != nil {
+		if parsed, err := strconv.ParseInt(*requested, 10, 64); err == nil {
+			requestedExecutors = &parsed
+		}
+	}
+	executors, reason := policy.Executors(stats, requestedExecutors, cores)
+	if se.SparkSubmitJobDriver != nil {
+		se.SparkSubmitJobDriver.NumExecutors = aws.Int64(executors)
+	}
+	setSparkConf(se, sparkMaxExecutorsConf, strconv.FormatInt(executors, 10))
+	report.Decide(sparkMaxExecutorsConf, requested, strconv.FormatInt(executors, 10), reason)
+
+	if policy.ShuffleHeavy(stats) {
+		shuffle := fmt.Sprintf("shuffle heavy, %dMB shuffled in recent runs", *stats.ShuffleMB)
+		if requested := sparkConfValue(se, sparkShuffleTrackingConf); requested == nil {
+			setSparkConf(se, sparkShuffleTrackingConf, "true")
+			report.Decide(sparkShuffleTrackingConf, nil, "true", fmt.Sprintf("%s, executors holding shuffle files are kept", shuffle))
+		}
+		if requested := sparkConfValue(se, sparkShufflePartitionConf); requested == nil {
+			partitions := strconv.FormatInt(policy.ShufflePartitions(stats), 10)
+			setSparkConf(se, sparkShufflePartitionConf, partitions)
+			report.Decide(sparkShufflePartitionConf, nil, partitions, fmt.Sprintf("%s, %dMB per partition", shuffle, policy.ShufflePartitionMB))
+		}
+		min := policy.ShuffleMinExecutors(executors)
+		requested := sparkConfValue(se, sparkMinExecutorsConf)
+		if current, err := strconv.ParseInt(aws.StringValue(requested), 10, 64); err != nil || current < min {
+			setSparkConf(se, sparkMinExecutorsConf, strconv.FormatInt(min, 10))
+			report.Decide(sparkMinExecutorsConf, requested, strconv.FormatInt(min, 10), fmt.Sprintf("%s, %.2f of the maximum executors", shuffle, policy.ShuffleMinExecutorsRatio))
+		}
+	}
+	se.SizingReport = report
+	return run
+}
+
+// sparkConfValue returns the value of a Spark conf of the run's request;
+// spark-submit confs take precedence over application confs.
+func sparkConfValue(se *state.SparkExtension, name string) *string {
+	if se.SparkSubmitJobDriver != nil {
+		for _, k := range se.SparkSubmitJobDriver.SparkSubmitConf {
+			if k.Name != nil && *k.Name == name && k.Value != nil {
+				return k.Value
+			}
+		}
+	}
+	for _, k := range se.ApplicationConf {
+		if k.Name != nil && *k.Name == name && k.Value != nil {
+			return k.Value
+		}
+	}
+	return nil
+}
+
+// setSparkConf sets a Spark conf where the run's request set it, and as an
+// application conf otherwise.
+func setSparkConf(se *state.SparkExtension, name string, value string) {
+	if se.SparkSubmitJobDriver != nil {
+		for i, k := range se.SparkSubmitJobDriver.SparkSubmitConf {
+			if k.Name != nil && *k.Name == name {
+				se.SparkSubmitJobDriver.SparkSubmitConf[i] = state.Conf{Name: k.Name, Value: aws.String(value)}
+				return
+			}
+		}
+	}
+	for i, k := range se.ApplicationConf {
+		if k.Name != nil && *k.Name == name {
+			se.ApplicationConf[i] = state.Conf{Name: k.Name, Value: aws.String(value)}
+			return
+		}
+	}
+	se.ApplicationConf = append(se.ApplicationConf, state.Conf{Name: aws.String(name), Value: aws.String(value)})
+}
+
+//
+// applyStageSummary records the summary of the completed stages of a stopped
+// run's Spark application, read once from the History Server
+//
+func (emr *EMRExecutionEngine) applyStageSummary(run state.Run) state.Run {
+	se := run.SparkExtension
+	if emr.sizing.historyAPI == nil || run.Status != state.StatusStopped ||
+		se == nil || se.SparkAppId == nil || se.StageSummary != nil {
+		return run
+	}
+	var stages []sparkStage
+	path := fmt.Sprintf("/api/v1/applications/%s/stages?status=complete", *se.SparkAppId)
+	if err := emr.sizing.historyAPI.Get(path, nil, &stages); err != nil {
+		_ = emr.log.Log("message", "unable to read spark stages", "run_id", run.RunID, "error", err.Error())
+		return run
+	}
+	se.StageSummary = summarizeStages(stages)
+	return run
+}
+
+func summarizeStages(stages []sparkStage) *state.SparkStageSummary {
+	const mb = 1024 * 1024
+	summary := state.SparkStageSummary{}
+	var runTime, input, shuffleRead, shuffleWrite, spilled int64
+	for _, stage := range stages {
+		summary.Stages++
+		if stage.NumTasks > summary.MaxTasks {
+			summary.MaxTasks = stage.NumTasks
+		}
+		submitted, err := time.Parse(sparkHistoryTimeLayout, stage.SubmissionTime)
+		completed, cerr := time.Parse(sparkHistoryTimeLayout, stage.CompletionTime)
+		if err == nil && cerr == nil {
+			if seconds := int64(completed.Sub(submitted).Seconds()); seconds > summary.LongestStage {
+				summary.LongestStage = seconds
+			}
+		}
+		runTime += stage.ExecutorRunTime
+		input += stage.InputBytes
+		shuffleRead += stage.ShuffleReadBytes
+		shuffleWrite += stage.ShuffleWriteBytes
+		spilled += stage.MemoryBytesSpill + stage.DiskBytesSpilled
+	}
+	summary.ExecutorRunTime = runTime / 1000
+	summary.InputMB = input / mb
+	summary.ShuffleReadMB = shuffleRead / mb
+	summary.ShuffleWriteMB = shuffleWrite / mb
+	summary.SpilledMB = spilled / mb
+	return &summary
+}
+
+// decideMemory reports the memory of the driver or executors when OOM kills
+// of recent runs raised it.
+func decideMemory(report *state.SparkSizingReport, name string, requested *string, value string, ooms int64, peak *int64) {
+	if report == nil || ooms == 0 || (requested != nil && *requested == value) {
+		return
+	}
+	reason := fmt.Sprintf("%d OOM kills in recent runs", ooms)
+	if peak != nil {
+		reason = fmt.Sprintf("%s, 1.5x the peak of %dMiB", reason, *peak)
+	}
+	report.Decide(name, requested, value, reason)
+}
@@ -73,16 +73,40 @@ func NewApp(conf config.Config,
 	if err != nil {
 		return app, errors.Wrap(err, "problem initializing worker service")
 	}
-	definitionService, err := services.NewDefinitionService(stateManager)
+	definitionService, err := services.NewDefinitionService(conf, stateManager)
 	if err != nil {
 		return app, errors.Wrap(err, "problem initializing definition service")
 	}
 
+	reportService, err := services.NewReportService(conf, stateManager)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing report service")
+	}
+
+	recService, err := services.NewRecommendationService(conf, stateManager)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing recommendation service")
+	}
+
+	sparkUIService, err := services.NewSparkUIService(conf, stateManager)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing spark ui service")
+	}
+
+	exitRuleService, err := services.NewExitRuleService(conf, stateManager)
+	if err != nil {
+		return app, errors.Wrap(err, "problem initializing exit rule service")
+	}
+
 	ep := endpoints{
 		executionService:  executionService,
 		eksLogService:     eksLogService,
 		workerService:     workerService,
 		templateService:   templateService,
+		reportService:     reportService,
+		recService:        recService,
+		sparkUIService:    sparkUIService,
+		exitRuleService:   exitRuleService,
 		logger:            log,
 		definitionService: definitionService,
 	}
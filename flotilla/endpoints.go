@@ -11,21 +11,43 @@ import (
 	"github.com/stitchfix/flotilla-os/services"
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/utils"
+	"io"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// IdempotencyKeyHeader carries a client supplied key; retried execute
+// requests with the same key return the run created by the first.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 type endpoints struct {
 	executionService  services.ExecutionService
 	definitionService services.DefinitionService
 	templateService   services.TemplateService
 	eksLogService     services.LogService
 	workerService     services.WorkerService
+	reportService     services.ReportService
+	recService        services.RecommendationService
+	sparkUIService    services.SparkUIService
+	exitRuleService   services.ExitRuleService
 	logger            flotillaLog.Logger
 }
 
+type applyRecommendationRequest struct {
+	Approved    bool   `json:"approved"`
+	CommandHash string `json:"command_hash,omitempty"`
+}
+
+// holdRequest selects the runs of a group and/or alias to hold or release.
+type holdRequest struct {
+	GroupName string `json:"group_name,omitempty"`
+	Alias     string `json:"alias,omitempty"`
+}
+
 type listRequest struct {
 	limit      int
 	offset     int
@@ -36,8 +58,9 @@ type listRequest struct {
 }
 
 type LaunchRequest struct {
-	ClusterName *string        `json:"cluster,omitempty"`
-	Env         *state.EnvList `json:"env,omitempty"`
+	ClusterName    *string        `json:"cluster,omitempty"`
+	Env            *state.EnvList `json:"env,omitempty"`
+	IdempotencyKey *string        `json:"idempotency_key,omitempty"`
 }
 
 type LaunchRequestV2 struct {
@@ -54,6 +77,9 @@ type LaunchRequestV2 struct {
 	Env                   *state.EnvList        `json:"env,omitempty"`
 	Description           *string               `json:"description,omitempty"`
 	CommandHash           *string               `json:"command_hash,omitempty"`
+	Placement             *state.Placement      `json:"placement,omitempty"`
+	Volumes               *state.VolumeList     `json:"volumes,omitempty"`
+	IdempotencyKey        *string               `json:"idempotency_key,omitempty"`
 }
 
 //
@@ -134,6 +160,14 @@ func (ep *endpoints) decodeOrderableListRequest(r *http.Request, orderable state
 	return lr
 }
 
+// idempotencyKey prefers the Idempotency-Key header over the request's field.
+func (ep *endpoints) idempotencyKey(r *http.Request, field *string) *string {
+	if key := r.Header.Get(IdempotencyKeyHeader); len(key) > 0 {
+		return &key
+	}
+	return field
+}
+
 func (ep *endpoints) decodeRequest(r *http.Request, entity interface{}) error {
 	return json.NewDecoder(r.Body).Decode(entity)
 }
@@ -283,6 +317,49 @@ func (ep *endpoints) DeleteDefinition(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Right-sizing recommendations for a definition based on recent usage.
+func (ep *endpoints) GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	recs, err := ep.recService.Get(vars["definition_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting recommendations",
+			"operation", "GetRecommendations",
+			"error", fmt.Sprintf("%+v", err),
+			"definition_id", vars["definition_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, recs)
+	}
+}
+
+// Applies an approved recommendation to the definition's cpu and memory.
+func (ep *endpoints) ApplyRecommendation(w http.ResponseWriter, r *http.Request) {
+	var req applyRecommendationRequest
+	err := ep.decodeRequest(r, &req)
+	if err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+	if !req.Approved {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: "recommendations are only applied when approved"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	updated, err := ep.recService.Apply(vars["definition_id"], req.CommandHash)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem applying recommendation",
+			"operation", "ApplyRecommendation",
+			"error", fmt.Sprintf("%+v", err),
+			"definition_id", vars["definition_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, updated)
+	}
+}
+
 // List all runs, supports filtering based on environment variables.
 // ListRequest is object used here to construct the query.
 func (ep *endpoints) ListRuns(w http.ResponseWriter, r *http.Request) {
@@ -429,6 +506,7 @@ func (ep *endpoints) CreateRun(w http.ResponseWriter, r *http.Request) {
 			EphemeralStorage: nil,
 			NodeLifecycle:    nil,
 			CommandHash:      nil,
+			IdempotencyKey:   ep.idempotencyKey(r, lr.IdempotencyKey),
 		},
 	}
 	run, err := ep.executionService.CreateDefinitionRunByDefinitionID(vars["definition_id"], &req)
@@ -485,6 +563,7 @@ func (ep *endpoints) CreateRunV2(w http.ResponseWriter, r *http.Request) {
 			SparkExtension:   lr.SparkExtension,
 			Description:      lr.Description,
 			CommandHash:      lr.CommandHash,
+			IdempotencyKey:   ep.idempotencyKey(r, lr.IdempotencyKey),
 		},
 	}
 	run, err := ep.executionService.CreateDefinitionRunByDefinitionID(vars["definition_id"], &req)
@@ -551,6 +630,9 @@ func (ep *endpoints) CreateRunV4(w http.ResponseWriter, r *http.Request) {
 			SparkExtension:        lr.SparkExtension,
 			Description:           lr.Description,
 			CommandHash:           lr.CommandHash,
+			Placement:             lr.Placement,
+			Volumes:               lr.Volumes,
+			IdempotencyKey:        ep.idempotencyKey(r, lr.IdempotencyKey),
 		},
 	}
 
@@ -618,6 +700,9 @@ func (ep *endpoints) CreateRunByAlias(w http.ResponseWriter, r *http.Request) {
 			SparkExtension:        lr.SparkExtension,
 			Description:           lr.Description,
 			CommandHash:           lr.CommandHash,
+			Placement:             lr.Placement,
+			Volumes:               lr.Volumes,
+			IdempotencyKey:        ep.idempotencyKey(r, lr.IdempotencyKey),
 		},
 	}
 	run, err := ep.executionService.CreateDefinitionRunByAlias(vars["alias"], &req)
@@ -648,6 +733,103 @@ func (ep *endpoints) StopRun(w http.ResponseWriter, r *http.Request) {
 	ep.encodeResponse(w, map[string]bool{"terminated": true})
 }
 
+// Hold a queued run back from dispatch until it is released.
+func (ep *endpoints) HoldRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	run, err := ep.executionService.Hold(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem holding run",
+			"operation", "HoldRun",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.logger.Log("message", "run held", "run_id", run.RunID, "user", ep.ExtractUserInfo(r).Email)
+		ep.encodeResponse(w, run)
+	}
+}
+
+// Release a held run back to the queue.
+func (ep *endpoints) ReleaseRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	run, err := ep.executionService.Release(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem releasing run",
+			"operation", "ReleaseRun",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.logger.Log("message", "run released", "run_id", run.RunID, "user", ep.ExtractUserInfo(r).Email)
+		ep.encodeResponse(w, run)
+	}
+}
+
+// Hold all queued runs of a group and/or alias.
+func (ep *endpoints) HoldRuns(w http.ResponseWriter, r *http.Request) {
+	ep.transitionRuns(w, r, "HoldRuns", ep.executionService.HoldRuns)
+}
+
+// Release all held runs of a group and/or alias.
+func (ep *endpoints) ReleaseRuns(w http.ResponseWriter, r *http.Request) {
+	ep.transitionRuns(w, r, "ReleaseRuns", ep.executionService.ReleaseRuns)
+}
+
+func (ep *endpoints) transitionRuns(w http.ResponseWriter, r *http.Request, operation string, transition func(groupName string, alias string) (state.RunList, error)) {
+	var req holdRequest
+	if err := ep.decodeRequest(r, &req); err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+	runList, err := transition(req.GroupName, req.Alias)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem updating runs",
+			"operation", operation,
+			"error", fmt.Sprintf("%+v", err),
+			"group_name", req.GroupName,
+			"alias", req.Alias)
+		ep.encodeError(w, err)
+	} else {
+		ep.logger.Log("message", "runs updated", "operation", operation, "total", runList.Total, "user", ep.ExtractUserInfo(r).Email)
+		response := make(map[string]interface{})
+		response["total"] = runList.Total
+		response["history"] = runList.Runs
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Rerun a run with its stored request, applying any overrides in the body.
+func (ep *endpoints) RerunRun(w http.ResponseWriter, r *http.Request) {
+	var req state.RerunRequest
+	if err := ep.decodeRequest(r, &req); err != nil && err != io.EOF {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+	if req.ExecutionRequestCommon != nil && req.NodeLifecycle != nil &&
+		!utils.StringSliceContains(state.NodeLifeCycles, *req.NodeLifecycle) {
+		ep.encodeError(w, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("Nodelifecyle must be [normal, spot]")})
+		return
+	}
+
+	vars := mux.Vars(r)
+	run, err := ep.executionService.Rerun(vars["run_id"], &req)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem rerunning run",
+			"operation", "RerunRun",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.logger.Log("message", "run rerun", "run_id", run.RunID, "rerun_of", vars["run_id"], "user", ep.ExtractUserInfo(r).Email)
+		ep.encodeResponse(w, run)
+	}
+}
+
 // Extracts user info if present in the headers.s
 func (ep *endpoints) ExtractUserInfo(r *http.Request) state.UserInfo {
 	var userInfo state.UserInfo
@@ -713,6 +895,88 @@ func (ep *endpoints) GetEvents(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Get the driver and executor records of a Spark run.
+func (ep *endpoints) GetExecutors(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	run, err := ep.executionService.Get(vars["run_id"])
+
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting run",
+			"operation", "GetRun",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+		return
+	}
+	executorList := state.SparkExecutorList{Executors: []state.SparkExecutor{}}
+	if run.SparkExtension != nil && len(run.SparkExtension.ExecutorRecords) > 0 {
+		executorList.Total = len(run.SparkExtension.ExecutorRecords)
+		executorList.Executors = run.SparkExtension.ExecutorRecords
+		executorList.Summary = run.SparkExtension.ExecutorSummary
+	}
+	ep.encodeResponse(w, executorList)
+}
+
+// Proxy the Spark UI of a run: the driver's UI while it runs, the History
+// Server once it is done.
+func (ep *endpoints) SparkUI(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := ep.sparkUIService.Target(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem resolving spark ui",
+			"operation", "SparkUI",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+		return
+	}
+
+	marker := fmt.Sprintf("/%s/spark-ui", vars["run_id"])
+	prefix := r.URL.Path[:strings.Index(r.URL.Path, marker)+len(marker)]
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = target.Path + "/" + strings.TrimLeft(strings.TrimPrefix(req.URL.Path, prefix), "/")
+			req.Host = target.Host
+			// Flotilla's credentials stay with Flotilla.
+			req.Header.Del("Authorization")
+			req.Header.Del("Cookie")
+			// Spark prefixes the links it renders with the forwarded context.
+			req.Header.Set("X-Forwarded-Context", prefix)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if location := resp.Header.Get("Location"); len(location) > 0 {
+				resp.Header.Set("Location", sparkUILocation(location, target, prefix))
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			ep.logger.Log(
+				"message", "problem proxying spark ui",
+				"operation", "SparkUI",
+				"error", fmt.Sprintf("%+v", err),
+				"run_id", vars["run_id"])
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// sparkUILocation points redirects from the Spark UI back through the proxy.
+func sparkUILocation(location string, target *url.URL, prefix string) string {
+	for _, base := range []string{target.String(), target.Path} {
+		if len(base) > 0 && strings.HasPrefix(location, base) {
+			return prefix + strings.TrimPrefix(location, base)
+		}
+	}
+	return location
+}
+
 // Get logs for a run.
 func (ep *endpoints) GetLogs(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -790,6 +1054,185 @@ func (ep *endpoints) ListClusters(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ListClusterHealth returns the registered clusters with their routing
+// labels and health as seen by this instance.
+func (ep *endpoints) ListClusterHealth(w http.ResponseWriter, r *http.Request) {
+	clusters, err := ep.executionService.ListClusterHealth()
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem listing cluster health",
+			"operation", "ListClusterHealth",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		response := make(map[string]interface{})
+		response["clusters"] = clusters
+		ep.encodeResponse(w, response)
+	}
+}
+
+// Usage analytics and SLO report over runs, sliced by group_by and windowed
+// by since/until (RFC3339).
+func (ep *endpoints) GetUsageReport(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	req := state.UsageReportRequest{
+		GroupBy: ep.getURLParam(params, "group_by", ""),
+	}
+
+	for k, v := range map[string]**string{
+		"group_name":   &req.GroupName,
+		"alias":        &req.Alias,
+		"engine":       &req.Engine,
+		"cluster_name": &req.ClusterName,
+	} {
+		if val := ep.getURLParam(params, k, ""); len(val) > 0 {
+			*v = aws.String(val)
+		}
+	}
+
+	for k, v := range map[string]*time.Time{"since": &req.Since, "until": &req.Until} {
+		if val := ep.getURLParam(params, k, ""); len(val) > 0 {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				ep.encodeError(w, exceptions.MalformedInput{
+					ErrorString: fmt.Sprintf("%s must be an RFC3339 timestamp", k)})
+				return
+			}
+			*v = t
+		}
+	}
+
+	if val := ep.getURLParam(params, "top_exit_reasons", ""); len(val) > 0 {
+		top, err := strconv.Atoi(val)
+		if err != nil {
+			ep.encodeError(w, exceptions.MalformedInput{
+				ErrorString: "top_exit_reasons must be an integer"})
+			return
+		}
+		req.TopExitReasons = top
+	}
+
+	report, err := ep.reportService.Usage(req)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting usage report",
+			"operation", "GetUsageReport",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, report)
+	}
+}
+
+// Get the exceptions logged by failed runs, grouped by fingerprint.
+func (ep *endpoints) GetExceptionReport(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	req := state.ExceptionReportRequest{}
+
+	for k, v := range map[string]**string{
+		"group_name":    &req.GroupName,
+		"definition_id": &req.DefinitionID,
+	} {
+		if val := ep.getURLParam(params, k, ""); len(val) > 0 {
+			*v = aws.String(val)
+		}
+	}
+
+	for k, v := range map[string]*time.Time{"since": &req.Since, "until": &req.Until} {
+		if val := ep.getURLParam(params, k, ""); len(val) > 0 {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				ep.encodeError(w, exceptions.MalformedInput{
+					ErrorString: fmt.Sprintf("%s must be an RFC3339 timestamp", k)})
+				return
+			}
+			*v = t
+		}
+	}
+
+	if val := ep.getURLParam(params, "limit", ""); len(val) > 0 {
+		req.Limit, _ = strconv.Atoi(val)
+	}
+
+	report, err := ep.reportService.Exceptions(req)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting exception report",
+			"operation", "GetExceptionReport",
+			"error", fmt.Sprintf("%+v", err))
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, report)
+	}
+}
+
+// Get the exceptions found in the logs of a run.
+func (ep *endpoints) GetRunExceptions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	found, err := ep.reportService.RunExceptions(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting run exceptions",
+			"operation", "GetRunExceptions",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, found)
+	}
+}
+
+// Get the AWS calls CloudTrail attributed to a run.
+func (ep *endpoints) GetRunAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	report, err := ep.reportService.RunAccess(vars["run_id"])
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting run access report",
+			"operation", "GetRunAccess",
+			"error", fmt.Sprintf("%+v", err),
+			"run_id", vars["run_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, report)
+	}
+}
+
+// Get the AWS calls of the runs of a definition over a window.
+func (ep *endpoints) GetDefinitionAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	params := r.URL.Query()
+	req := state.AccessReportRequest{DefinitionID: vars["definition_id"]}
+
+	for k, v := range map[string]*time.Time{"since": &req.Since, "until": &req.Until} {
+		if val := ep.getURLParam(params, k, ""); len(val) > 0 {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				ep.encodeError(w, exceptions.MalformedInput{
+					ErrorString: fmt.Sprintf("%s must be an RFC3339 timestamp", k)})
+				return
+			}
+			*v = t
+		}
+	}
+
+	if val := ep.getURLParam(params, "limit", ""); len(val) > 0 {
+		req.Limit, _ = strconv.Atoi(val)
+	}
+
+	report, err := ep.reportService.DefinitionAccess(req)
+	if err != nil {
+		ep.logger.Log(
+			"message", "problem getting definition access report",
+			"operation", "GetDefinitionAccess",
+			"error", fmt.Sprintf("%+v", err),
+			"definition_id", vars["definition_id"])
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, report)
+	}
+}
+
 // List active workers.
 func (ep *endpoints) ListWorkers(w http.ResponseWriter, r *http.Request) {
 	wl, err := ep.workerService.List(state.EKSEngine)
@@ -844,6 +1287,84 @@ func (ep *endpoints) UpdateWorker(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Get whether submit workers are dispatching queued runs.
+func (ep *endpoints) GetDispatch(w http.ResponseWriter, r *http.Request) {
+	dispatch, err := ep.workerService.GetDispatch()
+	if err != nil {
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, dispatch)
+	}
+}
+
+// List the exit rules in the order they classify failed runs.
+func (ep *endpoints) ListExitRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := ep.exitRuleService.List()
+	if err != nil {
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, rules)
+	}
+}
+
+// Store an exit rule.
+func (ep *endpoints) CreateExitRule(w http.ResponseWriter, r *http.Request) {
+	var rule state.ExitRule
+	if err := ep.decodeRequest(r, &rule); err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+	created, err := ep.exitRuleService.Create(rule)
+	if err != nil {
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, created)
+	}
+}
+
+// Delete a stored exit rule.
+func (ep *endpoints) DeleteExitRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := ep.exitRuleService.Delete(vars["rule_id"]); err != nil {
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, map[string]bool{"deleted": true})
+	}
+}
+
+// Test a set of exit rules against the run exceptions of past runs.
+func (ep *endpoints) TestExitRules(w http.ResponseWriter, r *http.Request) {
+	var req state.ExitRuleTestRequest
+	if err := ep.decodeRequest(r, &req); err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+	report, err := ep.exitRuleService.Test(req)
+	if err != nil {
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, report)
+	}
+}
+
+// Pause or resume dispatch of queued runs, e.g. for maintenance windows.
+func (ep *endpoints) UpdateDispatch(w http.ResponseWriter, r *http.Request) {
+	var dispatch state.DispatchState
+	if err := ep.decodeRequest(r, &dispatch); err != nil {
+		ep.encodeError(w, exceptions.MalformedInput{ErrorString: err.Error()})
+		return
+	}
+	if email := ep.ExtractUserInfo(r).Email; len(email) > 0 {
+		dispatch.UpdatedBy = &email
+	}
+	updated, err := ep.workerService.UpdateDispatch(dispatch)
+	if err != nil {
+		ep.encodeError(w, err)
+	} else {
+		ep.encodeResponse(w, updated)
+	}
+}
+
 // Update batches of workers - used to turn on/off in bulk.
 func (ep *endpoints) BatchUpdateWorkers(w http.ResponseWriter, r *http.Request) {
 	var wks []state.Worker
@@ -890,6 +1411,7 @@ func (ep *endpoints) CreateTemplateRunByName(w http.ResponseWriter, r *http.Requ
 	}
 
 	req.Engine = &state.DefaultEngine
+	req.IdempotencyKey = ep.idempotencyKey(r, req.IdempotencyKey)
 
 	if req.NodeLifecycle != nil {
 		if !utils.StringSliceContains(state.NodeLifeCycles, *req.NodeLifecycle) {
@@ -932,6 +1454,7 @@ func (ep *endpoints) CreateTemplateRun(w http.ResponseWriter, r *http.Request) {
 	}
 
 	req.Engine = &state.DefaultEngine
+	req.IdempotencyKey = ep.idempotencyKey(r, req.IdempotencyKey)
 
 	if req.NodeLifecycle != nil {
 		if !utils.StringSliceContains(state.NodeLifeCycles, *req.NodeLifecycle) {
@@ -3,7 +3,9 @@ package flotilla
 import (
 	"bytes"
 	"encoding/json"
+	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -29,7 +31,11 @@ func setUp(t *testing.T) *mux.Router {
 				RunID:     "runA", Status: state.StatusRunning},
 			"runB": {DefinitionID: "B", ClusterName: "B",
 				GroupName: "B", RunID: "runB",
-				InstanceDNSName: "cupcakedns", InstanceID: "cupcakeid"},
+				InstanceDNSName: "cupcakedns", InstanceID: "cupcakeid",
+				CloudTrailNotifications: &state.CloudTrailNotifications{Records: []state.Record{
+					{EventSource: "s3.amazonaws.com", EventName: "GetObject"},
+					{EventSource: "s3.amazonaws.com", EventName: "PutObject", ErrorCode: "AccessDenied"},
+				}}},
 		},
 		Qurls: map[string]string{
 			"A": "a/",
@@ -37,11 +43,17 @@ func setUp(t *testing.T) *mux.Router {
 		},
 		Groups: []string{"g1", "g2", "g3"},
 		Tags:   []string{"t1", "t2", "t3"},
+		LoggedExceptions: map[string][]state.LoggedException{
+			"runB": {{RunID: "runB", Fingerprint: "f1", Kind: state.ExceptionKindJVM, ExceptionType: "java.lang.OutOfMemoryError"}},
+		},
 	}
-	ds, _ := services.NewDefinitionService(&imp)
+	ds, _ := services.NewDefinitionService(c, &imp)
 	es, _ := services.NewExecutionService(c, &imp, &imp, &imp, &imp)
 	ls, _ := services.NewLogService(&imp, &imp)
-	ep := endpoints{definitionService: ds, executionService: es, eksLogService: ls}
+	rs, _ := services.NewReportService(c, &imp)
+	recs, _ := services.NewRecommendationService(c, &imp)
+	ers, _ := services.NewExitRuleService(c, &imp)
+	ep := endpoints{definitionService: ds, executionService: es, eksLogService: ls, reportService: rs, recService: recs, exitRuleService: ers}
 	return NewRouter(ep)
 }
 
@@ -707,3 +719,232 @@ func TestEndpoints_StopRun(t *testing.T) {
 		t.Errorf("Expected [terminated] acknowledgement")
 	}
 }
+
+func TestEndpoints_GetUsageReport(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("GET", "/api/v6/reports/usage?group_by=group_name&since=2021-10-01T00:00:00Z&until=2021-10-08T00:00:00Z", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	r := state.UsageReport{}
+	err := json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if r.GroupBy != "group_name" {
+		t.Errorf("Expected group_by [group_name] but was [%s]", r.GroupBy)
+	}
+
+	if r.Total != len(r.Stats) || r.Total != 2 {
+		t.Errorf("Expected 2 usage slices but was %v", r.Total)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/reports/usage?since=yesterday", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != 400 {
+		t.Errorf("Expected status 400 for malformed since, was %v", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/reports/usage?top_exit_reasons=ten", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != 400 {
+		t.Errorf("Expected status 400 for non-numeric top_exit_reasons, was %v", w.Result().StatusCode)
+	}
+}
+
+func TestEndpoints_GetExceptionReport(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("GET", "/api/v6/reports/exceptions?definition_id=B&since=2021-10-01T00:00:00Z&until=2021-10-08T00:00:00Z", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", w.Result().StatusCode)
+	}
+	r := state.ExceptionReport{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&r); err != nil {
+		t.Errorf(err.Error())
+	}
+	if r.Total != 1 || r.Exceptions[0].Fingerprint != "f1" || r.Exceptions[0].RecentRuns[0] != "runB" {
+		t.Errorf("Expected exception f1 of runB, got %v", r.Exceptions)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/runB/exceptions", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	found := state.LoggedExceptionList{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&found); err != nil {
+		t.Errorf(err.Error())
+	}
+	if found.Total != 1 || found.Exceptions[0].ExceptionType != "java.lang.OutOfMemoryError" {
+		t.Errorf("Expected the exception of runB, got %v", found.Exceptions)
+	}
+}
+
+func TestEndpoints_GetAccess(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("GET", "/api/v6/runB/access", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", w.Result().StatusCode)
+	}
+	r := state.AccessReport{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&r); err != nil {
+		t.Errorf(err.Error())
+	}
+	if r.RunID != "runB" || r.Calls != 2 || len(r.Denied) != 1 || r.Denied[0].Action != "s3:PutObject" {
+		t.Errorf("Expected the access of runB with a denied s3:PutObject, got %v", r)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/task/B/access?since=nope", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Result().StatusCode != 400 {
+		t.Errorf("Expected status 400 for an invalid since, was %v", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v6/task/B/access", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	r = state.AccessReport{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&r); err != nil {
+		t.Errorf(err.Error())
+	}
+	if r.DefinitionID != "B" || r.Runs != 1 || len(r.PolicySuggestion.Statement) != 1 {
+		t.Errorf("Expected the access of the runs of B, got %v", r)
+	}
+}
+
+func TestEndpoints_ExitRules(t *testing.T) {
+	router := setUp(t)
+
+	rule := `{"pattern": "(?i)sqlalchemy", "category": "dependency", "reason": "Database driver error", "group_name": "g1"}`
+	req := httptest.NewRequest("POST", "/api/v6/exit-rules", bytes.NewBufferString(rule))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	created := state.ExitRule{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&created); err != nil || len(created.RuleID) == 0 {
+		t.Fatalf("Expected a stored rule, got %+v %v", created, err)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v6/exit-rules", bytes.NewBufferString(`{"pattern": "x*", "category": "oom", "reason": "r"}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Result().StatusCode != 400 {
+		t.Errorf("Expected status 400 for a pattern matching everything, was %v", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v6/exit-rules/test", bytes.NewBufferString(`{"include_current": true}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v6/exit-rules/"+created.RuleID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", w.Result().StatusCode)
+	}
+}
+
+func TestEndpoints_GetRecommendations(t *testing.T) {
+	router := setUp(t)
+
+	req := httptest.NewRequest("GET", "/api/v6/task/A/recommendations", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, was %v", resp.StatusCode)
+	}
+
+	r := state.ResourceRecommendations{}
+	err := json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if r.DefinitionID != "A" {
+		t.Errorf("Expected definition_id [A] but was [%s]", r.DefinitionID)
+	}
+
+	if r.Definition.Confidence != state.ConfidenceInsufficient {
+		t.Errorf("Expected [%s] confidence without usage but was [%s]", state.ConfidenceInsufficient, r.Definition.Confidence)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v6/task/A/recommendations/apply", bytes.NewBufferString(`{"approved":false}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != 400 {
+		t.Errorf("Expected status 400 applying an unapproved recommendation, was %v", w.Result().StatusCode)
+	}
+}
+
+func TestEndpoints_SparkUI(t *testing.T) {
+	var upstreamPath, forwardedContext, authorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		forwardedContext = r.Header.Get("X-Forwarded-Context")
+		authorization = r.Header.Get("Authorization")
+		http.Redirect(w, r, "/history/spark-0001/jobs/job/?id=1", http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	os.Setenv("EMR_HISTORY_SERVER_URI", upstream.URL+"/history")
+	defer os.Unsetenv("EMR_HISTORY_SERVER_URI")
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	appID := "spark-0001"
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			"runS": {RunID: "runS", Engine: &state.EKSSparkEngine, Status: state.StatusStopped,
+				SparkExtension: &state.SparkExtension{SparkAppId: &appID}},
+		},
+	}
+	sus, _ := services.NewSparkUIService(c, &imp)
+	router := NewRouter(endpoints{sparkUIService: sus})
+
+	req := httptest.NewRequest("GET", "/api/v6/runS/spark-ui/jobs/", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected the upstream redirect, got %v", resp.StatusCode)
+	}
+	if upstreamPath != "/history/spark-0001/jobs/" {
+		t.Errorf("Expected request proxied to the history server's app, got [%s]", upstreamPath)
+	}
+	if forwardedContext != "/api/v6/runS/spark-ui" || len(authorization) > 0 {
+		t.Errorf("Expected forwarded context and no credentials, got [%s] [%s]", forwardedContext, authorization)
+	}
+	if location := resp.Header.Get("Location"); location != "/api/v6/runS/spark-ui/jobs/job/?id=1" {
+		t.Errorf("Expected redirect through the proxy, got [%s]", location)
+	}
+}
@@ -43,6 +43,8 @@ func NewRouter(ep endpoints) *mux.Router {
 	v5.HandleFunc("/worker", ep.BatchUpdateWorkers).Methods("PUT")
 	v5.HandleFunc("/worker/{worker_type}", ep.GetWorker).Methods("GET")
 	v5.HandleFunc("/worker/{worker_type}", ep.UpdateWorker).Methods("PUT")
+	v5.HandleFunc("/dispatch", ep.GetDispatch).Methods("GET")
+	v5.HandleFunc("/dispatch", ep.UpdateDispatch).Methods("PUT")
 
 	v6 := r.PathPrefix("/api/v6").Subrouter()
 	v6.HandleFunc("/task", ep.ListDefinitions).Methods("GET")
@@ -51,12 +53,20 @@ func NewRouter(ep endpoints) *mux.Router {
 	v6.HandleFunc("/task/{definition_id}", ep.UpdateDefinition).Methods("PUT")
 	v6.HandleFunc("/task/{definition_id}", ep.DeleteDefinition).Methods("DELETE")
 	v6.HandleFunc("/task/{definition_id}/execute", ep.CreateRunV4).Methods("PUT")
+	v6.HandleFunc("/task/{definition_id}/recommendations", ep.GetRecommendations).Methods("GET")
+	v6.HandleFunc("/task/{definition_id}/recommendations/apply", ep.ApplyRecommendation).Methods("POST")
+	v6.HandleFunc("/task/{definition_id}/access", ep.GetDefinitionAccess).Methods("GET")
 	v6.HandleFunc("/task/alias/{alias}", ep.GetDefinitionByAlias).Methods("GET")
 	v6.HandleFunc("/task/alias/{alias}/execute", ep.CreateRunByAlias).Methods("PUT")
 
 	v6.HandleFunc("/history", ep.ListRuns).Methods("GET")
 	v6.HandleFunc("/history/{run_id}", ep.GetRun).Methods("GET")
 	v6.HandleFunc("/history/{run_id}/payload", ep.GetPayload).Methods("GET")
+	v6.HandleFunc("/history/{run_id}/hold", ep.HoldRun).Methods("POST")
+	v6.HandleFunc("/history/{run_id}/release", ep.ReleaseRun).Methods("POST")
+	v6.HandleFunc("/history/{run_id}/rerun", ep.RerunRun).Methods("POST")
+	v6.HandleFunc("/history/hold", ep.HoldRuns).Methods("POST")
+	v6.HandleFunc("/history/release", ep.ReleaseRuns).Methods("POST")
 	v6.HandleFunc("/task/history/{run_id}", ep.GetRun).Methods("GET")
 	v6.HandleFunc("/task/{definition_id}/history", ep.ListDefinitionRuns).Methods("GET")
 	v6.HandleFunc("/task/{definition_id}/history/{run_id}", ep.GetRun).Methods("GET")
@@ -67,7 +77,18 @@ func NewRouter(ep endpoints) *mux.Router {
 	v6.HandleFunc("/groups", ep.GetGroups).Methods("GET")
 	v6.HandleFunc("/tags", ep.GetTags).Methods("GET")
 	v6.HandleFunc("/clusters", ep.ListClusters).Methods("GET")
+	v6.HandleFunc("/clusters/health", ep.ListClusterHealth).Methods("GET")
 	v6.HandleFunc("/{run_id}/events", ep.GetEvents).Methods("GET")
+	v6.HandleFunc("/{run_id}/executors", ep.GetExecutors).Methods("GET")
+	v6.PathPrefix("/{run_id}/spark-ui").HandlerFunc(ep.SparkUI).Methods("GET", "HEAD")
+	v6.HandleFunc("/reports/usage", ep.GetUsageReport).Methods("GET")
+	v6.HandleFunc("/reports/exceptions", ep.GetExceptionReport).Methods("GET")
+	v6.HandleFunc("/{run_id}/exceptions", ep.GetRunExceptions).Methods("GET")
+	v6.HandleFunc("/{run_id}/access", ep.GetRunAccess).Methods("GET")
+	v6.HandleFunc("/exit-rules", ep.ListExitRules).Methods("GET")
+	v6.HandleFunc("/exit-rules", ep.CreateExitRule).Methods("POST")
+	v6.HandleFunc("/exit-rules/test", ep.TestExitRules).Methods("POST")
+	v6.HandleFunc("/exit-rules/{rule_id}", ep.DeleteExitRule).Methods("DELETE")
 
 	v7 := r.PathPrefix("/api/v7").Subrouter()
 	v7.HandleFunc("/template/{template_id}/execute", ep.CreateTemplateRun).Methods("PUT")
@@ -69,11 +69,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	//
+	// Get the cluster registry shared by the cluster client
+	// and the EKS execution engine of this process
+	//
+	clusterRegistry, err := cluster.NewRegistry(c)
+	if err != nil {
+		fmt.Printf("%+v\n", errors.Wrap(err, "unable to initialize EKS cluster registry"))
+		os.Exit(1)
+	}
+
 	//
 	// Get cluster client for validating definitions
 	// against execution clusters
 	//
-	eksClusterClient, err := cluster.NewClusterClient(c, state.EKSEngine)
+	eksClusterClient, err := cluster.NewClusterClient(c, state.EKSEngine, clusterRegistry)
 	if err != nil {
 		fmt.Printf("%+v\n", errors.Wrap(err, "unable to initialize EKS cluster client"))
 		//TODO
@@ -106,13 +116,13 @@ func main() {
 	// Get execution engine for interacting with backend
 	// execution management framework (eg. EKS)
 	//
-	eksExecutionEngine, err := engine.NewExecutionEngine(c, eksQueueManager, state.EKSEngine, logger)
+	eksExecutionEngine, err := engine.NewExecutionEngine(c, eksQueueManager, state.EKSEngine, logger, clusterRegistry)
 	if err != nil {
 		fmt.Printf("%+v\n", errors.Wrap(err, "unable to initialize EKS execution engine"))
 		os.Exit(1)
 	}
 
-	emrExecutionEngine, err := engine.NewExecutionEngine(c, eksQueueManager, state.EKSSparkEngine, logger)
+	emrExecutionEngine, err := engine.NewExecutionEngine(c, eksQueueManager, state.EKSSparkEngine, logger, clusterRegistry)
 	if err != nil {
 		fmt.Printf("%+v\n", errors.Wrap(err, "unable to initialize EMR execution engine"))
 		os.Exit(1)
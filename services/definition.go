@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/state"
 	"strings"
@@ -28,14 +29,27 @@ type DefinitionService interface {
 }
 
 type definitionService struct {
-	sm state.Manager
+	sm        state.Manager
+	gpus      state.GPUCatalog
+	placement state.PlacementPolicy
+	volumes   state.VolumePolicy
+	accounts  state.ServiceAccountPolicy
+	secrets   state.SecretPolicy
 }
 
 //
 // NewDefinitionService configures and returns a DefinitionService
 //
-func NewDefinitionService(stateManager state.Manager) (DefinitionService, error) {
-	ds := definitionService{sm: stateManager}
+func NewDefinitionService(conf config.Config, stateManager state.Manager) (DefinitionService, error) {
+	gpus, err := state.NewGPUCatalog(conf)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := state.NewServiceAccountPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	ds := definitionService{sm: stateManager, gpus: gpus, placement: state.NewPlacementPolicy(conf), volumes: state.NewVolumePolicy(conf), accounts: accounts, secrets: state.NewSecretPolicy(conf)}
 	return &ds, nil
 }
 
@@ -46,9 +60,15 @@ func NewDefinitionService(stateManager state.Manager) (DefinitionService, error)
 // * Stores definition using state manager
 //
 func (ds *definitionService) Create(definition *state.Definition) (state.Definition, error) {
-	if valid, reasons := definition.IsValid(); !valid {
+	if valid, reasons := definition.IsValid(ds.gpus); !valid {
 		return state.Definition{}, exceptions.MalformedInput{strings.Join(reasons, "\n")}
 	}
+	reasons := append(ds.placement.Validate(definition.Placement), ds.volumes.Validate(definition.Volumes)...)
+	reasons = append(reasons, ds.accounts.Validate(definition.ServiceAccount, definition.GroupName)...)
+	reasons = append(reasons, ds.secrets.Validate(definition.GroupName, definition.EnvLists()...)...)
+	if len(reasons) > 0 {
+		return state.Definition{}, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
 
 	exists, err := ds.aliasExists(definition.Alias)
 	if err != nil {
@@ -111,6 +131,16 @@ func (ds *definitionService) Update(definitionID string, updates state.Definitio
 	}
 
 	definition.UpdateWith(updates)
+	reasons := append(ds.gpus.Validate(definition.ExecutableResources), ds.placement.Validate(definition.Placement)...)
+	reasons = append(reasons, ds.volumes.Validate(definition.Volumes)...)
+	reasons = append(reasons, state.ValidateEnv(definition.Env)...)
+	reasons = append(reasons, state.ValidateContainers(definition.InitContainers, definition.Sidecars, definition.Volumes)...)
+	reasons = append(reasons, state.ValidateConcurrency(definition.Concurrency)...)
+	reasons = append(reasons, ds.accounts.Validate(definition.ServiceAccount, definition.GroupName)...)
+	reasons = append(reasons, ds.secrets.Validate(definition.GroupName, definition.EnvLists()...)...)
+	if len(reasons) > 0 {
+		return definition, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
 	return ds.sm.UpdateDefinition(definitionID, definition)
 }
 
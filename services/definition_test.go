@@ -1,12 +1,15 @@
 package services
 
 import (
+	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/testutils"
 	"testing"
 )
 
 func setUpDefinitionServiceTest(t *testing.T) (DefinitionService, *testutils.ImplementsAllTheThings) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
 	imp := testutils.ImplementsAllTheThings{
 		T: t,
 		Definitions: map[string]state.Definition{
@@ -23,7 +26,7 @@ func setUpDefinitionServiceTest(t *testing.T) (DefinitionService, *testutils.Imp
 			"B": "b/",
 		},
 	}
-	ds, _ := NewDefinitionService(&imp)
+	ds, _ := NewDefinitionService(c, &imp)
 	return ds, &imp
 }
 
@@ -120,3 +123,73 @@ func TestDefinitionService_Delete(t *testing.T) {
 		}
 	}
 }
+
+func TestDefinitionService_CreateGpuType(t *testing.T) {
+	ds, _ := setUpDefinitionServiceTest(t)
+	gpu := int64(1)
+	unknown := "h100"
+	def := state.Definition{
+		Alias:     "cupcake-gpu",
+		GroupName: "group-cupcake",
+		ExecutableResources: state.ExecutableResources{
+			Image:   "image:cupcake",
+			Gpu:     &gpu,
+			GpuType: &unknown,
+		},
+	}
+	if _, err := ds.Create(&def); err == nil {
+		t.Errorf("Expected definition with gpu_type not in the catalog to result in error")
+	}
+
+	known := "v100"
+	def.GpuType = &known
+	if _, err := ds.Create(&def); err != nil {
+		t.Errorf("Expected definition with catalog gpu_type to be created, got %v", err)
+	}
+}
+
+func TestDefinitionService_CreateServiceAccount(t *testing.T) {
+	ds, _ := setUpDefinitionServiceTest(t)
+	ds.(*definitionService).accounts.Accounts["etl"] = state.ServiceAccount{
+		Name:    "etl",
+		RoleArn: "arn:aws:iam::123456789012:role/flotilla-etl",
+		Groups:  []string{"group-etl"},
+	}
+	sa := "etl"
+	def := state.Definition{
+		Alias:     "cupcake-etl",
+		GroupName: "group-cupcake",
+		ExecutableResources: state.ExecutableResources{
+			Image:          "image:cupcake",
+			ServiceAccount: &sa,
+		},
+	}
+	if _, err := ds.Create(&def); err == nil {
+		t.Errorf("Expected definition with a service account not permitted for its group to result in error")
+	}
+
+	def.GroupName = "group-etl"
+	if _, err := ds.Create(&def); err != nil {
+		t.Errorf("Expected definition with a permitted service account to be created, got %v", err)
+	}
+}
+
+func TestDefinitionService_CreateSecretRef(t *testing.T) {
+	ds, _ := setUpDefinitionServiceTest(t)
+	def := state.Definition{
+		Alias:     "cupcake-secrets",
+		GroupName: "group-cupcake",
+		ExecutableResources: state.ExecutableResources{
+			Image: "image:cupcake",
+			Env:   &state.EnvList{{Name: "DB_PASS", SecretRef: "group-etl/db#password"}},
+		},
+	}
+	if _, err := ds.Create(&def); err == nil {
+		t.Errorf("Expected definition referencing another group's secret to result in error")
+	}
+
+	def.Env = &state.EnvList{{Name: "DB_PASS", SecretRef: "group-cupcake/db#password"}}
+	if _, err := ds.Create(&def); err != nil {
+		t.Errorf("Expected definition referencing its group's secret to be created, got %v", err)
+	}
+}
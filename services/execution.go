@@ -1,13 +1,12 @@
 package services
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"math/rand"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/stitchfix/flotilla-os/clients/cluster"
@@ -35,27 +34,37 @@ type ExecutionService interface {
 	Get(runID string) (state.Run, error)
 	UpdateStatus(runID string, status string, exitCode *int64, runExceptions *state.RunExceptions, exitReason *string) error
 	Terminate(runID string, userInfo state.UserInfo) error
+	Hold(runID string) (state.Run, error)
+	Release(runID string) (state.Run, error)
+	HoldRuns(groupName string, alias string) (state.RunList, error)
+	ReleaseRuns(groupName string, alias string) (state.RunList, error)
+	Rerun(runID string, req *state.RerunRequest) (state.Run, error)
 	ReservedVariables() []string
 	ListClusters() ([]string, error)
+	ListClusterHealth() ([]cluster.Cluster, error)
 	GetEvents(run state.Run) (state.PodEventList, error)
 	CreateTemplateRunByTemplateID(templateID string, req *state.TemplateExecutionRequest) (state.Run, error)
 	CreateTemplateRunByTemplateName(templateName string, templateVersion string, req *state.TemplateExecutionRequest) (state.Run, error)
 }
 
 type executionService struct {
-	stateManager             state.Manager
-	eksClusterClient         cluster.Client
-	eksExecutionEngine       engine.Engine
-	emrExecutionEngine       engine.Engine
-	reservedEnv              map[string]func(run state.Run) string
-	eksClusterOverride       string
-	eksGPUClusterOverride    string
-	checkImageValidity       bool
-	baseUri                  string
-	spotReAttemptOverride    float32
-	eksSpotOverride          bool
-	spotThresholdMinutes     float64
-	terminateJobChannel      chan state.TerminateJob
+	stateManager          state.Manager
+	eksClusterClient      cluster.Client
+	eksExecutionEngine    engine.Engine
+	emrExecutionEngine    engine.Engine
+	reservedEnv           map[string]func(run state.Run) string
+	checkImageValidity    bool
+	baseUri               string
+	spotReAttemptOverride float32
+	eksSpotOverride       bool
+	spotThresholdMinutes  float64
+	terminateJobChannel   chan state.TerminateJob
+	placementPolicy       state.PlacementPolicy
+	volumePolicy          state.VolumePolicy
+	serviceAccounts       state.ServiceAccountPolicy
+	secretPolicy          state.SecretPolicy
+	idempotencyRetention  time.Duration
+	exitClassifiers       *state.ExitClassifierCache
 }
 
 func (es *executionService) GetEvents(run state.Run) (state.PodEventList, error) {
@@ -81,8 +90,6 @@ func NewExecutionService(conf config.Config, eksExecutionEngine engine.Engine, s
 		ownerKey = "FLOTILLA_RUN_OWNER_ID"
 	}
 
-	es.eksClusterOverride = conf.GetString("eks_cluster_override")
-	es.eksGPUClusterOverride = conf.GetString("eks_gpu_cluster_override")
 	if conf.IsSet("check_image_validity") {
 		es.checkImageValidity = conf.GetBool("check_image_validity")
 	} else {
@@ -112,6 +119,23 @@ func NewExecutionService(conf config.Config, eksExecutionEngine engine.Engine, s
 		es.spotThresholdMinutes = 30.0
 	}
 
+	exitClassifiers, err := state.NewExitClassifierCache(conf)
+	if err != nil {
+		return nil, err
+	}
+	es.exitClassifiers = exitClassifiers
+
+	es.serviceAccounts, err = state.NewServiceAccountPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replays of an idempotency key return the run created with it for a day.
+	es.idempotencyRetention = 24 * time.Hour
+	if conf.IsSet("idempotency_key_retention_hours") {
+		es.idempotencyRetention = time.Duration(conf.GetInt("idempotency_key_retention_hours")) * time.Hour
+	}
+
 	es.reservedEnv = map[string]func(run state.Run) string{
 		"FLOTILLA_SERVER_MODE": func(run state.Run) string {
 			return conf.GetString("flotilla_mode")
@@ -127,6 +151,9 @@ func NewExecutionService(conf config.Config, eksExecutionEngine engine.Engine, s
 		},
 	}
 
+	es.placementPolicy = state.NewPlacementPolicy(conf)
+	es.volumePolicy = state.NewVolumePolicy(conf)
+	es.secretPolicy = state.NewSecretPolicy(conf)
 	es.terminateJobChannel = make(chan state.TerminateJob, 100)
 	return &es, nil
 }
@@ -176,10 +203,6 @@ func (es *executionService) createFromDefinition(definition state.Definition, re
 	)
 	fields := req.GetExecutionRequestCommon()
 	rand.Seed(time.Now().Unix())
-	fields.ClusterName = es.eksClusterOverride
-	if fields.Gpu != nil && *fields.Gpu > 0 {
-		fields.ClusterName = es.eksGPUClusterOverride
-	}
 	es.sanitizeExecutionRequestCommonFields(fields)
 
 	// Construct run object with StatusQueued and new UUID4 run id
@@ -188,7 +211,7 @@ func (es *executionService) createFromDefinition(definition state.Definition, re
 		return run, err
 	}
 
-	return es.createAndEnqueueRun(run)
+	return es.createAndEnqueueIdempotentRun(run, fields.IdempotencyKey)
 }
 
 func (es *executionService) constructRunFromDefinition(definition state.Definition, req *state.DefinitionExecutionRequest) (state.Run, error) {
@@ -206,6 +229,13 @@ func (es *executionService) constructRunFromDefinition(definition state.Definiti
 	if req.Description != nil {
 		run.Description = req.Description
 	}
+	if err = es.applyServiceAccount(definition, &run); err != nil {
+		return run, err
+	}
+	if reasons := es.secretPolicy.Validate(run.GroupName, run.Env); len(reasons) > 0 {
+		return run, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	es.routeRun(definition, &run)
 
 	return run, nil
 }
@@ -229,6 +259,14 @@ func (es *executionService) constructBaseRunFromExecutable(executable state.Exec
 		return run, err
 	}
 
+	reasons := append(es.placementPolicy.Validate(fields.Placement), state.ValidateEnv(fields.Env)...)
+	reasons = append(reasons, es.volumePolicy.Validate(fields.Volumes)...)
+	volumes := state.MergeVolumes(resources.Volumes, fields.Volumes)
+	reasons = append(reasons, state.ValidateMountPaths(volumes)...)
+	if len(reasons) > 0 {
+		return run, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+
 	if *fields.Engine == state.EKSEngine {
 		executableCmd, err := executable.GetExecutableCommand(req)
 		if err != nil {
@@ -255,9 +293,9 @@ func (es *executionService) constructBaseRunFromExecutable(executable state.Exec
 		}
 	}
 
-	if *fields.Engine == state.EKSSparkEngine {
+	if state.IsSparkEngine(fields.Engine) {
 		if req.GetExecutionRequestCommon().SparkExtension == nil {
-			return run, errors.New("spark_extension can't be nil, when using eks-spark engine type")
+			return run, errors.New(fmt.Sprintf("spark_extension can't be nil, when using %s engine type", *fields.Engine))
 		}
 		fields.SparkExtension = req.GetExecutionRequestCommon().SparkExtension
 		reAttemptRate, _ := es.stateManager.GetPodReAttemptRate()
@@ -289,6 +327,8 @@ func (es *executionService) constructBaseRunFromExecutable(executable state.Exec
 		TaskType:              state.DefaultTaskType,
 		SparkExtension:        fields.SparkExtension,
 		CommandHash:           fields.CommandHash,
+		Placement:             state.MergePlacement(resources.Placement, fields.Placement),
+		Volumes:               volumes,
 	}
 
 	runEnv := es.constructEnviron(run, fields.Env)
@@ -296,6 +336,36 @@ func (es *executionService) constructBaseRunFromExecutable(executable state.Exec
 	return run, nil
 }
 
+// applyServiceAccount runs a run as its executable's service account, which
+// the run's group must still be permitted to use; EMR runs assume the IAM
+// role of the account.
+func (es *executionService) applyServiceAccount(executable state.Executable, run *state.Run) error {
+	run.ServiceAccount = executable.GetExecutableResources().ServiceAccount
+	if run.ServiceAccount == nil {
+		return nil
+	}
+	reasons := es.serviceAccounts.Validate(run.ServiceAccount, run.GroupName)
+	sa, ok := es.serviceAccounts.Get(run.ServiceAccount)
+	if ok && run.Engine != nil && *run.Engine == state.EKSSparkEngine && len(sa.RoleArn) == 0 {
+		reasons = append(reasons, fmt.Sprintf("service_account [%s] has no role_arn for the %s engine", sa.Name, *run.Engine))
+	}
+	if len(reasons) > 0 {
+		return exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	return nil
+}
+
+// routeRun picks the cluster an EKS run is submitted to from the cluster
+// registry's routing rules.
+func (es *executionService) routeRun(executable state.Executable, run *state.Run) {
+	if run.Engine != nil && *run.Engine != state.EKSEngine {
+		return
+	}
+	resources := executable.GetExecutableResources()
+	gpu := (run.Gpu != nil && *run.Gpu > 0) || (resources.Gpu != nil && *resources.Gpu > 0)
+	run.ClusterName = es.eksClusterClient.Route(*run, gpu)
+}
+
 func (es *executionService) constructEnviron(run state.Run, env *state.EnvList) state.EnvList {
 	size := len(es.reservedEnv)
 	if env != nil {
@@ -350,7 +420,7 @@ func (es *executionService) List(
 			}
 		}
 	}
-	return es.stateManager.ListRuns(limit, offset, sortField, sortOrder, filters, envFilters, []string{state.EKSEngine, state.EKSSparkEngine})
+	return es.stateManager.ListRuns(limit, offset, sortField, sortOrder, filters, envFilters, state.Engines)
 }
 
 //
@@ -379,46 +449,20 @@ func (es *executionService) UpdateStatus(runID string, status string, exitCode *
 	}
 	finishedAt := time.Now()
 
-	if exitReason == nil {
-		extractedExitReason := es.extractExitReason(runExceptions)
-		exitReason = &extractedExitReason
+	update := state.Run{Status: status, ExitCode: exitCode, ExitReason: exitReason, RunExceptions: runExceptions, FinishedAt: &finishedAt, StartedAt: startedAt}
+	if status == state.StatusStopped {
+		classified := run
+		classified.UpdateWith(update)
+		classifier, _ := es.exitClassifiers.Get(es.stateManager)
+		classifier.Apply(&classified)
+		update.ExitReason = classified.ExitReason
+		update.ExitCategory = classified.ExitCategory
 	}
 
-	_, err = es.stateManager.UpdateRun(runID, state.Run{Status: status, ExitCode: exitCode, ExitReason: exitReason, RunExceptions: runExceptions, FinishedAt: &finishedAt, StartedAt: startedAt})
+	_, err = es.stateManager.UpdateRun(runID, update)
 	return err
 }
 
-func (es *executionService) extractExitReason(runExceptions *state.RunExceptions) string {
-	connectionError := regexp.MustCompile(`(?i).*(timeout|gatewayerror|socketerror|\s503\s|\s502\s|\s500\s|\s504\s|connectionerror).*`)
-	pipError := regexp.MustCompile(`(?i).*(could\snot\sfind\sa\sversion|package\snot\sfound|ModuleNotFoundError|No\smatching\sdistribution\sfound).*`)
-	yumError := regexp.MustCompile(`(?i).*(Nothing\sto\sdo).*`)
-	gitError := regexp.MustCompile(`(?i).*(Could\snot\sread\sfrom\sremote\srepository|correct\saccess\srights|Repository\snot\sfound).*`)
-	argumentError := regexp.MustCompile(`(?i).*(404|400|keyerror|column\smissing|RuntimeError).*`)
-	syntaxError := regexp.MustCompile(`(?i).*(syntaxerror|typeerror|).*`)
-
-	value, _ := json.Marshal(runExceptions)
-	if value != nil {
-		errorMsg := string(value)
-		switch {
-		case connectionError.MatchString(errorMsg):
-			return "Connection error to downstream uri"
-		case pipError.MatchString(errorMsg):
-			return "Python pip package installation error"
-		case yumError.MatchString(errorMsg):
-			return "Yum installation error"
-		case gitError.MatchString(errorMsg):
-			return "Git clone error"
-		case argumentError.MatchString(errorMsg):
-			return "Data or argument error"
-		case syntaxError.MatchString(errorMsg):
-			return "Code or syntax error"
-		default:
-			return "Runtime exception encountered"
-		}
-	}
-	return "Runtime exception encountered"
-}
-
 func (es *executionService) terminateWorker(jobChan <-chan state.TerminateJob) {
 	for job := range jobChan {
 		runID := job.RunID
@@ -445,7 +489,7 @@ func (es *executionService) terminateWorker(jobChan <-chan state.TerminateJob) {
 		}
 
 		if run.Status != state.StatusStopped {
-			if *run.Engine == state.EKSSparkEngine {
+			if state.IsSparkEngine(run.Engine) {
 				err = es.emrExecutionEngine.Terminate(run)
 			} else {
 				err = es.eksExecutionEngine.Terminate(run)
@@ -478,11 +522,307 @@ func (es *executionService) Terminate(runID string, userInfo state.UserInfo) err
 	return nil
 }
 
+//
+// Hold keeps a queued run from being dispatched until it is released; the
+// submit worker drops held runs from the queue and Release enqueues them
+// again
+//
+func (es *executionService) Hold(runID string) (state.Run, error) {
+	return es.stateManager.HoldRun(runID)
+}
+
+//
+// Release queues a held run again
+//
+func (es *executionService) Release(runID string) (state.Run, error) {
+	run, err := es.stateManager.GetRun(runID)
+	if err != nil {
+		return run, err
+	}
+	if run.Status != state.StatusHeld {
+		return run, exceptions.ConflictingResource{
+			ErrorString: fmt.Sprintf("run [%s] is %s, only %s runs can be released", runID, run.Status, state.StatusHeld)}
+	}
+
+	if run, err = es.stateManager.UpdateRun(runID, state.Run{Status: state.StatusQueued}); err != nil {
+		return run, err
+	}
+	if state.IsSparkEngine(run.Engine) {
+		err = es.emrExecutionEngine.Enqueue(run)
+	} else {
+		err = es.eksExecutionEngine.Enqueue(run)
+	}
+	return run, err
+}
+
+//
+// HoldRuns holds all queued runs of a group and/or alias
+//
+func (es *executionService) HoldRuns(groupName string, alias string) (state.RunList, error) {
+	return es.transitionRuns(groupName, alias, state.StatusQueued, es.Hold)
+}
+
+//
+// ReleaseRuns releases all held runs of a group and/or alias
+//
+func (es *executionService) ReleaseRuns(groupName string, alias string) (state.RunList, error) {
+	return es.transitionRuns(groupName, alias, state.StatusHeld, es.Release)
+}
+
+func (es *executionService) transitionRuns(groupName string, alias string, status string, transition func(runID string) (state.Run, error)) (state.RunList, error) {
+	var result state.RunList
+	if len(groupName) == 0 && len(alias) == 0 {
+		return result, exceptions.MalformedInput{ErrorString: "one of group_name or alias is required"}
+	}
+	filters := map[string][]string{"status": {status}}
+	if len(groupName) > 0 {
+		filters["group_name"] = []string{groupName}
+	}
+	if len(alias) > 0 {
+		filters["alias"] = []string{alias}
+	}
+
+	// Group and alias filters match substrings, keep the exact matches.
+	var runIDs []string
+	pageSize := 1000
+	for offset := 0; ; offset += pageSize {
+		runList, err := es.stateManager.ListRuns(pageSize, offset, "run_id", "asc", filters, nil, state.Engines)
+		if err != nil {
+			return result, err
+		}
+		for _, run := range runList.Runs {
+			if (len(groupName) == 0 || run.GroupName == groupName) && (len(alias) == 0 || run.Alias == alias) {
+				runIDs = append(runIDs, run.RunID)
+			}
+		}
+		if offset+pageSize >= runList.Total {
+			break
+		}
+	}
+
+	result.Runs = []state.Run{}
+	for _, runID := range runIDs {
+		run, err := transition(runID)
+		if err != nil {
+			// The run moved on since it was listed.
+			if _, ok := err.(exceptions.ConflictingResource); ok {
+				continue
+			}
+			return result, err
+		}
+		result.Runs = append(result.Runs, run)
+	}
+	result.Total = len(result.Runs)
+	return result, nil
+}
+
+//
+// Rerun creates a new run from a stored run's executable, image, command,
+// env, requested resources, spark extension and template payload, with the
+// request's overrides applied; the new run records the run it reruns in
+// rerun_of
+//
+func (es *executionService) Rerun(runID string, req *state.RerunRequest) (state.Run, error) {
+	original, err := es.stateManager.GetRun(runID)
+	if err != nil {
+		return original, err
+	}
+	if req == nil {
+		req = &state.RerunRequest{}
+	}
+	overrides := req.ExecutionRequestCommon
+	if overrides == nil {
+		overrides = &state.ExecutionRequestCommon{}
+	}
+	fields := es.rerunFields(original, overrides)
+	es.sanitizeExecutionRequestCommonFields(fields)
+
+	var run state.Run
+	if original.ExecutableType != nil && *original.ExecutableType == state.ExecutableTypeTemplate {
+		template, err := es.stateManager.GetTemplateByID(original.DefinitionID)
+		if err != nil {
+			return run, err
+		}
+		payload := templatePayload(original.ExecutionRequestCustom)
+		if len(req.TemplatePayload) > 0 {
+			payload = req.TemplatePayload
+			// The stored command was rendered from the original payload.
+			if overrides.Command == nil {
+				fields.Command = nil
+			}
+		}
+		run, err = es.constructRunFromTemplate(template, &state.TemplateExecutionRequest{
+			ExecutionRequestCommon: fields,
+			TemplatePayload:        payload,
+		})
+		if err != nil {
+			return run, err
+		}
+	} else {
+		definition, err := es.stateManager.GetDefinition(original.DefinitionID)
+		if err != nil {
+			return run, err
+		}
+		if run, err = es.constructRunFromDefinition(definition, &state.DefinitionExecutionRequest{ExecutionRequestCommon: fields}); err != nil {
+			return run, err
+		}
+	}
+
+	// The stored placement and volumes already include the executable's.
+	run.Image = original.Image
+	run.Placement = state.MergePlacement(original.Placement, overrides.Placement)
+	run.Volumes = state.MergeVolumes(original.Volumes, overrides.Volumes)
+	run.RerunOf = &original.RunID
+	if len(overrides.ClusterName) > 0 {
+		if err = es.checkCluster(run, overrides.ClusterName); err != nil {
+			return run, err
+		}
+		run.ClusterName = overrides.ClusterName
+	}
+	return es.createAndEnqueueIdempotentRun(run, overrides.IdempotencyKey)
+}
+
+// checkCluster rejects an EKS run's cluster that isn't registered.
+func (es *executionService) checkCluster(run state.Run, clusterName string) error {
+	if run.Engine != nil && *run.Engine != state.EKSEngine {
+		return nil
+	}
+	clusters, err := es.eksClusterClient.ListClusters()
+	if err != nil {
+		return err
+	}
+	for _, name := range clusters {
+		if name == clusterName {
+			return nil
+		}
+	}
+	return exceptions.MalformedInput{ErrorString: fmt.Sprintf("cluster [%s] is not registered", clusterName)}
+}
+
+// requestedResources are the cpu and memory a run was created with; once
+// adapted on submission, the run's own fields hold the adapted resources.
+func requestedResources(run state.Run) (*int64, *int64) {
+	if run.AraEstimate != nil {
+		return run.AraEstimate.RequestedCpu, run.AraEstimate.RequestedMemory
+	}
+	return run.Cpu, run.Memory
+}
+
+// rerunFields are the request fields of a stored run with overrides applied;
+// placement and volumes are left to the overrides to be validated alone,
+// and the cluster to routing unless overridden.
+func (es *executionService) rerunFields(run state.Run, overrides *state.ExecutionRequestCommon) *state.ExecutionRequestCommon {
+	cpu, memory := requestedResources(run)
+	fields := &state.ExecutionRequestCommon{
+		OwnerID:               run.User,
+		Command:               run.Command,
+		Memory:                memory,
+		Cpu:                   cpu,
+		Gpu:                   run.Gpu,
+		Engine:                run.Engine,
+		EphemeralStorage:      run.EphemeralStorage,
+		NodeLifecycle:         run.NodeLifecycle,
+		ActiveDeadlineSeconds: run.ActiveDeadlineSeconds,
+		Description:           run.Description,
+		CommandHash:           run.CommandHash,
+		Placement:             overrides.Placement,
+		Volumes:               overrides.Volumes,
+	}
+	if run.SparkExtension != nil {
+		fields.SparkExtension = run.SparkExtension.Request()
+	}
+
+	// Reserved variables are set again for the new run.
+	env := state.EnvList{}
+	if run.Env != nil {
+		for _, e := range *run.Env {
+			if _, reserved := es.reservedEnv[e.Name]; !reserved {
+				env = append(env, e)
+			}
+		}
+	}
+	if overrides.Env != nil {
+		for _, o := range *overrides.Env {
+			replaced := false
+			for i, e := range env {
+				if e.Name == o.Name {
+					env[i] = o
+					replaced = true
+				}
+			}
+			if !replaced {
+				env = append(env, o)
+			}
+		}
+	}
+	fields.Env = &env
+
+	if len(overrides.OwnerID) > 0 {
+		fields.OwnerID = overrides.OwnerID
+	}
+	if overrides.Command != nil {
+		fields.Command = overrides.Command
+	}
+	if overrides.Memory != nil {
+		fields.Memory = overrides.Memory
+	}
+	if overrides.Cpu != nil {
+		fields.Cpu = overrides.Cpu
+	}
+	if overrides.Gpu != nil {
+		fields.Gpu = overrides.Gpu
+	}
+	if overrides.Engine != nil {
+		fields.Engine = overrides.Engine
+	}
+	if overrides.EphemeralStorage != nil {
+		fields.EphemeralStorage = overrides.EphemeralStorage
+	}
+	if overrides.NodeLifecycle != nil {
+		fields.NodeLifecycle = overrides.NodeLifecycle
+	}
+	if overrides.ActiveDeadlineSeconds != nil {
+		fields.ActiveDeadlineSeconds = overrides.ActiveDeadlineSeconds
+	}
+	if overrides.SparkExtension != nil {
+		fields.SparkExtension = overrides.SparkExtension
+	}
+	if overrides.Description != nil {
+		fields.Description = overrides.Description
+	}
+	if overrides.CommandHash != nil {
+		fields.CommandHash = overrides.CommandHash
+	}
+	return fields
+}
+
+// templatePayload is the payload a template run was rendered from.
+func templatePayload(custom *state.ExecutionRequestCustom) state.TemplatePayload {
+	if custom == nil {
+		return nil
+	}
+	switch payload := (*custom)[state.TemplatePayloadKey].(type) {
+	case state.TemplatePayload:
+		return payload
+	case map[string]interface{}:
+		return state.TemplatePayload(payload)
+	}
+	return nil
+}
+
 //
 // ListClusters returns a list of all execution clusters available
 //
 func (es *executionService) ListClusters() ([]string, error) {
-	return []string{}, nil
+	return es.eksClusterClient.ListClusters()
+}
+
+//
+// ListClusterHealth returns the clusters runs are routed to with their labels
+// and health
+//
+func (es *executionService) ListClusterHealth() ([]cluster.Cluster, error) {
+	return es.eksClusterClient.Clusters()
 }
 
 //
@@ -517,25 +857,68 @@ func (es *executionService) createAndEnqueueRun(run state.Run) (state.Run, error
 		return run, err
 	}
 
+	if err = es.enqueueRun(run); err != nil {
+		return run, err
+	}
+
+	return es.setQueuedAt(run)
+}
+
+// enqueueRun queues a saved run on its engine's queue.
+func (es *executionService) enqueueRun(run state.Run) error {
 	if *run.Engine == state.EKSEngine {
-		err = es.eksExecutionEngine.Enqueue(run)
-	} else {
-		err = es.emrExecutionEngine.Enqueue(run)
+		return es.eksExecutionEngine.Enqueue(run)
 	}
+	return es.emrExecutionEngine.Enqueue(run)
+}
 
+// setQueuedAt updates the run's QueuedAt field once it is queued.
+func (es *executionService) setQueuedAt(run state.Run) (state.Run, error) {
 	queuedAt := time.Now()
+	return es.stateManager.UpdateRun(run.RunID, state.Run{QueuedAt: &queuedAt})
+}
 
+//
+// createAndEnqueueIdempotentRun returns the run created for the same
+// executable and idempotency key within the retention window, instead of
+// creating the run again when a client retries its request. The key is
+// released only when the run couldn't be saved; once saved, the run is the
+// answer to every replay, and a run that couldn't be queued is stopped.
+//
+func (es *executionService) createAndEnqueueIdempotentRun(run state.Run, key *string) (state.Run, error) {
+	if key == nil || len(*key) == 0 || run.ExecutableID == nil {
+		return es.createAndEnqueueRun(run)
+	}
+	holder, err := es.stateManager.ClaimIdempotencyKey(*run.ExecutableID, *key, run.RunID, es.idempotencyRetention)
 	if err != nil {
 		return run, err
 	}
+	if holder != run.RunID {
+		held, err := es.stateManager.GetRun(holder)
+		if _, missing := err.(exceptions.MissingResource); missing {
+			// The request holding the key hasn't saved its run yet.
+			return held, exceptions.ConflictingResource{ErrorString: fmt.Sprintf(
+				"run [%s] of idempotency key [%s] is being created, retry the request", holder, *key)}
+		}
+		return held, err
+	}
 
-	// UpdateStatus the run's QueuedAt field
-	if run, err = es.stateManager.UpdateRun(run.RunID, state.Run{QueuedAt: &queuedAt}); err != nil {
+	if err = es.stateManager.CreateRun(run); err != nil {
+		// Nothing was saved, let a retry create the run.
+		es.stateManager.ReleaseIdempotencyKey(*run.ExecutableID, *key, run.RunID)
 		return run, err
 	}
-
-	return run, nil
+	if err = es.enqueueRun(run); err != nil {
+		exitReason := fmt.Sprintf("unable to queue run: %s", err.Error())
+		if stopped, stopErr := es.stateManager.UpdateRun(run.RunID, state.Run{
+			Status: state.StatusStopped, ExitReason: &exitReason}); stopErr == nil {
+			run = stopped
+		}
+		return run, err
+	}
+	return es.setQueuedAt(run)
 }
+
 func (es *executionService) CreateTemplateRunByTemplateName(templateName string, templateVersion string, req *state.TemplateExecutionRequest) (state.Run, error) {
 	version, err := strconv.Atoi(templateVersion)
 
@@ -583,7 +966,7 @@ func (es *executionService) createFromTemplate(template state.Template, req *sta
 		return run, err
 	}
 	if !req.DryRun {
-		return es.createAndEnqueueRun(run)
+		return es.createAndEnqueueIdempotentRun(run, fields.IdempotencyKey)
 	}
 	return run, nil
 }
@@ -597,8 +980,15 @@ func (es *executionService) constructRunFromTemplate(template state.Template, re
 
 	run.DefinitionID = template.TemplateID
 	run.Alias = template.TemplateID
-	run.GroupName = "template_group_name"
+	run.GroupName = state.TemplateGroupName
 	run.ExecutionRequestCustom = req.GetExecutionRequestCustom()
+	if err = es.applyServiceAccount(template, &run); err != nil {
+		return run, err
+	}
+	if reasons := es.secretPolicy.Validate(run.GroupName, run.Env); len(reasons) > 0 {
+		return run, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	es.routeRun(template, &run)
 
 	return run, nil
 }
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/testutils"
 )
@@ -272,3 +275,355 @@ func TestExecutionService_List2(t *testing.T) {
 		}
 	}
 }
+
+func TestExecutionService_CreateDefinitionRunPlacement(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.DefaultEngine
+	req := state.DefinitionExecutionRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{
+			OwnerID: "somebody",
+			Engine:  &engine,
+			Placement: &state.Placement{
+				NodeSelector: map[string]string{"kubernetes.io/arch": "arm64"},
+			},
+		},
+	}
+	if _, err := es.CreateDefinitionRunByDefinitionID("B", &req); err == nil {
+		t.Errorf("Expected node selector key outside the allowlist to result in error")
+	}
+	for _, call := range imp.Calls {
+		if call == "CreateRun" {
+			t.Errorf("Expected no run to be created")
+		}
+	}
+
+	req.Placement = &state.Placement{
+		TopologySpread: []state.TopologySpread{{TopologyKey: "topology.kubernetes.io/zone"}},
+	}
+	run, err := es.CreateDefinitionRunByDefinitionID("B", &req)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if run.Placement == nil || len(run.Placement.TopologySpread) != 1 {
+		t.Errorf("Expected run to record its placement, got %+v", run.Placement)
+	}
+}
+
+func TestExecutionService_CreateDefinitionRunVolumes(t *testing.T) {
+	es, imp := setUp(t)
+	def := imp.Definitions["B"]
+	def.Volumes = &state.VolumeList{{Name: "tmp", MountPath: "/etc/app", EmptyDir: &state.EmptyDirVolume{SizeLimit: 1024}}}
+	imp.Definitions["B"] = def
+	engine := state.DefaultEngine
+	volumes := state.VolumeList{
+		{Name: "conf", MountPath: "/etc/app/", ConfigFiles: map[string]string{"app.yaml": "a: 1"}},
+	}
+	req := state.DefinitionExecutionRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{
+			OwnerID: "somebody",
+			Engine:  &engine,
+			Volumes: &volumes,
+		},
+	}
+	if _, err := es.CreateDefinitionRunByDefinitionID("B", &req); err == nil {
+		t.Errorf("Expected a run volume at the mount_path of a definition volume to result in error")
+	}
+
+	volumes[0].MountPath = "/etc/conf"
+	run, err := es.CreateDefinitionRunByDefinitionID("B", &req)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if run.Volumes == nil || len(*run.Volumes) != 2 {
+		t.Errorf("Expected run to record its volumes, got %+v", run.Volumes)
+	}
+
+	engine = state.EKSSparkEngine
+	req.SparkExtension = &state.SparkExtension{}
+	if _, err := es.CreateDefinitionRunByDefinitionID("B", &req); err != nil {
+		t.Errorf("Expected config file volumes on the eks-spark engine, got %v", err)
+	}
+}
+
+func TestExecutionService_HoldAndRelease(t *testing.T) {
+	es, imp := setUp(t)
+	queuedAt := time.Now().Add(-time.Hour)
+	for _, runID := range []string{"runA", "runB"} {
+		run := imp.Runs[runID]
+		run.Status = state.StatusQueued
+		run.QueuedAt = &queuedAt
+		imp.Runs[runID] = run
+	}
+
+	held, err := es.HoldRuns("A", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.Total != 1 || held.Runs[0].RunID != "runA" || imp.Runs["runA"].Status != state.StatusHeld {
+		t.Errorf("Expected only runA to be held, got %v", held.Runs)
+	}
+	if imp.Runs["runB"].Status != state.StatusQueued {
+		t.Errorf("Expected runB of another group to stay queued")
+	}
+
+	if _, err = es.Hold("runA"); err == nil {
+		t.Errorf("Expected holding a held run to fail")
+	} else if _, ok := err.(exceptions.ConflictingResource); !ok {
+		t.Errorf("Expected holding a held run to conflict, got %v", err)
+	}
+	if _, err = es.HoldRuns("", ""); err == nil {
+		t.Errorf("Expected holding without a group or alias to fail")
+	}
+
+	run, err := es.Release("runA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != state.StatusQueued || len(imp.Queued) != 1 || imp.Queued[0] != "runA" {
+		t.Errorf("Expected runA to be queued and enqueued again, got %s and %v", run.Status, imp.Queued)
+	}
+	if run.QueuedAt == nil || !run.QueuedAt.Equal(queuedAt) {
+		t.Errorf("Expected runA to keep its queued_at, got %v", run.QueuedAt)
+	}
+}
+
+func TestExecutionService_Rerun(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.EKSEngine
+	cmd := "_test_cmd_"
+	mem := int64(1024)
+	imp.Runs["runA"] = state.Run{
+		RunID:        "runA",
+		DefinitionID: "A",
+		Image:        "image:v1",
+		Status:       state.StatusStopped,
+		User:         "somebody",
+		Engine:       &engine,
+		Command:      &cmd,
+		Memory:       &mem,
+		Env: &state.EnvList{
+			{Name: "FLOTILLA_RUN_ID", Value: "runA"},
+			{Name: "K1", Value: "V1"},
+			{Name: "K2", Value: "V2"},
+		},
+	}
+
+	overrideMem := int64(2048)
+	run, err := es.Rerun("runA", &state.RerunRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{
+			Memory: &overrideMem,
+			Env:    &state.EnvList{{Name: "K2", Value: "override"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RunID == "runA" || run.RerunOf == nil || *run.RerunOf != "runA" {
+		t.Errorf("Expected a new run with rerun_of runA, got %s and %v", run.RunID, run.RerunOf)
+	}
+	if run.Alias != "aliasA" || run.Image != "image:v1" || run.User != "somebody" {
+		t.Errorf("Expected the alias, image and owner of runA, got %s, %s and %s", run.Alias, run.Image, run.User)
+	}
+	if run.Command == nil || *run.Command != cmd || run.Memory == nil || *run.Memory != overrideMem {
+		t.Errorf("Expected the command of runA with memory %v, got %v and %v", overrideMem, run.Command, run.Memory)
+	}
+	env := map[string]string{}
+	for _, e := range *run.Env {
+		env[e.Name] = e.Value
+	}
+	if env["FLOTILLA_RUN_ID"] != run.RunID || env["K1"] != "V1" || env["K2"] != "override" {
+		t.Errorf("Expected reserved env of the new run and merged user env, got %v", env)
+	}
+	if len(imp.Queued) != 1 || imp.Queued[0] != run.RunID {
+		t.Errorf("Expected the rerun to be enqueued, got %v", imp.Queued)
+	}
+
+	if _, err = es.Rerun("nope", nil); err == nil {
+		t.Errorf("Expected rerunning a missing run to fail")
+	}
+}
+
+func TestExecutionService_RerunOverrides(t *testing.T) {
+	es, imp := setUp(t)
+	engine := state.EKSEngine
+	adaptedCpu, adaptedMem, requestedMem := int64(2000), int64(6000), int64(4096)
+	imp.Runs["runA"] = state.Run{
+		RunID:        "runA",
+		DefinitionID: "A",
+		ClusterName:  "cluster0",
+		Status:       state.StatusStopped,
+		Engine:       &engine,
+		Cpu:          &adaptedCpu,
+		Memory:       &adaptedMem,
+		AraEstimate:  &state.ARAEstimate{Source: state.ARASourceHistory, RequestedMemory: &requestedMem},
+	}
+
+	key := "rerun-1"
+	req := &state.RerunRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{ClusterName: "cluster1", IdempotencyKey: &key},
+	}
+	run, err := es.Rerun("runA", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.ClusterName != "cluster1" {
+		t.Errorf("Expected the cluster override to be kept, got [%s]", run.ClusterName)
+	}
+	if run.Cpu != nil || run.Memory == nil || *run.Memory != requestedMem {
+		t.Errorf("Expected only the requested memory of runA, got cpu %v and memory %v", run.Cpu, run.Memory)
+	}
+
+	replayed, err := es.Rerun("runA", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.RunID != run.RunID || len(imp.Queued) != 1 {
+		t.Errorf("Expected the replay to return %s without queuing, got %s and %v", run.RunID, replayed.RunID, imp.Queued)
+	}
+
+	_, err = es.Rerun("runA", &state.RerunRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{ClusterName: "nope"},
+	})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected MalformedInput for an unregistered cluster, got %v", err)
+	}
+}
+
+func TestExecutionService_RerunTemplate(t *testing.T) {
+	es, imp := setUp(t)
+	imp.Templates = map[string]state.Template{
+		"T": {TemplateID: "T", TemplateName: "greeting", CommandTemplate: "echo {{.name}}", Schema: state.TemplateJSONSchema{}},
+	}
+	engine := state.EKSEngine
+	cmd := "echo world"
+	templateType := state.ExecutableTypeTemplate
+	imp.Runs["runT"] = state.Run{
+		RunID:                  "runT",
+		DefinitionID:           "T",
+		Status:                 state.StatusStopped,
+		User:                   "somebody",
+		Engine:                 &engine,
+		Command:                &cmd,
+		ExecutableType:         &templateType,
+		ExecutionRequestCustom: &state.ExecutionRequestCustom{state.TemplatePayloadKey: map[string]interface{}{"name": "world"}},
+	}
+
+	run, err := es.Rerun("runT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Command == nil || *run.Command != cmd {
+		t.Errorf("Expected the stored command [%s], got %v", cmd, run.Command)
+	}
+
+	run, err = es.Rerun("runT", &state.RerunRequest{TemplatePayload: state.TemplatePayload{"name": "again"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Command == nil || *run.Command != "echo again" {
+		t.Errorf("Expected the command rendered from the new payload, got %v", run.Command)
+	}
+	if run.RerunOf == nil || *run.RerunOf != "runT" {
+		t.Errorf("Expected rerun_of runT, got %v", run.RerunOf)
+	}
+}
+
+func TestExecutionService_CreateIdempotentRun(t *testing.T) {
+	es, imp := setUp(t)
+	key := "retry-1"
+	newReq := func() *state.DefinitionExecutionRequest {
+		engine := state.DefaultEngine
+		return &state.DefinitionExecutionRequest{
+			ExecutionRequestCommon: &state.ExecutionRequestCommon{
+				OwnerID:        "somebody",
+				Engine:         &engine,
+				IdempotencyKey: &key,
+			},
+		}
+	}
+
+	first, err := es.CreateDefinitionRunByDefinitionID("A", newReq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := es.CreateDefinitionRunByAlias("aliasA", newReq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.RunID != first.RunID {
+		t.Errorf("Expected the replayed request to return run [%s], got [%s]", first.RunID, replayed.RunID)
+	}
+	if len(imp.Queued) != 1 {
+		t.Errorf("Expected a single enqueued run, got %v", imp.Queued)
+	}
+
+	other, err := es.CreateDefinitionRunByDefinitionID("B", newReq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.RunID == first.RunID {
+		t.Errorf("Expected idempotency keys to be scoped to the definition")
+	}
+
+	// The key is held by a request that hasn't saved its run yet.
+	key = "retry-2"
+	imp.IdempotencyKeys["A/retry-2"] = "eks-unsaved"
+	if _, err := es.CreateDefinitionRunByDefinitionID("A", newReq()); err == nil {
+		t.Errorf("Expected a replay of a run being created to result in error")
+	} else if _, conflict := err.(exceptions.ConflictingResource); !conflict {
+		t.Errorf("Expected a ConflictingResource error, got %v", err)
+	}
+
+	// A run that couldn't be queued keeps its key and is stopped.
+	key = "retry-3"
+	imp.EnqueueError = errors.New("queue unavailable")
+	failed, err := es.CreateDefinitionRunByDefinitionID("A", newReq())
+	if err == nil {
+		t.Fatalf("Expected the enqueue error to be returned")
+	}
+	imp.EnqueueError = nil
+	replayed, err = es.CreateDefinitionRunByDefinitionID("A", newReq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.RunID != failed.RunID || replayed.Status != state.StatusStopped {
+		t.Errorf("Expected the replay to return the stopped run [%s], got [%s] %s", failed.RunID, replayed.RunID, replayed.Status)
+	}
+	if len(imp.Queued) != 2 {
+		t.Errorf("Expected no run to be queued by the replay, got %v", imp.Queued)
+	}
+}
+
+func TestExecutionService_CreateDefinitionRunServiceAccount(t *testing.T) {
+	es, imp := setUp(t)
+	es.(*executionService).serviceAccounts.Accounts["etl"] = state.ServiceAccount{
+		Name:    "etl",
+		RoleArn: "arn:aws:iam::123456789012:role/flotilla-etl",
+		Groups:  []string{"B"},
+	}
+	sa := "etl"
+	def := imp.Definitions["B"]
+	def.GroupName = "B"
+	def.ServiceAccount = &sa
+	imp.Definitions["B"] = def
+	engine := state.DefaultEngine
+	req := state.DefinitionExecutionRequest{
+		ExecutionRequestCommon: &state.ExecutionRequestCommon{
+			OwnerID: "somebody",
+			Engine:  &engine,
+		},
+	}
+	run, err := es.CreateDefinitionRunByDefinitionID("B", &req)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if run.ServiceAccount == nil || *run.ServiceAccount != "etl" {
+		t.Errorf("Expected run to use the definition's service account, got %v", run.ServiceAccount)
+	}
+
+	def.GroupName = "A"
+	imp.Definitions["B"] = def
+	if _, err := es.CreateDefinitionRunByDefinitionID("B", &req); err == nil {
+		t.Errorf("Expected service account not permitted for the group to result in error")
+	}
+}
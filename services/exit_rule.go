@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+// ExitRuleService manages the rules that classify failed runs and tests rule
+// sets against the run exceptions of past runs
+type ExitRuleService interface {
+	List() (state.ExitRuleList, error)
+	Create(rule state.ExitRule) (state.ExitRule, error)
+	Delete(ruleID string) error
+	Test(req state.ExitRuleTestRequest) (state.ExitRuleTestReport, error)
+}
+
+type exitRuleService struct {
+	sm               state.Manager
+	configured       []state.ExitRule
+	defaultTestLimit int
+	maxTestLimit     int
+}
+
+// NewExitRuleService configures and returns an ExitRuleService; rules in
+// `exit_rules` apply after the stored rules
+func NewExitRuleService(conf config.Config, sm state.Manager) (ExitRuleService, error) {
+	configured, err := state.ExitRulesFromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	ers := exitRuleService{
+		sm:               sm,
+		configured:       configured,
+		defaultTestLimit: 500,
+		maxTestLimit:     5000,
+	}
+	return &ers, nil
+}
+
+// List returns the rules in the order they apply: stored, configured, then
+// the defaults
+func (ers *exitRuleService) List() (state.ExitRuleList, error) {
+	stored, err := ers.sm.ListExitRules()
+	if err != nil {
+		return stored, err
+	}
+	rules := append(stored.Rules, ers.configured...)
+	rules = append(rules, state.DefaultExitRules...)
+	return state.ExitRuleList{Total: len(rules), Rules: rules}, nil
+}
+
+// Create validates and stores a rule
+func (ers *exitRuleService) Create(rule state.ExitRule) (state.ExitRule, error) {
+	if valid, reasons := rule.IsValid(); !valid {
+		return rule, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
+	ruleID, err := state.NewExitRuleID()
+	if err != nil {
+		return rule, err
+	}
+	now := time.Now()
+	rule.RuleID = ruleID
+	rule.CreatedAt = &now
+	return rule, ers.sm.CreateExitRule(rule)
+}
+
+// Delete deletes a stored rule
+func (ers *exitRuleService) Delete(ruleID string) error {
+	return ers.sm.DeleteExitRule(ruleID)
+}
+
+// Test classifies the failed runs with run exceptions that most recently
+// finished with the requested rules, followed by the current rules when
+// requested
+func (ers *exitRuleService) Test(req state.ExitRuleTestRequest) (state.ExitRuleTestReport, error) {
+	var report state.ExitRuleTestReport
+	rules := append([]state.ExitRule{}, req.Rules...)
+	for i := range rules {
+		if len(rules[i].RuleID) == 0 {
+			rules[i].RuleID = fmt.Sprintf("test-%d", i)
+		}
+		if valid, reasons := rules[i].IsValid(); !valid {
+			return report, exceptions.MalformedInput{ErrorString: fmt.Sprintf(
+				"rule [%s] is invalid: %s", rules[i].RuleID, strings.Join(reasons, ", "))}
+		}
+	}
+	if req.IncludeCurrent {
+		current, err := ers.List()
+		if err != nil {
+			return report, err
+		}
+		rules = append(rules, current.Rules...)
+	}
+	if len(rules) == 0 {
+		return report, exceptions.MalformedInput{ErrorString: "at least one rule must be tested"}
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = ers.defaultTestLimit
+	}
+	if limit > ers.maxTestLimit {
+		limit = ers.maxTestLimit
+	}
+	classifier, err := state.NewExitClassifier(rules)
+	if err != nil {
+		return report, exceptions.MalformedInput{ErrorString: err.Error()}
+	}
+	runs, err := ers.sm.ListExceptionRuns(limit, req.GroupName)
+	if err != nil {
+		return report, err
+	}
+	return classifier.Test(runs), nil
+}
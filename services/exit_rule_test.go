@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func TestExitRuleService_Test(t *testing.T) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+
+	syntax := state.RunExceptions{"SyntaxError: invalid syntax"}
+	timeout := state.RunExceptions{"requests.exceptions.ConnectionError: timeout"}
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			"a": {RunID: "a", GroupName: "etl", ExitCode: aws.Int64(1), RunExceptions: &syntax,
+				ExitCategory: aws.String(state.ExitCategoryUserCode)},
+			"b": {RunID: "b", GroupName: "etl", ExitCode: aws.Int64(1), RunExceptions: &timeout,
+				ExitCategory: aws.String(state.ExitCategoryInfra)},
+			"c": {RunID: "c", GroupName: "ml", ExitCode: aws.Int64(1), RunExceptions: &timeout},
+		},
+	}
+	ers, _ := NewExitRuleService(c, &imp)
+
+	report, err := ers.Test(state.ExitRuleTestRequest{
+		Rules: []state.ExitRule{{
+			Pattern:  aws.String("ConnectionError"),
+			Category: state.ExitCategoryDependency,
+			Reason:   "Package index unreachable",
+		}},
+		IncludeCurrent: true,
+		GroupName:      aws.String("etl"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if report.Runs != 2 || report.ByRule["test-0"] != 1 || report.ByRule["default-syntax"] != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(report.Changed) != 1 || report.Changed[0].RunID != "b" ||
+		report.Changed[0].Classification.Category != state.ExitCategoryDependency {
+		t.Errorf("Expected only run b to change category, got %+v", report.Changed)
+	}
+
+	_, err = ers.Test(state.ExitRuleTestRequest{Rules: []state.ExitRule{{
+		Pattern: aws.String(".*"), Category: state.ExitCategoryUserCode, Reason: "everything"}}})
+	if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected a rule matching everything to be malformed, got %v", err)
+	}
+}
+
+func TestExitRuleService_CreateDelete(t *testing.T) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	imp := testutils.ImplementsAllTheThings{T: t}
+	ers, _ := NewExitRuleService(c, &imp)
+
+	created, err := ers.Create(state.ExitRule{
+		ExitCode: aws.Int64(3), Category: state.ExitCategoryInfra, Reason: "Lost the database"})
+	if err != nil || len(created.RuleID) == 0 || created.CreatedAt == nil {
+		t.Fatalf("Expected a stored rule, got %+v %v", created, err)
+	}
+	rules, _ := ers.List()
+	if rules.Total != len(state.DefaultExitRules)+1 || rules.Rules[0].RuleID != created.RuleID {
+		t.Errorf("Expected the stored rule ahead of the defaults, got %+v", rules.Rules)
+	}
+	if err = ers.Delete(created.RuleID); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+// RecommendationService suggests cpu and memory settings for definitions
+// based on the peak usage of their recent successful runs
+type RecommendationService interface {
+	Get(definitionID string) (state.ResourceRecommendations, error)
+	Apply(definitionID string, commandHash string) (state.Definition, error)
+}
+
+type recommendationService struct {
+	sm           state.Manager
+	lookbackDays int
+	minSamples   int64
+	headroom     float64
+}
+
+// Recommendations are rounded up to these increments (millicores and MB).
+const (
+	recommendationCpuStep    = int64(64)
+	recommendationMemoryStep = int64(128)
+)
+
+// NewRecommendationService configures and returns a RecommendationService
+func NewRecommendationService(conf config.Config, sm state.Manager) (RecommendationService, error) {
+	rs := recommendationService{
+		sm:           sm,
+		lookbackDays: 30,
+		minSamples:   5,
+		headroom:     1.25,
+	}
+	if conf.IsSet("recommendation_lookback_days") {
+		rs.lookbackDays = conf.GetInt("recommendation_lookback_days")
+	}
+	if conf.IsSet("recommendation_min_samples") {
+		rs.minSamples = int64(conf.GetInt("recommendation_min_samples"))
+	}
+	if conf.IsSet("recommendation_headroom") {
+		rs.headroom = conf.GetFloat64("recommendation_headroom")
+	}
+	return &rs, nil
+}
+
+// Get returns the definition wide recommendation along with one per command
+// hash seen in the lookback window
+func (rs *recommendationService) Get(definitionID string) (state.ResourceRecommendations, error) {
+	recs := state.ResourceRecommendations{
+		DefinitionID:  definitionID,
+		LookbackDays:  rs.lookbackDays,
+		CommandHashes: []state.ResourceRecommendation{},
+	}
+
+	definition, err := rs.sm.GetDefinition(definitionID)
+	if err != nil {
+		return recs, err
+	}
+
+	usage, err := rs.sm.GetResourceUsageStats(definitionID, rs.lookbackDays)
+	if err != nil {
+		return recs, err
+	}
+
+	recs.Definition = state.ResourceRecommendation{Confidence: state.ConfidenceInsufficient}
+	for _, u := range usage {
+		if u.Overall {
+			currentCpu, currentMemory := u.RequestedCpu, u.RequestedMemory
+			if definition.Cpu != nil {
+				currentCpu = *definition.Cpu
+			}
+			if definition.Memory != nil {
+				currentMemory = *definition.Memory
+			}
+			recs.Definition = rs.recommend(u, currentCpu, currentMemory)
+		} else {
+			recs.CommandHashes = append(recs.CommandHashes, rs.recommend(u, u.RequestedCpu, u.RequestedMemory))
+		}
+	}
+	return recs, nil
+}
+
+// Apply updates the definition's cpu and memory with its definition wide
+// recommendation. A command hash's recommendation only fits the runs of that
+// command, so it can't be applied to the whole definition.
+func (rs *recommendationService) Apply(definitionID string, commandHash string) (state.Definition, error) {
+	if len(commandHash) > 0 {
+		return state.Definition{}, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("The recommendation for command hash [%s] only fits runs of that command; set its cpu and memory on those runs", commandHash)}
+	}
+	recs, err := rs.Get(definitionID)
+	if err != nil {
+		return state.Definition{}, err
+	}
+
+	rec := recs.Definition
+
+	if rec.Confidence != state.ConfidenceMedium && rec.Confidence != state.ConfidenceHigh {
+		return state.Definition{}, exceptions.ConflictingResource{
+			ErrorString: fmt.Sprintf("Recommendation confidence [%s] is too low to apply", rec.Confidence)}
+	}
+
+	updates := state.Definition{}
+	updates.Cpu = &rec.RecommendedCpu
+	updates.Memory = &rec.RecommendedMemory
+	return rs.sm.UpdateDefinition(definitionID, updates)
+}
+
+func (rs *recommendationService) recommend(u state.ResourceUsageStats, currentCpu int64, currentMemory int64) state.ResourceRecommendation {
+	rec := state.ResourceRecommendation{
+		CommandHash:       u.CommandHash,
+		CurrentCpu:        currentCpu,
+		CurrentMemory:     currentMemory,
+		RecommendedCpu:    currentCpu,
+		RecommendedMemory: currentMemory,
+		Confidence:        rs.confidence(u),
+		Usage:             u,
+	}
+	if rec.Confidence == state.ConfidenceInsufficient {
+		return rec
+	}
+
+	rec.RecommendedCpu = roundUp(int64(math.Ceil(float64(u.CpuP95)*rs.headroom)), recommendationCpuStep)
+	rec.RecommendedMemory = roundUp(int64(math.Ceil(float64(u.MemoryP95)*rs.headroom)), recommendationMemoryStep)
+	if rec.RecommendedCpu < state.MinCPU {
+		rec.RecommendedCpu = state.MinCPU
+	}
+	if rec.RecommendedCpu > state.MaxCPU {
+		rec.RecommendedCpu = state.MaxCPU
+	}
+	if rec.RecommendedMemory < state.MinMem {
+		rec.RecommendedMemory = state.MinMem
+	}
+	if rec.RecommendedMemory > state.MaxMem {
+		rec.RecommendedMemory = state.MaxMem
+	}
+
+	// Savings are per run; negative values mean the definition is under provisioned.
+	rec.CpuSavings = rec.CurrentCpu - rec.RecommendedCpu
+	rec.MemorySavings = rec.CurrentMemory - rec.RecommendedMemory
+	rec.CpuCoreHoursSaved = float64(rec.CpuSavings) / 1000 * u.RuntimeHours
+	rec.MemoryGBHoursSaved = float64(rec.MemorySavings) / 1024 * u.RuntimeHours
+	return rec
+}
+
+// Confidence grows with the number of samples and shrinks with the spread
+// between median and p95 peak memory.
+func (rs *recommendationService) confidence(u state.ResourceUsageStats) string {
+	if u.SampleSize < rs.minSamples || u.MemoryP95 <= 0 || u.CpuP95 <= 0 {
+		return state.ConfidenceInsufficient
+	}
+	spread := math.Inf(1)
+	if u.MemoryP50 > 0 {
+		spread = float64(u.MemoryP95) / float64(u.MemoryP50)
+	}
+	switch {
+	case u.SampleSize >= 4*rs.minSamples && spread <= 1.5:
+		return state.ConfidenceHigh
+	case u.SampleSize >= 2*rs.minSamples && spread <= 2.5:
+		return state.ConfidenceMedium
+	default:
+		return state.ConfidenceLow
+	}
+}
+
+func roundUp(v int64, step int64) int64 {
+	if v%step == 0 {
+		return v
+	}
+	return (v/step + 1) * step
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func setUpRecommendationService(t *testing.T) (RecommendationService, *testutils.ImplementsAllTheThings) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	cpu := int64(4000)
+	mem := int64(16384)
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Definitions: map[string]state.Definition{
+			"A": {DefinitionID: "A", Alias: "aliasA", ExecutableResources: state.ExecutableResources{Cpu: &cpu, Memory: &mem}},
+			"B": {DefinitionID: "B", Alias: "aliasB"},
+		},
+		ResourceUsage: map[string][]state.ResourceUsageStats{
+			"A": {
+				{Overall: true, SampleSize: 40, MemoryP50: 3000, MemoryP95: 4000, CpuP50: 700, CpuP95: 1000, RuntimeHours: 10},
+				{CommandHash: "h1", SampleSize: 3, MemoryP50: 3000, MemoryP95: 4000, CpuP50: 700, CpuP95: 1000, RequestedCpu: 4000, RequestedMemory: 16384},
+			},
+		},
+	}
+	rs, _ := NewRecommendationService(c, &imp)
+	return rs, &imp
+}
+
+func TestRecommendationService_Get(t *testing.T) {
+	rs, _ := setUpRecommendationService(t)
+
+	recs, err := rs.Get("A")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	rec := recs.Definition
+	if rec.Confidence != state.ConfidenceHigh {
+		t.Errorf("Expected confidence [%s] but was [%s]", state.ConfidenceHigh, rec.Confidence)
+	}
+
+	// p95 * 1.25 rounded up to the next step
+	if rec.RecommendedMemory != 5120 {
+		t.Errorf("Expected recommended memory 5120 but was %v", rec.RecommendedMemory)
+	}
+	if rec.RecommendedCpu != 1280 {
+		t.Errorf("Expected recommended cpu 1280 but was %v", rec.RecommendedCpu)
+	}
+	if rec.MemorySavings != 16384-5120 || rec.CpuSavings != 4000-1280 {
+		t.Errorf("Unexpected savings, cpu: %v memory: %v", rec.CpuSavings, rec.MemorySavings)
+	}
+
+	if len(recs.CommandHashes) != 1 {
+		t.Fatalf("Expected 1 command hash recommendation but was %v", len(recs.CommandHashes))
+	}
+	if recs.CommandHashes[0].Confidence != state.ConfidenceInsufficient ||
+		recs.CommandHashes[0].RecommendedMemory != 16384 {
+		t.Errorf("Expected unchanged resources with insufficient samples, got %+v", recs.CommandHashes[0])
+	}
+
+	recs, err = rs.Get("B")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if recs.Definition.Confidence != state.ConfidenceInsufficient {
+		t.Errorf("Expected insufficient confidence without usage but was [%s]", recs.Definition.Confidence)
+	}
+}
+
+func TestRecommendationService_Apply(t *testing.T) {
+	rs, imp := setUpRecommendationService(t)
+
+	updated, err := rs.Apply("A", "")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if updated.Memory == nil || *updated.Memory != 5120 || updated.Cpu == nil || *updated.Cpu != 1280 {
+		t.Errorf("Expected definition to be updated with recommendation, got %+v", updated.ExecutableResources)
+	}
+	if imp.Calls[len(imp.Calls)-1] != "UpdateDefinition" {
+		t.Errorf("Expected UpdateDefinition call but was: %v", imp.Calls)
+	}
+
+	calls := len(imp.Calls)
+	if _, err = rs.Apply("A", "h1"); err == nil {
+		t.Errorf("Expected error applying a command hash recommendation to the definition")
+	} else if _, ok := err.(exceptions.MalformedInput); !ok {
+		t.Errorf("Expected MalformedInput but was %v", err)
+	}
+	if len(imp.Calls) != calls {
+		t.Errorf("Expected the definition to be left alone, got calls %v", imp.Calls[calls:])
+	}
+}
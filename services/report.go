@@ -0,0 +1,172 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+// ReportService defines an interface for usage analytics and SLO reporting
+// built on the history of runs
+type ReportService interface {
+	Usage(req state.UsageReportRequest) (state.UsageReport, error)
+	Exceptions(req state.ExceptionReportRequest) (state.ExceptionReport, error)
+	RunExceptions(runID string) (state.LoggedExceptionList, error)
+	RunAccess(runID string) (state.AccessReport, error)
+	DefinitionAccess(req state.AccessReportRequest) (state.AccessReport, error)
+}
+
+type reportService struct {
+	sm                 state.Manager
+	defaultWindow      time.Duration
+	maxWindow          time.Duration
+	topExitReasons     int
+	defaultReportSlice string
+	exceptionLimit     int
+	accessReportRuns   int
+}
+
+// NewReportService configures and returns a ReportService
+func NewReportService(conf config.Config, sm state.Manager) (ReportService, error) {
+	rs := reportService{
+		sm:                 sm,
+		defaultWindow:      7 * 24 * time.Hour,
+		maxWindow:          90 * 24 * time.Hour,
+		topExitReasons:     5,
+		defaultReportSlice: "group_name",
+		exceptionLimit:     100,
+		accessReportRuns:   1000,
+	}
+	if conf.IsSet("usage_report_default_window_hours") {
+		rs.defaultWindow = time.Duration(conf.GetInt("usage_report_default_window_hours")) * time.Hour
+	}
+	if conf.IsSet("usage_report_max_window_hours") {
+		rs.maxWindow = time.Duration(conf.GetInt("usage_report_max_window_hours")) * time.Hour
+	}
+	if conf.IsSet("usage_report_top_exit_reasons") {
+		rs.topExitReasons = conf.GetInt("usage_report_top_exit_reasons")
+	}
+	if conf.IsSet("access_report_max_runs") {
+		rs.accessReportRuns = conf.GetInt("access_report_max_runs")
+	}
+	return &rs, nil
+}
+
+// Usage returns queue wait and runtime percentiles, outcome rates, top exit
+// reasons, OOM and spot interruption counts for the requested slice and window
+func (rs *reportService) Usage(req state.UsageReportRequest) (state.UsageReport, error) {
+	var report state.UsageReport
+	if len(req.GroupBy) == 0 {
+		req.GroupBy = rs.defaultReportSlice
+	}
+	if !state.UsageReportGroupings[req.GroupBy] {
+		var valid []string
+		for k := range state.UsageReportGroupings {
+			valid = append(valid, k)
+		}
+		return report, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("group_by [%s] is not valid; valid groupings: %s", req.GroupBy, valid)}
+	}
+
+	if req.Until.IsZero() {
+		req.Until = time.Now()
+	}
+	if req.Since.IsZero() {
+		req.Since = req.Until.Add(-rs.defaultWindow)
+	}
+	if !req.Since.Before(req.Until) {
+		return report, exceptions.MalformedInput{ErrorString: "since must be before until"}
+	}
+	if req.Until.Sub(req.Since) > rs.maxWindow {
+		return report, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("report window may not exceed %v", rs.maxWindow)}
+	}
+	if req.TopExitReasons <= 0 {
+		req.TopExitReasons = rs.topExitReasons
+	}
+	return rs.sm.GetUsageReport(req)
+}
+
+// Exceptions groups the exceptions logged by failed runs of the requested
+// window by fingerprint, with when each was first seen across all runs
+func (rs *reportService) Exceptions(req state.ExceptionReportRequest) (state.ExceptionReport, error) {
+	var report state.ExceptionReport
+	if req.Until.IsZero() {
+		req.Until = time.Now()
+	}
+	if req.Since.IsZero() {
+		req.Since = req.Until.Add(-rs.defaultWindow)
+	}
+	if !req.Since.Before(req.Until) {
+		return report, exceptions.MalformedInput{ErrorString: "since must be before until"}
+	}
+	if req.Until.Sub(req.Since) > rs.maxWindow {
+		return report, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("report window may not exceed %v", rs.maxWindow)}
+	}
+	if req.Limit <= 0 || req.Limit > rs.exceptionLimit {
+		req.Limit = rs.exceptionLimit
+	}
+	return rs.sm.GetExceptionReport(req)
+}
+
+// RunExceptions returns the exceptions found in the logs of a run
+func (rs *reportService) RunExceptions(runID string) (state.LoggedExceptionList, error) {
+	if _, err := rs.sm.GetRun(runID); err != nil {
+		return state.LoggedExceptionList{}, err
+	}
+	return rs.sm.ListLoggedExceptions(runID)
+}
+
+// RunAccess summarizes the AWS calls cloudtrail attributed to a run
+func (rs *reportService) RunAccess(runID string) (state.AccessReport, error) {
+	run, err := rs.sm.GetRun(runID)
+	if err != nil {
+		return state.AccessReport{}, err
+	}
+	var runs []state.RunAccessRecords
+	if run.CloudTrailNotifications != nil {
+		runs = append(runs, state.RunAccessRecords{
+			RunID: run.RunID, FinishedAt: run.FinishedAt, CloudTrailNotifications: *run.CloudTrailNotifications})
+	}
+	report := state.NewAccessReport(runs)
+	report.RunID = run.RunID
+	report.DefinitionID = run.DefinitionID
+	return report, nil
+}
+
+// DefinitionAccess summarizes the AWS calls of the latest runs of a
+// definition that finished in the requested window
+func (rs *reportService) DefinitionAccess(req state.AccessReportRequest) (state.AccessReport, error) {
+	if _, err := rs.sm.GetDefinition(req.DefinitionID); err != nil {
+		return state.AccessReport{}, err
+	}
+	if req.Until.IsZero() {
+		req.Until = time.Now()
+	}
+	if req.Since.IsZero() {
+		req.Since = req.Until.Add(-rs.defaultWindow)
+	}
+	if !req.Since.Before(req.Until) {
+		return state.AccessReport{}, exceptions.MalformedInput{ErrorString: "since must be before until"}
+	}
+	if req.Until.Sub(req.Since) > rs.maxWindow {
+		return state.AccessReport{}, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("report window may not exceed %v", rs.maxWindow)}
+	}
+	if req.Limit <= 0 || req.Limit > rs.accessReportRuns {
+		req.Limit = rs.accessReportRuns
+	}
+	runs, err := rs.sm.ListRunAccessRecords(req)
+	if err != nil {
+		return state.AccessReport{}, err
+	}
+	report := state.NewAccessReport(runs)
+	report.DefinitionID = req.DefinitionID
+	report.Since = &req.Since
+	report.Until = &req.Until
+	return report, nil
+}
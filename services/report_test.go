@@ -0,0 +1,140 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func setUpReportService(t *testing.T) (ReportService, *testutils.ImplementsAllTheThings) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			"runA": {DefinitionID: "A", GroupName: "A", RunID: "runA"},
+			"runB": {DefinitionID: "B", GroupName: "B", RunID: "runB"},
+		},
+	}
+	rs, _ := NewReportService(c, &imp)
+	return rs, &imp
+}
+
+func TestReportService_Usage(t *testing.T) {
+	rs, imp := setUpReportService(t)
+
+	report, err := rs.Usage(state.UsageReportRequest{})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if len(imp.Calls) != 1 || imp.Calls[0] != "GetUsageReport" {
+		t.Errorf("Expected exactly one call to GetUsageReport but was: %v", imp.Calls)
+	}
+
+	if report.GroupBy != "group_name" {
+		t.Errorf("Expected default grouping [group_name] but was [%s]", report.GroupBy)
+	}
+
+	if report.Since.IsZero() || report.Until.IsZero() || !report.Since.Before(report.Until) {
+		t.Errorf("Expected a default report window, got [%v, %v]", report.Since, report.Until)
+	}
+
+	if report.Total != 2 {
+		t.Errorf("Expected 2 slices but was %v", report.Total)
+	}
+}
+
+func TestReportService_UsageInvalid(t *testing.T) {
+	rs, imp := setUpReportService(t)
+	now := time.Now()
+
+	invalid := []state.UsageReportRequest{
+		{GroupBy: "image"},
+		{Since: now, Until: now.Add(-time.Hour)},
+		{Since: now.Add(-365 * 24 * time.Hour), Until: now},
+	}
+
+	for _, req := range invalid {
+		_, err := rs.Usage(req)
+		if _, ok := err.(exceptions.MalformedInput); !ok {
+			t.Errorf("Expected MalformedInput for request %+v but was %v", req, err)
+		}
+	}
+
+	if len(imp.Calls) != 0 {
+		t.Errorf("Expected no state calls for invalid requests but was: %v", imp.Calls)
+	}
+}
+
+func TestReportService_Exceptions(t *testing.T) {
+	rs, imp := setUpReportService(t)
+	imp.LoggedExceptions = map[string][]state.LoggedException{
+		"runA": {{Fingerprint: "f1", Kind: state.ExceptionKindPython, ExceptionType: "KeyError"}},
+		"runB": {{Fingerprint: "f1", Kind: state.ExceptionKindPython, ExceptionType: "KeyError"}},
+	}
+
+	report, err := rs.Exceptions(state.ExceptionReportRequest{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if report.Since.IsZero() || report.Until.IsZero() {
+		t.Errorf("Expected a default report window, got [%v, %v]", report.Since, report.Until)
+	}
+	if report.Total != 1 || report.Exceptions[0].Runs != 2 {
+		t.Errorf("Expected one exception logged by 2 runs, got %v", report.Exceptions)
+	}
+
+	groupName := "A"
+	report, _ = rs.Exceptions(state.ExceptionReportRequest{GroupName: &groupName})
+	if report.Total != 1 || report.Exceptions[0].Runs != 1 {
+		t.Errorf("Expected one exception logged by 1 run of group A, got %v", report.Exceptions)
+	}
+
+	now := time.Now()
+	if _, err = rs.Exceptions(state.ExceptionReportRequest{Since: now, Until: now.Add(-time.Hour)}); err == nil {
+		t.Errorf("Expected an inverted window to be rejected")
+	}
+
+	found, err := rs.RunExceptions("runA")
+	if err != nil || found.Total != 1 {
+		t.Errorf("Expected the exception of runA, got %v %v", found, err)
+	}
+	if _, err = rs.RunExceptions("nope"); err == nil {
+		t.Errorf("Expected an error for a missing run")
+	}
+}
+
+func TestReportService_Access(t *testing.T) {
+	rs, imp := setUpReportService(t)
+	imp.Definitions = map[string]state.Definition{"A": {DefinitionID: "A"}}
+	records := &state.CloudTrailNotifications{Records: []state.Record{
+		{EventSource: "s3.amazonaws.com", EventName: "GetObject"},
+		{EventSource: "s3.amazonaws.com", EventName: "PutObject", ErrorCode: "AccessDenied"},
+	}}
+	imp.Runs["runA"] = state.Run{DefinitionID: "A", GroupName: "A", RunID: "runA", CloudTrailNotifications: records}
+	imp.Runs["runC"] = state.Run{DefinitionID: "A", GroupName: "A", RunID: "runC", CloudTrailNotifications: records}
+
+	report, err := rs.RunAccess("runA")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if report.RunID != "runA" || report.Runs != 1 || report.DeniedCalls != 1 || len(report.PolicySuggestion.Statement) != 1 {
+		t.Errorf("Expected the access of runA with one denied call, got %v", report)
+	}
+
+	report, err = rs.DefinitionAccess(state.AccessReportRequest{DefinitionID: "A"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if report.Runs != 2 || report.Calls != 4 || report.Since == nil || report.Until == nil {
+		t.Errorf("Expected the access of 2 runs in the default window, got %v", report)
+	}
+	if _, err = rs.DefinitionAccess(state.AccessReportRequest{DefinitionID: "nope"}); err == nil {
+		t.Errorf("Expected an error for a missing definition")
+	}
+}
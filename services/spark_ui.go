@@ -0,0 +1,81 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+)
+
+// SparkUIService resolves where the Spark UI of a run is served: the
+// driver's UI while the run is running, the History Server once it is done
+type SparkUIService interface {
+	Target(runID string) (*url.URL, error)
+}
+
+type sparkUIService struct {
+	sm             state.Manager
+	historyServer  string
+	historyServers map[string]string
+}
+
+// NewSparkUIService configures and returns a SparkUIService; History Servers
+// are looked up by virtual cluster in `emr_history_server_uris`, falling back
+// to `emr_history_server_uri`
+func NewSparkUIService(conf config.Config, sm state.Manager) (SparkUIService, error) {
+	sus := sparkUIService{
+		sm:             sm,
+		historyServer:  strings.TrimRight(conf.GetString("emr_history_server_uri"), "/"),
+		historyServers: map[string]string{},
+	}
+	if conf.IsSet("emr_history_server_uris") {
+		for virtualCluster, uri := range conf.GetStringMapString("emr_history_server_uris") {
+			sus.historyServers[virtualCluster] = strings.TrimRight(uri, "/")
+		}
+	}
+	return &sus, nil
+}
+
+// Target returns the base url of the run's Spark UI
+func (sus *sparkUIService) Target(runID string) (*url.URL, error) {
+	run, err := sus.sm.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	if !state.IsSparkEngine(run.Engine) || run.SparkExtension == nil {
+		return nil, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("run %s is not a spark run", runID)}
+	}
+	se := run.SparkExtension
+	if se.SparkAppId == nil {
+		return nil, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("run %s has not started a spark application yet", runID)}
+	}
+
+	var target string
+	if run.Status == state.StatusRunning && se.AppUri != nil {
+		target = *se.AppUri
+	} else {
+		historyServer := sus.historyServer
+		if se.VirtualClusterId != nil {
+			if uri, ok := sus.historyServers[*se.VirtualClusterId]; ok {
+				historyServer = uri
+			}
+		}
+		if len(historyServer) == 0 {
+			return nil, exceptions.MissingResource{
+				ErrorString: fmt.Sprintf("no spark history server for run %s", runID)}
+		}
+		target = fmt.Sprintf("%s/%s", historyServer, *se.SparkAppId)
+	}
+
+	u, err := url.Parse(strings.TrimRight(target, "/"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem parsing spark ui url of run %s", runID)
+	}
+	return u, nil
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func TestSparkUIService_Target(t *testing.T) {
+	os.Setenv("EMR_HISTORY_SERVER_URI", "https://shs.example.com/history/")
+	defer os.Unsetenv("EMR_HISTORY_SERVER_URI")
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+
+	appID := "spark-0001"
+	appURI := "https://spark.example.com/job/app-driver-svc"
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			"running": {RunID: "running", Engine: &state.EKSSparkEngine, Status: state.StatusRunning,
+				SparkExtension: &state.SparkExtension{SparkAppId: &appID, AppUri: &appURI}},
+			"stopped": {RunID: "stopped", Engine: &state.EKSSparkEngine, Status: state.StatusStopped,
+				SparkExtension: &state.SparkExtension{SparkAppId: &appID, AppUri: &appURI}},
+			"starting": {RunID: "starting", Engine: &state.EKSSparkEngine, Status: state.StatusPending,
+				SparkExtension: &state.SparkExtension{}},
+			"eks": {RunID: "eks", Engine: &state.EKSEngine, Status: state.StatusRunning},
+		},
+	}
+	sus, _ := NewSparkUIService(c, &imp)
+
+	target, err := sus.Target("running")
+	if err != nil || target.String() != appURI {
+		t.Errorf("Expected the driver's ui [%s] for a running run, got [%v] %v", appURI, target, err)
+	}
+	target, err = sus.Target("stopped")
+	if err != nil || target.String() != "https://shs.example.com/history/spark-0001" {
+		t.Errorf("Expected the history server for a stopped run, got [%v] %v", target, err)
+	}
+	if _, err = sus.Target("starting"); err == nil {
+		t.Errorf("Expected no spark ui before the application starts")
+	} else if _, ok := err.(exceptions.MissingResource); !ok {
+		t.Errorf("Expected MissingResource before the application starts, got %T", err)
+	}
+	if _, err = sus.Target("eks"); err == nil {
+		t.Errorf("Expected no spark ui for an eks run")
+	}
+}
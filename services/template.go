@@ -19,12 +19,25 @@ type TemplateService interface {
 }
 
 type templateService struct {
-	sm state.Manager
+	sm        state.Manager
+	placement state.PlacementPolicy
+	volumes   state.VolumePolicy
+	accounts  state.ServiceAccountPolicy
+	gpus      state.GPUCatalog
+	secrets   state.SecretPolicy
 }
 
 // NewTemplateService configures and returns a TemplateService.
 func NewTemplateService(conf config.Config, sm state.Manager) (TemplateService, error) {
-	ts := templateService{sm: sm}
+	accounts, err := state.NewServiceAccountPolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	gpus, err := state.NewGPUCatalog(conf)
+	if err != nil {
+		return nil, err
+	}
+	ts := templateService{sm: sm, placement: state.NewPlacementPolicy(conf), volumes: state.NewVolumePolicy(conf), accounts: accounts, gpus: gpus, secrets: state.NewSecretPolicy(conf)}
 	return &ts, nil
 }
 
@@ -40,6 +53,13 @@ func (ts *templateService) Create(req *state.CreateTemplateRequest) (state.Creat
 	if valid, reasons := curr.IsValid(); !valid {
 		return res, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
 	}
+	reasons := append(ts.placement.Validate(curr.Placement), ts.volumes.Validate(curr.Volumes)...)
+	reasons = append(reasons, ts.accounts.Validate(curr.ServiceAccount, state.TemplateGroupName)...)
+	reasons = append(reasons, ts.gpus.Validate(curr.ExecutableResources)...)
+	reasons = append(reasons, ts.secrets.Validate(state.TemplateGroupName, curr.EnvLists()...)...)
+	if len(reasons) > 0 {
+		return res, exceptions.MalformedInput{ErrorString: strings.Join(reasons, "\n")}
+	}
 
 	// 2. Attach template id.
 	templateID, err := state.NewTemplateID(curr)
@@ -179,6 +199,38 @@ func (ts *templateService) diff(prev state.Template, curr state.Template) bool {
 		return true
 	}
 
+	if reflect.DeepEqual(prev.Placement, curr.Placement) == false {
+		return true
+	}
+
+	if reflect.DeepEqual(prev.Volumes, curr.Volumes) == false {
+		return true
+	}
+
+	if reflect.DeepEqual(prev.InitContainers, curr.InitContainers) == false {
+		return true
+	}
+
+	if reflect.DeepEqual(prev.Sidecars, curr.Sidecars) == false {
+		return true
+	}
+
+	if reflect.DeepEqual(prev.UseImageEntrypoint, curr.UseImageEntrypoint) == false {
+		return true
+	}
+
+	if reflect.DeepEqual(prev.Concurrency, curr.Concurrency) == false {
+		return true
+	}
+
+	if reflect.DeepEqual(prev.ServiceAccount, curr.ServiceAccount) == false {
+		return true
+	}
+
+	if reflect.DeepEqual(prev.GpuType, curr.GpuType) == false {
+		return true
+	}
+
 	return false
 }
 
@@ -208,6 +260,9 @@ func (ts *templateService) constructTemplateFromCreateTemplateRequest(req *state
 	if req.Gpu != nil {
 		tpl.Gpu = req.Gpu
 	}
+	if req.GpuType != nil {
+		tpl.GpuType = req.GpuType
+	}
 	if req.Cpu != nil {
 		tpl.Cpu = req.Cpu
 	} else {
@@ -229,6 +284,27 @@ func (ts *templateService) constructTemplateFromCreateTemplateRequest(req *state
 	if req.Tags != nil {
 		tpl.Tags = req.Tags
 	}
+	if req.Placement != nil {
+		tpl.Placement = req.Placement
+	}
+	if req.Volumes != nil {
+		tpl.Volumes = req.Volumes
+	}
+	if req.InitContainers != nil {
+		tpl.InitContainers = req.InitContainers
+	}
+	if req.Sidecars != nil {
+		tpl.Sidecars = req.Sidecars
+	}
+	if req.UseImageEntrypoint != nil {
+		tpl.UseImageEntrypoint = req.UseImageEntrypoint
+	}
+	if req.Concurrency != nil {
+		tpl.Concurrency = req.Concurrency
+	}
+	if req.ServiceAccount != nil {
+		tpl.ServiceAccount = req.ServiceAccount
+	}
 	if req.Defaults != nil {
 		tpl.Defaults = req.Defaults
 	} else {
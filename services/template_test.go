@@ -0,0 +1,50 @@
+package services
+
+import (
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+	"testing"
+)
+
+func setUpTemplateServiceTest(t *testing.T) (TemplateService, *testutils.ImplementsAllTheThings) {
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	imp := testutils.ImplementsAllTheThings{
+		T:         t,
+		Templates: map[string]state.Template{},
+	}
+	ts, _ := NewTemplateService(c, &imp)
+	return ts, &imp
+}
+
+func TestTemplateService_CreateGpuType(t *testing.T) {
+	ts, imp := setUpTemplateServiceTest(t)
+	gpu := int64(1)
+	ara := false
+	unknown := "h100"
+	req := state.CreateTemplateRequest{
+		TemplateName:    "cupcake-gpu",
+		Schema:          state.TemplateJSONSchema{"type": "object"},
+		CommandTemplate: "echo hi",
+		ExecutableResources: state.ExecutableResources{
+			Image:                      "image:cupcake",
+			Gpu:                        &gpu,
+			GpuType:                    &unknown,
+			AdaptiveResourceAllocation: &ara,
+		},
+	}
+	if _, err := ts.Create(&req); err == nil {
+		t.Errorf("Expected template with gpu_type not in the catalog to result in error")
+	}
+
+	known := "v100"
+	req.GpuType = &known
+	res, err := ts.Create(&req)
+	if err != nil {
+		t.Fatalf("Expected template with catalog gpu_type to be created, got %v", err)
+	}
+	if tpl := imp.Templates[res.Template.TemplateID]; tpl.GpuType == nil || *tpl.GpuType != known {
+		t.Errorf("Expected the template to keep its gpu_type, got %v", tpl.GpuType)
+	}
+}
@@ -15,6 +15,8 @@ type WorkerService interface {
 	Get(workerType string, engine string) (state.Worker, error)
 	Update(workerType string, updates state.Worker) (state.Worker, error)
 	BatchUpdate(updates []state.Worker) (state.WorkersList, error)
+	GetDispatch() (state.DispatchState, error)
+	UpdateDispatch(update state.DispatchState) (state.DispatchState, error)
 }
 
 type workerService struct {
@@ -60,6 +62,16 @@ func (ws *workerService) BatchUpdate(updates []state.Worker) (state.WorkersList,
 	return ws.sm.BatchUpdateWorkers(updates)
 }
 
+// GetDispatch returns whether submit workers are dispatching queued runs
+func (ws *workerService) GetDispatch() (state.DispatchState, error) {
+	return ws.sm.GetDispatchState()
+}
+
+// UpdateDispatch pauses or resumes dispatch of queued runs for all instances
+func (ws *workerService) UpdateDispatch(update state.DispatchState) (state.DispatchState, error) {
+	return ws.sm.UpdateDispatchState(update)
+}
+
 func (ws *workerService) validate(workerType string) error {
 	if !state.IsValidWorkerType(workerType) {
 		var validTypesList []string
@@ -0,0 +1,429 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxAccessResources = 20
+	maxDeniedRuns      = 5
+	maxRecordEventIDs  = 200
+	iamPolicyVersion   = "2012-10-17"
+)
+
+// IAM prefixes of the services whose CloudTrail event source is named
+// differently.
+var iamServicePrefixes = map[string]string{
+	"monitoring": "cloudwatch",
+	"email":      "ses",
+}
+
+// IAM actions authorizing the S3 calls that are not named after one.
+var s3Actions = map[string]string{
+	"HeadObject":              "GetObject",
+	"HeadBucket":              "ListBucket",
+	"ListObjects":             "ListBucket",
+	"ListObjectsV2":           "ListBucket",
+	"ListObjectVersions":      "ListBucketVersions",
+	"CopyObject":              "PutObject",
+	"CreateMultipartUpload":   "PutObject",
+	"UploadPart":              "PutObject",
+	"UploadPartCopy":          "PutObject",
+	"CompleteMultipartUpload": "PutObject",
+	"DeleteObjects":           "DeleteObject",
+}
+
+// AccessReportRequest describes the definition and window of an access report.
+type AccessReportRequest struct {
+	DefinitionID string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// RunAccessRecords are the CloudTrail records attributed to a run.
+type RunAccessRecords struct {
+	RunID                   string                  `db:"run_id"`
+	FinishedAt              *time.Time              `db:"finished_at"`
+	CloudTrailNotifications CloudTrailNotifications `db:"cloudtrailnotifications"`
+}
+
+// AccessReport summarizes the AWS calls of one run, or of the runs of a
+// definition in a window: the services, actions and resources used, the
+// calls that were denied and the IAM policy that allows what was used.
+type AccessReport struct {
+	RunID            string          `json:"run_id,omitempty"`
+	DefinitionID     string          `json:"definition_id,omitempty"`
+	Since            *time.Time      `json:"since,omitempty"`
+	Until            *time.Time      `json:"until,omitempty"`
+	Runs             int             `json:"runs"`
+	Calls            int             `json:"calls"`
+	DeniedCalls      int             `json:"denied_calls"`
+	Services         []ServiceAccess `json:"services"`
+	Denied           []DeniedAccess  `json:"denied"`
+	PolicySuggestion IAMPolicy       `json:"policy_suggestion"`
+}
+
+// ServiceAccess lists the actions called on an AWS service.
+type ServiceAccess struct {
+	Service string         `json:"service"`
+	Calls   int            `json:"calls"`
+	Actions []ActionAccess `json:"actions"`
+}
+
+// ActionAccess counts the calls of an IAM action and the runs that made
+// them; at most 20 of the resources are listed.
+type ActionAccess struct {
+	Action    string   `json:"action"`
+	Calls     int      `json:"calls"`
+	Denied    int      `json:"denied"`
+	Runs      int      `json:"runs"`
+	Resources []string `json:"resources"`
+	LastSeen  string   `json:"last_seen,omitempty"`
+}
+
+// DeniedAccess is an action denied by IAM, with the first runs denied it.
+type DeniedAccess struct {
+	Action       string   `json:"action"`
+	ErrorCode    string   `json:"error_code"`
+	ErrorMessage string   `json:"error_message,omitempty"`
+	Calls        int      `json:"calls"`
+	Resources    []string `json:"resources"`
+	RunIDs       []string `json:"run_ids"`
+	LastSeen     string   `json:"last_seen,omitempty"`
+}
+
+// IAMPolicy is an IAM policy document.
+type IAMPolicy struct {
+	Version   string         `json:"Version"`
+	Statement []IAMStatement `json:"Statement"`
+}
+
+// IAMStatement is a statement of an IAM policy document.
+type IAMStatement struct {
+	Sid      string   `json:"Sid"`
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// Service is the IAM prefix of the service a record was sent to.
+func (w *Record) Service() string {
+	service := strings.TrimSuffix(w.EventSource, ".amazonaws.com")
+	if prefix, ok := iamServicePrefixes[service]; ok {
+		return prefix
+	}
+	return service
+}
+
+// Action is the IAM action authorizing the call of a record.
+func (w *Record) Action() string {
+	service := w.Service()
+	if action, ok := s3Actions[w.EventName]; ok && service == "s3" {
+		return fmt.Sprintf("%s:%s", service, action)
+	}
+	return fmt.Sprintf("%s:%s", service, w.EventName)
+}
+
+// Denied is true for calls that IAM did not authorize.
+func (w *Record) Denied() bool {
+	return strings.Contains(w.ErrorCode, "AccessDenied") ||
+		strings.Contains(w.ErrorCode, "UnauthorizedOperation") ||
+		strings.Contains(w.ErrorCode, "NotAuthorized")
+}
+
+// Calls is the number of calls of a record; records saved before calls were
+// counted stand for one.
+func (w *Record) Calls() int {
+	if w.Count > 0 {
+		return w.Count
+	}
+	return 1
+}
+
+// AccessedResources are the resources CloudTrail reported for a call or,
+// for the calls it reports none for, the S3 objects and ARNs of the request.
+func (w *Record) AccessedResources() []RecordResource {
+	if len(w.Resources) > 0 {
+		return w.Resources
+	}
+	var resources []RecordResource
+	if bucket, ok := w.RequestParameters["bucketName"].(string); ok && w.Service() == "s3" {
+		resources = append(resources, RecordResource{ARN: fmt.Sprintf("arn:aws:s3:::%s", bucket), Type: "AWS::S3::Bucket"})
+		if key, ok := w.RequestParameters["key"].(string); ok {
+			resources = append(resources, RecordResource{ARN: fmt.Sprintf("arn:aws:s3:::%s/%s", bucket, key), Type: "AWS::S3::Object"})
+		}
+	}
+	keys := make([]string, 0, len(w.RequestParameters))
+	for k := range w.RequestParameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if arn, ok := w.RequestParameters[k].(string); ok && strings.HasPrefix(arn, "arn:") {
+			resources = append(resources, RecordResource{ARN: arn})
+		}
+	}
+	return resources
+}
+
+// Merge adds the calls of another record of the same call and outcome to the
+// record kept for a run. S3 objects are kept as the prefix they are under and
+// at most 20 resources are kept. The ids of the last 200 calls are kept so
+// that a call delivered again, e.g. in a CloudTrail notification received
+// twice, is counted once.
+func (w *Record) Merge(other Record) {
+	ids := other.EventIDs
+	if len(other.EventID) > 0 {
+		ids = append(ids, other.EventID)
+	}
+	seen := make(map[string]bool, len(w.EventIDs))
+	for _, id := range w.EventIDs {
+		seen[id] = true
+	}
+	delivered := len(ids) > 0
+	for _, id := range ids {
+		if !seen[id] {
+			delivered = false
+			seen[id] = true
+			w.EventIDs = append(w.EventIDs, id)
+		}
+	}
+	if len(w.EventIDs) > maxRecordEventIDs {
+		w.EventIDs = w.EventIDs[len(w.EventIDs)-maxRecordEventIDs:]
+	}
+	if delivered {
+		return
+	}
+
+	w.Count += other.Calls()
+	if other.EventTime >= w.EventTime {
+		w.EventTime = other.EventTime
+		w.ErrorMessage = other.ErrorMessage
+	}
+	w.Resources = mergeResources(w.Resources, other.Resources)
+}
+
+// mergeResources adds resources to those of a record, S3 objects as the
+// prefix they are under, up to maxAccessResources.
+func mergeResources(resources []RecordResource, others []RecordResource) []RecordResource {
+	arns := make(map[string]bool, len(resources))
+	for _, resource := range resources {
+		arns[resource.ARN] = true
+	}
+	for _, resource := range others {
+		if len(resources) >= maxAccessResources {
+			break
+		}
+		if resource.Type == "AWS::S3::Object" {
+			resource.ARN = policyResource(resource.ARN)
+		}
+		if !arns[resource.ARN] {
+			arns[resource.ARN] = true
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// actionResources are the resources an action was called on; the bucket of
+// an S3 object is left out, object actions being authorized on the object.
+func (w *Record) actionResources() []string {
+	var objects, all []string
+	for _, resource := range w.Resources {
+		if resource.Type == "AWS::S3::Object" {
+			objects = append(objects, resource.ARN)
+		}
+		all = append(all, resource.ARN)
+	}
+	if len(objects) > 0 {
+		return objects
+	}
+	return all
+}
+
+// policyResource generalizes the resources of a policy: S3 objects are
+// allowed under the top level prefix of their key.
+func policyResource(arn string) string {
+	if !strings.HasPrefix(arn, "arn:aws:s3:::") || !strings.Contains(arn, "/") {
+		return arn
+	}
+	parts := strings.SplitN(strings.TrimPrefix(arn, "arn:aws:s3:::"), "/", 3)
+	if len(parts) < 3 {
+		return fmt.Sprintf("arn:aws:s3:::%s/*", parts[0])
+	}
+	return fmt.Sprintf("arn:aws:s3:::%s/%s/*", parts[0], parts[1])
+}
+
+type actionAccess struct {
+	ActionAccess
+	resources       map[string]bool
+	policyResources map[string]bool
+	runs            map[string]bool
+}
+
+type deniedAccess struct {
+	DeniedAccess
+	resources map[string]bool
+}
+
+// NewAccessReport builds the access report of the records of runs.
+func NewAccessReport(runs []RunAccessRecords) AccessReport {
+	report := AccessReport{Services: []ServiceAccess{}, Denied: []DeniedAccess{}}
+	actions := map[string]*actionAccess{}
+	denied := map[string]*deniedAccess{}
+	for _, run := range runs {
+		report.Runs++
+		for _, record := range run.CloudTrailNotifications.Records {
+			action := record.Action()
+			calls := record.Calls()
+			report.Calls += calls
+			a, ok := actions[action]
+			if !ok {
+				a = &actionAccess{
+					ActionAccess:    ActionAccess{Action: action, Resources: []string{}},
+					resources:       map[string]bool{},
+					policyResources: map[string]bool{},
+					runs:            map[string]bool{},
+				}
+				actions[action] = a
+			}
+			a.Calls += calls
+			if !a.runs[run.RunID] {
+				a.runs[run.RunID] = true
+				a.Runs++
+			}
+			if record.EventTime > a.LastSeen {
+				a.LastSeen = record.EventTime
+			}
+			resources := record.actionResources()
+			for _, resource := range resources {
+				if !a.resources[resource] && len(a.Resources) < maxAccessResources {
+					a.Resources = append(a.Resources, resource)
+				}
+				a.resources[resource] = true
+			}
+
+			if !record.Denied() {
+				if len(resources) == 0 {
+					a.policyResources["*"] = true
+				}
+				for _, resource := range resources {
+					a.policyResources[policyResource(resource)] = true
+				}
+				continue
+			}
+			a.Denied += calls
+			report.DeniedCalls += calls
+			key := fmt.Sprintf("%s-%s", action, record.ErrorCode)
+			d, ok := denied[key]
+			if !ok {
+				d = &deniedAccess{
+					DeniedAccess: DeniedAccess{Action: action, ErrorCode: record.ErrorCode, Resources: []string{}, RunIDs: []string{}},
+					resources:    map[string]bool{},
+				}
+				denied[key] = d
+			}
+			d.Calls += calls
+			if record.EventTime >= d.LastSeen {
+				d.LastSeen = record.EventTime
+				d.ErrorMessage = record.ErrorMessage
+			}
+			for _, resource := range resources {
+				if !d.resources[resource] && len(d.Resources) < maxAccessResources {
+					d.Resources = append(d.Resources, resource)
+				}
+				d.resources[resource] = true
+			}
+			if len(d.RunIDs) < maxDeniedRuns && (len(d.RunIDs) == 0 || d.RunIDs[len(d.RunIDs)-1] != run.RunID) {
+				d.RunIDs = append(d.RunIDs, run.RunID)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	services := map[string]int{}
+	for _, name := range names {
+		a := actions[name]
+		sort.Strings(a.Resources)
+		service := strings.SplitN(name, ":", 2)[0]
+		i, ok := services[service]
+		if !ok {
+			i = len(report.Services)
+			services[service] = i
+			report.Services = append(report.Services, ServiceAccess{Service: service, Actions: []ActionAccess{}})
+		}
+		report.Services[i].Calls += a.Calls
+		report.Services[i].Actions = append(report.Services[i].Actions, a.ActionAccess)
+	}
+
+	for _, d := range denied {
+		sort.Strings(d.Resources)
+		report.Denied = append(report.Denied, d.DeniedAccess)
+	}
+	sort.Slice(report.Denied, func(i, j int) bool {
+		if report.Denied[i].Calls != report.Denied[j].Calls {
+			return report.Denied[i].Calls > report.Denied[j].Calls
+		}
+		return report.Denied[i].Action < report.Denied[j].Action
+	})
+
+	report.PolicySuggestion = suggestPolicy(names, actions)
+	return report
+}
+
+// suggestPolicy allows the actions that were called and not denied on the
+// resources they were called on; actions of a service called on the same
+// resources share a statement.
+func suggestPolicy(names []string, actions map[string]*actionAccess) IAMPolicy {
+	policy := IAMPolicy{Version: iamPolicyVersion, Statement: []IAMStatement{}}
+	statements := map[string]int{}
+	sids := map[string]int{}
+	for _, name := range names {
+		a := actions[name]
+		if len(a.policyResources) == 0 {
+			continue
+		}
+		var resources []string
+		if a.policyResources["*"] {
+			resources = []string{"*"}
+		} else {
+			for resource := range a.policyResources {
+				resources = append(resources, resource)
+			}
+			sort.Strings(resources)
+		}
+		service := strings.SplitN(name, ":", 2)[0]
+		key := fmt.Sprintf("%s %s", service, strings.Join(resources, " "))
+		if i, ok := statements[key]; ok {
+			policy.Statement[i].Action = append(policy.Statement[i].Action, name)
+			continue
+		}
+		sids[service]++
+		statements[key] = len(policy.Statement)
+		policy.Statement = append(policy.Statement, IAMStatement{
+			Sid:      fmt.Sprintf("%s%d", statementName(service), sids[service]),
+			Effect:   "Allow",
+			Action:   []string{name},
+			Resource: resources,
+		})
+	}
+	return policy
+}
+
+func statementName(service string) string {
+	var name []string
+	for _, part := range strings.FieldsFunc(service, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		name = append(name, strings.ToUpper(part[:1])+part[1:])
+	}
+	return strings.Join(name, "")
+}
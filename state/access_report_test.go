@@ -0,0 +1,119 @@
+package state
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRecord_Action(t *testing.T) {
+	cases := map[string]Record{
+		"s3:ListBucket":            {EventSource: "s3.amazonaws.com", EventName: "ListObjectsV2"},
+		"s3:PutObject":             {EventSource: "s3.amazonaws.com", EventName: "CompleteMultipartUpload"},
+		"dynamodb:GetItem":         {EventSource: "dynamodb.amazonaws.com", EventName: "GetItem"},
+		"cloudwatch:PutMetricData": {EventSource: "monitoring.amazonaws.com", EventName: "PutMetricData"},
+	}
+	for expected, record := range cases {
+		if record.Action() != expected {
+			t.Errorf("Expected action %s, got %s", expected, record.Action())
+		}
+	}
+}
+
+func TestRecord_AccessedResources(t *testing.T) {
+	record := Record{
+		EventSource: "s3.amazonaws.com",
+		EventName:   "GetObject",
+		RequestParameters: map[string]interface{}{
+			"bucketName": "data",
+			"key":        "raw/2022/01/10/part-0.parquet",
+		},
+	}
+	expected := []RecordResource{
+		{ARN: "arn:aws:s3:::data", Type: "AWS::S3::Bucket"},
+		{ARN: "arn:aws:s3:::data/raw/2022/01/10/part-0.parquet", Type: "AWS::S3::Object"},
+	}
+	if resources := record.AccessedResources(); !reflect.DeepEqual(resources, expected) {
+		t.Errorf("Expected the S3 object of the request, got %v", resources)
+	}
+
+	record = Record{
+		EventSource:       "secretsmanager.amazonaws.com",
+		EventName:         "GetSecretValue",
+		RequestParameters: map[string]interface{}{"secretId": "arn:aws:secretsmanager:us-east-1:123:secret:db"},
+	}
+	if resources := record.AccessedResources(); len(resources) != 1 || resources[0].ARN != "arn:aws:secretsmanager:us-east-1:123:secret:db" {
+		t.Errorf("Expected the ARN of the request, got %v", resources)
+	}
+}
+
+func TestNewAccessReport(t *testing.T) {
+	object := func(key string) []RecordResource {
+		return []RecordResource{
+			{ARN: "arn:aws:s3:::data", Type: "AWS::S3::Bucket"},
+			{ARN: "arn:aws:s3:::data/" + key, Type: "AWS::S3::Object"},
+		}
+	}
+	runs := []RunAccessRecords{
+		{RunID: "runA", CloudTrailNotifications: CloudTrailNotifications{Records: []Record{
+			{EventSource: "s3.amazonaws.com", EventName: "GetObject", Resources: object("raw/a.csv"), Count: 3},
+			{EventSource: "s3.amazonaws.com", EventName: "HeadObject", Resources: object("raw/b.csv")},
+			{EventSource: "sts.amazonaws.com", EventName: "GetCallerIdentity"},
+		}}},
+		{RunID: "runB", CloudTrailNotifications: CloudTrailNotifications{Records: []Record{
+			{EventSource: "s3.amazonaws.com", EventName: "GetObject", Resources: object("raw/c.csv")},
+			{EventSource: "s3.amazonaws.com", EventName: "PutObject", Resources: object("out/c.csv"),
+				ErrorCode: "AccessDenied", ErrorMessage: "Access Denied", EventTime: "2022-01-10T12:00:00Z"},
+		}}},
+	}
+	report := NewAccessReport(runs)
+	if report.Runs != 2 || report.Calls != 7 || report.DeniedCalls != 1 {
+		t.Errorf("Expected 2 runs, 7 calls and 1 denied, got %d, %d and %d", report.Runs, report.Calls, report.DeniedCalls)
+	}
+	if len(report.Services) != 2 || report.Services[0].Service != "s3" || report.Services[0].Calls != 6 {
+		t.Fatalf("Expected s3 and sts, got %v", report.Services)
+	}
+	get := report.Services[0].Actions[0]
+	if get.Action != "s3:GetObject" || get.Calls != 5 || get.Runs != 2 || len(get.Resources) != 3 {
+		t.Errorf("Expected s3:GetObject on 3 objects by 2 runs, got %v", get)
+	}
+	if len(report.Denied) != 1 || report.Denied[0].Action != "s3:PutObject" || report.Denied[0].RunIDs[0] != "runB" {
+		t.Errorf("Expected the denied s3:PutObject, got %v", report.Denied)
+	}
+
+	expected := []IAMStatement{
+		{Sid: "S31", Effect: "Allow", Action: []string{"s3:GetObject"}, Resource: []string{"arn:aws:s3:::data/raw/*"}},
+		{Sid: "Sts1", Effect: "Allow", Action: []string{"sts:GetCallerIdentity"}, Resource: []string{"*"}},
+	}
+	if !reflect.DeepEqual(report.PolicySuggestion.Statement, expected) {
+		t.Errorf("Expected the policy to allow the calls that were not denied, got %v", report.PolicySuggestion.Statement)
+	}
+}
+
+func TestRecord_Merge(t *testing.T) {
+	var record Record
+	for i := 0; i < maxAccessResources+5; i++ {
+		record.Merge(Record{
+			EventID:   fmt.Sprintf("event-%d", i),
+			EventTime: fmt.Sprintf("2022-01-10T10:%02d:00Z", i),
+			Resources: []RecordResource{{ARN: fmt.Sprintf("arn:aws:s3:::bucket-%d/key", i), Type: "AWS::S3::Object"}},
+		})
+	}
+	if record.Count != maxAccessResources+5 || len(record.Resources) != maxAccessResources {
+		t.Errorf("Expected %d calls on at most %d resources, got %d and %d", maxAccessResources+5, maxAccessResources, record.Count, len(record.Resources))
+	}
+	if record.Resources[0].ARN != "arn:aws:s3:::bucket-0/*" {
+		t.Errorf("Expected S3 objects kept as their prefix, got %s", record.Resources[0].ARN)
+	}
+
+	record.Merge(Record{EventID: "event-3"})
+	if record.Count != maxAccessResources+5 {
+		t.Errorf("Expected a call delivered again to be counted once, got %d", record.Count)
+	}
+	for i := 0; i < maxRecordEventIDs; i++ {
+		record.Merge(Record{EventID: fmt.Sprintf("later-%d", i)})
+	}
+	if len(record.EventIDs) != maxRecordEventIDs {
+		t.Errorf("Expected the ids of the last %d calls, got %d", maxRecordEventIDs, len(record.EventIDs))
+	}
+}
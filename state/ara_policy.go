@@ -0,0 +1,162 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+)
+
+// ARAPolicy controls how adaptive resource allocation estimates the
+// resources of a run from the history of its command
+type ARAPolicy struct {
+	Name                  string  `json:"-"`
+	Enabled               bool    `json:"enabled"`
+	MemoryPercentile      float64 `json:"memory_percentile"`
+	CpuPercentile         float64 `json:"cpu_percentile"`
+	MemoryMultiplier      float64 `json:"memory_multiplier"`
+	CpuMultiplier         float64 `json:"cpu_multiplier"`
+	OOMMemoryMultiplier   float64 `json:"oom_memory_multiplier"`
+	LookbackDays          int     `json:"lookback_days"`
+	SampleLimit           int     `json:"sample_limit"`
+	IncludeSuccessfulRuns bool    `json:"include_successful_runs"`
+	LargeMemoryMin        int64   `json:"large_memory_min"`
+	LargeMemoryMax        int64   `json:"large_memory_max"`
+	LargeMemoryCpuRatio   int64   `json:"large_memory_cpu_ratio"`
+}
+
+// ARAPolicies holds the default policy and any per group overrides
+type ARAPolicies struct {
+	Default ARAPolicy
+	Groups  map[string]ARAPolicy
+}
+
+// DefaultARAPolicy mirrors the historical behavior: p99 of OOM'd runs over
+// 30 days, memory x1.75 and cpu x1.25, and an r5 style 8:1 memory to cpu
+// ratio for requests between 36GB and 128GB.
+var DefaultARAPolicy = ARAPolicy{
+	Name:                  "default",
+	Enabled:               true,
+	MemoryPercentile:      0.99,
+	CpuPercentile:         0.99,
+	MemoryMultiplier:      1.75,
+	CpuMultiplier:         1.25,
+	OOMMemoryMultiplier:   2,
+	LookbackDays:          30,
+	SampleLimit:           30,
+	IncludeSuccessfulRuns: false,
+	LargeMemoryMin:        36864,
+	LargeMemoryMax:        131072,
+	LargeMemoryCpuRatio:   8,
+}
+
+// NewARAPolicies reads the default policy from `ara_*` keys and per group
+// overrides from `ara_group_policies`, a map of group name to a JSON object
+// holding only the fields to override
+func NewARAPolicies(conf config.Config) (ARAPolicies, error) {
+	policies := ARAPolicies{Default: DefaultARAPolicy, Groups: make(map[string]ARAPolicy)}
+	p := &policies.Default
+
+	if conf.IsSet("ara_enabled") {
+		p.Enabled = conf.GetBool("ara_enabled")
+	}
+	if conf.IsSet("ara_memory_percentile") {
+		p.MemoryPercentile = conf.GetFloat64("ara_memory_percentile")
+	}
+	if conf.IsSet("ara_cpu_percentile") {
+		p.CpuPercentile = conf.GetFloat64("ara_cpu_percentile")
+	}
+	if conf.IsSet("ara_memory_multiplier") {
+		p.MemoryMultiplier = conf.GetFloat64("ara_memory_multiplier")
+	}
+	if conf.IsSet("ara_cpu_multiplier") {
+		p.CpuMultiplier = conf.GetFloat64("ara_cpu_multiplier")
+	}
+	if conf.IsSet("ara_oom_memory_multiplier") {
+		p.OOMMemoryMultiplier = conf.GetFloat64("ara_oom_memory_multiplier")
+	}
+	if conf.IsSet("ara_lookback_days") {
+		p.LookbackDays = conf.GetInt("ara_lookback_days")
+	}
+	if conf.IsSet("ara_sample_limit") {
+		p.SampleLimit = conf.GetInt("ara_sample_limit")
+	}
+	if conf.IsSet("ara_include_successful_runs") {
+		p.IncludeSuccessfulRuns = conf.GetBool("ara_include_successful_runs")
+	}
+	if conf.IsSet("ara_large_memory_min") {
+		p.LargeMemoryMin = int64(conf.GetInt("ara_large_memory_min"))
+	}
+	if conf.IsSet("ara_large_memory_max") {
+		p.LargeMemoryMax = int64(conf.GetInt("ara_large_memory_max"))
+	}
+	if conf.IsSet("ara_large_memory_cpu_ratio") {
+		p.LargeMemoryCpuRatio = int64(conf.GetInt("ara_large_memory_cpu_ratio"))
+	}
+	if err := p.validate(); err != nil {
+		return policies, err
+	}
+
+	if conf.IsSet("ara_group_policies") {
+		for group, overrides := range conf.GetStringMapString("ara_group_policies") {
+			gp := policies.Default
+			if err := json.Unmarshal([]byte(overrides), &gp); err != nil {
+				return policies, errors.Wrapf(err, "invalid ara policy for group [%s]", group)
+			}
+			gp.Name = fmt.Sprintf("group:%s", group)
+			if err := gp.validate(); err != nil {
+				return policies, err
+			}
+			policies.Groups[group] = gp
+		}
+	}
+	return policies, nil
+}
+
+// For returns the policy that applies to a group.
+func (p ARAPolicies) For(groupName string) ARAPolicy {
+	if gp, ok := p.Groups[groupName]; ok {
+		return gp
+	}
+	return p.Default
+}
+
+func (p ARAPolicy) validate() error {
+	if p.MemoryPercentile <= 0 || p.MemoryPercentile > 1 || p.CpuPercentile <= 0 || p.CpuPercentile > 1 {
+		return errors.Errorf("ara policy [%s] percentiles must be within (0, 1]", p.Name)
+	}
+	if p.MemoryMultiplier <= 0 || p.CpuMultiplier <= 0 || p.OOMMemoryMultiplier <= 0 {
+		return errors.Errorf("ara policy [%s] multipliers must be positive", p.Name)
+	}
+	if p.LookbackDays <= 0 || p.SampleLimit <= 0 {
+		return errors.Errorf("ara policy [%s] lookback_days and sample_limit must be positive", p.Name)
+	}
+	if p.LargeMemoryCpuRatio <= 0 {
+		return errors.Errorf("ara policy [%s] large_memory_cpu_ratio must be positive", p.Name)
+	}
+	return nil
+}
+
+// Sources of the resources applied to a run.
+const (
+	ARASourceDefaults    = "defaults"
+	ARASourceLargeMemory = "large_memory_ratio"
+	ARASourceHistory     = "history"
+	ARASourceGpu         = "gpu"
+)
+
+// ARAEstimate records which resource estimate was applied to a run and why,
+// along with the cpu and memory the run requested before it was adapted
+type ARAEstimate struct {
+	Policy          string `json:"policy"`
+	Source          string `json:"source"`
+	Reason          string `json:"reason"`
+	Samples         int64  `json:"samples,omitempty"`
+	DefaultCpu      int64  `json:"default_cpu"`
+	DefaultMemory   int64  `json:"default_memory"`
+	EstimatedCpu    int64  `json:"estimated_cpu,omitempty"`
+	EstimatedMemory int64  `json:"estimated_memory,omitempty"`
+	RequestedCpu    *int64 `json:"requested_cpu,omitempty"`
+	RequestedMemory *int64 `json:"requested_memory,omitempty"`
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"testing"
+)
+
+type araTestConfig map[string]interface{}
+
+func (c araTestConfig) GetString(key string) string        { s, _ := c[key].(string); return s }
+func (c araTestConfig) GetStringSlice(key string) []string { s, _ := c[key].([]string); return s }
+func (c araTestConfig) GetInt(key string) int              { i, _ := c[key].(int); return i }
+func (c araTestConfig) GetBool(key string) bool            { b, _ := c[key].(bool); return b }
+func (c araTestConfig) GetFloat64(key string) float64      { f, _ := c[key].(float64); return f }
+func (c araTestConfig) IsSet(key string) bool              { _, ok := c[key]; return ok }
+func (c araTestConfig) GetStringMapString(key string) map[string]string {
+	m, _ := c[key].(map[string]string)
+	return m
+}
+
+func TestNewARAPolicies(t *testing.T) {
+	policies, err := NewARAPolicies(araTestConfig{
+		"ara_memory_multiplier": 1.5,
+		"ara_lookback_days":     14,
+		"ara_group_policies": map[string]string{
+			"batch": `{"memory_percentile": 0.9, "include_successful_runs": true}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	def := policies.For("unknown")
+	if def.Name != "default" || def.MemoryMultiplier != 1.5 || def.LookbackDays != 14 || def.CpuMultiplier != 1.25 {
+		t.Errorf("Unexpected default policy: %+v", def)
+	}
+
+	batch := policies.For("batch")
+	if batch.Name != "group:batch" {
+		t.Errorf("Expected policy [group:batch] but was [%s]", batch.Name)
+	}
+	if batch.MemoryPercentile != 0.9 || !batch.IncludeSuccessfulRuns {
+		t.Errorf("Expected group overrides to apply: %+v", batch)
+	}
+	if batch.MemoryMultiplier != 1.5 || batch.LookbackDays != 14 {
+		t.Errorf("Expected group policy to inherit the configured default: %+v", batch)
+	}
+}
+
+func TestNewARAPolicies_Invalid(t *testing.T) {
+	if _, err := NewARAPolicies(araTestConfig{"ara_cpu_percentile": 1.5}); err == nil {
+		t.Errorf("Expected error for percentile above 1")
+	}
+	if _, err := NewARAPolicies(araTestConfig{
+		"ara_group_policies": map[string]string{"batch": `{"sample_limit": 0}`},
+	}); err == nil {
+		t.Errorf("Expected error for group policy with no samples")
+	}
+}
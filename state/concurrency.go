@@ -0,0 +1,72 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/utils"
+)
+
+// Concurrency modes decide what happens to a run submitted while its
+// executable is at its limit of active runs.
+const (
+	// ConcurrencyQueue keeps the run queued until a slot frees up.
+	ConcurrencyQueue = "queue"
+	// ConcurrencySkip stops the run without executing it.
+	ConcurrencySkip = "skip"
+	// ConcurrencyReplace stops the oldest active runs to make room.
+	ConcurrencyReplace = "replace"
+)
+
+var ConcurrencyModes = []string{ConcurrencyQueue, ConcurrencySkip, ConcurrencyReplace}
+
+// ConcurrencyPolicy limits the runs of a definition or template that are
+// PENDING or RUNNING at once; Mode defaults to queue.
+type ConcurrencyPolicy struct {
+	MaxConcurrent int64  `json:"max_concurrent"`
+	Mode          string `json:"mode,omitempty"`
+}
+
+// GetMode returns the policy's mode, queue when unset.
+func (cp *ConcurrencyPolicy) GetMode() string {
+	if len(cp.Mode) == 0 {
+		return ConcurrencyQueue
+	}
+	return cp.Mode
+}
+
+// ValidateConcurrency returns the reasons a concurrency policy is invalid.
+func ValidateConcurrency(cp *ConcurrencyPolicy) []string {
+	var reasons []string
+	if cp == nil {
+		return reasons
+	}
+	if cp.MaxConcurrent < 1 {
+		reasons = append(reasons, "concurrency.max_concurrent must be at least 1")
+	}
+	if !utils.StringSliceContains(ConcurrencyModes, cp.GetMode()) {
+		reasons = append(reasons, fmt.Sprintf(
+			"concurrency.mode must be one of [%s]", strings.Join(ConcurrencyModes, ", ")))
+	}
+	return reasons
+}
+
+// ConcurrencySkippedReason is the exit reason of a run skipped because
+// its executable was at its limit.
+func ConcurrencySkippedReason(active []string) string {
+	return fmt.Sprintf("Skipped, concurrency limit reached by runs [%s]", strings.Join(active, ", "))
+}
+
+// IsSubmitted is true for an active run that has reached its engine: it is
+// RUNNING or holds a Spark job. Other PENDING runs are still being submitted
+// by the worker that claimed them.
+func IsSubmitted(run Run) bool {
+	return run.Status == StatusRunning || (run.SparkExtension != nil &&
+		(run.SparkExtension.EMRJobId != nil || run.SparkExtension.SparkApplication != nil))
+}
+
+// ConcurrencyReplacedReason is the exit reason of an active run stopped to
+// make room for a newer run.
+func ConcurrencyReplacedReason(runID string) string {
+	return fmt.Sprintf("Replaced by run %s under the concurrency limit", runID)
+}
@@ -0,0 +1,17 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestValidateConcurrency(t *testing.T) {
+	if reasons := ValidateConcurrency(&ConcurrencyPolicy{MaxConcurrent: 1}); len(reasons) > 0 {
+		t.Errorf("Expected policy to be valid, got %v", reasons)
+	}
+	if reasons := ValidateConcurrency(nil); len(reasons) > 0 {
+		t.Errorf("Expected no policy to be valid, got %v", reasons)
+	}
+	if reasons := ValidateConcurrency(&ConcurrencyPolicy{MaxConcurrent: 0, Mode: "drop"}); len(reasons) != 2 {
+		t.Errorf("Expected 2 reasons, got %v", reasons)
+	}
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"fmt"
+)
+
+// Container is an init container or sidecar that runs in the pod alongside
+// a run's main container; Cpu is in millicores and Memory in MB.
+type Container struct {
+	Name    string   `json:"name"`
+	Image   string   `json:"image"`
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Env     *EnvList `json:"env,omitempty"`
+	Cpu     *int64   `json:"cpu,omitempty"`
+	Memory  *int64   `json:"memory,omitempty"`
+	Volumes []string `json:"volumes,omitempty"`
+}
+
+// ContainerList wraps a list of Containers
+type ContainerList []Container
+
+// ValidateContainers returns the reasons an executable's init containers
+// and sidecars are invalid; containers may only mount the executable's
+// volumes or the platform's scratch space.
+func ValidateContainers(initContainers *ContainerList, sidecars *ContainerList, volumes *VolumeList) []string {
+	var reasons []string
+	mountable := map[string]bool{"scratch": true, "shared-memory": true}
+	if volumes != nil {
+		for _, v := range *volumes {
+			mountable[v.Name] = true
+		}
+	}
+	names := make(map[string]bool)
+	for _, containers := range []*ContainerList{initContainers, sidecars} {
+		if containers == nil {
+			continue
+		}
+		for _, c := range *containers {
+			if !volumeNamePattern.MatchString(c.Name) || len(c.Name) > 63 {
+				reasons = append(reasons, fmt.Sprintf("container name [%s] must be a lowercase DNS label", c.Name))
+			} else if names[c.Name] {
+				reasons = append(reasons, fmt.Sprintf("container name [%s] is used more than once", c.Name))
+			}
+			names[c.Name] = true
+			if len(c.Image) == 0 {
+				reasons = append(reasons, fmt.Sprintf("container [%s] must specify an image", c.Name))
+			}
+			if (c.Cpu != nil && *c.Cpu <= 0) || (c.Memory != nil && *c.Memory <= 0) {
+				reasons = append(reasons, fmt.Sprintf("container [%s] cpu and memory must be positive", c.Name))
+			}
+			for _, v := range c.Volumes {
+				if !mountable[v] {
+					reasons = append(reasons, fmt.Sprintf("container [%s] volume [%s] is not declared", c.Name, v))
+				}
+			}
+			reasons = append(reasons, ValidateEnv(c.Env)...)
+		}
+	}
+	return reasons
+}
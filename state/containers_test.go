@@ -0,0 +1,22 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestValidateContainers(t *testing.T) {
+	volumes := &VolumeList{{Name: "code", MountPath: "/code", EmptyDir: &EmptyDirVolume{SizeLimit: 1024}}}
+	initContainers := &ContainerList{{Name: "fetch", Image: "alpine", Volumes: []string{"code"}}}
+	sidecars := &ContainerList{{Name: "proxy", Image: "envoy", Volumes: []string{"scratch"}}}
+	if reasons := ValidateContainers(initContainers, sidecars, volumes); len(reasons) > 0 {
+		t.Errorf("Expected containers to be valid, got %v", reasons)
+	}
+
+	sidecars = &ContainerList{
+		{Name: "fetch", Image: "alpine"},
+		{Name: "Proxy", Volumes: []string{"data"}},
+	}
+	if reasons := ValidateContainers(initContainers, sidecars, volumes); len(reasons) != 4 {
+		t.Errorf("Expected 4 reasons, got %v", reasons)
+	}
+}
@@ -0,0 +1,82 @@
+package state
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// States of an EMR on EKS job run.
+const (
+	EMRJobPending       = "PENDING"
+	EMRJobSubmitted     = "SUBMITTED"
+	EMRJobRunning       = "RUNNING"
+	EMRJobCompleted     = "COMPLETED"
+	EMRJobFailed        = "FAILED"
+	EMRJobCancelled     = "CANCELLED"
+	EMRJobCancelPending = "CANCEL_PENDING"
+)
+
+const emrLogsHint = "Please refer logs uploaded to S3/CloudWatch based on your monitoring configuration."
+
+// ApplyEMRJobState updates a Spark run from the state of its EMR job run,
+// whether reported by an EMR event or by DescribeJobRun; at is when the job
+// run reached the state.
+func (r *Run) ApplyEMRJobState(jobState string, stateDetails *string, failureReason *string, at time.Time) {
+	switch jobState {
+	case EMRJobCompleted:
+		r.ExitCode = aws.Int64(0)
+		r.Status = StatusStopped
+		r.FinishedAt = &at
+		if r.StartedAt == nil || r.StartedAt.After(*r.FinishedAt) {
+			r.StartedAt = r.QueuedAt
+		}
+		r.ExitReason = stateDetails
+	case EMRJobRunning:
+		r.Status = StatusRunning
+		if r.StartedAt == nil {
+			r.StartedAt = &at
+		}
+	case EMRJobFailed:
+		r.ExitCode = aws.Int64(-1)
+		r.Status = StatusStopped
+		r.FinishedAt = &at
+		if r.StartedAt == nil || r.StartedAt.After(*r.FinishedAt) {
+			r.StartedAt = r.QueuedAt
+		}
+
+		r.ExitReason = aws.String("Job failed, please look at Driver Init and/or Driver Stdout logs.")
+		if stateDetails != nil && !strings.Contains(*stateDetails, "JobRun failed. Please refer logs uploaded") {
+			r.ExitReason = aws.String(strings.Replace(*stateDetails, emrLogsHint, "", -1))
+		} else if failureReason != nil && !strings.Contains(*failureReason, "USER_ERROR") {
+			r.ExitReason = aws.String(strings.Replace(*failureReason, emrLogsHint, "", -1))
+		}
+
+		if r.SparkExtension != nil && r.SparkExtension.DriverOOM != nil && *r.SparkExtension.DriverOOM {
+			r.ExitReason = aws.String("Driver OOMKilled, retry with more driver memory.")
+			r.ExitCode = aws.Int64(137)
+		}
+		if r.SparkExtension != nil && r.SparkExtension.ExecutorOOM != nil && *r.SparkExtension.ExecutorOOM {
+			r.ExitReason = aws.String("Executor OOMKilled, retry with more executor memory.")
+			r.ExitCode = aws.Int64(137)
+		}
+	case EMRJobCancelled:
+		r.Status = StatusStopped
+		r.FinishedAt = &at
+		if r.StartedAt == nil || r.StartedAt.After(*r.FinishedAt) {
+			r.StartedAt = r.QueuedAt
+		}
+		if r.ExitCode == nil {
+			r.ExitCode = aws.Int64(-1)
+		}
+		if r.ExitReason == nil {
+			r.ExitReason = aws.String("Job run was cancelled")
+			if stateDetails != nil {
+				r.ExitReason = stateDetails
+			}
+		}
+	case EMRJobSubmitted, EMRJobPending:
+		r.Status = StatusPending
+	}
+}
@@ -0,0 +1,38 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestRun_ApplyEMRJobState(t *testing.T) {
+	queuedAt := time.Now().Add(-time.Hour)
+	startedAt := time.Now().Add(-30 * time.Minute)
+	run := Run{Status: StatusQueued, QueuedAt: &queuedAt}
+
+	run.ApplyEMRJobState(EMRJobSubmitted, nil, nil, queuedAt)
+	if run.Status != StatusPending {
+		t.Errorf("Expected submitted job run to be pending, got %s", run.Status)
+	}
+
+	run.ApplyEMRJobState(EMRJobRunning, nil, nil, startedAt)
+	run.ApplyEMRJobState(EMRJobRunning, nil, nil, time.Now())
+	if run.Status != StatusRunning || !run.StartedAt.Equal(startedAt) {
+		t.Errorf("Expected running since the first running state, got %s at %v", run.Status, run.StartedAt)
+	}
+
+	finishedAt := time.Now()
+	failed := run
+	failed.SparkExtension = &SparkExtension{ExecutorOOM: aws.Bool(true)}
+	failed.ApplyEMRJobState(EMRJobFailed, nil, aws.String("USER_ERROR"), finishedAt)
+	if failed.Status != StatusStopped || *failed.ExitCode != 137 || !failed.FinishedAt.Equal(finishedAt) {
+		t.Errorf("Expected executor OOM to stop the run with 137, got %v", *failed.ExitCode)
+	}
+
+	run.ApplyEMRJobState(EMRJobCancelled, aws.String("JobRun CANCELLED successfully."), nil, finishedAt)
+	if run.Status != StatusStopped || *run.ExitCode != -1 || *run.ExitReason != "JobRun CANCELLED successfully." {
+		t.Errorf("Expected cancelled job run to stop the run, got %s", run.Status)
+	}
+}
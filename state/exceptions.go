@@ -0,0 +1,288 @@
+package state
+
+import (
+	"crypto/md5"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of exceptions found in the logs of runs.
+const (
+	ExceptionKindPython = "python"
+	ExceptionKindJVM    = "jvm"
+	ExceptionKindGo     = "go"
+	ExceptionKindBash   = "bash"
+)
+
+const (
+	maxExceptionMessage = 1000
+	maxExceptionTrace   = 8192
+	fingerprintFrames   = 5
+)
+
+// LoggedException is a stack trace or shell failure found in the logs of a
+// run. Exceptions with the same kind, type, normalized message and frames
+// share a Fingerprint, whichever run they were found in.
+type LoggedException struct {
+	RunID         string     `json:"run_id" db:"run_id"`
+	Fingerprint   string     `json:"fingerprint" db:"fingerprint"`
+	Kind          string     `json:"kind" db:"kind"`
+	ExceptionType string     `json:"exception_type" db:"exception_type"`
+	Message       string     `json:"message" db:"message"`
+	Trace         string     `json:"trace" db:"trace"`
+	CreatedAt     *time.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+// LoggedExceptionList wraps a list of logged exceptions.
+type LoggedExceptionList struct {
+	Total      int               `json:"total"`
+	Exceptions []LoggedException `json:"exceptions"`
+}
+
+// Summary is the exception as recorded in the run exceptions of a run.
+func (e LoggedException) Summary() string {
+	if len(e.Message) == 0 {
+		return e.ExceptionType
+	}
+	return fmt.Sprintf("%s: %s", e.ExceptionType, e.Message)
+}
+
+// ExceptionReportRequest describes the window and runs of an exception report.
+type ExceptionReportRequest struct {
+	GroupName    *string
+	DefinitionID *string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// ExceptionGroup counts the runs of a window that logged an exception;
+// FirstSeen is the first time any run logged it, so recent regressions
+// stand out.
+type ExceptionGroup struct {
+	Fingerprint   string         `json:"fingerprint" db:"fingerprint"`
+	Kind          string         `json:"kind" db:"kind"`
+	ExceptionType string         `json:"exception_type" db:"exception_type"`
+	Message       string         `json:"message" db:"message"`
+	FirstSeen     time.Time      `json:"first_seen" db:"first_seen"`
+	LastSeen      time.Time      `json:"last_seen" db:"last_seen"`
+	Runs          int64          `json:"runs" db:"runs"`
+	Groups        int64          `json:"groups" db:"groups"`
+	RecentRuns    pq.StringArray `json:"recent_runs" db:"recent_runs"`
+}
+
+// ExceptionReport lists the exceptions logged by the runs of a window, most
+// frequent first.
+type ExceptionReport struct {
+	Since      time.Time        `json:"since"`
+	Until      time.Time        `json:"until"`
+	Total      int              `json:"total"`
+	Exceptions []ExceptionGroup `json:"exceptions"`
+}
+
+var (
+	pythonTraceback = regexp.MustCompile(`Traceback \(most recent call last\):\s*$`)
+	pythonFrame     = regexp.MustCompile(`^\s+File "([^"]+)", line \d+, in (\S+)`)
+	pythonException = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?::\s?(.*))?$`)
+	jvmException    = regexp.MustCompile(`(?:^|\s)((?:[a-zA-Z_$][\w$]*\.)+[A-Za-z_$][\w$]*(?:Exception|Error|Throwable))(?::\s?(.*))?$`)
+	jvmCausedBy     = regexp.MustCompile(`^\s*Caused by: ((?:[a-zA-Z_$][\w$]*\.)*[A-Za-z_$][\w$]*)(?::\s?(.*))?$`)
+	jvmFrame        = regexp.MustCompile(`^\s+at ([^(\s]+)\(.*\)$`)
+	goPanic         = regexp.MustCompile(`^(panic|fatal error): (.*)$`)
+	goGoroutine     = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	goFunction      = regexp.MustCompile(`^([\w./*()\-]+)\(.*\)$`)
+	goFile          = regexp.MustCompile(`^\t\S+\.go:\d+`)
+	shellError      = regexp.MustCompile(`^((?:\S*/)?(?:bash|sh|dash|zsh|[\w.\-]+\.sh)): (?:line )?(\d+): (.+)$`)
+	shellTrace      = regexp.MustCompile(`^\++ (.+)$`)
+
+	normalizeUUID   = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	normalizeHex    = regexp.MustCompile(`(?i)\b(?:0x[0-9a-f]+|[0-9a-f]*[0-9][0-9a-f]*[a-f][0-9a-f]*|[0-9a-f]*[a-f][0-9a-f]*[0-9][0-9a-f]*)\b`)
+	normalizeQuoted = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+	normalizeNumber = regexp.MustCompile(`\d+`)
+)
+
+// ExtractExceptions finds the Python, JVM and Go stack traces and the shell
+// errors in the text of a run's logs; with none of those, the last command
+// traced by `set -x` is reported. Repeated exceptions are reported once and
+// at most limit of the last ones are returned.
+func ExtractExceptions(text string, limit int) []LoggedException {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	var found []LoggedException
+	var lastTraced *string
+	for i := 0; i < len(lines); {
+		if e, next, ok := parsePythonTrace(lines, i); ok {
+			found = append(found, e)
+			i = next
+			continue
+		}
+		if e, next, ok := parseGoPanic(lines, i); ok {
+			found = append(found, e)
+			i = next
+			continue
+		}
+		if e, next, ok := parseJVMTrace(lines, i); ok {
+			found = append(found, e)
+			i = next
+			continue
+		}
+		if m := shellError.FindStringSubmatch(lines[i]); m != nil {
+			found = append(found, newLoggedException(ExceptionKindBash, path.Base(m[1]), m[3], nil, lines[i:i+1]))
+		} else if m := shellTrace.FindStringSubmatch(lines[i]); m != nil {
+			lastTraced = &lines[i]
+		}
+		i++
+	}
+	if len(found) == 0 && lastTraced != nil {
+		command := shellTrace.FindStringSubmatch(*lastTraced)[1]
+		found = append(found, newLoggedException(ExceptionKindBash, "command failed", command,
+			nil, []string{*lastTraced}))
+	}
+
+	var exceptions []LoggedException
+	seen := map[string]bool{}
+	for i := len(found) - 1; i >= 0 && len(exceptions) < limit; i-- {
+		if !seen[found[i].Fingerprint] {
+			seen[found[i].Fingerprint] = true
+			exceptions = append([]LoggedException{found[i]}, exceptions...)
+		}
+	}
+	return exceptions
+}
+
+// parsePythonTrace parses a traceback starting at lines[i], up to and
+// including the line of the exception raised.
+func parsePythonTrace(lines []string, i int) (LoggedException, int, bool) {
+	if !pythonTraceback.MatchString(lines[i]) {
+		return LoggedException{}, i, false
+	}
+	var frames []string
+	j := i + 1
+	for ; j < len(lines); j++ {
+		if m := pythonFrame.FindStringSubmatch(lines[j]); m != nil {
+			frames = append(frames, fmt.Sprintf("%s:%s", path.Base(m[1]), m[2]))
+		} else if len(strings.TrimSpace(lines[j])) > 0 && !strings.HasPrefix(lines[j], " ") && !strings.HasPrefix(lines[j], "\t") {
+			break
+		}
+	}
+	if j == len(lines) || len(frames) == 0 {
+		return LoggedException{}, i, false
+	}
+	m := pythonException.FindStringSubmatch(strings.TrimSpace(lines[j]))
+	if m == nil {
+		return LoggedException{}, i, false
+	}
+	return newLoggedException(ExceptionKindPython, m[1], m[2], innermost(frames), lines[i:j+1]), j + 1, true
+}
+
+// parseJVMTrace parses a Java or Scala exception starting at lines[i] and
+// followed by its frames; the exception is its root cause, the last one it
+// was caused by.
+func parseJVMTrace(lines []string, i int) (LoggedException, int, bool) {
+	m := jvmException.FindStringSubmatch(lines[i])
+	if m == nil || i+1 == len(lines) || !jvmFrame.MatchString(lines[i+1]) {
+		return LoggedException{}, i, false
+	}
+	exceptionType, message := m[1], m[2]
+	var frames []string
+	j := i + 1
+	for ; j < len(lines); j++ {
+		line := lines[j]
+		if f := jvmFrame.FindStringSubmatch(line); f != nil {
+			frames = append(frames, f[1])
+		} else if c := jvmCausedBy.FindStringSubmatch(line); c != nil {
+			exceptionType, message = c[1], c[2]
+			frames = nil
+		} else if trimmed := strings.TrimSpace(line); !strings.HasPrefix(trimmed, "...") && !strings.HasPrefix(trimmed, "Suppressed:") {
+			break
+		}
+	}
+	if len(frames) > fingerprintFrames {
+		frames = frames[:fingerprintFrames]
+	}
+	return newLoggedException(ExceptionKindJVM, exceptionType, message, frames, lines[i:j]), j, true
+}
+
+// parseGoPanic parses a panic or fatal error starting at lines[i] and the
+// stack of the goroutine that raised it; the frames of the runtime are left
+// out of the fingerprint.
+func parseGoPanic(lines []string, i int) (LoggedException, int, bool) {
+	m := goPanic.FindStringSubmatch(lines[i])
+	if m == nil {
+		return LoggedException{}, i, false
+	}
+	var frames []string
+	inStack := false
+	j := i + 1
+	for ; j < len(lines); j++ {
+		line := lines[j]
+		if goGoroutine.MatchString(line) {
+			if inStack {
+				break
+			}
+			inStack = true
+		} else if f := goFunction.FindStringSubmatch(line); inStack && f != nil {
+			if f[1] != "panic" && !strings.HasPrefix(f[1], "runtime.") && len(frames) < fingerprintFrames {
+				frames = append(frames, f[1])
+			}
+		} else if inStack && !goFile.MatchString(line) {
+			break
+		} else if !inStack && len(strings.TrimSpace(line)) > 0 && !strings.HasPrefix(line, "[") {
+			break
+		}
+	}
+	if !inStack {
+		return LoggedException{}, i, false
+	}
+	return newLoggedException(ExceptionKindGo, m[1], m[2], frames, lines[i:j]), j, true
+}
+
+func innermost(frames []string) []string {
+	if len(frames) > fingerprintFrames {
+		return frames[len(frames)-fingerprintFrames:]
+	}
+	return frames
+}
+
+func newLoggedException(kind string, exceptionType string, message string, frames []string, trace []string) LoggedException {
+	message = strings.TrimSpace(message)
+	return LoggedException{
+		Fingerprint:   ExceptionFingerprint(kind, exceptionType, message, frames),
+		Kind:          kind,
+		ExceptionType: exceptionType,
+		Message:       truncate(message, maxExceptionMessage),
+		Trace:         truncate(strings.Join(trace, "\n"), maxExceptionTrace),
+	}
+}
+
+// ExceptionFingerprint identifies an exception independently of the ids,
+// numbers and quoted values of its message and of the line numbers and
+// generated names of its frames.
+func ExceptionFingerprint(kind string, exceptionType string, message string, frames []string) string {
+	normalized := normalizeUUID.ReplaceAllString(message, "<uuid>")
+	normalized = normalizeQuoted.ReplaceAllString(normalized, "<str>")
+	normalized = normalizeHex.ReplaceAllString(normalized, "<hex>")
+	normalized = normalizeNumber.ReplaceAllString(normalized, "<n>")
+	normalized = truncate(normalized, 200)
+	key := []string{kind, exceptionType, normalized}
+	for _, frame := range frames {
+		frame = normalizeHex.ReplaceAllString(frame, "<hex>")
+		key = append(key, normalizeNumber.ReplaceAllString(frame, "<n>"))
+	}
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(key, "\n"))))[:16]
+}
+
+// truncate cuts s to at most max bytes without splitting a UTF-8 sequence.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
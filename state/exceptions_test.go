@@ -0,0 +1,135 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractExceptions_Python(t *testing.T) {
+	log := strings.Join([]string{
+		"loading data",
+		"Traceback (most recent call last):",
+		`  File "/app/main.py", line 12, in <module>`,
+		"    run()",
+		`  File "/usr/local/lib/python3.8/site-packages/etl/load.py", line 40, in run`,
+		"    price = float(row['price'])",
+		"ValueError: could not convert string to float: '12,30'",
+	}, "\n")
+	found := ExtractExceptions(log, 10)
+	if len(found) != 1 {
+		t.Fatalf("Expected 1 exception, got %d", len(found))
+	}
+	e := found[0]
+	if e.Kind != ExceptionKindPython || e.ExceptionType != "ValueError" {
+		t.Errorf("Expected a python ValueError, got %s %s", e.Kind, e.ExceptionType)
+	}
+	if e.Summary() != "ValueError: could not convert string to float: '12,30'" {
+		t.Errorf("Unexpected summary %s", e.Summary())
+	}
+
+	other := strings.Replace(log, "'12,30'", "'7,5'", 1)
+	other = strings.Replace(other, "line 40", "line 42", 1)
+	other = strings.Replace(other, "python3.8", "python3.9", 1)
+	if ExtractExceptions(other, 10)[0].Fingerprint != e.Fingerprint {
+		t.Errorf("Expected the fingerprint to ignore values, line numbers and paths")
+	}
+	renamed := strings.Replace(log, "in run", "in load", 1)
+	if ExtractExceptions(renamed, 10)[0].Fingerprint == e.Fingerprint {
+		t.Errorf("Expected a different fingerprint for different frames")
+	}
+}
+
+func TestExtractExceptions_JVM(t *testing.T) {
+	log := strings.Join([]string{
+		"22/01/10 12:00:00 ERROR Executor: Exception in task 0.0",
+		"org.apache.spark.SparkException: Job aborted.",
+		"\tat org.apache.spark.sql.execution.datasources.FileFormatWriter$.write(FileFormatWriter.scala:231)",
+		"\tat org.apache.spark.sql.Dataset.$anonfun$save$1(Dataset.scala:3625)",
+		"Caused by: java.io.FileNotFoundException: s3://bucket/part-00012.parquet",
+		"\tat org.apache.hadoop.fs.s3a.S3AFileSystem.open(S3AFileSystem.java:702)",
+		"\tat com.example.Job$$Lambda$1234/0x0000000840d6c040.apply(Unknown Source)",
+		"\t... 24 more",
+		"22/01/10 12:00:01 INFO SparkContext: Invoking stop()",
+	}, "\n")
+	found := ExtractExceptions(log, 10)
+	if len(found) != 1 {
+		t.Fatalf("Expected 1 exception, got %d", len(found))
+	}
+	e := found[0]
+	if e.Kind != ExceptionKindJVM || e.ExceptionType != "java.io.FileNotFoundException" {
+		t.Errorf("Expected the root cause, got %s %s", e.Kind, e.ExceptionType)
+	}
+	if strings.Contains(e.Trace, "Invoking stop") {
+		t.Errorf("Expected the trace to end with its frames, got %s", e.Trace)
+	}
+	other := strings.Replace(log, "$1234/0x0000000840d6c040", "$987/0x0000000840aa1000", 1)
+	other = strings.Replace(other, "part-00012", "part-00007", 1)
+	if ExtractExceptions(other, 10)[0].Fingerprint != e.Fingerprint {
+		t.Errorf("Expected the fingerprint to ignore generated names and numbers")
+	}
+}
+
+func TestExtractExceptions_Go(t *testing.T) {
+	log := strings.Join([]string{
+		"panic: runtime error: index out of range [5] with length 3",
+		"",
+		"goroutine 1 [running]:",
+		"main.parse(0xc000010230, 0x3, 0x3)",
+		"\t/app/main.go:14 +0x1d",
+		"main.main()",
+		"\t/app/main.go:8 +0x25",
+		"exit status 2",
+	}, "\n")
+	found := ExtractExceptions(log, 10)
+	if len(found) != 1 {
+		t.Fatalf("Expected 1 exception, got %d", len(found))
+	}
+	if found[0].Kind != ExceptionKindGo || found[0].ExceptionType != "panic" {
+		t.Errorf("Expected a go panic, got %s %s", found[0].Kind, found[0].ExceptionType)
+	}
+	if strings.Contains(found[0].Trace, "exit status") {
+		t.Errorf("Expected the trace to end with the stack, got %s", found[0].Trace)
+	}
+}
+
+func TestExtractExceptions_Bash(t *testing.T) {
+	found := ExtractExceptions("+ cd /app\n./run.sh: line 4: pyhton: command not found\n", 10)
+	if len(found) != 1 || found[0].ExceptionType != "run.sh" || found[0].Message != "pyhton: command not found" {
+		t.Errorf("Expected the shell error, got %v", found)
+	}
+
+	found = ExtractExceptions("+ cd /app\n+ aws s3 cp s3://bucket/input.csv .\ndownload failed\n", 10)
+	if len(found) != 1 || found[0].ExceptionType != "command failed" || found[0].Message != "aws s3 cp s3://bucket/input.csv ." {
+		t.Errorf("Expected the last traced command, got %v", found)
+	}
+}
+
+func TestExtractExceptions_Limit(t *testing.T) {
+	log := strings.Join([]string{
+		"bash: line 1: first: command not found",
+		"bash: line 2: second: command not found",
+		"bash: line 3: first: command not found",
+		"bash: line 4: third: command not found",
+	}, "\n")
+	found := ExtractExceptions(log, 2)
+	if len(found) != 2 || found[0].Message != "first: command not found" || found[1].Message != "third: command not found" {
+		t.Errorf("Expected the last 2 distinct exceptions, got %v", found)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := "abc€def"
+	if got := truncate(s, 4); got != "abc" {
+		t.Errorf("Expected the cut to fall before the euro sign, got %q", got)
+	}
+	if got := truncate(s, 6); got != "abc€" {
+		t.Errorf("Expected the whole euro sign, got %q", got)
+	}
+	if got := truncate(s, 20); got != s {
+		t.Errorf("Expected a short string to be kept, got %q", got)
+	}
+	if !utf8.ValidString(truncate(strings.Repeat("é", 100), 51)) {
+		t.Errorf("Expected a valid UTF-8 string")
+	}
+}
@@ -0,0 +1,349 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/utils"
+)
+
+// Exit categories of failed runs.
+const (
+	ExitCategoryInfra      = "infra"
+	ExitCategoryUserCode   = "user-code"
+	ExitCategoryDependency = "dependency"
+	ExitCategoryOOM        = "oom"
+	ExitCategoryTimeout    = "timeout"
+	ExitCategorySpot       = "spot"
+	ExitCategoryUnknown    = "unknown"
+)
+
+var ExitCategories = []string{
+	ExitCategoryInfra,
+	ExitCategoryUserCode,
+	ExitCategoryDependency,
+	ExitCategoryOOM,
+	ExitCategoryTimeout,
+	ExitCategorySpot,
+	ExitCategoryUnknown,
+}
+
+// UnclassifiedExitReason is the exit reason of failed runs no rule matched.
+const UnclassifiedExitReason = "Runtime exception encountered"
+
+// ExitRule classifies the failed runs whose exit reason or run exceptions
+// match Pattern and, when set, that exited with ExitCode. Rules with a
+// higher Priority are tried first; at equal priority rules of the run's
+// group come before rules of every group.
+type ExitRule struct {
+	RuleID    string     `json:"rule_id" db:"rule_id"`
+	Pattern   *string    `json:"pattern,omitempty" db:"pattern"`
+	ExitCode  *int64     `json:"exit_code,omitempty" db:"exit_code"`
+	Category  string     `json:"category" db:"category"`
+	Reason    string     `json:"reason" db:"reason"`
+	Priority  int64      `json:"priority" db:"priority"`
+	GroupName *string    `json:"group_name,omitempty" db:"group_name"`
+	CreatedAt *time.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+// ExitRuleList wraps a list of exit rules.
+type ExitRuleList struct {
+	Total int        `json:"total"`
+	Rules []ExitRule `json:"rules"`
+}
+
+// DefaultExitRules apply after the configured and stored rules.
+var DefaultExitRules = []ExitRule{
+	{RuleID: "default-spot", Pattern: exitPattern(regexp.QuoteMeta(SpotInterruptionExitReason)), Category: ExitCategorySpot, Reason: SpotInterruptionExitReason},
+	{RuleID: "default-oom-exit-code", ExitCode: exitCode(137), Category: ExitCategoryOOM, Reason: "Container OOMKilled"},
+	{RuleID: "default-oom", Pattern: exitPattern(`(?i)OOMKilled`), Category: ExitCategoryOOM, Reason: "Container OOMKilled"},
+	{RuleID: "default-deadline-exit-code", ExitCode: exitCode(124), Category: ExitCategoryTimeout, Reason: "Run exceeded its timeout"},
+	{RuleID: "default-timeout", Pattern: exitPattern(`(?i)exceeded specified timeout|timed out or not found`), Category: ExitCategoryTimeout, Reason: "Run exceeded its timeout"},
+	{RuleID: "default-connection", Pattern: exitPattern(`(?i)(timeout|gatewayerror|socketerror|\s503\s|\s502\s|\s500\s|\s504\s|connectionerror)`), Category: ExitCategoryInfra, Reason: "Connection error to downstream uri"},
+	{RuleID: "default-pip", Pattern: exitPattern(`(?i)(could\snot\sfind\sa\sversion|package\snot\sfound|ModuleNotFoundError|No\smatching\sdistribution\sfound)`), Category: ExitCategoryDependency, Reason: "Python pip package installation error"},
+	{RuleID: "default-yum", Pattern: exitPattern(`(?i)Nothing\sto\sdo`), Category: ExitCategoryDependency, Reason: "Yum installation error"},
+	{RuleID: "default-git", Pattern: exitPattern(`(?i)(Could\snot\sread\sfrom\sremote\srepository|correct\saccess\srights|Repository\snot\sfound)`), Category: ExitCategoryDependency, Reason: "Git clone error"},
+	{RuleID: "default-argument", Pattern: exitPattern(`(?i)(404|400|keyerror|column\smissing|RuntimeError)`), Category: ExitCategoryUserCode, Reason: "Data or argument error"},
+	{RuleID: "default-syntax", Pattern: exitPattern(`(?i)(syntaxerror|typeerror)`), Category: ExitCategoryUserCode, Reason: "Code or syntax error"},
+}
+
+func exitPattern(pattern string) *string {
+	return &pattern
+}
+
+func exitCode(code int64) *int64 {
+	return &code
+}
+
+// IsValid checks the validity of an exit rule; patterns matching the empty
+// string would match every run.
+func (r *ExitRule) IsValid() (bool, []string) {
+	var reasons []string
+	if r.Pattern == nil && r.ExitCode == nil {
+		reasons = append(reasons, "one of [pattern] or [exit_code] must be specified")
+	}
+	if r.Pattern != nil {
+		re, err := regexp.Compile(*r.Pattern)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("pattern is invalid: %v", err))
+		} else if re.MatchString("") {
+			reasons = append(reasons, "pattern must not match the empty string")
+		}
+	}
+	if !utils.StringSliceContains(ExitCategories, r.Category) {
+		reasons = append(reasons, fmt.Sprintf(
+			"category must be one of [%s]", strings.Join(ExitCategories, ", ")))
+	}
+	if len(r.Reason) == 0 {
+		reasons = append(reasons, "string [reason] must be specified")
+	}
+	return len(reasons) == 0, reasons
+}
+
+// NewExitRuleID returns a new id for an exit rule
+func NewExitRuleID() (string, error) {
+	uuid4, err := newUUIDv4()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rule-%s", uuid4), nil
+}
+
+// ExitRulesFromConfig reads the JSON list of exit rules in `exit_rules`.
+func ExitRulesFromConfig(conf config.Config) ([]ExitRule, error) {
+	var rules []ExitRule
+	if !conf.IsSet("exit_rules") {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(conf.GetString("exit_rules")), &rules); err != nil {
+		return nil, errors.Wrap(err, "invalid exit_rules")
+	}
+	for i := range rules {
+		if len(rules[i].RuleID) == 0 {
+			rules[i].RuleID = fmt.Sprintf("config-%d", i)
+		}
+		if valid, reasons := rules[i].IsValid(); !valid {
+			return nil, errors.Errorf("invalid exit rule [%s]: %s", rules[i].RuleID, strings.Join(reasons, ", "))
+		}
+	}
+	return rules, nil
+}
+
+// LoadExitClassifier builds the classifier of the stored exit rules, then
+// the configured ones, then DefaultExitRules. When the stored rules can't be
+// used, the classifier of the others is returned along with the error.
+func LoadExitClassifier(sm Manager, configured []ExitRule) (*ExitClassifier, error) {
+	fallback := append(append([]ExitRule{}, configured...), DefaultExitRules...)
+	stored, err := sm.ListExitRules()
+	if err != nil {
+		c, _ := NewExitClassifier(fallback)
+		return c, err
+	}
+	c, err := NewExitClassifier(append(stored.Rules, fallback...))
+	if err != nil {
+		c, _ = NewExitClassifier(fallback)
+		return c, err
+	}
+	return c, nil
+}
+
+// ExitClassifierCache keeps the exit classifier between runs, loading the
+// stored exit rules again once the classifier is older than its TTL. A nil
+// cache loads the classifier of the stored and default rules every time.
+type ExitClassifierCache struct {
+	configured []ExitRule
+	ttl        time.Duration
+	mu         sync.Mutex
+	classifier *ExitClassifier
+	loadedAt   time.Time
+}
+
+// NewExitClassifierCache reads the configured exit rules and how long the
+// stored ones are kept, `exit_rules_refresh_interval`, one minute by default.
+func NewExitClassifierCache(conf config.Config) (*ExitClassifierCache, error) {
+	configured, err := ExitRulesFromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	c := &ExitClassifierCache{configured: configured, ttl: time.Minute}
+	if conf.IsSet("exit_rules_refresh_interval") {
+		if c.ttl, err = time.ParseDuration(conf.GetString("exit_rules_refresh_interval")); err != nil {
+			return nil, errors.Wrap(err, "invalid exit_rules_refresh_interval")
+		}
+	}
+	return c, nil
+}
+
+// Get returns the cached classifier, loading it when it is older than the
+// TTL. When the stored rules can't be loaded the last classifier that has
+// them is kept, and they are loaded again on the next call.
+func (c *ExitClassifierCache) Get(sm Manager) (*ExitClassifier, error) {
+	if c == nil {
+		return LoadExitClassifier(sm, nil)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.classifier != nil && time.Since(c.loadedAt) < c.ttl {
+		return c.classifier, nil
+	}
+	classifier, err := LoadExitClassifier(sm, c.configured)
+	if err != nil {
+		if c.classifier != nil {
+			return c.classifier, err
+		}
+		return classifier, err
+	}
+	c.classifier = classifier
+	c.loadedAt = time.Now()
+	return classifier, nil
+}
+
+// ExitClassification is the category and reason of a failed run; RuleID is
+// the rule that matched, if any.
+type ExitClassification struct {
+	Category string  `json:"category"`
+	Reason   string  `json:"reason"`
+	RuleID   *string `json:"rule_id,omitempty"`
+}
+
+type compiledExitRule struct {
+	ExitRule
+	re *regexp.Regexp
+}
+
+// ExitClassifier classifies failed runs with an ordered set of exit rules.
+type ExitClassifier struct {
+	rules []compiledExitRule
+}
+
+// NewExitClassifier compiles rules; earlier rules win ties of priority.
+func NewExitClassifier(rules []ExitRule) (*ExitClassifier, error) {
+	c := ExitClassifier{}
+	for _, r := range rules {
+		compiled := compiledExitRule{ExitRule: r}
+		if r.Pattern != nil {
+			re, err := regexp.Compile(*r.Pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid pattern of exit rule [%s]", r.RuleID)
+			}
+			compiled.re = re
+		}
+		c.rules = append(c.rules, compiled)
+	}
+	sort.SliceStable(c.rules, func(i, j int) bool {
+		if c.rules[i].Priority != c.rules[j].Priority {
+			return c.rules[i].Priority > c.rules[j].Priority
+		}
+		return c.rules[i].GroupName != nil && c.rules[j].GroupName == nil
+	})
+	return &c, nil
+}
+
+// RunFailed is true for a stopped run that didn't exit cleanly.
+func RunFailed(run Run) bool {
+	if run.ExitCode != nil {
+		return *run.ExitCode != 0
+	}
+	return run.ExitReason != nil || (run.RunExceptions != nil && len(*run.RunExceptions) > 0)
+}
+
+// Classify returns the category and reason of a run from the first rule of
+// its group or of every group that matches.
+func (c *ExitClassifier) Classify(run Run) ExitClassification {
+	var text []string
+	if run.ExitReason != nil {
+		text = append(text, *run.ExitReason)
+	}
+	if run.RunExceptions != nil {
+		text = append(text, *run.RunExceptions...)
+	}
+	joined := strings.Join(text, "\n")
+
+	for _, r := range c.rules {
+		if r.GroupName != nil && *r.GroupName != run.GroupName {
+			continue
+		}
+		if r.ExitCode != nil && (run.ExitCode == nil || *run.ExitCode != *r.ExitCode) {
+			continue
+		}
+		if r.re != nil && !r.re.MatchString(joined) {
+			continue
+		}
+		ruleID := r.RuleID
+		return ExitClassification{Category: r.Category, Reason: r.Reason, RuleID: &ruleID}
+	}
+	return ExitClassification{Category: ExitCategoryUnknown, Reason: UnclassifiedExitReason}
+}
+
+// Apply sets the exit category of a failed run, and its exit reason when it
+// has none.
+func (c *ExitClassifier) Apply(run *Run) {
+	if !RunFailed(*run) {
+		return
+	}
+	classification := c.Classify(*run)
+	run.ExitCategory = &classification.Category
+	if run.ExitReason == nil {
+		run.ExitReason = &classification.Reason
+	}
+}
+
+// ExitRuleTestRequest asks how a rule set, followed by the current rules
+// when IncludeCurrent is set, would classify the failed runs with run
+// exceptions that most recently finished, optionally of one group.
+type ExitRuleTestRequest struct {
+	Rules          []ExitRule `json:"rules"`
+	IncludeCurrent bool       `json:"include_current"`
+	GroupName      *string    `json:"group_name,omitempty"`
+	Limit          int        `json:"limit"`
+}
+
+// ExitRuleTestReport counts the runs a rule set matched by rule and category,
+// and lists the runs whose category would change.
+type ExitRuleTestReport struct {
+	Runs       int               `json:"runs"`
+	ByRule     map[string]int    `json:"by_rule"`
+	ByCategory map[string]int    `json:"by_category"`
+	Changed    []ExitRuleTestRun `json:"changed"`
+}
+
+// ExitRuleTestRun is a run whose category a rule set would change.
+type ExitRuleTestRun struct {
+	RunID            string             `json:"run_id"`
+	GroupName        string             `json:"group_name"`
+	PreviousCategory *string            `json:"previous_category,omitempty"`
+	Classification   ExitClassification `json:"classification"`
+}
+
+// Test classifies runs and reports the outcome.
+func (c *ExitClassifier) Test(runs []Run) ExitRuleTestReport {
+	report := ExitRuleTestReport{
+		ByRule:     map[string]int{},
+		ByCategory: map[string]int{},
+		Changed:    []ExitRuleTestRun{},
+	}
+	for _, run := range runs {
+		report.Runs++
+		classification := c.Classify(run)
+		if classification.RuleID != nil {
+			report.ByRule[*classification.RuleID]++
+		}
+		report.ByCategory[classification.Category]++
+		if run.ExitCategory == nil || *run.ExitCategory != classification.Category {
+			report.Changed = append(report.Changed, ExitRuleTestRun{
+				RunID:            run.RunID,
+				GroupName:        run.GroupName,
+				PreviousCategory: run.ExitCategory,
+				Classification:   classification,
+			})
+		}
+	}
+	return report
+}
@@ -0,0 +1,131 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestExitClassifier_Classify(t *testing.T) {
+	c, err := NewExitClassifier(DefaultExitRules)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	exceptions := RunExceptions{"Traceback (most recent call last):", "ValueError: could not convert"}
+	failed := Run{ExitCode: aws.Int64(1), RunExceptions: &exceptions}
+	if got := c.Classify(failed); got.Category != ExitCategoryUnknown || got.RuleID != nil {
+		t.Errorf("Expected an unmatched run to be unknown, got %+v", got)
+	}
+
+	exceptions = RunExceptions{"SyntaxError: invalid syntax"}
+	if got := c.Classify(failed); got.Category != ExitCategoryUserCode || *got.RuleID != "default-syntax" {
+		t.Errorf("Expected a syntax error, got %+v", got)
+	}
+
+	oom := Run{ExitCode: aws.Int64(137)}
+	if got := c.Classify(oom); got.Category != ExitCategoryOOM {
+		t.Errorf("Expected exit code 137 to be an oom, got %+v", got)
+	}
+
+	spot := Run{ExitCode: aws.Int64(1), ExitReason: aws.String(SpotInterruptionReason(3))}
+	if got := c.Classify(spot); got.Category != ExitCategorySpot {
+		t.Errorf("Expected a spot interruption, got %+v", got)
+	}
+}
+
+func TestExitClassifier_Priority(t *testing.T) {
+	rules := []ExitRule{
+		{RuleID: "group", Pattern: aws.String("KeyError"), Category: ExitCategoryDependency, Reason: "Missing feature", GroupName: aws.String("etl")},
+		{RuleID: "urgent", Pattern: aws.String("KeyError: 'region'"), Category: ExitCategoryInfra, Reason: "Region lookup", Priority: 10},
+	}
+	c, _ := NewExitClassifier(append(rules, DefaultExitRules...))
+
+	exceptions := RunExceptions{"KeyError: 'user_id'"}
+	if got := c.Classify(Run{GroupName: "etl", ExitCode: aws.Int64(1), RunExceptions: &exceptions}); *got.RuleID != "group" {
+		t.Errorf("Expected the group's rule, got %+v", got)
+	}
+	if got := c.Classify(Run{GroupName: "ml", ExitCode: aws.Int64(1), RunExceptions: &exceptions}); *got.RuleID != "default-argument" {
+		t.Errorf("Expected other groups to fall through to the defaults, got %+v", got)
+	}
+
+	exceptions = RunExceptions{"KeyError: 'region'"}
+	if got := c.Classify(Run{GroupName: "etl", ExitCode: aws.Int64(1), RunExceptions: &exceptions}); *got.RuleID != "urgent" {
+		t.Errorf("Expected the higher priority rule, got %+v", got)
+	}
+}
+
+func TestExitClassifier_Apply(t *testing.T) {
+	c, _ := NewExitClassifier(DefaultExitRules)
+
+	succeeded := Run{ExitCode: aws.Int64(0)}
+	c.Apply(&succeeded)
+	if succeeded.ExitCategory != nil || succeeded.ExitReason != nil {
+		t.Errorf("Expected successful runs to be left alone")
+	}
+
+	timedOut := Run{ExitCode: aws.Int64(1), ExitReason: aws.String("JobRun exceeded specified timeout of 60 seconds")}
+	c.Apply(&timedOut)
+	if *timedOut.ExitCategory != ExitCategoryTimeout || *timedOut.ExitReason != "JobRun exceeded specified timeout of 60 seconds" {
+		t.Errorf("Expected a timeout keeping its exit reason, got %s: %s", *timedOut.ExitCategory, *timedOut.ExitReason)
+	}
+}
+
+func TestExitRule_IsValid(t *testing.T) {
+	valid := ExitRule{Pattern: aws.String("(?i)syntaxerror"), Category: ExitCategoryUserCode, Reason: "Code or syntax error"}
+	if ok, reasons := valid.IsValid(); !ok {
+		t.Errorf("Expected a valid rule, got %v", reasons)
+	}
+	for _, invalid := range []ExitRule{
+		{Pattern: aws.String("(?i).*(syntaxerror|typeerror|).*"), Category: ExitCategoryUserCode, Reason: "matches everything"},
+		{Pattern: aws.String("("), Category: ExitCategoryUserCode, Reason: "invalid"},
+		{Category: ExitCategoryUserCode, Reason: "matches nothing"},
+		{Pattern: aws.String("Error"), Category: "bad luck", Reason: "unknown category"},
+		{Pattern: aws.String("Error"), Category: ExitCategoryUserCode},
+	} {
+		if ok, _ := invalid.IsValid(); ok {
+			t.Errorf("Expected rule %+v to be invalid", invalid)
+		}
+	}
+}
+
+type exitRulesManager struct {
+	Manager
+	loads int
+	err   error
+}
+
+func (m *exitRulesManager) ListExitRules() (ExitRuleList, error) {
+	m.loads++
+	return ExitRuleList{}, m.err
+}
+
+func TestExitClassifierCache_Get(t *testing.T) {
+	cache, err := NewExitClassifierCache(araTestConfig{"exit_rules_refresh_interval": "1h"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sm := &exitRulesManager{}
+	first, _ := cache.Get(sm)
+	second, _ := cache.Get(sm)
+	if sm.loads != 1 || first != second {
+		t.Errorf("Expected the stored exit rules to be loaded once within the interval, got %v loads", sm.loads)
+	}
+
+	cache.loadedAt = cache.loadedAt.Add(-2 * time.Hour)
+	sm.err = errors.New("unavailable")
+	if c, err := cache.Get(sm); err == nil || c != first {
+		t.Errorf("Expected the previous classifier to be kept when loading fails")
+	}
+	sm.err = nil
+	cache.Get(sm)
+	if sm.loads != 3 {
+		t.Errorf("Expected the exit rules to be loaded again after a failed load, got %v loads", sm.loads)
+	}
+
+	if _, err := NewExitClassifierCache(araTestConfig{"exit_rules_refresh_interval": "soon"}); err == nil {
+		t.Errorf("Expected an invalid exit_rules_refresh_interval to be rejected")
+	}
+}
@@ -0,0 +1,136 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+)
+
+// GPUType describes how runs using a type of GPU are placed and sized; cpu
+// and memory are per GPU, in millicores and MB.
+type GPUType struct {
+	Name                string            `json:"-"`
+	InstanceTypes       []string          `json:"instance_types"`
+	NodeSelector        map[string]string `json:"node_selector,omitempty"`
+	Tolerations         []Toleration      `json:"tolerations,omitempty"`
+	CpuLimitPerGpu      int64             `json:"cpu_limit_per_gpu"`
+	CpuRequestPerGpu    int64             `json:"cpu_request_per_gpu"`
+	MemoryLimitPerGpu   int64             `json:"memory_limit_per_gpu"`
+	MemoryRequestPerGpu int64             `json:"memory_request_per_gpu"`
+	MaxGpus             int64             `json:"max_gpus"`
+}
+
+// GPUCatalog is the set of GPU types runs may request.
+type GPUCatalog struct {
+	DefaultType string
+	Types       map[string]GPUType
+}
+
+// DefaultGPUCatalog mirrors the historical p3 only behavior.
+var DefaultGPUCatalog = GPUCatalog{
+	DefaultType: "v100",
+	Types: map[string]GPUType{
+		"v100": {
+			Name:                "v100",
+			InstanceTypes:       []string{"p3.2xlarge", "p3.8xlarge", "p3.16xlarge"},
+			CpuLimitPerGpu:      7500,
+			CpuRequestPerGpu:    6000,
+			MemoryLimitPerGpu:   60000,
+			MemoryRequestPerGpu: 50000,
+			MaxGpus:             8,
+		},
+	},
+}
+
+// NewGPUCatalog reads `eks_gpu_catalog`, a map of GPU type to a JSON
+// GPUType, and `eks_gpu_default_type`; without them the p3 catalog is used
+func NewGPUCatalog(conf config.Config) (GPUCatalog, error) {
+	if !conf.IsSet("eks_gpu_catalog") {
+		return DefaultGPUCatalog, nil
+	}
+
+	catalog := GPUCatalog{Types: make(map[string]GPUType)}
+	for name, raw := range conf.GetStringMapString("eks_gpu_catalog") {
+		var gt GPUType
+		if err := json.Unmarshal([]byte(raw), &gt); err != nil {
+			return catalog, errors.Wrapf(err, "invalid gpu catalog entry [%s]", name)
+		}
+		gt.Name = name
+		if gt.CpuLimitPerGpu <= 0 || gt.MemoryLimitPerGpu <= 0 {
+			return catalog, errors.Errorf("gpu catalog entry [%s] must set cpu_limit_per_gpu and memory_limit_per_gpu", name)
+		}
+		if gt.CpuRequestPerGpu <= 0 || gt.CpuRequestPerGpu > gt.CpuLimitPerGpu {
+			gt.CpuRequestPerGpu = gt.CpuLimitPerGpu
+		}
+		if gt.MemoryRequestPerGpu <= 0 || gt.MemoryRequestPerGpu > gt.MemoryLimitPerGpu {
+			gt.MemoryRequestPerGpu = gt.MemoryLimitPerGpu
+		}
+		catalog.Types[name] = gt
+	}
+
+	catalog.DefaultType = conf.GetString("eks_gpu_default_type")
+	if len(catalog.DefaultType) == 0 && len(catalog.Types) == 1 {
+		for name := range catalog.Types {
+			catalog.DefaultType = name
+		}
+	}
+	if _, ok := catalog.Types[catalog.DefaultType]; !ok {
+		return catalog, errors.Errorf("eks_gpu_default_type [%s] is not in the gpu catalog", catalog.DefaultType)
+	}
+	return catalog, nil
+}
+
+// Get returns the named GPU type, or the default type when name is empty.
+func (c GPUCatalog) Get(name *string) (GPUType, bool) {
+	if name == nil || len(*name) == 0 {
+		gt, ok := c.Types[c.DefaultType]
+		return gt, ok
+	}
+	gt, ok := c.Types[*name]
+	return gt, ok
+}
+
+// TypeNames returns the sorted names of the catalog's GPU types.
+func (c GPUCatalog) TypeNames() []string {
+	var names []string
+	for name := range c.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InstanceTypes returns every GPU instance type in the catalog, so that
+// non GPU runs can be kept off them.
+func (c GPUCatalog) InstanceTypes() []string {
+	var instanceTypes []string
+	for _, name := range c.TypeNames() {
+		instanceTypes = append(instanceTypes, c.Types[name].InstanceTypes...)
+	}
+	return instanceTypes
+}
+
+// Validate returns the reasons a gpu request can't be satisfied by the catalog.
+func (c GPUCatalog) Validate(resources ExecutableResources) []string {
+	var reasons []string
+	hasGpu := resources.Gpu != nil && *resources.Gpu > 0
+	if resources.GpuType != nil && len(*resources.GpuType) > 0 && !hasGpu {
+		reasons = append(reasons, "int [gpu] must be positive when [gpu_type] is specified")
+	}
+	if !hasGpu {
+		return reasons
+	}
+	gt, ok := c.Get(resources.GpuType)
+	if !ok {
+		return append(reasons, fmt.Sprintf(
+			"string [gpu_type] must be one of %v", c.TypeNames()))
+	}
+	if gt.MaxGpus > 0 && *resources.Gpu > gt.MaxGpus {
+		reasons = append(reasons, fmt.Sprintf(
+			"int [gpu] may not exceed %d for gpu_type [%s]", gt.MaxGpus, gt.Name))
+	}
+	return reasons
+}
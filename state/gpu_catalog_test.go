@@ -0,0 +1,54 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestNewGPUCatalog(t *testing.T) {
+	catalog, err := NewGPUCatalog(araTestConfig{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if catalog.DefaultType != "v100" || len(catalog.InstanceTypes()) != 3 {
+		t.Errorf("Expected the p3 catalog by default, got %+v", catalog)
+	}
+
+	catalog, err = NewGPUCatalog(araTestConfig{
+		"eks_gpu_default_type": "a10g",
+		"eks_gpu_catalog": map[string]string{
+			"a10g": `{"instance_types": ["g5.xlarge"], "cpu_limit_per_gpu": 4000, "memory_limit_per_gpu": 16000, "max_gpus": 1}`,
+			"a100": `{"node_selector": {"gpu": "a100"}, "tolerations": [{"key": "nvidia.com/gpu", "effect": "NoSchedule"}], "cpu_limit_per_gpu": 12000, "cpu_request_per_gpu": 10000, "memory_limit_per_gpu": 140000}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	a10g, ok := catalog.Get(nil)
+	if !ok || a10g.Name != "a10g" || a10g.CpuRequestPerGpu != 4000 {
+		t.Errorf("Expected default a10g with request defaulted to limit, got %+v", a10g)
+	}
+	if len(catalog.InstanceTypes()) != 1 {
+		t.Errorf("Expected 1 gpu instance type, got %v", catalog.InstanceTypes())
+	}
+
+	gpus := int64(2)
+	a100 := "a100"
+	def := Definition{Alias: "a", ExecutableResources: ExecutableResources{Image: "i", Gpu: &gpus}}
+	if valid, _ := def.IsValid(catalog); valid {
+		t.Errorf("Expected 2 gpus to exceed max_gpus of the default type")
+	}
+	def.GpuType = &a100
+	if valid, reasons := def.IsValid(catalog); !valid {
+		t.Errorf("Expected a100 definition to be valid, got %v", reasons)
+	}
+}
+
+func TestNewGPUCatalog_Invalid(t *testing.T) {
+	_, err := NewGPUCatalog(araTestConfig{
+		"eks_gpu_default_type": "v100",
+		"eks_gpu_catalog":      map[string]string{"a10g": `{"cpu_limit_per_gpu": 4000, "memory_limit_per_gpu": 16000}`},
+	})
+	if err == nil {
+		t.Errorf("Expected error for default type missing from the catalog")
+	}
+}
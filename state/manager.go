@@ -3,6 +3,7 @@ package state
 import (
 	"github.com/pkg/errors"
 	"github.com/stitchfix/flotilla-os/config"
+	"time"
 )
 
 //
@@ -24,10 +25,11 @@ type Manager interface {
 	DeleteDefinition(definitionID string) error
 
 	ListRuns(limit int, offset int, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string) (RunList, error)
-	EstimateRunResources(executableID string, commandHash string) (TaskResources, error)
-	EstimateExecutorCount(executableID string, commandHash string) (int64, error)
+	EstimateRunResources(executableID string, runID string, policy ARAPolicy) (TaskResources, error)
+	SparkSizingStats(executableID string, commandHash string) (SparkSizingStats, error)
 	ExecutorOOM(executableID string, commandHash string) (bool, error)
 	DriverOOM(executableID string, commandHash string) (bool, error)
+	SparkExecutorStats(executableID string, commandHash string) (SparkExecutorStats, error)
 
 	GetRun(runID string) (Run, error)
 	CreateRun(r Run) error
@@ -40,6 +42,16 @@ type Manager interface {
 	BatchUpdateWorkers(updates []Worker) (WorkersList, error)
 	GetWorker(workerType string, engine string) (Worker, error)
 	UpdateWorker(workerType string, updates Worker) (Worker, error)
+	GetDispatchState() (DispatchState, error)
+	UpdateDispatchState(update DispatchState) (DispatchState, error)
+	ListExitRules() (ExitRuleList, error)
+	CreateExitRule(r ExitRule) error
+	DeleteExitRule(ruleID string) error
+	ListExceptionRuns(limit int, groupName *string) ([]Run, error)
+	SaveLoggedExceptions(runID string, found []LoggedException) error
+	ListLoggedExceptions(runID string) (LoggedExceptionList, error)
+	GetExceptionReport(req ExceptionReportRequest) (ExceptionReport, error)
+	ListRunAccessRecords(req AccessReportRequest) ([]RunAccessRecords, error)
 
 	GetExecutableByTypeAndID(executableType ExecutableType, executableID string) (Executable, error)
 
@@ -54,8 +66,16 @@ type Manager interface {
 	GetPodReAttemptRate() (float32, error)
 	GetNodeLifecycle(executableID string, commandHash string) (string, error)
 	GetTaskHistoricalRuntime(executableID string, runId string) (float32, error)
+	GetUsageReport(req UsageReportRequest) (UsageReport, error)
+	GetResourceUsageStats(definitionID string, lookbackDays int) ([]ResourceUsageStats, error)
 
 	GetRunByEMRJobId(string) (Run, error)
+	ClaimIdempotencyKey(executableID string, key string, runID string, retention time.Duration) (string, error)
+	ReleaseIdempotencyKey(executableID string, key string, runID string) error
+	ClaimConcurrencySlot(run Run, maxConcurrent int64) ([]string, bool, error)
+	ReleaseConcurrencySlot(runID string) error
+	CancelPendingRun(runID string, exitReason string) (bool, error)
+	HoldRun(runID string) (Run, error)
 }
 
 //
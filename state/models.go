@@ -22,6 +22,9 @@ var EKSEngine = "eks"
 
 var EKSSparkEngine = "eks-spark"
 
+// EKSSparkNativeEngine runs Spark on the EKS cluster without EMR
+var EKSSparkNativeEngine = "eks-spark-native"
+
 var DefaultEngine = EKSEngine
 
 var DefaultTaskType = "task"
@@ -48,7 +51,15 @@ var DefaultLifecycle = SpotLifecycle
 
 var NodeLifeCycles = []string{OndemandLifecycle, SpotLifecycle}
 
-var Engines = []string{EKSEngine, EKSSparkEngine}
+var Engines = []string{EKSEngine, EKSSparkEngine, EKSSparkNativeEngine}
+
+// SparkEngines run a run's SparkExtension
+var SparkEngines = []string{EKSSparkEngine, EKSSparkNativeEngine}
+
+// IsSparkEngine is true for the engines that run Spark
+func IsSparkEngine(engine *string) bool {
+	return engine != nil && (*engine == EKSSparkEngine || *engine == EKSSparkNativeEngine)
+}
 
 // StatusRunning indicates the run is running
 var StatusRunning = "RUNNING"
@@ -65,6 +76,9 @@ var StatusPending = "PENDING"
 // StatusStopped means the run is finished
 var StatusStopped = "STOPPED"
 
+// StatusHeld means the queued run is held back from dispatch until released
+var StatusHeld = "HELD"
+
 var MaxLogLines = int64(256)
 
 var EKSBackoffLimit = int32(0)
@@ -88,7 +102,8 @@ func IsValidStatus(status string) bool {
 		status == StatusQueued ||
 		status == StatusNeedsRetry ||
 		status == StatusPending ||
-		status == StatusStopped
+		status == StatusStopped ||
+		status == StatusHeld
 }
 
 // NewRunID returns a new uuid for a Run
@@ -130,11 +145,13 @@ type PortsList []int
 
 //
 // EnvVar represents a single environment variable
-// for either a definition or a run
+// for either a definition or a run; when SecretRef is
+// set the value is resolved from the secret store
 //
 type EnvVar struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	SecretRef string `json:"secret_ref,omitempty"`
 }
 
 type NodeList []string
@@ -149,14 +166,22 @@ type Tags []string
 // ExecutableResources define the resources and flags required to run an
 // executable.
 type ExecutableResources struct {
-	Image                      string     `json:"image"`
-	Memory                     *int64     `json:"memory,omitempty"`
-	Gpu                        *int64     `json:"gpu,omitempty"`
-	Cpu                        *int64     `json:"cpu,omitempty"`
-	Env                        *EnvList   `json:"env"`
-	AdaptiveResourceAllocation *bool      `json:"adaptive_resource_allocation,omitempty"`
-	Ports                      *PortsList `json:"ports,omitempty"`
-	Tags                       *Tags      `json:"tags,omitempty"`
+	Image                      string             `json:"image"`
+	Memory                     *int64             `json:"memory,omitempty"`
+	Gpu                        *int64             `json:"gpu,omitempty"`
+	GpuType                    *string            `json:"gpu_type,omitempty"`
+	Cpu                        *int64             `json:"cpu,omitempty"`
+	Env                        *EnvList           `json:"env"`
+	AdaptiveResourceAllocation *bool              `json:"adaptive_resource_allocation,omitempty"`
+	Ports                      *PortsList         `json:"ports,omitempty"`
+	Tags                       *Tags              `json:"tags,omitempty"`
+	Placement                  *Placement         `json:"placement,omitempty"`
+	Volumes                    *VolumeList        `json:"volumes,omitempty"`
+	InitContainers             *ContainerList     `json:"init_containers,omitempty"`
+	Sidecars                   *ContainerList     `json:"sidecars,omitempty"`
+	UseImageEntrypoint         *bool              `json:"use_image_entrypoint,omitempty"`
+	Concurrency                *ConcurrencyPolicy `json:"concurrency,omitempty"`
+	ServiceAccount             *string            `json:"service_account,omitempty"`
 }
 
 type ExecutableType string
@@ -201,6 +226,24 @@ type SparkExtension struct {
 	Executors            []string              `json:"executors,omitempty"`
 	ExecutorOOM          *bool                 `json:"executor_oom,omitempty"`
 	DriverOOM            *bool                 `json:"driver_oom,omitempty"`
+	ExecutorRecords      []SparkExecutor       `json:"executor_records,omitempty"`
+	ExecutorSummary      *SparkExecutorSummary `json:"executor_summary,omitempty"`
+	SparkApplication     *string               `json:"spark_application,omitempty"`
+	StageSummary         *SparkStageSummary    `json:"stage_summary,omitempty"`
+	SizingReport         *SparkSizingReport    `json:"sizing_report,omitempty"`
+}
+
+// Request returns the fields of the extension set by the run's request,
+// leaving out those recorded while the run executed.
+func (se SparkExtension) Request() *SparkExtension {
+	return &SparkExtension{
+		SparkSubmitJobDriver: se.SparkSubmitJobDriver,
+		ApplicationConf:      se.ApplicationConf,
+		HiveConf:             se.HiveConf,
+		EMRReleaseLabel:      se.EMRReleaseLabel,
+		ExecutorInitCommand:  se.ExecutorInitCommand,
+		DriverInitCommand:    se.DriverInitCommand,
+	}
 }
 
 type Conf struct {
@@ -237,6 +280,9 @@ type ExecutionRequestCommon struct {
 	SparkExtension        *SparkExtension `json:"spark_extension,omitempty"`
 	Description           *string         `json:"description,omitempty"`
 	CommandHash           *string         `json:"command_hash,omitempty"`
+	Placement             *Placement      `json:"placement,omitempty"`
+	Volumes               *VolumeList     `json:"volumes,omitempty"`
+	IdempotencyKey        *string         `json:"idempotency_key,omitempty"`
 }
 
 type ExecutionRequestCustom map[string]interface{}
@@ -259,6 +305,13 @@ func (d *DefinitionExecutionRequest) GetExecutionRequestCustom() *ExecutionReque
 	return nil
 }
 
+// RerunRequest overrides fields of the run being rerun; unset fields keep
+// the run's values and env vars are merged by name.
+type RerunRequest struct {
+	*ExecutionRequestCommon
+	TemplatePayload TemplatePayload `json:"template_payload,omitempty"`
+}
+
 type TerminateJob struct {
 	RunID    string
 	UserInfo UserInfo
@@ -323,9 +376,9 @@ type validationCondition struct {
 
 //
 // IsValid returns true only if this is a valid definition with all
-// required information
+// required information and a gpu request the catalog can satisfy
 //
-func (d *Definition) IsValid() (bool, []string) {
+func (d *Definition) IsValid(gpus GPUCatalog) (bool, []string) {
 	conditions := []validationCondition{
 		{len(d.Image) == 0, "string [image] must be specified"},
 		{len(d.Alias) == 0, "string [alias] must be specified"},
@@ -339,6 +392,22 @@ func (d *Definition) IsValid() (bool, []string) {
 			reasons = append(reasons, cond.reason)
 		}
 	}
+	if gpuReasons := gpus.Validate(d.ExecutableResources); len(gpuReasons) > 0 {
+		valid = false
+		reasons = append(reasons, gpuReasons...)
+	}
+	if envReasons := ValidateEnv(d.Env); len(envReasons) > 0 {
+		valid = false
+		reasons = append(reasons, envReasons...)
+	}
+	if containerReasons := ValidateContainers(d.InitContainers, d.Sidecars, d.Volumes); len(containerReasons) > 0 {
+		valid = false
+		reasons = append(reasons, containerReasons...)
+	}
+	if concurrencyReasons := ValidateConcurrency(d.Concurrency); len(concurrencyReasons) > 0 {
+		valid = false
+		reasons = append(reasons, concurrencyReasons...)
+	}
 	return valid, reasons
 }
 
@@ -364,6 +433,30 @@ func (d *Definition) UpdateWith(other Definition) {
 	if other.Gpu != nil {
 		d.Gpu = other.Gpu
 	}
+	if other.GpuType != nil {
+		d.GpuType = other.GpuType
+	}
+	if other.Placement != nil {
+		d.Placement = other.Placement
+	}
+	if other.Volumes != nil {
+		d.Volumes = other.Volumes
+	}
+	if other.InitContainers != nil {
+		d.InitContainers = other.InitContainers
+	}
+	if other.Concurrency != nil {
+		d.Concurrency = other.Concurrency
+	}
+	if other.Sidecars != nil {
+		d.Sidecars = other.Sidecars
+	}
+	if other.UseImageEntrypoint != nil {
+		d.UseImageEntrypoint = other.UseImageEntrypoint
+	}
+	if other.ServiceAccount != nil {
+		d.ServiceAccount = other.ServiceAccount
+	}
 	if other.Cpu != nil {
 		d.Cpu = other.Cpu
 	}
@@ -463,6 +556,7 @@ type Run struct {
 	CpuLimit                *int64                   `json:"cpu_limit,omitempty"`
 	Gpu                     *int64                   `json:"gpu,omitempty"`
 	ExitReason              *string                  `json:"exit_reason,omitempty"`
+	ExitCategory            *string                  `json:"exit_category,omitempty"`
 	Engine                  *string                  `json:"engine,omitempty"`
 	NodeLifecycle           *string                  `json:"node_lifecycle,omitempty"`
 	EphemeralStorage        *int64                   `json:"ephemeral_storage,omitempty"`
@@ -482,6 +576,12 @@ type Run struct {
 	SparkExtension          *SparkExtension          `json:"spark_extension,omitempty"`
 	MetricsUri              *string                  `json:"metrics_uri,omitempty"`
 	Description             *string                  `json:"description,omitempty"`
+	AraEstimate             *ARAEstimate             `json:"ara_estimate,omitempty"`
+	Placement               *Placement               `json:"placement,omitempty"`
+	Volumes                 *VolumeList              `json:"volumes,omitempty"`
+	SpotInterruptions       *int64                   `json:"spot_interruptions,omitempty"`
+	RerunOf                 *string                  `json:"rerun_of,omitempty"`
+	ServiceAccount          *string                  `json:"service_account,omitempty"`
 }
 
 //
@@ -538,6 +638,10 @@ func (d *Run) UpdateWith(other Run) {
 		d.ExitReason = other.ExitReason
 	}
 
+	if other.ExitCategory != nil {
+		d.ExitCategory = other.ExitCategory
+	}
+
 	if other.Command != nil && len(*other.Command) > 0 {
 		d.Command = other.Command
 	}
@@ -630,6 +734,30 @@ func (d *Run) UpdateWith(other Run) {
 		d.Description = other.Description
 	}
 
+	if other.AraEstimate != nil {
+		d.AraEstimate = other.AraEstimate
+	}
+
+	if other.Placement != nil {
+		d.Placement = other.Placement
+	}
+
+	if other.Volumes != nil {
+		d.Volumes = other.Volumes
+	}
+
+	if other.SpotInterruptions != nil {
+		d.SpotInterruptions = other.SpotInterruptions
+	}
+
+	if other.RerunOf != nil {
+		d.RerunOf = other.RerunOf
+	}
+
+	if other.ServiceAccount != nil {
+		d.ServiceAccount = other.ServiceAccount
+	}
+
 	if other.MemoryLimit != nil {
 		d.MemoryLimit = other.MemoryLimit
 	}
@@ -643,17 +771,19 @@ func (d *Run) UpdateWith(other Run) {
 	// QUEUED --> PENDING --> RUNNING --> STOPPED
 	// QUEUED --> PENDING --> NEEDS_RETRY --> QUEUED ...
 	// QUEUED --> PENDING --> STOPPED ...
+	// QUEUED --> HELD --> QUEUED ...
 	//
 	statusPrecedence := map[string]int{
 		StatusNeedsRetry: -1,
+		StatusHeld:       -1,
 		StatusQueued:     0,
 		StatusPending:    1,
 		StatusRunning:    2,
 		StatusStopped:    3,
 	}
 
-	if other.Status == StatusNeedsRetry {
-		d.Status = StatusNeedsRetry
+	if other.Status == StatusNeedsRetry || other.Status == StatusHeld {
+		d.Status = other.Status
 	} else {
 		if runStatus, ok := statusPrecedence[d.Status]; ok {
 			if newStatus, ok := statusPrecedence[other.Status]; ok {
@@ -805,11 +935,13 @@ func (w *PodEvent) Equal(other PodEvent) bool {
 }
 
 type PodEvent struct {
-	Timestamp    *time.Time `json:"timestamp,omitempty"`
-	EventType    string     `json:"event_type"`
-	Reason       string     `json:"reason"`
-	SourceObject string     `json:"source_object"`
-	Message      string     `json:"message"`
+	Timestamp     *time.Time `json:"timestamp,omitempty"`
+	LastTimestamp *time.Time `json:"last_timestamp,omitempty"`
+	Count         int64      `json:"count,omitempty"`
+	EventType     string     `json:"event_type"`
+	Reason        string     `json:"reason"`
+	SourceObject  string     `json:"source_object"`
+	Message       string     `json:"message"`
 }
 
 //
@@ -846,6 +978,18 @@ func (w *Worker) UpdateWith(other Worker) {
 	}
 }
 
+//
+// DispatchState is the global switch that stops submit workers from
+// dispatching queued runs, e.g. during maintenance windows; the API keeps
+// accepting runs while dispatch is paused
+//
+type DispatchState struct {
+	Paused    bool       `json:"paused"`
+	Reason    *string    `json:"reason,omitempty"`
+	UpdatedBy *string    `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
 //
 // WorkersList wraps a list of Workers
 //
@@ -862,8 +1006,112 @@ type UserInfo struct {
 
 // Internal object for tracking cpu / memory resources.
 type TaskResources struct {
-	Cpu    int64 `json:"cpu"`
-	Memory int64 `json:"memory"`
+	Cpu     int64 `json:"cpu"`
+	Memory  int64 `json:"memory"`
+	Samples int64 `json:"samples"`
+}
+
+// UsageReportGroupings are the run columns a usage report can be sliced by.
+var UsageReportGroupings = map[string]bool{
+	"group_name":   true,
+	"alias":        true,
+	"engine":       true,
+	"cluster_name": true,
+}
+
+// UsageReportRequest describes the slice and time window of a usage report
+type UsageReportRequest struct {
+	GroupBy        string
+	GroupName      *string
+	Alias          *string
+	Engine         *string
+	ClusterName    *string
+	Since          time.Time
+	Until          time.Time
+	TopExitReasons int
+}
+
+// UsageStats holds queue wait, runtime and outcome metrics for one slice of runs
+type UsageStats struct {
+	Key                   string            `json:"key"`
+	TotalRuns             int64             `json:"total_runs"`
+	SucceededRuns         int64             `json:"succeeded_runs"`
+	FailedRuns            int64             `json:"failed_runs"`
+	SuccessRate           float64           `json:"success_rate"`
+	FailureRate           float64           `json:"failure_rate"`
+	QueueWaitP50Seconds   float64           `json:"queue_wait_p50_seconds"`
+	QueueWaitP95Seconds   float64           `json:"queue_wait_p95_seconds"`
+	QueueWaitP99Seconds   float64           `json:"queue_wait_p99_seconds"`
+	RuntimeP50Seconds     float64           `json:"runtime_p50_seconds"`
+	RuntimeP95Seconds     float64           `json:"runtime_p95_seconds"`
+	RuntimeP99Seconds     float64           `json:"runtime_p99_seconds"`
+	OOMCount              int64             `json:"oom_count"`
+	SpotInterruptionCount int64             `json:"spot_interruption_count"`
+	SpotRuns              int64             `json:"spot_runs"`
+	InterruptedRuns       int64             `json:"interrupted_runs"`
+	SpotInterruptionRate  float64           `json:"spot_interruption_rate"`
+	TopExitReasons        []ExitReasonCount `json:"top_exit_reasons"`
+}
+
+// ExitReasonCount is the number of failed runs sharing an exit reason.
+type ExitReasonCount struct {
+	ExitReason string `json:"exit_reason"`
+	Count      int64  `json:"count"`
+}
+
+// UsageReport wraps the usage stats for each slice in a time window
+type UsageReport struct {
+	GroupBy string       `json:"group_by"`
+	Since   time.Time    `json:"since"`
+	Until   time.Time    `json:"until"`
+	Total   int          `json:"total"`
+	Stats   []UsageStats `json:"stats"`
+}
+
+// ResourceUsageStats summarizes observed peak usage across the successful
+// runs of a definition; Overall marks the row aggregated across command hashes.
+type ResourceUsageStats struct {
+	CommandHash     string  `json:"command_hash"`
+	Overall         bool    `json:"overall"`
+	SampleSize      int64   `json:"sample_size"`
+	MemoryP50       int64   `json:"memory_p50"`
+	MemoryP95       int64   `json:"memory_p95"`
+	CpuP50          int64   `json:"cpu_p50"`
+	CpuP95          int64   `json:"cpu_p95"`
+	RequestedMemory int64   `json:"requested_memory"`
+	RequestedCpu    int64   `json:"requested_cpu"`
+	RuntimeHours    float64 `json:"runtime_hours"`
+}
+
+// Confidence levels of a resource recommendation.
+const (
+	ConfidenceInsufficient = "insufficient"
+	ConfidenceLow          = "low"
+	ConfidenceMedium       = "medium"
+	ConfidenceHigh         = "high"
+)
+
+// ResourceRecommendation is a suggested cpu/memory setting derived from usage.
+type ResourceRecommendation struct {
+	CommandHash        string             `json:"command_hash,omitempty"`
+	CurrentCpu         int64              `json:"current_cpu"`
+	CurrentMemory      int64              `json:"current_memory"`
+	RecommendedCpu     int64              `json:"recommended_cpu"`
+	RecommendedMemory  int64              `json:"recommended_memory"`
+	Confidence         string             `json:"confidence"`
+	CpuSavings         int64              `json:"cpu_savings"`
+	MemorySavings      int64              `json:"memory_savings"`
+	CpuCoreHoursSaved  float64            `json:"cpu_core_hours_saved"`
+	MemoryGBHoursSaved float64            `json:"memory_gb_hours_saved"`
+	Usage              ResourceUsageStats `json:"usage"`
+}
+
+// ResourceRecommendations wraps the recommendations for a definition.
+type ResourceRecommendations struct {
+	DefinitionID  string                   `json:"definition_id"`
+	LookbackDays  int                      `json:"lookback_days"`
+	Definition    ResourceRecommendation   `json:"definition"`
+	CommandHashes []ResourceRecommendation `json:"command_hashes"`
 }
 
 // SQS notification object for CloudTrail S3 files.
@@ -883,11 +1131,28 @@ type CloudTrailNotifications struct {
 	Records []Record `json:"Records"`
 }
 
-// CloudTrail notification record.
+// CloudTrail notification record. Records of the same call and outcome are
+// kept once per run, with Count the number of calls seen.
 type Record struct {
-	UserIdentity UserIdentity `json:"userIdentity"`
-	EventSource  string       `json:"eventSource"`
-	EventName    string       `json:"eventName"`
+	EventID           string                 `json:"eventID,omitempty"`
+	EventIDs          []string               `json:"eventIDs,omitempty"`
+	UserIdentity      UserIdentity           `json:"userIdentity"`
+	EventSource       string                 `json:"eventSource"`
+	EventName         string                 `json:"eventName"`
+	EventTime         string                 `json:"eventTime,omitempty"`
+	AWSRegion         string                 `json:"awsRegion,omitempty"`
+	ErrorCode         string                 `json:"errorCode,omitempty"`
+	ErrorMessage      string                 `json:"errorMessage,omitempty"`
+	Resources         []RecordResource       `json:"resources,omitempty"`
+	RequestParameters map[string]interface{} `json:"requestParameters,omitempty"`
+	Count             int                    `json:"count,omitempty"`
+}
+
+// AWS resource a CloudTrail record was about.
+type RecordResource struct {
+	ARN       string `json:"ARN"`
+	AccountID string `json:"accountId,omitempty"`
+	Type      string `json:"type,omitempty"`
 }
 
 // User ARN who performed the AWS api action.
@@ -902,7 +1167,11 @@ func (w *Record) Equal(other Record) bool {
 
 // String helper method for Record.
 func (w *Record) String() string {
-	return fmt.Sprintf("%s-%s", w.EventSource, w.EventName)
+	key := fmt.Sprintf("%s-%s", w.EventSource, w.EventName)
+	if len(w.ErrorCode) > 0 {
+		key = fmt.Sprintf("%s-%s", key, w.ErrorCode)
+	}
+	return key
 }
 
 const TemplatePayloadKey = "template_payload"
@@ -1071,6 +1340,18 @@ func (t *Template) IsValid() (bool, []string) {
 			reasons = append(reasons, cond.reason)
 		}
 	}
+	if envReasons := ValidateEnv(t.Env); len(envReasons) > 0 {
+		valid = false
+		reasons = append(reasons, envReasons...)
+	}
+	if containerReasons := ValidateContainers(t.InitContainers, t.Sidecars, t.Volumes); len(containerReasons) > 0 {
+		valid = false
+		reasons = append(reasons, containerReasons...)
+	}
+	if concurrencyReasons := ValidateConcurrency(t.Concurrency); len(concurrencyReasons) > 0 {
+		valid = false
+		reasons = append(reasons, concurrencyReasons...)
+	}
 	return valid, reasons
 }
 
@@ -1115,6 +1396,7 @@ type KubernetesEvent struct {
 	ReportingComponent string         `json:"reportingComponent,omitempty"`
 	ReportingInstance  string         `json:"reportingInstance,omitempty"`
 	InvolvedObject     InvolvedObject `json:"involvedObject,omitempty"`
+	Cluster            string         `json:"cluster,omitempty"`
 	Done               func() error
 }
 
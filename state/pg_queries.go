@@ -15,6 +15,14 @@ select td.definition_id                    as definitionid,
        env::TEXT                           as env,
        td.cpu                              as cpu,
        td.gpu                              as gpu,
+       td.gpu_type                         as gputype,
+       td.placement::TEXT                  as placement,
+       td.volumes::TEXT                    as volumes,
+       td.init_containers::TEXT            as initcontainers,
+       td.sidecars::TEXT                   as sidecars,
+       td.use_image_entrypoint             as useimageentrypoint,
+       td.concurrency::TEXT                as concurrency,
+       td.service_account                  as serviceaccount,
        array_to_json('{""}'::TEXT[])::TEXT as tags,
        array_to_json('{}'::INT[])::TEXT    as ports
 from (select * from task_def) td
@@ -35,40 +43,55 @@ const GetDefinitionSQL = DefinitionSelect + "\nwhere definition_id = $1"
 //
 const GetDefinitionByAliasSQL = DefinitionSelect + "\nwhere alias = $1"
 
+// TaskResourcesSelectCommandSQL estimates run resources from the history of the
+// same command; $3..$10 are the ARAPolicy parameters
 const TaskResourcesSelectCommandSQL = `
-SELECT cast((percentile_disc(0.99) within GROUP (ORDER BY A.max_memory_used)) * 1.75 as int) as memory,
-       cast((percentile_disc(0.99) within GROUP (ORDER BY A.max_cpu_used)) * 1.25  as int)  as cpu
-FROM (SELECT CASE WHEN (exit_code = 137 or exit_reason = 'OOMKilled') THEN memory * 2 ELSE max_memory_used END as max_memory_used, cpu as max_cpu_used
+SELECT coalesce(cast((percentile_disc($3::float8) within GROUP (ORDER BY A.max_memory_used)) * $4::float8 as int), 0) as memory,
+       coalesce(cast((percentile_disc($5::float8) within GROUP (ORDER BY A.max_cpu_used)) * $6::float8 as int), 0) as cpu,
+       count(*) as samples
+FROM (SELECT CASE WHEN (exit_code = 137 or exit_reason = 'OOMKilled') THEN memory * $7::float8 ELSE max_memory_used END as max_memory_used, cpu as max_cpu_used
       FROM TASK
       WHERE
-           queued_at >= CURRENT_TIMESTAMP - INTERVAL '30 days'
-           AND (exit_code = 137 or exit_reason = 'OOMKilled')
+           queued_at >= CURRENT_TIMESTAMP - make_interval(days => $8::int)
+           AND (exit_code = 137 or exit_reason = 'OOMKilled' or ($9::boolean AND exit_code = 0 AND max_memory_used IS NOT NULL))
            AND engine = 'eks'
            AND definition_id = $1
            AND command_hash = (SELECT command_hash FROM task WHERE run_id = $2)
-      LIMIT 30) A
+      LIMIT $10::int) A
 `
 
-const TaskResourcesExecutorCountSQL = `
-SELECT least(coalesce(cast((percentile_disc(0.99) within GROUP (ORDER BY A.executor_count)) as int), 25), 100) as executor_count
-FROM (SELECT CASE
-                 WHEN (exit_reason like '%Exception%')
-                     THEN (spark_extension -> 'spark_submit_job_driver' -> 'num_executors')::int * 1.75
-                 ELSE (spark_extension -> 'spark_submit_job_driver' -> 'num_executors')::int * 1
-                 END as executor_count
+//
+// TaskResourcesSparkSizingStatsSQL takes the 90th percentile of the executor
+// and stage summaries of recent runs of an executable and command
+//
+const TaskResourcesSparkSizingStatsSQL = `
+SELECT count(*) AS runs,
+       cast((percentile_disc(0.9) within GROUP (ORDER BY A.peak_executors)) as int) AS peak_executors,
+       cast((percentile_disc(0.9) within GROUP (ORDER BY A.churn)) as int) AS churn,
+       cast((percentile_disc(0.9) within GROUP (ORDER BY A.max_stage_tasks)) as int) AS max_stage_tasks,
+       cast((percentile_disc(0.9) within GROUP (ORDER BY A.longest_stage)) as int) AS longest_stage,
+       cast((percentile_disc(0.9) within GROUP (ORDER BY A.shuffle_mb)) as bigint) AS shuffle_mb
+FROM (SELECT (spark_extension -> 'executor_summary' ->> 'peak_executors')::int AS peak_executors,
+             (spark_extension -> 'executor_summary' ->> 'churn')::int AS churn,
+             (spark_extension -> 'stage_summary' ->> 'max_tasks')::int AS max_stage_tasks,
+             (spark_extension -> 'stage_summary' ->> 'longest_stage')::int AS longest_stage,
+             (spark_extension -> 'stage_summary' ->> 'shuffle_read_mb')::bigint +
+             (spark_extension -> 'stage_summary' ->> 'shuffle_write_mb')::bigint AS shuffle_mb
       FROM TASK
       WHERE
            queued_at >= CURRENT_TIMESTAMP - INTERVAL '30 days'
-           AND engine = 'eks-spark'
+           AND engine IN ('eks-spark', 'eks-spark-native')
            AND definition_id = $1
            AND command_hash = $2
+           AND spark_extension ? 'executor_summary'
+      ORDER BY queued_at DESC
       LIMIT 30) A
 `
 const TaskResourcesDriverOOMSQL = `
 SELECT (spark_extension -> 'driver_oom')::boolean AS driver_oom
 FROM TASK
 WHERE queued_at >= CURRENT_TIMESTAMP - INTERVAL '30 days'
-  AND engine = 'eks-spark'
+  AND engine IN ('eks-spark', 'eks-spark-native')
   AND definition_id = $1
   AND command_hash = $2
   AND exit_code = 137
@@ -80,7 +103,7 @@ const TaskResourcesExecutorOOMSQL = `
 SELECT (spark_extension -> 'executor_oom')::boolean AS executor_oom
 FROM TASK
 WHERE queued_at >= CURRENT_TIMESTAMP - INTERVAL '30 days'
-  AND engine = 'eks-spark'
+  AND engine IN ('eks-spark', 'eks-spark-native')
   AND definition_id = $1
   AND command_hash = $2
   AND exit_code = 137
@@ -88,6 +111,24 @@ WHERE queued_at >= CURRENT_TIMESTAMP - INTERVAL '30 days'
 GROUP BY 1
 `
 
+//
+// TaskResourcesSparkExecutorStatsSQL aggregates the executor summaries of
+// recent runs of an executable and command
+//
+const TaskResourcesSparkExecutorStatsSQL = `
+SELECT count(*) AS runs,
+       coalesce(sum((spark_extension -> 'executor_summary' ->> 'executor_ooms')::int), 0) AS executor_ooms,
+       coalesce(sum((spark_extension -> 'executor_summary' ->> 'driver_ooms')::int), 0) AS driver_ooms,
+       max((spark_extension -> 'executor_summary' ->> 'executor_peak_memory')::bigint) AS executor_peak_memory,
+       max((spark_extension -> 'executor_summary' ->> 'driver_peak_memory')::bigint) AS driver_peak_memory
+FROM TASK
+WHERE queued_at >= CURRENT_TIMESTAMP - INTERVAL '30 days'
+  AND engine IN ('eks-spark', 'eks-spark-native')
+  AND definition_id = $1
+  AND command_hash = $2
+  AND spark_extension ? 'executor_summary'
+`
+
 const TaskResourcesExecutorNodeLifecycleSQL = `
 SELECT CASE WHEN A.c >= 1 THEN 'ondemand' ELSE 'spot' END
 FROM (SELECT count(*) as c
@@ -186,7 +227,14 @@ select t.run_id                          as runid,
        active_deadline_seconds           as activedeadlineseconds,
        spark_extension::TEXT             as sparkextension,
        metrics_uri                       as metricsuri,
-       description                       as description
+       description                       as description,
+       ara_estimate::TEXT                as araestimate,
+       placement::TEXT                   as placement,
+       volumes::TEXT                     as volumes,
+       spot_interruptions                as spotinterruptions,
+       t.rerun_of                        as rerunof,
+       t.exit_category                   as exitcategory,
+       t.service_account                 as serviceaccount
 from task t
 `
 
@@ -263,6 +311,210 @@ const GetWorkerSQL = WorkerSelect + "\nwhere worker_type = $1 and engine = $2"
 //
 const GetWorkerSQLForUpdate = GetWorkerSQL + " for update"
 
+//
+// GetDispatchStateSQL reads the single row dispatch switch
+//
+const GetDispatchStateSQL = `
+  select
+    paused,
+    reason,
+    updated_by as updatedby,
+    updated_at as updatedat
+  from dispatch_state
+  where id = 1
+`
+
+//
+// UpdateDispatchStateSQL sets the dispatch switch
+//
+const UpdateDispatchStateSQL = `
+  INSERT INTO dispatch_state (id, paused, reason, updated_by, updated_at)
+  VALUES (1, $1, $2, $3, $4)
+  ON CONFLICT (id) DO UPDATE SET
+    paused = $1, reason = $2, updated_by = $3, updated_at = $4
+`
+
+//
+// ListExitRulesSQL lists the stored exit rules
+//
+const ListExitRulesSQL = `
+  select rule_id, pattern, exit_code, category, reason, priority, group_name, created_at
+  from exit_rule
+  order by priority desc, created_at asc
+`
+
+//
+// CreateExitRuleSQL stores an exit rule
+//
+const CreateExitRuleSQL = `
+  INSERT INTO exit_rule (rule_id, pattern, exit_code, category, reason, priority, group_name, created_at)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+//
+// DeleteExitRuleSQL deletes a stored exit rule
+//
+const DeleteExitRuleSQL = `
+  DELETE FROM exit_rule WHERE rule_id = $1
+`
+
+//
+// ListExceptionRunsSQL lists the failed runs with run exceptions that most
+// recently finished, optionally of one group
+//
+const ListExceptionRunsSQL = `
+  select run_id as runid,
+         group_name as groupname,
+         exit_code as exitcode,
+         exit_reason as exitreason,
+         exit_category as exitcategory,
+         run_exceptions as runexceptions
+  from task
+  where status = 'STOPPED'
+    and run_exceptions is not null
+    and ($2::varchar is null or group_name = $2)
+  order by finished_at desc nulls last
+  limit $1
+`
+
+//
+// SaveLoggedExceptionSQL stores an exception found in the logs of a run
+//
+const SaveLoggedExceptionSQL = `
+  INSERT INTO run_exception (run_id, fingerprint, kind, exception_type, message, trace, created_at)
+  VALUES ($1, $2, $3, $4, $5, $6, $7)
+  ON CONFLICT (run_id, fingerprint) DO NOTHING
+`
+
+//
+// ListLoggedExceptionsSQL lists the exceptions found in the logs of a run
+//
+const ListLoggedExceptionsSQL = `
+  select run_id, fingerprint, kind, exception_type, message, trace, created_at
+  from run_exception
+  where run_id = $1
+  order by created_at asc, fingerprint asc
+`
+
+//
+// ExceptionReportSQL groups the exceptions of the runs of a window by
+// fingerprint; first_seen is across all runs
+//
+const ExceptionReportSQL = `
+  SELECT e.fingerprint,
+         min(e.kind) AS kind,
+         min(e.exception_type) AS exception_type,
+         (array_agg(e.message ORDER BY e.created_at DESC))[1] AS message,
+         min(e.created_at) AS first_seen,
+         max(e.created_at) FILTER (WHERE e.created_at < $2) AS last_seen,
+         count(*) FILTER (WHERE e.created_at >= $1 AND e.created_at < $2) AS runs,
+         count(DISTINCT t.group_name) FILTER (WHERE e.created_at >= $1 AND e.created_at < $2) AS groups,
+         (array_agg(e.run_id ORDER BY e.created_at DESC) FILTER (WHERE e.created_at >= $1 AND e.created_at < $2))[1:5] AS recent_runs
+  FROM run_exception e
+  JOIN task t ON t.run_id = e.run_id
+  WHERE 1 = 1
+  %s
+  GROUP BY e.fingerprint
+  HAVING count(*) FILTER (WHERE e.created_at >= $1 AND e.created_at < $2) > 0
+  ORDER BY runs DESC, last_seen DESC
+  LIMIT %d
+`
+
+//
+// ListRunAccessRecordsSQL lists the cloudtrail records of the runs of a
+// definition that finished in a window
+//
+const ListRunAccessRecordsSQL = `
+  select run_id, finished_at, cloudtrail_notifications::TEXT as cloudtrailnotifications
+  from task
+  where definition_id = $1
+    and finished_at >= $2 and finished_at < $3
+    and cloudtrail_notifications is not null
+  order by finished_at desc
+  limit $4
+`
+
+//
+// ClaimIdempotencyKeySQL records the run created with a key unless the key
+// was used for the executable after $4
+//
+const ClaimIdempotencyKeySQL = `
+  INSERT INTO run_idempotency_key (executable_id, idempotency_key, run_id, created_at)
+  VALUES ($1, $2, $3, now())
+  ON CONFLICT (executable_id, idempotency_key) DO UPDATE SET
+    run_id = $3, created_at = now()
+  WHERE run_idempotency_key.created_at < $4
+  RETURNING run_id
+`
+
+//
+// GetIdempotencyKeySQL gets the run created with a key
+//
+const GetIdempotencyKeySQL = `
+  select run_id from run_idempotency_key
+  where executable_id = $1 and idempotency_key = $2
+`
+
+//
+// ReleaseIdempotencyKeySQL forgets a key claimed by a run that wasn't created
+//
+const ReleaseIdempotencyKeySQL = `
+  DELETE FROM run_idempotency_key
+  where executable_id = $1 and idempotency_key = $2 and run_id = $3
+`
+
+//
+// LockExecutableSQL serializes concurrency checks of an executable's runs
+// until the transaction ends
+//
+const LockExecutableSQL = `SELECT pg_advisory_xact_lock(hashtext($1))`
+
+//
+// ActiveRunsSQL lists the other PENDING and RUNNING runs of an executable,
+// and the QUEUED runs already submitted to a Spark engine, oldest first
+//
+const ActiveRunsSQL = `
+  select run_id from task
+  where executable_id = $1 and run_id != $2 and (
+    status in ('PENDING', 'RUNNING') or
+    (status = 'QUEUED' and (spark_extension->>'emr_job_id' is not null or spark_extension->>'spark_application' is not null))
+  )
+  order by queued_at asc
+`
+
+//
+// ClaimConcurrencySlotSQL moves a queued run to PENDING ahead of its
+// submission
+//
+const ClaimConcurrencySlotSQL = `
+  UPDATE task SET status = 'PENDING' WHERE run_id = $1 AND status = 'QUEUED'
+`
+
+//
+// HoldRunSQL holds a run only while it is still QUEUED
+//
+const HoldRunSQL = `
+  UPDATE task SET status = 'HELD' WHERE run_id = $1 AND status = 'QUEUED'
+`
+
+//
+// CancelPendingRunSQL stops a PENDING run only while it hasn't been
+// submitted to its engine
+//
+const CancelPendingRunSQL = `
+  UPDATE task SET status = 'STOPPED', exit_reason = $2, exit_code = 1, finished_at = now()
+  WHERE run_id = $1 AND status = 'PENDING' AND
+    spark_extension->>'emr_job_id' is null AND spark_extension->>'spark_application' is null
+`
+
+//
+// ReleaseConcurrencySlotSQL returns a run that failed to be submitted to
+// QUEUED
+//
+const ReleaseConcurrencySlotSQL = `
+  UPDATE task SET status = 'QUEUED' WHERE run_id = $1 AND status = 'PENDING'
+`
+
 // TemplateSelect selects a template
 const TemplateSelect = `
 SELECT
@@ -278,8 +530,16 @@ SELECT
   privileged,
   cpu,
   gpu,
+  gpu_type as gputype,
   defaults,
-  coalesce(avatar_uri, '') as avataruri
+  coalesce(avatar_uri, '') as avataruri,
+  placement::TEXT as placement,
+  volumes::TEXT as volumes,
+  init_containers::TEXT as initcontainers,
+  sidecars::TEXT as sidecars,
+  use_image_entrypoint as useimageentrypoint,
+  concurrency::TEXT as concurrency,
+  service_account as serviceaccount
 FROM template
 `
 
@@ -305,8 +565,16 @@ const ListTemplatesLatestOnlySQL = `
     privileged,
     cpu,
     gpu,
+    gpu_type as gputype,
     defaults,
-    coalesce(avatar_uri, '') as avataruri
+    coalesce(avatar_uri, '') as avataruri,
+    placement::TEXT as placement,
+    volumes::TEXT as volumes,
+    init_containers::TEXT as initcontainers,
+    sidecars::TEXT as sidecars,
+    use_image_entrypoint as useimageentrypoint,
+    concurrency::TEXT as concurrency,
+    service_account as serviceaccount
   FROM template
   ORDER BY template_name, version DESC, template_id
   LIMIT $1 OFFSET $2
@@ -315,3 +583,72 @@ const ListTemplatesLatestOnlySQL = `
 // GetTemplateLatestOnlySQL get the latest version of a specific template name.
 const GetTemplateLatestOnlySQL = TemplateSelect + "\nWHERE template_name = $1 ORDER BY version DESC LIMIT 1;"
 const GetTemplateByVersionSQL = TemplateSelect + "\nWHERE template_name = $1 AND version = $2 ORDER BY version DESC LIMIT 1;"
+
+// UsageStatsSQL aggregates queue wait, runtime and outcomes of runs queued
+// in a time window; the grouping column and extra filters are formatted in
+const UsageStatsSQL = `
+SELECT coalesce(%s, '')                                                          AS key,
+       count(*)                                                                  AS totalruns,
+       count(CASE WHEN exit_code = 0 THEN 1 END)                                 AS succeededruns,
+       count(CASE WHEN status = 'STOPPED' AND coalesce(exit_code, -1) != 0 THEN 1 END) AS failedruns,
+       coalesce(percentile_cont(0.50) within GROUP (ORDER BY EXTRACT(epoch from started_at - queued_at)), 0)  AS queuewaitp50seconds,
+       coalesce(percentile_cont(0.95) within GROUP (ORDER BY EXTRACT(epoch from started_at - queued_at)), 0)  AS queuewaitp95seconds,
+       coalesce(percentile_cont(0.99) within GROUP (ORDER BY EXTRACT(epoch from started_at - queued_at)), 0)  AS queuewaitp99seconds,
+       coalesce(percentile_cont(0.50) within GROUP (ORDER BY EXTRACT(epoch from finished_at - started_at)), 0) AS runtimep50seconds,
+       coalesce(percentile_cont(0.95) within GROUP (ORDER BY EXTRACT(epoch from finished_at - started_at)), 0) AS runtimep95seconds,
+       coalesce(percentile_cont(0.99) within GROUP (ORDER BY EXTRACT(epoch from finished_at - started_at)), 0) AS runtimep99seconds,
+       count(CASE WHEN exit_code = 137 OR exit_reason like '%%OOM%%' THEN 1 END) AS oomcount,
+       coalesce(sum(spot_interruptions), 0)                                      AS spotinterruptioncount,
+       count(CASE WHEN node_lifecycle = 'spot' OR spot_interruptions > 0 THEN 1 END) AS spotruns,
+       count(CASE WHEN spot_interruptions > 0 THEN 1 END)                        AS interruptedruns
+FROM task
+WHERE queued_at >= $1
+  AND queued_at < $2
+  %s
+GROUP BY 1
+ORDER BY 2 DESC
+`
+
+// UsageTopExitReasonsSQL ranks the exit reasons of failed runs within each
+// slice of a usage report
+const UsageTopExitReasonsSQL = `
+SELECT key, exitreason, count
+FROM (SELECT coalesce(%s, '')                                                  AS key,
+             exit_reason                                                       AS exitreason,
+             count(*)                                                          AS count,
+             row_number() OVER (PARTITION BY coalesce(%s, '') ORDER BY count(*) DESC) AS rank
+      FROM task
+      WHERE queued_at >= $1
+        AND queued_at < $2
+        AND status = 'STOPPED'
+        AND coalesce(exit_code, -1) != 0
+        AND exit_reason IS NOT NULL
+        %s
+      GROUP BY 1, 2) A
+WHERE rank <= %d
+ORDER BY key, count DESC
+`
+
+// ResourceUsageStatsSQL summarizes peak memory and cpu of the recent successful
+// runs of a definition, per command hash and across all of them.
+const ResourceUsageStatsSQL = `
+SELECT CASE WHEN grouping(command_hash) = 1 THEN '' ELSE coalesce(command_hash, '') END          AS commandhash,
+       grouping(command_hash) = 1                                                             AS overall,
+       count(*)                                                                               AS samplesize,
+       coalesce(percentile_disc(0.50) within GROUP (ORDER BY max_memory_used), 0)             AS memoryp50,
+       coalesce(percentile_disc(0.95) within GROUP (ORDER BY max_memory_used), 0)             AS memoryp95,
+       coalesce(percentile_disc(0.50) within GROUP (ORDER BY max_cpu_used), 0)                AS cpup50,
+       coalesce(percentile_disc(0.95) within GROUP (ORDER BY max_cpu_used), 0)                AS cpup95,
+       coalesce(cast(avg(memory) as int), 0)                                                  AS requestedmemory,
+       coalesce(cast(avg(cpu) as int), 0)                                                     AS requestedcpu,
+       coalesce(sum(EXTRACT(epoch from finished_at - started_at)) / 3600, 0)                  AS runtimehours
+FROM task
+WHERE definition_id = $1
+  AND exit_code = 0
+  AND engine = 'eks'
+  AND queued_at >= CURRENT_TIMESTAMP - make_interval(days => $2)
+  AND max_memory_used IS NOT NULL
+  AND max_cpu_used IS NOT NULL
+GROUP BY GROUPING SETS ((command_hash), ())
+ORDER BY 2 DESC, 3 DESC
+`
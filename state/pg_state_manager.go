@@ -94,11 +94,13 @@ func (sm *SQLStateManager) GetTaskHistoricalRuntime(executableID string, runID s
 	return minutes, err
 }
 
-func (sm *SQLStateManager) EstimateRunResources(executableID string, runID string) (TaskResources, error) {
+func (sm *SQLStateManager) EstimateRunResources(executableID string, runID string, policy ARAPolicy) (TaskResources, error) {
 	var err error
 	var taskResources TaskResources
 
-	err = sm.readonlyDB.Get(&taskResources, TaskResourcesSelectCommandSQL, executableID, runID)
+	err = sm.readonlyDB.Get(&taskResources, TaskResourcesSelectCommandSQL, executableID, runID,
+		policy.MemoryPercentile, policy.MemoryMultiplier, policy.CpuPercentile, policy.CpuMultiplier,
+		policy.OOMMemoryMultiplier, policy.LookbackDays, policy.IncludeSuccessfulRuns, policy.SampleLimit)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -108,23 +110,23 @@ func (sm *SQLStateManager) EstimateRunResources(executableID string, runID strin
 			return taskResources, errors.Wrapf(err, "issue getting resources with executable [%s]", executableID)
 		}
 	}
+	if taskResources.Samples == 0 {
+		return taskResources, exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Resource usage with executable %s not found", executableID)}
+	}
 	return taskResources, err
 }
 
-func (sm *SQLStateManager) EstimateExecutorCount(executableID string, commandHash string) (int64, error) {
-	var err error
-	executorCount := int64(25)
-	err = sm.readonlyDB.Get(&executorCount, TaskResourcesExecutorCountSQL, executableID, commandHash)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return executorCount, exceptions.MissingResource{
-				ErrorString: fmt.Sprintf("Resource usage with executable %s not found", executableID)}
-		} else {
-			return executorCount, errors.Wrapf(err, "issue getting resources with executable [%s]", executableID)
-		}
+//
+// SparkSizingStats returns what the executor and stage summaries of recent
+// runs of the executable and command observed
+//
+func (sm *SQLStateManager) SparkSizingStats(executableID string, commandHash string) (SparkSizingStats, error) {
+	var stats SparkSizingStats
+	if err := sm.readonlyDB.Get(&stats, TaskResourcesSparkSizingStatsSQL, executableID, commandHash); err != nil {
+		return stats, errors.Wrapf(err, "issue getting sizing stats with executable [%s]", executableID)
 	}
-	return executorCount, err
+	return stats, nil
 }
 
 func (sm *SQLStateManager) ExecutorOOM(executableID string, commandHash string) (bool, error) {
@@ -159,6 +161,95 @@ func (sm *SQLStateManager) DriverOOM(executableID string, commandHash string) (b
 	return driverOOM, err
 }
 
+//
+// SparkExecutorStats returns what the executor records of recent runs of the
+// executable and command observed
+//
+func (sm *SQLStateManager) SparkExecutorStats(executableID string, commandHash string) (SparkExecutorStats, error) {
+	var stats SparkExecutorStats
+	if err := sm.readonlyDB.Get(&stats, TaskResourcesSparkExecutorStatsSQL, executableID, commandHash); err != nil {
+		return stats, errors.Wrapf(err, "issue getting executor stats with executable [%s]", executableID)
+	}
+	return stats, nil
+}
+
+// GetUsageReport aggregates run outcomes, queue wait and runtime percentiles
+// for runs queued within the requested window, sliced by req.GroupBy
+func (sm *SQLStateManager) GetUsageReport(req UsageReportRequest) (UsageReport, error) {
+	report := UsageReport{GroupBy: req.GroupBy, Since: req.Since, Until: req.Until}
+	if !UsageReportGroupings[req.GroupBy] {
+		return report, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("Invalid usage report grouping [%s]", req.GroupBy)}
+	}
+
+	args := []interface{}{req.Since, req.Until}
+	var filters []string
+	for col, val := range map[string]*string{
+		"group_name":   req.GroupName,
+		"alias":        req.Alias,
+		"engine":       req.Engine,
+		"cluster_name": req.ClusterName,
+	} {
+		if val != nil {
+			args = append(args, *val)
+			filters = append(filters, fmt.Sprintf("AND %s = $%d", col, len(args)))
+		}
+	}
+	filterClause := strings.Join(filters, "\n  ")
+
+	var rows []UsageStats
+	err := sm.readonlyDB.Select(&rows, fmt.Sprintf(UsageStatsSQL, req.GroupBy, filterClause), args...)
+	if err != nil {
+		return report, errors.Wrap(err, "issue running usage stats sql")
+	}
+
+	var reasons []struct {
+		Key        string
+		ExitReason string
+		Count      int64
+	}
+	err = sm.readonlyDB.Select(&reasons,
+		fmt.Sprintf(UsageTopExitReasonsSQL, req.GroupBy, req.GroupBy, filterClause, req.TopExitReasons), args...)
+	if err != nil {
+		return report, errors.Wrap(err, "issue running usage exit reasons sql")
+	}
+
+	reasonsByKey := make(map[string][]ExitReasonCount)
+	for _, r := range reasons {
+		reasonsByKey[r.Key] = append(reasonsByKey[r.Key], ExitReasonCount{ExitReason: r.ExitReason, Count: r.Count})
+	}
+
+	report.Stats = make([]UsageStats, len(rows))
+	for i, stats := range rows {
+		finished := stats.SucceededRuns + stats.FailedRuns
+		if finished > 0 {
+			stats.SuccessRate = float64(stats.SucceededRuns) / float64(finished)
+			stats.FailureRate = float64(stats.FailedRuns) / float64(finished)
+		}
+		if stats.SpotRuns > 0 {
+			stats.SpotInterruptionRate = float64(stats.InterruptedRuns) / float64(stats.SpotRuns)
+		}
+		stats.TopExitReasons = reasonsByKey[stats.Key]
+		if stats.TopExitReasons == nil {
+			stats.TopExitReasons = []ExitReasonCount{}
+		}
+		report.Stats[i] = stats
+	}
+	report.Total = len(report.Stats)
+	return report, nil
+}
+
+// GetResourceUsageStats returns peak usage of the successful runs of a
+// definition over the lookback window, per command hash and overall
+func (sm *SQLStateManager) GetResourceUsageStats(definitionID string, lookbackDays int) ([]ResourceUsageStats, error) {
+	var stats []ResourceUsageStats
+	err := sm.readonlyDB.Select(&stats, ResourceUsageStatsSQL, definitionID, lookbackDays)
+	if err != nil {
+		return stats, errors.Wrapf(err, "issue getting resource usage for definition [%s]", definitionID)
+	}
+	return stats, nil
+}
+
 //
 // Name is the name of the state manager - matches value in configuration
 //
@@ -420,7 +511,15 @@ func (sm *SQLStateManager) UpdateDefinition(definitionID string, updates Definit
       env = $6,
       cpu = $7,
       gpu = $8,
-      adaptive_resource_allocation = $9
+      adaptive_resource_allocation = $9,
+      gpu_type = $10,
+      placement = $11,
+      volumes = $12,
+      init_containers = $13,
+      sidecars = $14,
+      use_image_entrypoint = $15,
+      concurrency = $16,
+      service_account = $17
     WHERE definition_id = $1;
     `
 	if _, err = tx.Exec(
@@ -433,7 +532,15 @@ func (sm *SQLStateManager) UpdateDefinition(definitionID string, updates Definit
 		existing.Env,
 		existing.Cpu,
 		existing.Gpu,
-		existing.AdaptiveResourceAllocation); err != nil {
+		existing.AdaptiveResourceAllocation,
+		existing.GpuType,
+		existing.Placement,
+		existing.Volumes,
+		existing.InitContainers,
+		existing.Sidecars,
+		existing.UseImageEntrypoint,
+		existing.Concurrency,
+		existing.ServiceAccount); err != nil {
 		return existing, errors.Wrapf(err, "issue updating definition [%s]", definitionID)
 	}
 
@@ -504,9 +611,17 @@ func (sm *SQLStateManager) CreateDefinition(d Definition) error {
       env,
       cpu,
       gpu,
-      adaptive_resource_allocation
+      adaptive_resource_allocation,
+      gpu_type,
+      placement,
+      volumes,
+      init_containers,
+      sidecars,
+      use_image_entrypoint,
+      concurrency,
+      service_account
     )
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18);
     `
 
 	if _, err = tx.Exec(insert,
@@ -519,7 +634,15 @@ func (sm *SQLStateManager) CreateDefinition(d Definition) error {
 		d.Env,
 		d.Cpu,
 		d.Gpu,
-		d.AdaptiveResourceAllocation); err != nil {
+		d.AdaptiveResourceAllocation,
+		d.GpuType,
+		d.Placement,
+		d.Volumes,
+		d.InitContainers,
+		d.Sidecars,
+		d.UseImageEntrypoint,
+		d.Concurrency,
+		d.ServiceAccount); err != nil {
 		tx.Rollback()
 		return errors.Wrapf(
 			err, "issue creating new task definition with alias [%s] and id [%s]", d.DefinitionID, d.Alias)
@@ -667,6 +790,119 @@ func (sm *SQLStateManager) GetRunByEMRJobId(emrJobId string) (Run, error) {
 	return r, nil
 }
 
+//
+// ClaimIdempotencyKey returns the run holding an idempotency key for the
+// executable; the key is claimed for runID when it is new or was last used
+// longer than the retention ago.
+//
+func (sm *SQLStateManager) ClaimIdempotencyKey(executableID string, key string, runID string, retention time.Duration) (string, error) {
+	var holder string
+	err := sm.db.Get(&holder, ClaimIdempotencyKeySQL, executableID, key, runID, time.Now().Add(-retention))
+	if err == sql.ErrNoRows {
+		err = sm.db.Get(&holder, GetIdempotencyKeySQL, executableID, key)
+	}
+	if err != nil {
+		return holder, errors.Wrapf(err, "issue claiming idempotency key [%s]", key)
+	}
+	return holder, nil
+}
+
+//
+// ReleaseIdempotencyKey frees a key claimed by a run that failed to be
+// created.
+//
+func (sm *SQLStateManager) ReleaseIdempotencyKey(executableID string, key string, runID string) error {
+	if _, err := sm.db.Exec(ReleaseIdempotencyKeySQL, executableID, key, runID); err != nil {
+		return errors.Wrapf(err, "issue releasing idempotency key [%s]", key)
+	}
+	return nil
+}
+
+//
+// ClaimConcurrencySlot moves the run to PENDING when fewer than
+// maxConcurrent other runs of its executable are active, including
+// submitted Spark runs still QUEUED on their engine; the check and claim
+// hold a lock on the executable so concurrent submit workers can't both
+// take the last slot. The active runs are returned either way.
+//
+func (sm *SQLStateManager) ClaimConcurrencySlot(run Run, maxConcurrent int64) ([]string, bool, error) {
+	var active []string
+	if run.ExecutableID == nil {
+		return active, false, errors.Errorf("run [%s] has no executable", run.RunID)
+	}
+	tx, err := sm.db.Beginx()
+	if err != nil {
+		return active, false, errors.WithStack(err)
+	}
+	if _, err = tx.Exec(LockExecutableSQL, *run.ExecutableID); err != nil {
+		tx.Rollback()
+		return active, false, errors.Wrapf(err, "issue locking executable [%s]", *run.ExecutableID)
+	}
+	if err = tx.Select(&active, ActiveRunsSQL, *run.ExecutableID, run.RunID); err != nil {
+		tx.Rollback()
+		return active, false, errors.Wrapf(err, "issue listing active runs of executable [%s]", *run.ExecutableID)
+	}
+	if int64(len(active)) >= maxConcurrent {
+		tx.Rollback()
+		return active, false, nil
+	}
+	if _, err = tx.Exec(ClaimConcurrencySlotSQL, run.RunID); err != nil {
+		tx.Rollback()
+		return active, false, errors.Wrapf(err, "issue claiming concurrency slot for run [%s]", run.RunID)
+	}
+	if err = tx.Commit(); err != nil {
+		return active, false, errors.WithStack(err)
+	}
+	return active, true, nil
+}
+
+//
+// ReleaseConcurrencySlot returns a claimed run to QUEUED after its
+// submission failed, to be submitted again.
+//
+func (sm *SQLStateManager) ReleaseConcurrencySlot(runID string) error {
+	if _, err := sm.db.Exec(ReleaseConcurrencySlotSQL, runID); err != nil {
+		return errors.Wrapf(err, "issue releasing concurrency slot for run [%s]", runID)
+	}
+	return nil
+}
+
+//
+// CancelPendingRun stops a PENDING run that hasn't been submitted yet,
+// returning false when the run has moved on in the meantime.
+//
+func (sm *SQLStateManager) CancelPendingRun(runID string, exitReason string) (bool, error) {
+	result, err := sm.db.Exec(CancelPendingRunSQL, runID, exitReason)
+	if err != nil {
+		return false, errors.Wrapf(err, "issue cancelling run [%s]", runID)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return n > 0, nil
+}
+
+//
+// HoldRun holds a QUEUED run; a run that has left the queue, e.g. because a
+// submit worker picked it up first, results in a conflict.
+//
+func (sm *SQLStateManager) HoldRun(runID string) (Run, error) {
+	result, err := sm.db.Exec(HoldRunSQL, runID)
+	if err != nil {
+		return Run{}, errors.Wrapf(err, "issue holding run [%s]", runID)
+	}
+	run, err := sm.GetRun(runID)
+	if err != nil {
+		return run, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return run, exceptions.ConflictingResource{
+			ErrorString: fmt.Sprintf("run [%s] is %s, only %s runs can be held", runID, run.Status, StatusQueued)}
+	}
+	return run, nil
+}
+
 func (sm *SQLStateManager) GetResources(runID string) (Run, error) {
 	var err error
 	var r Run
@@ -747,6 +983,13 @@ func (sm *SQLStateManager) UpdateRun(runID string, updates Run) (Run, error) {
 			&existing.SparkExtension,
 			&existing.MetricsUri,
 			&existing.Description,
+			&existing.AraEstimate,
+			&existing.Placement,
+			&existing.Volumes,
+			&existing.SpotInterruptions,
+			&existing.RerunOf,
+			&existing.ExitCategory,
+			&existing.ServiceAccount,
 		)
 	}
 	if err != nil {
@@ -795,7 +1038,14 @@ func (sm *SQLStateManager) UpdateRun(runID string, updates Run) (Run, error) {
 		active_deadline_seconds = $37,
 		spark_extension = $38,
 		metrics_uri = $39,
-		description = $40
+		description = $40,
+		ara_estimate = $41,
+		placement = $42,
+		volumes = $43,
+		spot_interruptions = $44,
+		rerun_of = $45,
+		exit_category = $46,
+		service_account = $47
     WHERE run_id = $1;
     `
 
@@ -840,7 +1090,14 @@ func (sm *SQLStateManager) UpdateRun(runID string, updates Run) (Run, error) {
 		existing.ActiveDeadlineSeconds,
 		existing.SparkExtension,
 		existing.MetricsUri,
-		existing.Description); err != nil {
+		existing.Description,
+		existing.AraEstimate,
+		existing.Placement,
+		existing.Volumes,
+		existing.SpotInterruptions,
+		existing.RerunOf,
+		existing.ExitCategory,
+		existing.ServiceAccount); err != nil {
 		tx.Rollback()
 		return existing, errors.WithStack(err)
 	}
@@ -901,7 +1158,14 @@ func (sm *SQLStateManager) CreateRun(r Run) error {
 		command_hash,
 		spark_extension,
 		metrics_uri,
-		description
+		description,
+		ara_estimate,
+		placement,
+		volumes,
+		spot_interruptions,
+		rerun_of,
+		exit_category,
+		service_account
     ) VALUES (
         $1,
 		$2,
@@ -943,7 +1207,14 @@ func (sm *SQLStateManager) CreateRun(r Run) error {
 		$38,
 		$39,
 		$40,
-		$41
+		$41,
+		$42,
+		$43,
+		$44,
+		$45,
+		$46,
+		$47,
+		$48
 	);
     `
 
@@ -993,7 +1264,14 @@ func (sm *SQLStateManager) CreateRun(r Run) error {
 		r.CommandHash,
 		r.SparkExtension,
 		r.MetricsUri,
-		r.Description); err != nil {
+		r.Description,
+		r.AraEstimate,
+		r.Placement,
+		r.Volumes,
+		r.SpotInterruptions,
+		r.RerunOf,
+		r.ExitCategory,
+		r.ServiceAccount); err != nil {
 		tx.Rollback()
 		return errors.Wrapf(err, "issue creating new task run with id [%s]", r.RunID)
 	}
@@ -1147,8 +1425,151 @@ func (sm *SQLStateManager) GetWorker(workerType string, engine string) (w Worker
 }
 
 //
-// UpdateWorker updates a single worker.
+// GetDispatchState returns the dispatch switch; dispatch is not paused
+// until it has been set.
+//
+func (sm *SQLStateManager) GetDispatchState() (DispatchState, error) {
+	var ds DispatchState
+	if err := sm.db.Get(&ds, GetDispatchStateSQL); err != nil && err != sql.ErrNoRows {
+		return ds, errors.Wrap(err, "issue getting dispatch state")
+	}
+	return ds, nil
+}
+
+//
+// UpdateDispatchState sets the dispatch switch.
+//
+func (sm *SQLStateManager) UpdateDispatchState(update DispatchState) (DispatchState, error) {
+	now := time.Now()
+	update.UpdatedAt = &now
+	if _, err := sm.db.Exec(UpdateDispatchStateSQL, update.Paused, update.Reason, update.UpdatedBy, update.UpdatedAt); err != nil {
+		return update, errors.Wrap(err, "issue updating dispatch state")
+	}
+	return update, nil
+}
+
+//
+// ListExitRules returns the stored exit rules.
+//
+func (sm *SQLStateManager) ListExitRules() (ExitRuleList, error) {
+	result := ExitRuleList{Rules: []ExitRule{}}
+	if err := sm.readonlyDB.Select(&result.Rules, ListExitRulesSQL); err != nil {
+		return result, errors.Wrap(err, "issue listing exit rules")
+	}
+	result.Total = len(result.Rules)
+	return result, nil
+}
+
+//
+// CreateExitRule stores an exit rule.
+//
+func (sm *SQLStateManager) CreateExitRule(r ExitRule) error {
+	if _, err := sm.db.Exec(CreateExitRuleSQL,
+		r.RuleID, r.Pattern, r.ExitCode, r.Category, r.Reason, r.Priority, r.GroupName, r.CreatedAt); err != nil {
+		return errors.Wrapf(err, "issue creating exit rule [%s]", r.RuleID)
+	}
+	return nil
+}
+
+//
+// DeleteExitRule deletes a stored exit rule.
+//
+func (sm *SQLStateManager) DeleteExitRule(ruleID string) error {
+	result, err := sm.db.Exec(DeleteExitRuleSQL, ruleID)
+	if err != nil {
+		return errors.Wrapf(err, "issue deleting exit rule [%s]", ruleID)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return exceptions.MissingResource{
+			ErrorString: fmt.Sprintf("Exit rule %s not found", ruleID)}
+	}
+	return nil
+}
+
+//
+// ListExceptionRuns returns the failed runs with run exceptions that most
+// recently finished, of groupName when set; only the fields exit rules
+// classify are read.
+//
+func (sm *SQLStateManager) ListExceptionRuns(limit int, groupName *string) ([]Run, error) {
+	runs := []Run{}
+	if err := sm.readonlyDB.Select(&runs, ListExceptionRunsSQL, limit, groupName); err != nil {
+		return runs, errors.Wrap(err, "issue listing runs with exceptions")
+	}
+	return runs, nil
+}
+
+//
+// SaveLoggedExceptions stores the exceptions found in the logs of a run; ones
+// already stored for the run are kept.
+//
+func (sm *SQLStateManager) SaveLoggedExceptions(runID string, found []LoggedException) error {
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, e := range found {
+		if _, err = tx.Exec(SaveLoggedExceptionSQL,
+			runID, e.Fingerprint, e.Kind, e.ExceptionType, e.Message, e.Trace, e.CreatedAt); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "issue saving exceptions of run [%s]", runID)
+		}
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+//
+// ListLoggedExceptions returns the exceptions found in the logs of a run.
 //
+func (sm *SQLStateManager) ListLoggedExceptions(runID string) (LoggedExceptionList, error) {
+	result := LoggedExceptionList{Exceptions: []LoggedException{}}
+	if err := sm.readonlyDB.Select(&result.Exceptions, ListLoggedExceptionsSQL, runID); err != nil {
+		return result, errors.Wrapf(err, "issue listing exceptions of run [%s]", runID)
+	}
+	result.Total = len(result.Exceptions)
+	return result, nil
+}
+
+//
+// GetExceptionReport groups the exceptions logged by the runs of a window by
+// fingerprint, optionally of one group or definition.
+//
+func (sm *SQLStateManager) GetExceptionReport(req ExceptionReportRequest) (ExceptionReport, error) {
+	report := ExceptionReport{Since: req.Since, Until: req.Until, Exceptions: []ExceptionGroup{}}
+	args := []interface{}{req.Since, req.Until}
+	var filters []string
+	for col, val := range map[string]*string{
+		"t.group_name":    req.GroupName,
+		"t.definition_id": req.DefinitionID,
+	} {
+		if val != nil {
+			args = append(args, *val)
+			filters = append(filters, fmt.Sprintf("AND %s = $%d", col, len(args)))
+		}
+	}
+	err := sm.readonlyDB.Select(&report.Exceptions,
+		fmt.Sprintf(ExceptionReportSQL, strings.Join(filters, "\n  "), req.Limit), args...)
+	if err != nil {
+		return report, errors.Wrap(err, "issue running exception report sql")
+	}
+	report.Total = len(report.Exceptions)
+	return report, nil
+}
+
+//
+// ListRunAccessRecords lists the cloudtrail records of the runs of a
+// definition that finished in the requested window, latest first
+//
+func (sm *SQLStateManager) ListRunAccessRecords(req AccessReportRequest) ([]RunAccessRecords, error) {
+	var runs []RunAccessRecords
+	err := sm.readonlyDB.Select(&runs, ListRunAccessRecordsSQL, req.DefinitionID, req.Since, req.Until, req.Limit)
+	if err != nil {
+		return runs, errors.Wrap(err, "issue listing run access records")
+	}
+	return runs, nil
+}
+
+// UpdateWorker updates a single worker.
 func (sm *SQLStateManager) UpdateWorker(workerType string, updates Worker) (Worker, error) {
 	var (
 		err      error
@@ -1313,6 +1734,76 @@ func (e *SpawnedRuns) Scan(value interface{}) error {
 	return nil
 }
 
+// Value to db
+func (e Placement) Value() (driver.Value, error) {
+	res, _ := json.Marshal(e)
+	return res, nil
+}
+
+func (e *Placement) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &e)
+	}
+	return nil
+}
+
+// Value to db
+func (e VolumeList) Value() (driver.Value, error) {
+	res, _ := json.Marshal(e)
+	return res, nil
+}
+
+func (e *VolumeList) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &e)
+	}
+	return nil
+}
+
+// Value to db
+func (e ContainerList) Value() (driver.Value, error) {
+	res, _ := json.Marshal(e)
+	return res, nil
+}
+
+func (e *ContainerList) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &e)
+	}
+	return nil
+}
+
+// Value to db
+func (e ConcurrencyPolicy) Value() (driver.Value, error) {
+	res, _ := json.Marshal(e)
+	return res, nil
+}
+
+func (e *ConcurrencyPolicy) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &e)
+	}
+	return nil
+}
+
+// Value to db
+func (e ARAEstimate) Value() (driver.Value, error) {
+	res, _ := json.Marshal(e)
+	return res, nil
+}
+
+func (e *ARAEstimate) Scan(value interface{}) error {
+	if value != nil {
+		s := []byte(value.(string))
+		json.Unmarshal(s, &e)
+	}
+	return nil
+}
+
 // Value to db
 func (e SparkExtension) Value() (driver.Value, error) {
 	res, _ := json.Marshal(e)
@@ -1534,9 +2025,10 @@ func (sm *SQLStateManager) CreateTemplate(t Template) error {
 	insert := `
     INSERT INTO template(
 			template_id, template_name, version, schema, command_template,
-			adaptive_resource_allocation, image, memory, env, cpu, gpu, defaults, avatar_uri
+			adaptive_resource_allocation, image, memory, env, cpu, gpu, defaults, avatar_uri, placement, volumes,
+			init_containers, sidecars, use_image_entrypoint, concurrency, service_account, gpu_type
     )
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15);
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21);
     `
 
 	tx, err := sm.db.Begin()
@@ -1547,7 +2039,8 @@ func (sm *SQLStateManager) CreateTemplate(t Template) error {
 	if _, err = tx.Exec(insert,
 		t.TemplateID, t.TemplateName, t.Version, t.Schema, t.CommandTemplate,
 		t.AdaptiveResourceAllocation, t.Image, t.Memory, t.Env,
-		t.Cpu, t.Gpu, t.Defaults, t.AvatarURI); err != nil {
+		t.Cpu, t.Gpu, t.Defaults, t.AvatarURI, t.Placement, t.Volumes,
+		t.InitContainers, t.Sidecars, t.UseImageEntrypoint, t.Concurrency, t.ServiceAccount, t.GpuType); err != nil {
 		tx.Rollback()
 		return errors.Wrapf(
 			err, "issue creating new template with template_name [%s] and version [%d]", t.TemplateName, t.Version)
@@ -0,0 +1,131 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/utils"
+)
+
+// Toleration is a pod toleration in Kubernetes terms.
+type Toleration struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Effect   string `json:"effect,omitempty"`
+}
+
+// TopologySpread asks the scheduler to spread pods of the same executable
+// across a topology domain; it is a preference unless WhenUnsatisfiable is
+// DoNotSchedule.
+type TopologySpread struct {
+	TopologyKey       string `json:"topology_key"`
+	MaxSkew           int32  `json:"max_skew,omitempty"`
+	WhenUnsatisfiable string `json:"when_unsatisfiable,omitempty"`
+}
+
+// Placement holds user supplied Kubernetes scheduling constraints; they are
+// merged with the platform's required rules when pods are built
+type Placement struct {
+	NodeSelector   map[string]string `json:"node_selector,omitempty"`
+	Tolerations    []Toleration      `json:"tolerations,omitempty"`
+	TopologySpread []TopologySpread  `json:"topology_spread,omitempty"`
+}
+
+// Empty is true when the placement adds no constraints.
+func (p *Placement) Empty() bool {
+	return p == nil || (len(p.NodeSelector) == 0 && len(p.Tolerations) == 0 && len(p.TopologySpread) == 0)
+}
+
+// MergePlacement layers a run's placement over its executable's; node
+// selector values from the run win, tolerations and spreads accumulate.
+func MergePlacement(base *Placement, override *Placement) *Placement {
+	if override.Empty() {
+		return base
+	}
+	if base.Empty() {
+		return override
+	}
+	merged := Placement{NodeSelector: make(map[string]string)}
+	for k, v := range base.NodeSelector {
+		merged.NodeSelector[k] = v
+	}
+	for k, v := range override.NodeSelector {
+		merged.NodeSelector[k] = v
+	}
+	merged.Tolerations = append(append(merged.Tolerations, base.Tolerations...), override.Tolerations...)
+	merged.TopologySpread = append(append(merged.TopologySpread, base.TopologySpread...), override.TopologySpread...)
+	return &merged
+}
+
+// PlacementPolicy is the admin allowlist of keys users may schedule with
+type PlacementPolicy struct {
+	AllowedNodeSelectorKeys []string
+	AllowedTolerationKeys   []string
+	AllowedTopologyKeys     []string
+}
+
+var (
+	tolerationOperators = []string{"", "Equal", "Exists"}
+	taintEffects        = []string{"", "NoSchedule", "PreferNoSchedule", "NoExecute"}
+	unsatisfiableModes  = []string{"", "ScheduleAnyway", "DoNotSchedule"}
+)
+
+// NewPlacementPolicy reads the allowlists from config; node selector and
+// toleration keys default to none, topology keys to zone and hostname
+func NewPlacementPolicy(conf config.Config) PlacementPolicy {
+	policy := PlacementPolicy{
+		AllowedTopologyKeys: []string{"topology.kubernetes.io/zone", "kubernetes.io/hostname"},
+	}
+	if conf.IsSet("eks_placement_allowed_node_selector_keys") {
+		policy.AllowedNodeSelectorKeys = conf.GetStringSlice("eks_placement_allowed_node_selector_keys")
+	}
+	if conf.IsSet("eks_placement_allowed_toleration_keys") {
+		policy.AllowedTolerationKeys = conf.GetStringSlice("eks_placement_allowed_toleration_keys")
+	}
+	if conf.IsSet("eks_placement_allowed_topology_keys") {
+		policy.AllowedTopologyKeys = conf.GetStringSlice("eks_placement_allowed_topology_keys")
+	}
+	return policy
+}
+
+// Validate returns the reasons a placement is not permitted.
+func (pp PlacementPolicy) Validate(p *Placement) []string {
+	var reasons []string
+	if p == nil {
+		return reasons
+	}
+	for k, v := range p.NodeSelector {
+		if !utils.StringSliceContains(pp.AllowedNodeSelectorKeys, k) {
+			reasons = append(reasons, fmt.Sprintf("node_selector key [%s] is not allowed; allowed keys: %v", k, pp.AllowedNodeSelectorKeys))
+		} else if len(v) == 0 {
+			reasons = append(reasons, fmt.Sprintf("node_selector key [%s] must have a value", k))
+		}
+	}
+	for _, t := range p.Tolerations {
+		if !utils.StringSliceContains(pp.AllowedTolerationKeys, t.Key) {
+			reasons = append(reasons, fmt.Sprintf("toleration key [%s] is not allowed; allowed keys: %v", t.Key, pp.AllowedTolerationKeys))
+		}
+		if !utils.StringSliceContains(tolerationOperators, t.Operator) {
+			reasons = append(reasons, fmt.Sprintf("toleration operator [%s] must be one of [Equal, Exists]", t.Operator))
+		}
+		if t.Operator == "Exists" && len(t.Value) > 0 {
+			reasons = append(reasons, fmt.Sprintf("toleration key [%s] with operator [Exists] may not have a value", t.Key))
+		}
+		if !utils.StringSliceContains(taintEffects, t.Effect) {
+			reasons = append(reasons, fmt.Sprintf("toleration effect [%s] must be one of [NoSchedule, PreferNoSchedule, NoExecute]", t.Effect))
+		}
+	}
+	for _, s := range p.TopologySpread {
+		if !utils.StringSliceContains(pp.AllowedTopologyKeys, s.TopologyKey) {
+			reasons = append(reasons, fmt.Sprintf("topology_key [%s] is not allowed; allowed keys: %v", s.TopologyKey, pp.AllowedTopologyKeys))
+		}
+		if s.MaxSkew < 0 {
+			reasons = append(reasons, "max_skew must be positive")
+		}
+		if !utils.StringSliceContains(unsatisfiableModes, s.WhenUnsatisfiable) {
+			reasons = append(reasons, fmt.Sprintf("when_unsatisfiable [%s] must be one of [ScheduleAnyway, DoNotSchedule]", s.WhenUnsatisfiable))
+		}
+	}
+	return reasons
+}
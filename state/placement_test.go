@@ -0,0 +1,50 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestPlacementPolicy_Validate(t *testing.T) {
+	policy := NewPlacementPolicy(araTestConfig{
+		"eks_placement_allowed_node_selector_keys": []string{"kubernetes.io/arch"},
+		"eks_placement_allowed_toleration_keys":    []string{"dedicated"},
+	})
+	valid := &Placement{
+		NodeSelector:   map[string]string{"kubernetes.io/arch": "arm64"},
+		Tolerations:    []Toleration{{Key: "dedicated", Operator: "Exists", Effect: "NoSchedule"}},
+		TopologySpread: []TopologySpread{{TopologyKey: "topology.kubernetes.io/zone"}},
+	}
+	if reasons := policy.Validate(valid); len(reasons) > 0 {
+		t.Errorf("Expected placement to be valid, got %v", reasons)
+	}
+
+	invalid := &Placement{
+		NodeSelector:   map[string]string{"node.kubernetes.io/lifecycle": "normal"},
+		Tolerations:    []Toleration{{Key: "dedicated", Operator: "Exists", Value: "x", Effect: "Sometimes"}},
+		TopologySpread: []TopologySpread{{TopologyKey: "rack", WhenUnsatisfiable: "Never"}},
+	}
+	if reasons := policy.Validate(invalid); len(reasons) != 5 {
+		t.Errorf("Expected 5 reasons, got %v", reasons)
+	}
+}
+
+func TestMergePlacement(t *testing.T) {
+	base := &Placement{
+		NodeSelector: map[string]string{"a": "1", "b": "1"},
+		Tolerations:  []Toleration{{Key: "a"}},
+	}
+	override := &Placement{
+		NodeSelector: map[string]string{"b": "2"},
+		Tolerations:  []Toleration{{Key: "b"}},
+	}
+	merged := MergePlacement(base, override)
+	if merged.NodeSelector["a"] != "1" || merged.NodeSelector["b"] != "2" {
+		t.Errorf("Expected run node selector to win, got %v", merged.NodeSelector)
+	}
+	if len(merged.Tolerations) != 2 {
+		t.Errorf("Expected tolerations to accumulate, got %v", merged.Tolerations)
+	}
+	if MergePlacement(base, nil) != base || MergePlacement(nil, override) != override {
+		t.Errorf("Expected an empty side to return the other")
+	}
+}
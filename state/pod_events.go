@@ -0,0 +1,54 @@
+package state
+
+// Record adds an event to the pod events. An event repeating a recorded one,
+// other than by time, raises its count and last timestamp instead; counts
+// reported by Kubernetes already include the earlier repeats. Past max
+// events the oldest are dropped, except the spot interruptions and
+// resubmissions the status worker relies on.
+func (pe PodEvents) Record(event PodEvent, max int) PodEvents {
+	if event.LastTimestamp == nil {
+		event.LastTimestamp = event.Timestamp
+	}
+	for i := range pe {
+		recorded := &pe[i]
+		if recorded.EventType != event.EventType || recorded.Reason != event.Reason ||
+			recorded.SourceObject != event.SourceObject || recorded.Message != event.Message {
+			continue
+		}
+		count := recorded.count() + 1
+		if event.Count > count {
+			count = event.Count
+		}
+		recorded.Count = count
+		if event.LastTimestamp != nil && (recorded.LastTimestamp == nil || event.LastTimestamp.After(*recorded.LastTimestamp)) {
+			recorded.LastTimestamp = event.LastTimestamp
+		}
+		return pe
+	}
+
+	pe = append(pe, event)
+	for i := 0; i < len(pe) && max > 0 && len(pe) > max; {
+		if IsSpotInterruption(pe[i].Reason, pe[i].Message) || pe[i].Reason == SpotResubmittedReason {
+			i++
+			continue
+		}
+		pe = append(pe[:i], pe[i+1:]...)
+	}
+	return pe
+}
+
+// Total counts the events recorded, repeats included.
+func (pe PodEvents) Total() int64 {
+	var total int64
+	for _, e := range pe {
+		total += e.count()
+	}
+	return total
+}
+
+func (e PodEvent) count() int64 {
+	if e.Count > 0 {
+		return e.Count
+	}
+	return 1
+}
@@ -0,0 +1,37 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPodEvents_Record(t *testing.T) {
+	at := func(minute int) *time.Time {
+		t := time.Date(2022, 1, 1, 0, minute, 0, 0, time.UTC)
+		return &t
+	}
+	backoff := PodEvent{Timestamp: at(1), EventType: "Warning", Reason: "BackOff", SourceObject: "pod-a", Message: "Back-off pulling image"}
+
+	var events PodEvents
+	events = events.Record(backoff, 10)
+	repeat := backoff
+	repeat.Timestamp = at(2)
+	events = events.Record(repeat, 10)
+	if len(events) != 1 || events[0].Count != 2 || !events[0].LastTimestamp.Equal(*at(2)) || !events[0].Timestamp.Equal(*at(1)) {
+		t.Errorf("Expected one event seen twice from minute 1 to 2, got %+v", events)
+	}
+
+	repeat.Timestamp = at(5)
+	repeat.Count = 7
+	events = events.Record(repeat, 10)
+	if events[0].Count != 7 || events.Total() != 7 {
+		t.Errorf("Expected the count reported by Kubernetes, got %d", events[0].Count)
+	}
+
+	events = events.Record(PodEvent{Reason: "NodeShutdown", SourceObject: "pod-a"}, 2)
+	events = events.Record(PodEvent{Reason: "Scheduled", SourceObject: "pod-b"}, 2)
+	events = events.Record(PodEvent{Reason: "Pulled", SourceObject: "pod-b"}, 2)
+	if len(events) != 2 || events[0].Reason != "NodeShutdown" || events[1].Reason != "Pulled" {
+		t.Errorf("Expected the oldest events but the interruption to be dropped, got %+v", events)
+	}
+}
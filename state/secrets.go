@@ -0,0 +1,150 @@
+package state
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
+)
+
+// MaskedValue replaces secret values wherever they would be written out.
+const MaskedValue = "****"
+
+// SecretRef points at one key of a secret in the secret store; it is
+// written as "path#key", e.g. "team/db#password".
+type SecretRef struct {
+	Path string
+	Key  string
+}
+
+// ParseSecretRef parses a "path#key" reference.
+func ParseSecretRef(ref string) (SecretRef, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return SecretRef{}, exceptions.MalformedInput{
+			ErrorString: fmt.Sprintf("secret_ref [%s] must be of the form path#key", ref)}
+	}
+	return SecretRef{Path: strings.Trim(parts[0], "/"), Key: parts[1]}, nil
+}
+
+func (r SecretRef) String() string {
+	return fmt.Sprintf("%s#%s", r.Path, r.Key)
+}
+
+// ValidateEnv returns the reasons an env list's secret references are invalid.
+func ValidateEnv(env *EnvList) []string {
+	var reasons []string
+	if env == nil {
+		return reasons
+	}
+	for _, e := range *env {
+		if len(e.SecretRef) == 0 {
+			continue
+		}
+		if len(e.Value) > 0 {
+			reasons = append(reasons, fmt.Sprintf("env [%s] may set only one of [value] and [secret_ref]", e.Name))
+		}
+		if _, err := ParseSecretRef(e.SecretRef); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	return reasons
+}
+
+// SecretPolicy scopes secret references to the group of the definition or
+// template using them: a group reads the secrets under
+// `<secrets_group_prefix>/<group>/` and those under `secrets_shared_paths`.
+type SecretPolicy struct {
+	GroupPrefix string
+	SharedPaths []string
+}
+
+// NewSecretPolicy reads the group prefix and the shared paths from config;
+// without a prefix a group reads the secrets under `<group>/`.
+func NewSecretPolicy(conf config.Config) SecretPolicy {
+	policy := SecretPolicy{GroupPrefix: strings.Trim(conf.GetString("secrets_group_prefix"), "/")}
+	for _, shared := range conf.GetStringSlice("secrets_shared_paths") {
+		if shared = strings.Trim(shared, "/"); len(shared) > 0 {
+			policy.SharedPaths = append(policy.SharedPaths, shared)
+		}
+	}
+	return policy
+}
+
+// GroupPath is the path under which a group's secrets are kept.
+func (p SecretPolicy) GroupPath(groupName string) string {
+	return path.Join(p.GroupPrefix, groupName)
+}
+
+// Permits is true when the runs of a group may read the referenced secret.
+func (p SecretPolicy) Permits(ref SecretRef, groupName string) bool {
+	for _, segment := range strings.Split(ref.Path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	if len(groupName) > 0 && underPath(ref.Path, p.GroupPath(groupName)) {
+		return true
+	}
+	for _, shared := range p.SharedPaths {
+		if underPath(ref.Path, shared) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error when the runs of a group may not read the
+// referenced secret.
+func (p SecretPolicy) Check(ref SecretRef, groupName string) error {
+	if p.Permits(ref, groupName) {
+		return nil
+	}
+	return exceptions.MalformedInput{ErrorString: fmt.Sprintf(
+		"secret_ref [%s] is not permitted for group [%s]; its secrets are under [%s/]", ref, groupName, p.GroupPath(groupName))}
+}
+
+// Validate returns the reasons a group may not read the secrets referenced
+// by the env lists; malformed references are left to ValidateEnv.
+func (p SecretPolicy) Validate(groupName string, envs ...*EnvList) []string {
+	var reasons []string
+	for _, env := range envs {
+		if env == nil {
+			continue
+		}
+		for _, e := range *env {
+			if len(e.SecretRef) == 0 {
+				continue
+			}
+			ref, err := ParseSecretRef(e.SecretRef)
+			if err != nil {
+				continue
+			}
+			if err := p.Check(ref, groupName); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		}
+	}
+	return reasons
+}
+
+// EnvLists returns the env lists of the executable's container, its init
+// containers and sidecars.
+func (r ExecutableResources) EnvLists() []*EnvList {
+	envs := []*EnvList{r.Env}
+	for _, containers := range []*ContainerList{r.InitContainers, r.Sidecars} {
+		if containers == nil {
+			continue
+		}
+		for _, c := range *containers {
+			envs = append(envs, c.Env)
+		}
+	}
+	return envs
+}
+
+func underPath(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
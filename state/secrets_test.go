@@ -0,0 +1,71 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	ref, err := ParseSecretRef("/team/db#password")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ref.Path != "team/db" || ref.Key != "password" {
+		t.Errorf("Expected team/db#password but got %s", ref)
+	}
+
+	for _, bad := range []string{"team/db", "#password", "team/db#"} {
+		if _, err := ParseSecretRef(bad); err == nil {
+			t.Errorf("Expected error for secret_ref [%s]", bad)
+		}
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	env := EnvList{
+		{Name: "PLAIN", Value: "a"},
+		{Name: "DB_PASS", SecretRef: "team/db#password"},
+	}
+	if reasons := ValidateEnv(&env); len(reasons) > 0 {
+		t.Errorf("Expected valid env but got %v", reasons)
+	}
+
+	env = EnvList{
+		{Name: "DB_PASS", Value: "a", SecretRef: "team/db#password"},
+		{Name: "DB_USER", SecretRef: "team/db"},
+	}
+	if reasons := ValidateEnv(&env); len(reasons) != 2 {
+		t.Errorf("Expected 2 reasons but got %v", reasons)
+	}
+}
+
+func TestSecretPolicy_Validate(t *testing.T) {
+	policy := NewSecretPolicy(araTestConfig{
+		"secrets_group_prefix": "flotilla/",
+		"secrets_shared_paths": []string{"/shared/certs"},
+	})
+	env := EnvList{
+		{Name: "DB_PASS", SecretRef: "flotilla/team/db#password"},
+		{Name: "CA", SecretRef: "shared/certs#ca"},
+	}
+	resources := ExecutableResources{Env: &env, Sidecars: &ContainerList{
+		{Name: "proxy", Env: &EnvList{{Name: "TOKEN", SecretRef: "flotilla/team/api#token"}}},
+	}}
+	if reasons := policy.Validate("team", resources.EnvLists()...); len(reasons) > 0 {
+		t.Errorf("Expected the group's and shared secrets to be permitted, got %v", reasons)
+	}
+
+	for _, ref := range []string{
+		"flotilla/other-team/db#password",
+		"flotilla/team-b/db#password",
+		"flotilla/team/../other-team/db#password",
+		"team/db#password",
+	} {
+		cross := EnvList{{Name: "DB_PASS", SecretRef: ref}}
+		if reasons := policy.Validate("team", &cross); len(reasons) != 1 {
+			t.Errorf("Expected secret_ref [%s] to be rejected for group team, got %v", ref, reasons)
+		}
+	}
+	if reasons := policy.Validate("other-team", resources.EnvLists()...); len(reasons) != 2 {
+		t.Errorf("Expected the team's secrets to be rejected for another group, got %v", reasons)
+	}
+}
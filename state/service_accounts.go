@@ -0,0 +1,159 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/utils"
+)
+
+// TemplateGroupName is the group of the runs of templates; service accounts
+// are permitted to templates by permitting them to this group.
+const TemplateGroupName = "template_group_name"
+
+// AllGroups permits a service account to the runs of every group.
+const AllGroups = "*"
+
+var (
+	serviceAccountPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+	roleArnPattern        = regexp.MustCompile(`^arn:aws[\w-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+)
+
+// ServiceAccount is a Kubernetes service account runs may use and the IAM
+// role it is bound to through IRSA; Spark runs on EMR assume the role.
+type ServiceAccount struct {
+	Name    string   `json:"-"`
+	RoleArn string   `json:"role_arn,omitempty"`
+	Groups  []string `json:"groups"`
+}
+
+// Permits is true for the groups whose runs may use the service account.
+func (sa ServiceAccount) Permits(groupName string) bool {
+	return utils.StringSliceContains(sa.Groups, AllGroups) || utils.StringSliceContains(sa.Groups, groupName)
+}
+
+// ServiceAccountPolicy is the admin allowlist of the service accounts
+// definitions and templates may run as; runs without one use the default
+// account, which every group may use.
+type ServiceAccountPolicy struct {
+	Default  ServiceAccount
+	Accounts map[string]ServiceAccount
+}
+
+// NewServiceAccountPolicy reads `eks_service_accounts`, a map of service
+// account to a JSON ServiceAccount; the default account is
+// `eks_service_account` bound to `emr_job_role_arn`
+func NewServiceAccountPolicy(conf config.Config) (ServiceAccountPolicy, error) {
+	policy := ServiceAccountPolicy{
+		Default: ServiceAccount{
+			Name:    conf.GetString("eks_service_account"),
+			RoleArn: conf.GetString("emr_job_role_arn"),
+			Groups:  []string{AllGroups},
+		},
+		Accounts: make(map[string]ServiceAccount),
+	}
+	if !conf.IsSet("eks_service_accounts") {
+		return policy, nil
+	}
+	for name, raw := range conf.GetStringMapString("eks_service_accounts") {
+		var sa ServiceAccount
+		if err := json.Unmarshal([]byte(raw), &sa); err != nil {
+			return policy, errors.Wrapf(err, "invalid service account [%s]", name)
+		}
+		sa.Name = name
+		if !serviceAccountPattern.MatchString(name) || len(name) > 253 {
+			return policy, errors.Errorf("service account [%s] must be a lowercase DNS subdomain", name)
+		}
+		if len(sa.RoleArn) > 0 && !roleArnPattern.MatchString(sa.RoleArn) {
+			return policy, errors.Errorf("service account [%s] role_arn [%s] must be an IAM role arn", name, sa.RoleArn)
+		}
+		if len(sa.Groups) == 0 {
+			return policy, errors.Errorf("service account [%s] must be permitted to at least one group", name)
+		}
+		policy.Accounts[name] = sa
+	}
+	return policy, nil
+}
+
+// Get returns the named service account, or the default account when name
+// is empty.
+func (p ServiceAccountPolicy) Get(name *string) (ServiceAccount, bool) {
+	if name == nil || len(*name) == 0 || *name == p.Default.Name {
+		return p.Default, true
+	}
+	sa, ok := p.Accounts[*name]
+	return sa, ok
+}
+
+// Names returns the sorted names of the service accounts a group may use.
+func (p ServiceAccountPolicy) Names(groupName string) []string {
+	names := []string{}
+	for name, sa := range p.Accounts {
+		if sa.Permits(groupName) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate returns the reasons the runs of a group may not use a service
+// account.
+func (p ServiceAccountPolicy) Validate(name *string, groupName string) []string {
+	var reasons []string
+	if name == nil || len(*name) == 0 {
+		return reasons
+	}
+	sa, ok := p.Get(name)
+	if !ok {
+		return append(reasons, fmt.Sprintf(
+			"service_account [%s] is not allowed; allowed service accounts: %v", *name, p.Names(groupName)))
+	}
+	if !sa.Permits(groupName) {
+		reasons = append(reasons, fmt.Sprintf(
+			"service_account [%s] is not permitted for group [%s]; allowed service accounts: %v", *name, groupName, p.Names(groupName)))
+	}
+	return reasons
+}
+
+// MatchesSession is true for the CloudTrail identities of runs: sessions of
+// the IAM role of a service account, or of a role named after one. The role
+// name of the session must match exactly.
+func (p ServiceAccountPolicy) MatchesSession(arn string) bool {
+	role, ok := sessionRole(arn)
+	if !ok {
+		return false
+	}
+	accounts := []ServiceAccount{p.Default}
+	for _, sa := range p.Accounts {
+		accounts = append(accounts, sa)
+	}
+	for _, sa := range accounts {
+		if i := strings.LastIndex(sa.RoleArn, "/"); i >= 0 && role == sa.RoleArn[i+1:] {
+			return true
+		}
+		if len(sa.Name) > 0 && role == sa.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionRole returns the role name of an assumed-role session arn,
+// arn:aws:sts::<account>:assumed-role/<role>/<session>.
+func sessionRole(arn string) (string, bool) {
+	i := strings.Index(arn, ":assumed-role/")
+	if i < 0 {
+		return "", false
+	}
+	parts := strings.SplitN(arn[i+len(":assumed-role/"):], "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", false
+	}
+	return parts[0], true
+}
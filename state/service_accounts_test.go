@@ -0,0 +1,70 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestNewServiceAccountPolicy(t *testing.T) {
+	policy, err := NewServiceAccountPolicy(araTestConfig{
+		"eks_service_account": "flotilla-jobs",
+		"emr_job_role_arn":    "arn:aws:iam::123456789012:role/flotilla-emr",
+		"eks_service_accounts": map[string]string{
+			"etl":     `{"role_arn": "arn:aws:iam::123456789012:role/teams/flotilla-etl", "groups": ["etl", "data"]}`,
+			"reports": `{"groups": ["*"]}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if sa, ok := policy.Get(nil); !ok || sa.Name != "flotilla-jobs" || sa.RoleArn != "arn:aws:iam::123456789012:role/flotilla-emr" {
+		t.Errorf("Expected the default service account, got %+v", sa)
+	}
+
+	etl, reports, missing := "etl", "reports", "admin"
+	if reasons := policy.Validate(&etl, "data"); len(reasons) != 0 {
+		t.Errorf("Expected etl to be permitted for data, got %v", reasons)
+	}
+	if reasons := policy.Validate(&etl, "web"); len(reasons) != 1 {
+		t.Errorf("Expected etl not to be permitted for web")
+	}
+	if reasons := policy.Validate(&reports, TemplateGroupName); len(reasons) != 0 {
+		t.Errorf("Expected reports to be permitted for every group, got %v", reasons)
+	}
+	if reasons := policy.Validate(&missing, "data"); len(reasons) != 1 {
+		t.Errorf("Expected an account outside the allowlist to be rejected")
+	}
+	if names := policy.Names("web"); len(names) != 1 || names[0] != "reports" {
+		t.Errorf("Expected only reports for web, got %v", names)
+	}
+
+	sessions := map[string]bool{
+		"arn:aws:sts::123456789012:assumed-role/flotilla-etl/eks-abc":         true,
+		"arn:aws:sts::123456789012:assumed-role/flotilla-emr/eks-abc":         true,
+		"arn:aws:sts::123456789012:assumed-role/flotilla-jobs/eks-abc":        true,
+		"arn:aws:sts::123456789012:assumed-role/reports/eks-ab":               true,
+		"arn:aws:sts::123456789012:assumed-role/flotilla-jobs-role/eks-abc":   false,
+		"arn:aws:sts::123456789012:assumed-role/flotilla-reports-role/eks-ab": false,
+		"arn:aws:sts::123456789012:assumed-role/flotilla-etl-admin/eks-abc":   false,
+		"arn:aws:sts::123456789012:assumed-role/flotilla-admin/eks-abc":       false,
+		"arn:aws:iam::123456789012:user/flotilla-etl":                         false,
+	}
+	for arn, expected := range sessions {
+		if policy.MatchesSession(arn) != expected {
+			t.Errorf("Expected MatchesSession(%s) to be %v", arn, expected)
+		}
+	}
+}
+
+func TestNewServiceAccountPolicy_Invalid(t *testing.T) {
+	invalid := []map[string]string{
+		{"Etl": `{"groups": ["etl"]}`},
+		{"etl": `{"role_arn": "flotilla-etl", "groups": ["etl"]}`},
+		{"etl": `{"role_arn": "arn:aws:iam::123456789012:role/flotilla-etl"}`},
+		{"etl": `not json`},
+	}
+	for _, accounts := range invalid {
+		if _, err := NewServiceAccountPolicy(araTestConfig{"eks_service_accounts": accounts}); err == nil {
+			t.Errorf("Expected %v to be rejected", accounts)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package state
+
+import (
+	"sort"
+	"time"
+)
+
+// Spark roles of the pods of a Spark run.
+const (
+	SparkDriverRole   = "driver"
+	SparkExecutorRole = "executor"
+)
+
+const sparkOOMExitCode = int32(137)
+
+// SparkExecutor is the record of one driver or executor pod of a Spark run;
+// PeakMemory is in MiB.
+type SparkExecutor struct {
+	PodName    string     `json:"pod_name"`
+	Role       string     `json:"role"`
+	NodeName   *string    `json:"node_name,omitempty"`
+	PeakMemory *int64     `json:"peak_memory,omitempty"`
+	ExitCode   *int32     `json:"exit_code,omitempty"`
+	ExitReason *string    `json:"exit_reason,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	SpotLost   bool       `json:"spot_lost,omitempty"`
+}
+
+// OOMKilled is true when the pod's container was killed for exceeding its
+// memory.
+func (e SparkExecutor) OOMKilled() bool {
+	return (e.ExitCode != nil && *e.ExitCode == sparkOOMExitCode) ||
+		(e.ExitReason != nil && *e.ExitReason == "OOMKilled")
+}
+
+// Lost is true when the pod ended abnormally, leaving Spark to replace it.
+func (e SparkExecutor) Lost() bool {
+	return e.SpotLost || (e.ExitCode != nil && *e.ExitCode != 0)
+}
+
+// SparkExecutorSummary summarizes the executor records of a Spark run.
+// Churn counts executors lost and replaced while the run executed,
+// PeakExecutors the most executors running at once and MemorySkew the ratio
+// of the largest to the median executor peak memory.
+type SparkExecutorSummary struct {
+	Executors          int64    `json:"executors"`
+	PeakExecutors      int64    `json:"peak_executors"`
+	Churn              int64    `json:"churn"`
+	ExecutorOOMs       int64    `json:"executor_ooms"`
+	DriverOOMs         int64    `json:"driver_ooms"`
+	SpotLosses         int64    `json:"spot_losses"`
+	ExecutorPeakMemory *int64   `json:"executor_peak_memory,omitempty"`
+	DriverPeakMemory   *int64   `json:"driver_peak_memory,omitempty"`
+	MemorySkew         *float64 `json:"memory_skew,omitempty"`
+}
+
+// SparkExecutorList wraps the executor records of a Spark run.
+type SparkExecutorList struct {
+	Total     int                   `json:"total"`
+	Executors []SparkExecutor       `json:"executors"`
+	Summary   *SparkExecutorSummary `json:"summary,omitempty"`
+}
+
+// SparkExecutorStats aggregates the executor summaries of recent runs of an
+// executable and command; memory is in MiB.
+type SparkExecutorStats struct {
+	Runs               int64  `db:"runs"`
+	ExecutorOOMs       int64  `db:"executor_ooms"`
+	DriverOOMs         int64  `db:"driver_ooms"`
+	ExecutorPeakMemory *int64 `db:"executor_peak_memory"`
+	DriverPeakMemory   *int64 `db:"driver_peak_memory"`
+}
+
+// RecordExecutor adds or updates the record of a pod, keeping what earlier
+// updates learned, and refreshes the summary.
+func (se *SparkExtension) RecordExecutor(update SparkExecutor) {
+	for i, e := range se.ExecutorRecords {
+		if e.PodName == update.PodName {
+			se.ExecutorRecords[i] = mergeExecutor(e, update)
+			se.ExecutorSummary = SummarizeExecutors(se.ExecutorRecords)
+			return
+		}
+	}
+	se.ExecutorRecords = append(se.ExecutorRecords, update)
+	se.ExecutorSummary = SummarizeExecutors(se.ExecutorRecords)
+}
+
+func mergeExecutor(e SparkExecutor, update SparkExecutor) SparkExecutor {
+	if len(update.Role) > 0 {
+		e.Role = update.Role
+	}
+	if update.NodeName != nil {
+		e.NodeName = update.NodeName
+	}
+	if update.PeakMemory != nil && (e.PeakMemory == nil || *update.PeakMemory > *e.PeakMemory) {
+		e.PeakMemory = update.PeakMemory
+	}
+	if update.ExitCode != nil {
+		e.ExitCode = update.ExitCode
+	}
+	if update.ExitReason != nil {
+		e.ExitReason = update.ExitReason
+	}
+	if update.StartedAt != nil {
+		e.StartedAt = update.StartedAt
+	}
+	if update.FinishedAt != nil {
+		e.FinishedAt = update.FinishedAt
+	}
+	e.SpotLost = e.SpotLost || update.SpotLost
+	return e
+}
+
+// SummarizeExecutors computes the summary of a run's executor records.
+func SummarizeExecutors(records []SparkExecutor) *SparkExecutorSummary {
+	summary := SparkExecutorSummary{}
+	var peaks []int64
+	for _, e := range records {
+		if e.Role == SparkDriverRole {
+			if e.OOMKilled() {
+				summary.DriverOOMs++
+			}
+			summary.DriverPeakMemory = maxMemory(summary.DriverPeakMemory, e.PeakMemory)
+			continue
+		}
+		summary.Executors++
+		if e.OOMKilled() {
+			summary.ExecutorOOMs++
+		}
+		if e.SpotLost {
+			summary.SpotLosses++
+		}
+		if e.Lost() {
+			summary.Churn++
+		}
+		if e.PeakMemory != nil {
+			peaks = append(peaks, *e.PeakMemory)
+			summary.ExecutorPeakMemory = maxMemory(summary.ExecutorPeakMemory, e.PeakMemory)
+		}
+	}
+	summary.PeakExecutors = peakExecutors(records)
+	if len(peaks) > 1 {
+		sort.Slice(peaks, func(i, j int) bool { return peaks[i] < peaks[j] })
+		if median := peaks[(len(peaks)-1)/2]; median > 0 {
+			skew := float64(peaks[len(peaks)-1]) / float64(median)
+			summary.MemorySkew = &skew
+		}
+	}
+	return &summary
+}
+
+// peakExecutors returns the most executors running at once; without start
+// times every executor is assumed to have run at once.
+func peakExecutors(records []SparkExecutor) int64 {
+	type edge struct {
+		at    time.Time
+		delta int64
+	}
+	var edges []edge
+	var executors int64
+	for _, e := range records {
+		if e.Role == SparkDriverRole {
+			continue
+		}
+		executors++
+		if e.StartedAt == nil {
+			continue
+		}
+		edges = append(edges, edge{*e.StartedAt, 1})
+		if e.FinishedAt != nil {
+			edges = append(edges, edge{*e.FinishedAt, -1})
+		}
+	}
+	if len(edges) == 0 {
+		return executors
+	}
+	// Executors finishing as others start aren't running at once.
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at.Equal(edges[j].at) {
+			return edges[i].delta < edges[j].delta
+		}
+		return edges[i].at.Before(edges[j].at)
+	})
+	var running, peak int64
+	for _, e := range edges {
+		running += e.delta
+		if running > peak {
+			peak = running
+		}
+	}
+	return peak
+}
+
+func maxMemory(current *int64, m *int64) *int64 {
+	if m != nil && (current == nil || *m > *current) {
+		return m
+	}
+	return current
+}
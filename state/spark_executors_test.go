@@ -0,0 +1,40 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSparkExtension_RecordExecutor(t *testing.T) {
+	oom := int32(137)
+	failed := int32(1)
+	se := SparkExtension{}
+	se.RecordExecutor(SparkExecutor{PodName: "driver", Role: SparkDriverRole, PeakMemory: aws.Int64(2000)})
+	se.RecordExecutor(SparkExecutor{PodName: "exec-1", Role: SparkExecutorRole, PeakMemory: aws.Int64(1000)})
+	se.RecordExecutor(SparkExecutor{PodName: "exec-2", Role: SparkExecutorRole, PeakMemory: aws.Int64(1200)})
+	se.RecordExecutor(SparkExecutor{PodName: "exec-3", Role: SparkExecutorRole, PeakMemory: aws.Int64(3600)})
+
+	// Later updates keep the peak memory and learn how the pod ended.
+	se.RecordExecutor(SparkExecutor{PodName: "exec-3", Role: SparkExecutorRole, PeakMemory: aws.Int64(100), ExitCode: &oom})
+	se.RecordExecutor(SparkExecutor{PodName: "exec-2", Role: SparkExecutorRole, ExitCode: &failed, SpotLost: true})
+
+	if len(se.ExecutorRecords) != 4 {
+		t.Fatalf("Expected 4 records, got %d", len(se.ExecutorRecords))
+	}
+	if *se.ExecutorRecords[3].PeakMemory != 3600 || *se.ExecutorRecords[3].ExitCode != 137 {
+		t.Errorf("Expected exec-3 to keep its peak memory and exit code")
+	}
+
+	summary := se.ExecutorSummary
+	if summary.Executors != 3 || summary.Churn != 2 || summary.ExecutorOOMs != 1 ||
+		summary.DriverOOMs != 0 || summary.SpotLosses != 1 {
+		t.Errorf("Unexpected summary %+v", *summary)
+	}
+	if *summary.ExecutorPeakMemory != 3600 || *summary.DriverPeakMemory != 2000 {
+		t.Errorf("Expected peaks of 3600 and 2000, got %d and %d", *summary.ExecutorPeakMemory, *summary.DriverPeakMemory)
+	}
+	if summary.MemorySkew == nil || *summary.MemorySkew != 3 {
+		t.Errorf("Expected memory skew of 3, got %v", summary.MemorySkew)
+	}
+}
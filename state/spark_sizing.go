@@ -0,0 +1,180 @@
+package state
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// SparkStageSummary summarizes the completed stages of a Spark run as read
+// from the Spark History Server; sizes are in MB and durations in seconds.
+type SparkStageSummary struct {
+	Stages          int64 `json:"stages"`
+	MaxTasks        int64 `json:"max_tasks"`
+	LongestStage    int64 `json:"longest_stage"`
+	ExecutorRunTime int64 `json:"executor_run_time"`
+	InputMB         int64 `json:"input_mb"`
+	ShuffleReadMB   int64 `json:"shuffle_read_mb"`
+	ShuffleWriteMB  int64 `json:"shuffle_write_mb"`
+	SpilledMB       int64 `json:"spilled_mb"`
+}
+
+// SparkSizingStats aggregates the executor and stage summaries of recent runs
+// of an executable and command; each is the 90th percentile across runs.
+type SparkSizingStats struct {
+	Runs          int64  `db:"runs" json:"runs"`
+	PeakExecutors *int64 `db:"peak_executors" json:"peak_executors,omitempty"`
+	Churn         *int64 `db:"churn" json:"churn,omitempty"`
+	MaxStageTasks *int64 `db:"max_stage_tasks" json:"max_stage_tasks,omitempty"`
+	LongestStage  *int64 `db:"longest_stage" json:"longest_stage,omitempty"`
+	ShuffleMB     *int64 `db:"shuffle_mb" json:"shuffle_mb,omitempty"`
+}
+
+// SparkSizingPolicy bounds and tunes the executors of a group's Spark runs.
+// Headroom multiplies the peak executors of recent runs; runs whose stages
+// shuffle at least ShuffleHeavyMB are shuffle heavy.
+type SparkSizingPolicy struct {
+	MinExecutors             int64   `json:"min_executors"`
+	MaxExecutors             int64   `json:"max_executors"`
+	DefaultExecutors         int64   `json:"default_executors"`
+	Headroom                 float64 `json:"headroom"`
+	ShortStageSeconds        int64   `json:"short_stage_seconds"`
+	ShuffleHeavyMB           int64   `json:"shuffle_heavy_mb"`
+	ShufflePartitionMB       int64   `json:"shuffle_partition_mb"`
+	ShuffleMinExecutorsRatio float64 `json:"shuffle_min_executors_ratio"`
+}
+
+// DefaultSparkSizingPolicy applies to groups without a policy of their own.
+var DefaultSparkSizingPolicy = SparkSizingPolicy{
+	MinExecutors:             1,
+	MaxExecutors:             100,
+	DefaultExecutors:         25,
+	Headroom:                 1.25,
+	ShortStageSeconds:        60,
+	ShuffleHeavyMB:           10240,
+	ShufflePartitionMB:       128,
+	ShuffleMinExecutorsRatio: 0.25,
+}
+
+// Validate returns an error for a policy whose executor bounds or headroom
+// can't size a run.
+func (p SparkSizingPolicy) Validate() error {
+	if p.MinExecutors < 0 || p.MaxExecutors < 0 || p.DefaultExecutors < 0 ||
+		p.ShortStageSeconds < 0 || p.ShuffleHeavyMB < 0 || p.ShufflePartitionMB < 0 || p.ShuffleMinExecutorsRatio < 0 {
+		return errors.New("executor counts, durations, sizes and ratios must not be negative")
+	}
+	if p.MinExecutors > p.MaxExecutors {
+		return errors.Errorf("min_executors %d must not exceed max_executors %d", p.MinExecutors, p.MaxExecutors)
+	}
+	if p.Headroom <= 0 {
+		return errors.New("headroom must be positive")
+	}
+	return nil
+}
+
+// SparkSizingReport explains the Spark conf a run was submitted with.
+type SparkSizingReport struct {
+	Policy            string                `json:"policy"`
+	Stats             *SparkSizingStats     `json:"stats,omitempty"`
+	Decisions         []SparkSizingDecision `json:"decisions"`
+	SparkSubmitParams *string               `json:"spark_submit_params,omitempty"`
+}
+
+// SparkSizingDecision is the value a Spark conf was given and why; Requested
+// is the value of the run's request, if any.
+type SparkSizingDecision struct {
+	Name      string  `json:"name"`
+	Requested *string `json:"requested,omitempty"`
+	Value     string  `json:"value"`
+	Reason    string  `json:"reason"`
+}
+
+// Executors returns the maximum executors of a run: the peak executors of
+// recent runs with headroom and room to replace lost executors, no more than
+// the widest stage has tasks for, and no more than the peak when stages end
+// before new executors would start. A requested value is kept as a floor and
+// the result stays within the policy's bounds.
+func (p SparkSizingPolicy) Executors(stats SparkSizingStats, requested *int64, cores int64) (int64, string) {
+	var executors int64
+	var reason string
+	if stats.PeakExecutors == nil || *stats.PeakExecutors <= 0 {
+		executors = p.DefaultExecutors
+		reason = "no executor records in recent runs, policy default"
+	} else {
+		peak := *stats.PeakExecutors
+		executors = int64(math.Ceil(float64(peak) * p.Headroom))
+		reason = fmt.Sprintf("peak of %d executors in recent runs with %.2fx headroom", peak, p.Headroom)
+		if stats.Churn != nil && *stats.Churn > 0 {
+			executors += *stats.Churn
+			reason = fmt.Sprintf("%s, plus %d lost executors", reason, *stats.Churn)
+		}
+		if stats.MaxStageTasks != nil && *stats.MaxStageTasks > 0 {
+			if cores < 1 {
+				cores = 1
+			}
+			if usable := int64(math.Ceil(float64(*stats.MaxStageTasks) / float64(cores))); usable < executors {
+				executors = usable
+				reason = fmt.Sprintf("%s, capped at the %d tasks of the widest stage", reason, *stats.MaxStageTasks)
+			}
+		}
+		if stats.LongestStage != nil && *stats.LongestStage < p.ShortStageSeconds && executors > peak {
+			executors = peak
+			reason = fmt.Sprintf("%s, capped at the peak as no stage runs longer than %ds", reason, p.ShortStageSeconds)
+		}
+	}
+	if requested != nil && *requested > executors {
+		executors = *requested
+		reason = "requested value"
+	}
+	if executors < p.MinExecutors {
+		executors = p.MinExecutors
+		reason = fmt.Sprintf("%s, raised to the policy minimum", reason)
+	}
+	if executors > p.MaxExecutors {
+		executors = p.MaxExecutors
+		reason = fmt.Sprintf("%s, lowered to the policy maximum", reason)
+	}
+	return executors, reason
+}
+
+// ShuffleHeavy is true when recent runs shuffled at least ShuffleHeavyMB.
+func (p SparkSizingPolicy) ShuffleHeavy(stats SparkSizingStats) bool {
+	return p.ShuffleHeavyMB > 0 && stats.ShuffleMB != nil && *stats.ShuffleMB >= p.ShuffleHeavyMB
+}
+
+// ShufflePartitions returns the shuffle partitions that keep each partition
+// of a shuffle heavy run near ShufflePartitionMB, and no fewer than Spark's
+// default of 200.
+func (p SparkSizingPolicy) ShufflePartitions(stats SparkSizingStats) int64 {
+	partitions := int64(200)
+	if stats.ShuffleMB != nil && p.ShufflePartitionMB > 0 {
+		if n := *stats.ShuffleMB / p.ShufflePartitionMB; n > partitions {
+			partitions = n
+		}
+	}
+	return partitions
+}
+
+// ShuffleMinExecutors returns the executors a shuffle heavy run keeps while
+// dynamic allocation scales down, so shuffle files outlive idle executors.
+func (p SparkSizingPolicy) ShuffleMinExecutors(executors int64) int64 {
+	min := int64(math.Ceil(float64(executors) * p.ShuffleMinExecutorsRatio))
+	if min < p.MinExecutors {
+		min = p.MinExecutors
+	}
+	if min > executors {
+		min = executors
+	}
+	return min
+}
+
+// Decide records the value a Spark conf was given and why.
+func (r *SparkSizingReport) Decide(name string, requested *string, value string, reason string) {
+	r.Decisions = append(r.Decisions, SparkSizingDecision{
+		Name:      name,
+		Requested: requested,
+		Value:     value,
+		Reason:    reason,
+	})
+}
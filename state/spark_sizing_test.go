@@ -0,0 +1,78 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSparkSizingPolicy_Executors(t *testing.T) {
+	p := DefaultSparkSizingPolicy
+
+	if executors, _ := p.Executors(SparkSizingStats{}, nil, 1); executors != 25 {
+		t.Errorf("Expected the default of 25 executors without history, got %d", executors)
+	}
+
+	stats := SparkSizingStats{Runs: 5, PeakExecutors: aws.Int64(40), Churn: aws.Int64(2)}
+	if executors, _ := p.Executors(stats, nil, 1); executors != 52 {
+		t.Errorf("Expected 40 * 1.25 + 2 executors, got %d", executors)
+	}
+
+	stats.MaxStageTasks = aws.Int64(80)
+	if executors, _ := p.Executors(stats, nil, 4); executors != 20 {
+		t.Errorf("Expected 80 tasks over 4 cores to cap at 20 executors, got %d", executors)
+	}
+
+	stats.MaxStageTasks = nil
+	stats.LongestStage = aws.Int64(30)
+	if executors, _ := p.Executors(stats, nil, 1); executors != 40 {
+		t.Errorf("Expected short stages to cap at the peak of 40, got %d", executors)
+	}
+
+	if executors, reason := p.Executors(stats, aws.Int64(60), 1); executors != 60 || reason != "requested value" {
+		t.Errorf("Expected the requested 60 executors, got %d (%s)", executors, reason)
+	}
+
+	if executors, _ := p.Executors(stats, aws.Int64(500), 1); executors != 100 {
+		t.Errorf("Expected the policy maximum of 100, got %d", executors)
+	}
+}
+
+func TestSparkSizingPolicy_Shuffle(t *testing.T) {
+	p := DefaultSparkSizingPolicy
+
+	if p.ShuffleHeavy(SparkSizingStats{ShuffleMB: aws.Int64(1024)}) {
+		t.Errorf("Expected 1GB shuffled not to be shuffle heavy")
+	}
+	heavy := SparkSizingStats{ShuffleMB: aws.Int64(102400)}
+	if !p.ShuffleHeavy(heavy) {
+		t.Errorf("Expected 100GB shuffled to be shuffle heavy")
+	}
+	if partitions := p.ShufflePartitions(heavy); partitions != 800 {
+		t.Errorf("Expected 800 partitions of 128MB, got %d", partitions)
+	}
+	if partitions := p.ShufflePartitions(SparkSizingStats{ShuffleMB: aws.Int64(10240)}); partitions != 200 {
+		t.Errorf("Expected no fewer than 200 partitions, got %d", partitions)
+	}
+	if min := p.ShuffleMinExecutors(40); min != 10 {
+		t.Errorf("Expected a quarter of 40 executors, got %d", min)
+	}
+}
+
+func TestSummarizeExecutors_PeakExecutors(t *testing.T) {
+	at := func(minute int) *time.Time {
+		t := time.Date(2022, 1, 1, 0, minute, 0, 0, time.UTC)
+		return &t
+	}
+	records := []SparkExecutor{
+		{PodName: "driver", Role: SparkDriverRole, StartedAt: at(0)},
+		{PodName: "exec-1", Role: SparkExecutorRole, StartedAt: at(1), FinishedAt: at(5)},
+		{PodName: "exec-2", Role: SparkExecutorRole, StartedAt: at(2), FinishedAt: at(3)},
+		{PodName: "exec-3", Role: SparkExecutorRole, StartedAt: at(3)},
+		{PodName: "exec-4", Role: SparkExecutorRole, StartedAt: at(4)},
+	}
+	if peak := SummarizeExecutors(records).PeakExecutors; peak != 3 {
+		t.Errorf("Expected a peak of 3 executors, got %d", peak)
+	}
+}
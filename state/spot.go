@@ -0,0 +1,128 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/utils"
+)
+
+// SpotInterruptionExitReason prefixes the exit reason of runs stopped after
+// their spot nodes were reclaimed too often.
+const SpotInterruptionExitReason = "Spot instance interrupted"
+
+// SpotResubmittedReason marks the pod event recorded when an interrupted
+// run is resubmitted.
+const SpotResubmittedReason = "SpotInterruptionResubmitted"
+
+// spotInterruptionReasons are event and pod status reasons meaning the pod's
+// node was reclaimed rather than the run failing on its own; they cover the
+// node termination handler, graceful node shutdown and the node controller.
+var spotInterruptionReasons = []string{
+	"SpotInterruption",
+	"SpotInterrupted",
+	"TerminationNotice",
+	"NodeShutdown",
+	"Shutdown",
+	"NodeLost",
+	"TaintManagerEviction",
+}
+
+var spotInterruptionMessages = []string{
+	"node shutdown",
+	"spot interruption",
+	"instance is being terminated",
+}
+
+// IsSpotInterruption is true when an event or pod status reason, or its
+// message, says the node went away.
+func IsSpotInterruption(reason string, message string) bool {
+	if utils.StringSliceContains(spotInterruptionReasons, reason) {
+		return true
+	}
+	message = strings.ToLower(message)
+	for _, m := range spotInterruptionMessages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// SpotInterrupted is true when a pod of the run lost its node.
+func (pe *PodEvents) SpotInterrupted(podName string) bool {
+	return pe.has(podName, func(e PodEvent) bool { return IsSpotInterruption(e.Reason, e.Message) })
+}
+
+// Resubmitted is true when the run was already resubmitted after the pod
+// was interrupted.
+func (pe *PodEvents) Resubmitted(podName string) bool {
+	return pe.has(podName, func(e PodEvent) bool { return e.Reason == SpotResubmittedReason })
+}
+
+// SubmittedAt is when the run was last submitted: when it was queued or,
+// once resubmitted after a spot interruption, when it was last resubmitted.
+func (r *Run) SubmittedAt() *time.Time {
+	submittedAt := r.QueuedAt
+	if r.PodEvents == nil {
+		return submittedAt
+	}
+	for _, e := range *r.PodEvents {
+		if e.Reason == SpotResubmittedReason && e.Timestamp != nil && (submittedAt == nil || e.Timestamp.After(*submittedAt)) {
+			submittedAt = e.Timestamp
+		}
+	}
+	return submittedAt
+}
+
+func (pe *PodEvents) has(podName string, match func(e PodEvent) bool) bool {
+	if pe == nil {
+		return false
+	}
+	for _, e := range *pe {
+		if e.SourceObject == podName && match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// SpotInterruptionPolicy decides how runs whose spot nodes were reclaimed
+// are resubmitted
+type SpotInterruptionPolicy struct {
+	OndemandAfter int64
+	MaxResubmits  int64
+}
+
+// NewSpotInterruptionPolicy reads the policy from config; runs move to
+// ondemand after the first interruption and are resubmitted up to 3 times
+func NewSpotInterruptionPolicy(conf config.Config) SpotInterruptionPolicy {
+	policy := SpotInterruptionPolicy{OndemandAfter: 1, MaxResubmits: 3}
+	if conf.IsSet("eks_spot_interruptions_before_ondemand") {
+		policy.OndemandAfter = int64(conf.GetInt("eks_spot_interruptions_before_ondemand"))
+	}
+	if conf.IsSet("eks_spot_interruptions_max_resubmits") {
+		policy.MaxResubmits = int64(conf.GetInt("eks_spot_interruptions_max_resubmits"))
+	}
+	return policy
+}
+
+// Resubmit returns the node lifecycle to resubmit a run on after its nth
+// interruption, or false once the run has been resubmitted enough.
+func (sp SpotInterruptionPolicy) Resubmit(interruptions int64) (string, bool) {
+	if interruptions > sp.MaxResubmits {
+		return "", false
+	}
+	if interruptions >= sp.OndemandAfter {
+		return OndemandLifecycle, true
+	}
+	return SpotLifecycle, true
+}
+
+// SpotInterruptionReason is the exit reason of a run that is not resubmitted
+// after its nth interruption.
+func SpotInterruptionReason(interruptions int64) string {
+	return fmt.Sprintf("%s %d times", SpotInterruptionExitReason, interruptions)
+}
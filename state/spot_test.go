@@ -0,0 +1,79 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsSpotInterruption(t *testing.T) {
+	interruptions := [][]string{
+		{"SpotInterruption", "Spot interruption notice received"},
+		{"TaintManagerEviction", "Marking for deletion Pod flotilla/eks-1"},
+		{"Terminated", "Pod was terminated in response to imminent node shutdown."},
+	}
+	for _, i := range interruptions {
+		if !IsSpotInterruption(i[0], i[1]) {
+			t.Errorf("Expected [%s: %s] to be a spot interruption", i[0], i[1])
+		}
+	}
+	if IsSpotInterruption("Evicted", "The node was low on resource: memory.") {
+		t.Errorf("Expected memory pressure eviction not to be a spot interruption")
+	}
+}
+
+func TestPodEvents_SpotInterrupted(t *testing.T) {
+	var missing *PodEvents
+	if missing.SpotInterrupted("pod-a") || missing.Resubmitted("pod-a") {
+		t.Errorf("Expected no interruptions without events")
+	}
+	events := &PodEvents{
+		{Reason: "Scheduled", SourceObject: "pod-a"},
+		{Reason: "NodeShutdown", SourceObject: "pod-a"},
+		{Reason: SpotResubmittedReason, SourceObject: "pod-a"},
+		{Reason: "Scheduled", SourceObject: "pod-b"},
+	}
+	if !events.SpotInterrupted("pod-a") || !events.Resubmitted("pod-a") {
+		t.Errorf("Expected pod-a to be interrupted and resubmitted")
+	}
+	if events.SpotInterrupted("pod-b") || events.Resubmitted("pod-b") {
+		t.Errorf("Expected pod-b not to be interrupted")
+	}
+}
+
+func TestRun_SubmittedAt(t *testing.T) {
+	queuedAt := time.Now().Add(-time.Hour)
+	resubmittedAt := queuedAt.Add(time.Minute)
+	run := Run{QueuedAt: &queuedAt}
+	if !run.SubmittedAt().Equal(queuedAt) {
+		t.Errorf("Expected a run never resubmitted to be submitted when queued, got %v", run.SubmittedAt())
+	}
+	run.PodEvents = &PodEvents{
+		{Reason: "NodeShutdown", Timestamp: &queuedAt},
+		{Reason: SpotResubmittedReason, Timestamp: &resubmittedAt},
+	}
+	if !run.SubmittedAt().Equal(resubmittedAt) {
+		t.Errorf("Expected a resubmitted run to be submitted when resubmitted, got %v", run.SubmittedAt())
+	}
+}
+
+func TestSpotInterruptionPolicy_Resubmit(t *testing.T) {
+	policy := NewSpotInterruptionPolicy(araTestConfig{
+		"eks_spot_interruptions_before_ondemand": 2,
+		"eks_spot_interruptions_max_resubmits":   3,
+	})
+	expected := []struct {
+		lifecycle string
+		ok        bool
+	}{
+		{SpotLifecycle, true},
+		{OndemandLifecycle, true},
+		{OndemandLifecycle, true},
+		{"", false},
+	}
+	for i, e := range expected {
+		lifecycle, ok := policy.Resubmit(int64(i + 1))
+		if lifecycle != e.lifecycle || ok != e.ok {
+			t.Errorf("Interruption %d: expected [%s, %v], got [%s, %v]", i+1, e.lifecycle, e.ok, lifecycle, ok)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package state
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/utils"
+)
+
+// Volume is mounted into a run's container at MountPath; exactly one of
+// EmptyDir, Claim and ConfigFiles is set.
+type Volume struct {
+	Name        string            `json:"name"`
+	MountPath   string            `json:"mount_path"`
+	ReadOnly    bool              `json:"read_only,omitempty"`
+	EmptyDir    *EmptyDirVolume   `json:"empty_dir,omitempty"`
+	Claim       *string           `json:"claim,omitempty"`
+	ConfigFiles map[string]string `json:"config_files,omitempty"`
+}
+
+// EmptyDirVolume is scratch space that lives as long as the pod; SizeLimit
+// is in MB and Medium is either disk ("") or "Memory".
+type EmptyDirVolume struct {
+	SizeLimit int64  `json:"size_limit"`
+	Medium    string `json:"medium,omitempty"`
+}
+
+// VolumeList wraps a list of Volumes
+type VolumeList []Volume
+
+// ValidateMountPaths returns the reasons the volumes can't all be mounted,
+// e.g. those of a run layered over its executable's.
+func ValidateMountPaths(volumes *VolumeList) []string {
+	var reasons []string
+	if volumes == nil {
+		return reasons
+	}
+	mountPaths := make(map[string]string)
+	for _, v := range *volumes {
+		mountPath := path.Clean(v.MountPath)
+		if other, ok := mountPaths[mountPath]; ok {
+			reasons = append(reasons, fmt.Sprintf("volumes [%s] and [%s] have the same mount_path [%s]", other, v.Name, v.MountPath))
+			continue
+		}
+		mountPaths[mountPath] = v.Name
+	}
+	return reasons
+}
+
+// MergeVolumes layers a run's volumes over its executable's; a run volume
+// replaces the executable's volume of the same name.
+func MergeVolumes(base *VolumeList, override *VolumeList) *VolumeList {
+	if override == nil || len(*override) == 0 {
+		return base
+	}
+	if base == nil || len(*base) == 0 {
+		return override
+	}
+	merged := VolumeList{}
+	for _, v := range *base {
+		replaced := false
+		for _, o := range *override {
+			if o.Name == v.Name {
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, v)
+		}
+	}
+	merged = append(merged, *override...)
+	return &merged
+}
+
+// ReservedVolumeNames are used by volumes the platform mounts itself.
+var ReservedVolumeNames = []string{"shared-memory", "scratch", "shared-lib-volume"}
+
+var (
+	volumeNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	fileNamePattern   = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	emptyDirMediums   = []string{"", "Memory"}
+)
+
+// VolumePolicy is the admin allowlist of claims users may mount and the
+// limits on scratch space and inline config files
+type VolumePolicy struct {
+	AllowedClaims      []string
+	MaxEmptyDirSize    int64
+	MaxConfigFilesSize int64
+}
+
+// NewVolumePolicy reads the volume limits from config; no claims are allowed
+// by default, scratch is capped at 100GB and config files at 256KB
+func NewVolumePolicy(conf config.Config) VolumePolicy {
+	policy := VolumePolicy{
+		MaxEmptyDirSize:    102400,
+		MaxConfigFilesSize: 262144,
+	}
+	if conf.IsSet("eks_volumes_allowed_claims") {
+		policy.AllowedClaims = conf.GetStringSlice("eks_volumes_allowed_claims")
+	}
+	if conf.IsSet("eks_volumes_max_empty_dir_size") {
+		policy.MaxEmptyDirSize = int64(conf.GetInt("eks_volumes_max_empty_dir_size"))
+	}
+	if conf.IsSet("eks_volumes_max_config_files_size") {
+		policy.MaxConfigFilesSize = int64(conf.GetInt("eks_volumes_max_config_files_size"))
+	}
+	return policy
+}
+
+// Validate returns the reasons a volume list is not permitted.
+func (vp VolumePolicy) Validate(volumes *VolumeList) []string {
+	var reasons []string
+	if volumes == nil {
+		return reasons
+	}
+	reasons = append(reasons, ValidateMountPaths(volumes)...)
+	names := make(map[string]bool)
+	for _, v := range *volumes {
+		if !volumeNamePattern.MatchString(v.Name) || len(v.Name) > 63 {
+			reasons = append(reasons, fmt.Sprintf("volume name [%s] must be a lowercase DNS label", v.Name))
+		} else if utils.StringSliceContains(ReservedVolumeNames, v.Name) {
+			reasons = append(reasons, fmt.Sprintf("volume name [%s] is reserved", v.Name))
+		} else if names[v.Name] {
+			reasons = append(reasons, fmt.Sprintf("volume name [%s] is used more than once", v.Name))
+		}
+		names[v.Name] = true
+
+		if !path.IsAbs(v.MountPath) || path.Clean(v.MountPath) == "/" {
+			reasons = append(reasons, fmt.Sprintf("volume [%s] mount_path must be an absolute path", v.Name))
+		}
+
+		sources := 0
+		if v.EmptyDir != nil {
+			sources++
+			if v.EmptyDir.SizeLimit <= 0 || v.EmptyDir.SizeLimit > vp.MaxEmptyDirSize {
+				reasons = append(reasons, fmt.Sprintf("volume [%s] empty_dir size_limit must be within (0, %d] MB", v.Name, vp.MaxEmptyDirSize))
+			}
+			if !utils.StringSliceContains(emptyDirMediums, v.EmptyDir.Medium) {
+				reasons = append(reasons, fmt.Sprintf("volume [%s] empty_dir medium [%s] must be empty or Memory", v.Name, v.EmptyDir.Medium))
+			}
+		}
+		if v.Claim != nil {
+			sources++
+			if !utils.StringSliceContains(vp.AllowedClaims, *v.Claim) {
+				reasons = append(reasons, fmt.Sprintf("volume [%s] claim [%s] is not allowed; allowed claims: %v", v.Name, *v.Claim, vp.AllowedClaims))
+			}
+		}
+		if len(v.ConfigFiles) > 0 {
+			sources++
+			var size int64
+			for name, contents := range v.ConfigFiles {
+				if !fileNamePattern.MatchString(name) || name == "." || name == ".." {
+					reasons = append(reasons, fmt.Sprintf("volume [%s] config file name [%s] is invalid", v.Name, name))
+				}
+				size += int64(len(contents))
+			}
+			if size > vp.MaxConfigFilesSize {
+				reasons = append(reasons, fmt.Sprintf("volume [%s] config_files may not exceed %d bytes", v.Name, vp.MaxConfigFilesSize))
+			}
+		}
+		if sources != 1 {
+			reasons = append(reasons, fmt.Sprintf("volume [%s] must set exactly one of [empty_dir, claim, config_files]", v.Name))
+		}
+	}
+	return reasons
+}
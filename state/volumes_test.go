@@ -0,0 +1,60 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestVolumePolicy_Validate(t *testing.T) {
+	policy := NewVolumePolicy(araTestConfig{
+		"eks_volumes_allowed_claims": []string{"shared-models"},
+	})
+	claim := "shared-models"
+	valid := &VolumeList{
+		{Name: "tmp", MountPath: "/tmp/work", EmptyDir: &EmptyDirVolume{SizeLimit: 1024}},
+		{Name: "models", MountPath: "/models", ReadOnly: true, Claim: &claim},
+		{Name: "conf", MountPath: "/etc/app", ConfigFiles: map[string]string{"app.yaml": "a: 1"}},
+	}
+	if reasons := policy.Validate(valid); len(reasons) > 0 {
+		t.Errorf("Expected volumes to be valid, got %v", reasons)
+	}
+
+	other := "someone-elses"
+	invalid := &VolumeList{
+		{Name: "scratch", MountPath: "/scratch", EmptyDir: &EmptyDirVolume{SizeLimit: 1024}},
+		{Name: "data", MountPath: "data", Claim: &other},
+		{Name: "big", MountPath: "/big", EmptyDir: &EmptyDirVolume{SizeLimit: 1024000}},
+		{Name: "none", MountPath: "/none"},
+	}
+	if reasons := policy.Validate(invalid); len(reasons) != 5 {
+		t.Errorf("Expected 5 reasons, got %v", reasons)
+	}
+}
+
+func TestValidateMountPaths(t *testing.T) {
+	volumes := &VolumeList{
+		{Name: "a", MountPath: "/data"},
+		{Name: "b", MountPath: "/data/"},
+		{Name: "c", MountPath: "/data/c"},
+	}
+	if reasons := ValidateMountPaths(volumes); len(reasons) != 1 {
+		t.Errorf("Expected 1 reason, got %v", reasons)
+	}
+	if reasons := ValidateMountPaths(nil); len(reasons) > 0 {
+		t.Errorf("Expected no volumes to be valid, got %v", reasons)
+	}
+}
+
+func TestMergeVolumes(t *testing.T) {
+	base := &VolumeList{
+		{Name: "a", MountPath: "/a"},
+		{Name: "b", MountPath: "/b"},
+	}
+	override := &VolumeList{{Name: "b", MountPath: "/other"}}
+	merged := MergeVolumes(base, override)
+	if len(*merged) != 2 || (*merged)[1].MountPath != "/other" {
+		t.Errorf("Expected run volume to replace the executable's, got %v", *merged)
+	}
+	if MergeVolumes(base, nil) != base || MergeVolumes(nil, override) != override {
+		t.Errorf("Expected an empty side to return the other")
+	}
+}
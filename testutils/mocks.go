@@ -3,11 +3,16 @@ package testutils
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"io"
 	"math"
 	"net/http"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/stitchfix/flotilla-os/clients/cluster"
 	"github.com/stitchfix/flotilla-os/config"
+	"github.com/stitchfix/flotilla-os/exceptions"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	"github.com/stitchfix/flotilla-os/queue"
 	"github.com/stitchfix/flotilla-os/state"
@@ -30,14 +35,26 @@ type ImplementsAllTheThings struct {
 	StatusUpdatesAsRuns     []state.Run                 // List of queued status updates (Execution Engine)
 	ExecuteError            error                       // Execution Engine - error to return
 	ExecuteErrorIsRetryable bool                        // Execution Engine - is the run retryable?
+	ExecuteStatus           string                      // Execution Engine - status of the launched run
+	FetchedRuns             map[string]state.Run        // Execution Engine - runs returned by FetchUpdateStatus
+	EnqueueError            error                       // Execution Engine - error to return from Enqueue
 	Groups                  []string
 	Tags                    []string
 	Templates               map[string]state.Template
+	ResourceUsage           map[string][]state.ResourceUsageStats // Usage stats by definition id
+	Dispatch                state.DispatchState                   // Dispatch switch stored in "state"
+	IdempotencyKeys         map[string]string                     // Run ids by executable id and idempotency key
+	ExecutorStats           state.SparkExecutorStats              // Spark executor stats returned by "state"
+	SizingStats             state.SparkSizingStats                // Spark sizing stats returned by "state"
+	ExitRules               []state.ExitRule                      // Exit rules stored in "state"
+	LogText                 string                                // Logs written by "LogsText"
+	LoggedExceptions        map[string][]state.LoggedException    // Logged exceptions by run id
 }
 
 func (iatt *ImplementsAllTheThings) LogsText(executable state.Executable, run state.Run, w http.ResponseWriter) error {
 	iatt.Calls = append(iatt.Calls, "LogsText")
-	return nil
+	_, err := io.WriteString(w, iatt.LogText)
+	return err
 }
 
 func (iatt *ImplementsAllTheThings) Log(keyvals ...interface{}) error {
@@ -89,6 +106,31 @@ func (iatt *ImplementsAllTheThings) GetTaskHistoricalRuntime(executableID string
 	return 1.0, nil
 }
 
+// GetUsageReport - StateManager
+func (iatt *ImplementsAllTheThings) GetUsageReport(req state.UsageReportRequest) (state.UsageReport, error) {
+	iatt.Calls = append(iatt.Calls, "GetUsageReport")
+	report := state.UsageReport{GroupBy: req.GroupBy, Since: req.Since, Until: req.Until}
+	stats := make(map[string]*state.UsageStats)
+	for _, r := range iatt.Runs {
+		key := r.GroupName
+		if _, ok := stats[key]; !ok {
+			stats[key] = &state.UsageStats{Key: key, TopExitReasons: []state.ExitReasonCount{}}
+		}
+		stats[key].TotalRuns++
+	}
+	for _, s := range stats {
+		report.Stats = append(report.Stats, *s)
+	}
+	report.Total = len(report.Stats)
+	return report, nil
+}
+
+// GetResourceUsageStats - StateManager
+func (iatt *ImplementsAllTheThings) GetResourceUsageStats(definitionID string, lookbackDays int) ([]state.ResourceUsageStats, error) {
+	iatt.Calls = append(iatt.Calls, "GetResourceUsageStats")
+	return iatt.ResourceUsage[definitionID], nil
+}
+
 // ListDefinitions - StateManager
 func (iatt *ImplementsAllTheThings) ListDefinitions(
 	limit int, offset int, sortBy string,
@@ -150,20 +192,41 @@ func (iatt *ImplementsAllTheThings) DeleteDefinition(definitionID string) error
 // ListRuns - StateManager
 func (iatt *ImplementsAllTheThings) ListRuns(limit int, offset int, sortBy string, order string, filters map[string][]string, envFilters map[string]string, engines []string) (state.RunList, error) {
 	iatt.Calls = append(iatt.Calls, "ListRuns")
-	rl := state.RunList{Total: len(iatt.Runs)}
+	rl := state.RunList{Runs: []state.Run{}}
 	for _, r := range iatt.Runs {
-		rl.Runs = append(rl.Runs, r)
+		if runHasEnv(r, envFilters) {
+			rl.Runs = append(rl.Runs, r)
+		}
 	}
+	rl.Total = len(rl.Runs)
 	return rl, nil
 }
 
+// runHasEnv is true when the run's environment has every filtered value
+func runHasEnv(r state.Run, envFilters map[string]string) bool {
+	for name, value := range envFilters {
+		found := false
+		if r.Env != nil {
+			for _, e := range *r.Env {
+				if e.Name == name && e.Value == value {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 // GetRun - StateManager
 func (iatt *ImplementsAllTheThings) GetRun(runID string) (state.Run, error) {
 	iatt.Calls = append(iatt.Calls, "GetRun")
 	var err error
 	r, ok := iatt.Runs[runID]
 	if !ok {
-		err = fmt.Errorf("No run %s", runID)
+		err = exceptions.MissingResource{ErrorString: fmt.Sprintf("No run %s", runID)}
 	}
 	return r, err
 }
@@ -178,6 +241,91 @@ func (iatt *ImplementsAllTheThings) GetRunByEMRJobId(emrJobId string) (state.Run
 	return r, err
 }
 
+// ClaimIdempotencyKey - StateManager
+func (iatt *ImplementsAllTheThings) ClaimIdempotencyKey(executableID string, key string, runID string, retention time.Duration) (string, error) {
+	iatt.Calls = append(iatt.Calls, "ClaimIdempotencyKey")
+	if iatt.IdempotencyKeys == nil {
+		iatt.IdempotencyKeys = make(map[string]string)
+	}
+	if holder, ok := iatt.IdempotencyKeys[executableID+"/"+key]; ok {
+		return holder, nil
+	}
+	iatt.IdempotencyKeys[executableID+"/"+key] = runID
+	return runID, nil
+}
+
+// ReleaseIdempotencyKey - StateManager
+func (iatt *ImplementsAllTheThings) ReleaseIdempotencyKey(executableID string, key string, runID string) error {
+	iatt.Calls = append(iatt.Calls, "ReleaseIdempotencyKey")
+	if iatt.IdempotencyKeys[executableID+"/"+key] == runID {
+		delete(iatt.IdempotencyKeys, executableID+"/"+key)
+	}
+	return nil
+}
+
+// ClaimConcurrencySlot - StateManager
+func (iatt *ImplementsAllTheThings) ClaimConcurrencySlot(run state.Run, maxConcurrent int64) ([]string, bool, error) {
+	iatt.Calls = append(iatt.Calls, "ClaimConcurrencySlot")
+	var active []string
+	for _, r := range iatt.Runs {
+		if r.RunID != run.RunID && r.ExecutableID != nil && run.ExecutableID != nil && *r.ExecutableID == *run.ExecutableID &&
+			(r.Status == state.StatusPending || r.Status == state.StatusRunning ||
+				(r.Status == state.StatusQueued && r.SparkExtension != nil &&
+					(r.SparkExtension.EMRJobId != nil || r.SparkExtension.SparkApplication != nil))) {
+			active = append(active, r.RunID)
+		}
+	}
+	sort.Strings(active)
+	if int64(len(active)) >= maxConcurrent {
+		return active, false, nil
+	}
+	run.Status = state.StatusPending
+	iatt.Runs[run.RunID] = run
+	return active, true, nil
+}
+
+// ReleaseConcurrencySlot - StateManager
+func (iatt *ImplementsAllTheThings) ReleaseConcurrencySlot(runID string) error {
+	iatt.Calls = append(iatt.Calls, "ReleaseConcurrencySlot")
+	if run, ok := iatt.Runs[runID]; ok && run.Status == state.StatusPending {
+		run.Status = state.StatusQueued
+		iatt.Runs[runID] = run
+	}
+	return nil
+}
+
+// CancelPendingRun - StateManager
+func (iatt *ImplementsAllTheThings) CancelPendingRun(runID string, exitReason string) (bool, error) {
+	iatt.Calls = append(iatt.Calls, "CancelPendingRun")
+	run, ok := iatt.Runs[runID]
+	if !ok || run.Status != state.StatusPending || state.IsSubmitted(run) {
+		return false, nil
+	}
+	exitCode := int64(1)
+	finishedAt := time.Now()
+	run.Status = state.StatusStopped
+	run.ExitReason = &exitReason
+	run.ExitCode = &exitCode
+	run.FinishedAt = &finishedAt
+	iatt.Runs[runID] = run
+	return true, nil
+}
+
+// HoldRun - StateManager
+func (iatt *ImplementsAllTheThings) HoldRun(runID string) (state.Run, error) {
+	iatt.Calls = append(iatt.Calls, "HoldRun")
+	run, ok := iatt.Runs[runID]
+	if !ok {
+		return run, fmt.Errorf("No run %s", runID)
+	}
+	if run.Status != state.StatusQueued {
+		return run, exceptions.ConflictingResource{ErrorString: fmt.Sprintf("run [%s] is %s", runID, run.Status)}
+	}
+	run.Status = state.StatusHeld
+	iatt.Runs[runID] = run
+	return run, nil
+}
+
 // CreateRun - StateManager
 func (iatt *ImplementsAllTheThings) CreateRun(r state.Run) error {
 	iatt.Calls = append(iatt.Calls, "CreateRun")
@@ -185,14 +333,14 @@ func (iatt *ImplementsAllTheThings) CreateRun(r state.Run) error {
 	return nil
 }
 
-func (iatt *ImplementsAllTheThings) EstimateRunResources(executableID string, command string) (state.TaskResources, error) {
+func (iatt *ImplementsAllTheThings) EstimateRunResources(executableID string, runID string, policy state.ARAPolicy) (state.TaskResources, error) {
 	iatt.Calls = append(iatt.Calls, "EstimateRunResources")
 	return state.TaskResources{}, nil
 }
 
-func (iatt *ImplementsAllTheThings) EstimateExecutorCount(executableID string, commandHash string) (int64, error) {
-	iatt.Calls = append(iatt.Calls, "EstimateExecutorCount")
-	return 0, nil
+func (iatt *ImplementsAllTheThings) SparkSizingStats(executableID string, commandHash string) (state.SparkSizingStats, error) {
+	iatt.Calls = append(iatt.Calls, "SparkSizingStats")
+	return iatt.SizingStats, nil
 }
 
 func (iatt *ImplementsAllTheThings) ExecutorOOM(executableID string, commandHash string) (bool, error) {
@@ -204,6 +352,11 @@ func (iatt *ImplementsAllTheThings) DriverOOM(executableID string, commandHash s
 	return false, nil
 }
 
+func (iatt *ImplementsAllTheThings) SparkExecutorStats(executableID string, commandHash string) (state.SparkExecutorStats, error) {
+	iatt.Calls = append(iatt.Calls, "SparkExecutorStats")
+	return iatt.ExecutorStats, nil
+}
+
 // UpdateRun - StateManager
 func (iatt *ImplementsAllTheThings) UpdateRun(runID string, updates state.Run) (state.Run, error) {
 	iatt.Calls = append(iatt.Calls, "UpdateRun")
@@ -265,6 +418,9 @@ func (iatt *ImplementsAllTheThings) QurlFor(name string, prefixed bool) (string,
 // Enqueue - ExecutionEngine
 func (iatt *ImplementsAllTheThings) Enqueue(run state.Run) error {
 	iatt.Calls = append(iatt.Calls, "Enqueue")
+	if iatt.EnqueueError != nil {
+		return iatt.EnqueueError
+	}
 	iatt.Queued = append(iatt.Queued, run.RunID)
 	return nil
 }
@@ -332,7 +488,9 @@ func (iatt *ImplementsAllTheThings) GetEvents(run state.Run) (state.PodEventList
 
 func (iatt *ImplementsAllTheThings) FetchUpdateStatus(run state.Run) (state.Run, error) {
 	iatt.Calls = append(iatt.Calls, "FetchUpdateStatus")
-
+	if fetched, ok := iatt.FetchedRuns[run.RunID]; ok {
+		return fetched, nil
+	}
 	return run, nil
 }
 
@@ -350,11 +508,143 @@ func (iatt *ImplementsAllTheThings) CanBeRun(clusterName string, executableResou
 	return true, nil
 }
 
+// GetDispatchState - StateManager
+func (iatt *ImplementsAllTheThings) GetDispatchState() (state.DispatchState, error) {
+	iatt.Calls = append(iatt.Calls, "GetDispatchState")
+	return iatt.Dispatch, nil
+}
+
+// UpdateDispatchState - StateManager
+func (iatt *ImplementsAllTheThings) UpdateDispatchState(update state.DispatchState) (state.DispatchState, error) {
+	iatt.Calls = append(iatt.Calls, "UpdateDispatchState")
+	iatt.Dispatch = update
+	return update, nil
+}
+
+// ListExitRules - StateManager
+func (iatt *ImplementsAllTheThings) ListExitRules() (state.ExitRuleList, error) {
+	iatt.Calls = append(iatt.Calls, "ListExitRules")
+	rules := append([]state.ExitRule{}, iatt.ExitRules...)
+	return state.ExitRuleList{Total: len(rules), Rules: rules}, nil
+}
+
+// CreateExitRule - StateManager
+func (iatt *ImplementsAllTheThings) CreateExitRule(r state.ExitRule) error {
+	iatt.Calls = append(iatt.Calls, "CreateExitRule")
+	iatt.ExitRules = append(iatt.ExitRules, r)
+	return nil
+}
+
+// DeleteExitRule - StateManager
+func (iatt *ImplementsAllTheThings) DeleteExitRule(ruleID string) error {
+	iatt.Calls = append(iatt.Calls, "DeleteExitRule")
+	for i, r := range iatt.ExitRules {
+		if r.RuleID == ruleID {
+			iatt.ExitRules = append(iatt.ExitRules[:i], iatt.ExitRules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("No exit rule %s", ruleID)
+}
+
+// ListExceptionRuns - StateManager
+func (iatt *ImplementsAllTheThings) ListExceptionRuns(limit int, groupName *string) ([]state.Run, error) {
+	iatt.Calls = append(iatt.Calls, "ListExceptionRuns")
+	runs := []state.Run{}
+	for _, run := range iatt.Runs {
+		if run.RunExceptions == nil || (groupName != nil && run.GroupName != *groupName) {
+			continue
+		}
+		if len(runs) < limit {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// SaveLoggedExceptions - StateManager
+func (iatt *ImplementsAllTheThings) SaveLoggedExceptions(runID string, found []state.LoggedException) error {
+	iatt.Calls = append(iatt.Calls, "SaveLoggedExceptions")
+	if iatt.LoggedExceptions == nil {
+		iatt.LoggedExceptions = map[string][]state.LoggedException{}
+	}
+	for _, e := range found {
+		e.RunID = runID
+		iatt.LoggedExceptions[runID] = append(iatt.LoggedExceptions[runID], e)
+	}
+	return nil
+}
+
+// ListLoggedExceptions - StateManager
+func (iatt *ImplementsAllTheThings) ListLoggedExceptions(runID string) (state.LoggedExceptionList, error) {
+	iatt.Calls = append(iatt.Calls, "ListLoggedExceptions")
+	found := append([]state.LoggedException{}, iatt.LoggedExceptions[runID]...)
+	return state.LoggedExceptionList{Total: len(found), Exceptions: found}, nil
+}
+
+// GetExceptionReport - StateManager
+func (iatt *ImplementsAllTheThings) GetExceptionReport(req state.ExceptionReportRequest) (state.ExceptionReport, error) {
+	iatt.Calls = append(iatt.Calls, "GetExceptionReport")
+	report := state.ExceptionReport{Since: req.Since, Until: req.Until, Exceptions: []state.ExceptionGroup{}}
+	groups := map[string]*state.ExceptionGroup{}
+	var order []string
+	for runID, found := range iatt.LoggedExceptions {
+		run := iatt.Runs[runID]
+		if (req.GroupName != nil && run.GroupName != *req.GroupName) ||
+			(req.DefinitionID != nil && run.DefinitionID != *req.DefinitionID) {
+			continue
+		}
+		for _, e := range found {
+			g, ok := groups[e.Fingerprint]
+			if !ok {
+				g = &state.ExceptionGroup{Fingerprint: e.Fingerprint, Kind: e.Kind, ExceptionType: e.ExceptionType, Message: e.Message}
+				groups[e.Fingerprint] = g
+				order = append(order, e.Fingerprint)
+			}
+			g.Runs++
+			g.RecentRuns = append(g.RecentRuns, runID)
+		}
+	}
+	sort.Strings(order)
+	for _, fingerprint := range order {
+		report.Exceptions = append(report.Exceptions, *groups[fingerprint])
+	}
+	report.Total = len(report.Exceptions)
+	return report, nil
+}
+
+// ListRunAccessRecords - StateManager
+func (iatt *ImplementsAllTheThings) ListRunAccessRecords(req state.AccessReportRequest) ([]state.RunAccessRecords, error) {
+	iatt.Calls = append(iatt.Calls, "ListRunAccessRecords")
+	var runs []state.RunAccessRecords
+	for _, run := range iatt.Runs {
+		if run.DefinitionID != req.DefinitionID || run.CloudTrailNotifications == nil {
+			continue
+		}
+		runs = append(runs, state.RunAccessRecords{RunID: run.RunID, FinishedAt: run.FinishedAt, CloudTrailNotifications: *run.CloudTrailNotifications})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].RunID < runs[j].RunID })
+	if len(runs) > req.Limit {
+		runs = runs[:req.Limit]
+	}
+	return runs, nil
+}
+
 // ListClusters - Cluster Client
 func (iatt *ImplementsAllTheThings) ListClusters() ([]string, error) {
 	return []string{"cluster0", "cluster1"}, nil
 }
 
+// Route - Cluster Client
+func (iatt *ImplementsAllTheThings) Route(run state.Run, gpu bool) string {
+	return ""
+}
+
+// Clusters - Cluster Client
+func (iatt *ImplementsAllTheThings) Clusters() ([]cluster.Cluster, error) {
+	return []cluster.Cluster{{Name: "cluster0", Healthy: true}, {Name: "cluster1", Healthy: true}}, nil
+}
+
 // IsImageValid - Registry Client
 func (iatt *ImplementsAllTheThings) IsImageValid(imageRef string) (bool, error) {
 	iatt.Calls = append(iatt.Calls, "IsImageValid")
@@ -415,7 +705,7 @@ func (iatt *ImplementsAllTheThings) PollStatus() (engine.RunReceipt, error) {
 // Execute - Execution Engine
 func (iatt *ImplementsAllTheThings) Execute(executable state.Executable, run state.Run, manager state.Manager) (state.Run, bool, error) {
 	iatt.Calls = append(iatt.Calls, "Execute")
-	return state.Run{}, iatt.ExecuteErrorIsRetryable, iatt.ExecuteError
+	return state.Run{Status: iatt.ExecuteStatus}, iatt.ExecuteErrorIsRetryable, iatt.ExecuteError
 }
 
 // Terminate - Execution Engine
@@ -491,7 +781,7 @@ func (iatt *ImplementsAllTheThings) GetTemplateByVersion(templateName string, te
 	}
 
 	if tpl == nil {
-		return false, *tpl, fmt.Errorf("No template with name: %s", templateName)
+		return false, state.Template{}, nil
 	}
 
 	return true, *tpl, err
@@ -524,7 +814,7 @@ func (iatt *ImplementsAllTheThings) GetLatestTemplateByTemplateName(templateName
 	}
 
 	if tpl == nil {
-		return false, *tpl, fmt.Errorf("No template with name: %s", templateName)
+		return false, state.Template{}, nil
 	}
 
 	return true, *tpl, err
@@ -26,6 +26,7 @@ type cloudtrailWorker struct {
 	queue        string
 	engine       *string
 	s3Client     *s3.S3
+	accounts     state.ServiceAccountPolicy
 }
 
 func (ctw *cloudtrailWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
@@ -37,6 +38,12 @@ func (ctw *cloudtrailWorker) Initialize(conf config.Config, sm state.Manager, ek
 	ctw.queue = conf.GetString("cloudtrail_queue")
 	_ = ctw.qm.Initialize(ctw.conf, "eks")
 
+	accounts, err := state.NewServiceAccountPolicy(conf)
+	if err != nil {
+		return err
+	}
+	ctw.accounts = accounts
+
 	awsRegion := conf.GetString("eks_manifest_storage_options_region")
 	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(awsRegion)}))
 	ctw.s3Client = s3.New(sess, aws.NewConfig().WithRegion(awsRegion))
@@ -102,11 +109,16 @@ func (ctw *cloudtrailWorker) processS3Keys(cloudTrailS3File state.CloudTrailS3Fi
 	}
 }
 
+//
+// processCloudTrailNotifications attributes the records of the sessions of
+// runs, under the role of any service account runs may use, to their runs
+//
 func (ctw *cloudtrailWorker) processCloudTrailNotifications(ctn state.CloudTrailNotifications) {
-	sa := ctw.conf.GetString("eks_service_account")
 	runIdRecordMap := make(map[string][]state.Record)
 	for _, record := range ctn.Records {
-		if strings.Contains(record.UserIdentity.Arn, sa) && strings.Contains(record.UserIdentity.Arn, "eks-") {
+		if ctw.accounts.MatchesSession(record.UserIdentity.Arn) && strings.Contains(record.UserIdentity.Arn, "eks-") {
+			record.Resources = record.AccessedResources()
+			record.RequestParameters = nil
 			runId := ctw.getRunId(record)
 			runIdRecordMap[runId] = append(runIdRecordMap[runId], record)
 		}
@@ -130,14 +142,27 @@ func (ctw *cloudtrailWorker) processCloudTrailNotifications(ctn state.CloudTrail
 	}
 }
 
+//
+// makeSet keeps one record of the same call and outcome, with the number of
+// calls, the time of the last one and the resources they were made on
+//
 func (ctw *cloudtrailWorker) makeSet(records []state.Record) []state.Record {
-	keys := make(map[string]bool)
+	keys := make(map[string]int)
 	var set []state.Record
 	for _, record := range records {
-		if _, value := keys[record.String()]; !value {
-			keys[record.String()] = true
-			set = append(set, record)
+		i, value := keys[record.String()]
+		if !value {
+			keys[record.String()] = len(set)
+			set = append(set, state.Record{
+				UserIdentity: record.UserIdentity,
+				EventSource:  record.EventSource,
+				EventName:    record.EventName,
+				AWSRegion:    record.AWSRegion,
+				ErrorCode:    record.ErrorCode,
+			})
+			i = len(set) - 1
 		}
+		set[i].Merge(record)
 	}
 	return set
 }
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	gklog "github.com/go-kit/kit/log"
+	"github.com/stitchfix/flotilla-os/config"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+)
+
+func TestCloudtrailWorker_ProcessCloudTrailNotifications(t *testing.T) {
+	os.Setenv("EKS_SERVICE_ACCOUNT", "flotilla-jobs")
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	l := gklog.NewLogfmtLogger(gklog.NewSyncWriter(os.Stderr))
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			"eks-run": {RunID: "eks-run", CloudTrailNotifications: &state.CloudTrailNotifications{Records: []state.Record{
+				{EventSource: "s3.amazonaws.com", EventName: "GetObject", EventTime: "2022-01-10T09:00:00Z"},
+			}}},
+		},
+	}
+	accounts, _ := state.NewServiceAccountPolicy(c)
+	accounts.Accounts["etl"] = state.ServiceAccount{
+		Name: "etl", RoleArn: "arn:aws:iam::123456789012:role/teams/flotilla-etl", Groups: []string{"etl"}}
+	ctw := &cloudtrailWorker{sm: &imp, conf: c, log: flotillaLog.NewLogger(l, nil), accounts: accounts}
+
+	arn := "arn:aws:sts::123:assumed-role/flotilla-jobs/eks-run"
+	get := state.Record{
+		UserIdentity: state.UserIdentity{Arn: arn},
+		EventSource:  "s3.amazonaws.com",
+		EventName:    "GetObject",
+		EventTime:    "2022-01-10T10:00:00Z",
+	}
+	put := state.Record{
+		UserIdentity:      state.UserIdentity{Arn: arn},
+		EventSource:       "s3.amazonaws.com",
+		EventName:         "PutObject",
+		ErrorCode:         "AccessDenied",
+		RequestParameters: map[string]interface{}{"bucketName": "data", "key": "out/a.csv"},
+	}
+	sqs := state.Record{
+		UserIdentity: state.UserIdentity{Arn: "arn:aws:sts::123:assumed-role/flotilla-etl/eks-run"},
+		EventSource:  "sqs.amazonaws.com",
+		EventName:    "SendMessage",
+	}
+	other := state.Record{UserIdentity: state.UserIdentity{Arn: "arn:aws:sts::123:assumed-role/other/eks-run"}}
+	ctw.processCloudTrailNotifications(state.CloudTrailNotifications{Records: []state.Record{get, get, put, sqs, other}})
+
+	records := imp.Runs["eks-run"].CloudTrailNotifications.Records
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 distinct records, got %v", records)
+	}
+	if records[2].EventName != "SendMessage" {
+		t.Errorf("Expected the record of the role of a service account, got %v", records[2])
+	}
+	if records[0].Count != 3 || records[0].EventTime != "2022-01-10T10:00:00Z" {
+		t.Errorf("Expected 3 calls of GetObject, the last at 10:00, got %v", records[0])
+	}
+	if len(records[1].Resources) != 2 || records[1].RequestParameters != nil || records[1].Count != 1 {
+		t.Errorf("Expected the resources of the denied PutObject without its request, got %v", records[1])
+	}
+}
+
+func TestCloudtrailWorker_ProcessCloudTrailNotificationsTwice(t *testing.T) {
+	os.Setenv("EKS_SERVICE_ACCOUNT", "flotilla-jobs")
+	confDir := "../conf"
+	c, _ := config.NewConfig(&confDir)
+	l := gklog.NewLogfmtLogger(gklog.NewSyncWriter(os.Stderr))
+	imp := testutils.ImplementsAllTheThings{
+		T:    t,
+		Runs: map[string]state.Run{"eks-run": {RunID: "eks-run"}},
+	}
+	accounts, _ := state.NewServiceAccountPolicy(c)
+	ctw := &cloudtrailWorker{sm: &imp, conf: c, log: flotillaLog.NewLogger(l, nil), accounts: accounts}
+
+	arn := "arn:aws:sts::123:assumed-role/flotilla-jobs/eks-run"
+	var records []state.Record
+	for i := 0; i < 30; i++ {
+		records = append(records, state.Record{
+			EventID:           fmt.Sprintf("event-%d", i),
+			UserIdentity:      state.UserIdentity{Arn: arn},
+			EventSource:       "s3.amazonaws.com",
+			EventName:         "GetObject",
+			RequestParameters: map[string]interface{}{"bucketName": "data", "key": fmt.Sprintf("raw/%d/part-0.parquet", i)},
+		})
+	}
+	ctn := state.CloudTrailNotifications{Records: records}
+	ctw.processCloudTrailNotifications(ctn)
+	ctw.processCloudTrailNotifications(ctn)
+
+	kept := imp.Runs["eks-run"].CloudTrailNotifications.Records
+	if len(kept) != 1 || kept[0].Count != 30 {
+		t.Fatalf("Expected 30 calls of GetObject counted once, got %v", kept)
+	}
+	if len(kept[0].Resources) != 2 || kept[0].Resources[1].ARN != "arn:aws:s3:::data/raw/*" {
+		t.Errorf("Expected the bucket and the prefix of its objects, got %v", kept[0].Resources)
+	}
+}
@@ -0,0 +1,189 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stitchfix/flotilla-os/state"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Sources of the Kubernetes events of the events worker.
+const (
+	eventSourceQueue = "queue"
+	eventSourceWatch = "watch"
+)
+
+//
+// initializeWatchers creates the clients of the clusters in `eks_clusters`
+// whose events are watched, and the redis client that keeps the events
+// worker of each process from recording an event the others have
+//
+func (ew *eventsWorker) initializeWatchers(clusters []string) error {
+	ew.watchClients = make(map[string]kubernetes.Clientset)
+	for _, clusterName := range clusters {
+		filename := fmt.Sprintf("%s/%s", ew.conf.GetString("eks_kubeconfig_basepath"), clusterName)
+		clientConf, err := clientcmd.BuildConfigFromFlags("", filename)
+		if err != nil {
+			return err
+		}
+		kClient, err := kubernetes.NewForConfig(clientConf)
+		if err != nil {
+			return err
+		}
+		ew.watchClients[clusterName] = *kClient
+	}
+	ew.watched = make(chan state.KubernetesEvent, 1000)
+	ew.setupRedisClient(ew.conf)
+	return nil
+}
+
+//
+// watchEvents watches the events of every namespace of a cluster from the
+// time it starts, and passes on the events of Flotilla's jobs and pods and
+// of interrupted nodes; expired watches start over from the current events
+//
+func (ew *eventsWorker) watchEvents(clusterName string, kClient kubernetes.Clientset) {
+	timeout := int64(300)
+	resourceVersion := ""
+	for {
+		select {
+		case <-ew.t.Dying():
+			return
+		default:
+		}
+		if len(resourceVersion) == 0 {
+			list, err := kClient.CoreV1().Events("").List(metav1.ListOptions{Limit: 1})
+			if err != nil {
+				_ = ew.log.Log("message", "error listing kubernetes events", "cluster", clusterName, "error", fmt.Sprintf("%+v", err))
+				time.Sleep(ew.pollInterval)
+				continue
+			}
+			resourceVersion = list.ResourceVersion
+		}
+		watcher, err := kClient.CoreV1().Events("").Watch(metav1.ListOptions{
+			ResourceVersion: resourceVersion,
+			TimeoutSeconds:  &timeout,
+		})
+		if err != nil {
+			_ = ew.log.Log("message", "error watching kubernetes events", "cluster", clusterName, "error", fmt.Sprintf("%+v", err))
+			resourceVersion = ""
+			time.Sleep(ew.pollInterval)
+			continue
+		}
+		for change := range watcher.ResultChan() {
+			if change.Type == watch.Error {
+				if status := apierrors.FromObject(change.Object); apierrors.IsResourceExpired(status) || apierrors.IsGone(status) {
+					resourceVersion = ""
+				}
+				break
+			}
+			event, ok := change.Object.(*corev1.Event)
+			if !ok || (change.Type != watch.Added && change.Type != watch.Modified) {
+				continue
+			}
+			resourceVersion = event.ResourceVersion
+			kubernetesEvent, ok := ew.toKubernetesEvent(clusterName, event)
+			if !ok || !ew.claimEvent(clusterName, event) {
+				continue
+			}
+			select {
+			case ew.watched <- kubernetesEvent:
+			case <-ew.t.Dying():
+				watcher.Stop()
+				return
+			}
+		}
+		watcher.Stop()
+	}
+}
+
+//
+// runOnceWatch records the watched events received since the last poll
+//
+func (ew *eventsWorker) runOnceWatch() {
+	for {
+		select {
+		case kubernetesEvent := <-ew.watched:
+			ew.processEvent(kubernetesEvent)
+		default:
+			return
+		}
+	}
+}
+
+//
+// toKubernetesEvent converts the events of Flotilla's jobs and their pods,
+// of pods of Spark runs and of interrupted nodes to the events the queue
+// carries; a job's pods are named after it
+//
+func (ew *eventsWorker) toKubernetesEvent(clusterName string, event *corev1.Event) (state.KubernetesEvent, bool) {
+	object := event.InvolvedObject
+	kubernetesEvent := state.KubernetesEvent{
+		Reason:         event.Reason,
+		Message:        event.Message,
+		FirstTimestamp: kubernetesTimestamp(event.FirstTimestamp, event.EventTime),
+		LastTimestamp:  kubernetesTimestamp(event.LastTimestamp, event.EventTime),
+		Count:          int64(event.Count),
+		Type:           event.Type,
+		InvolvedObject: state.InvolvedObject{
+			Kind:            object.Kind,
+			Namespace:       object.Namespace,
+			Name:            object.Name,
+			Uid:             string(object.UID),
+			APIVersion:      object.APIVersion,
+			ResourceVersion: object.ResourceVersion,
+			FieldPath:       object.FieldPath,
+		},
+		Cluster: clusterName,
+		Done:    func() error { return nil },
+	}
+	switch {
+	case object.Kind == "Node":
+		return kubernetesEvent, state.IsSpotInterruption(event.Reason, event.Message)
+	case object.Kind == "Job" && object.Namespace == ew.jobNamespace && strings.HasPrefix(object.Name, "eks"):
+		kubernetesEvent.InvolvedObject.Labels.JobName = object.Name
+		return kubernetesEvent, true
+	case object.Kind == "Pod" && object.Namespace == ew.jobNamespace && strings.HasPrefix(object.Name, "eks"):
+		if i := strings.LastIndex(object.Name, "-"); i > 0 {
+			kubernetesEvent.InvolvedObject.Labels.JobName = object.Name[:i]
+		}
+		return kubernetesEvent, true
+	case object.Kind == "Pod" && object.Namespace == ew.emrJobNamespace:
+		return kubernetesEvent, true
+	}
+	return kubernetesEvent, false
+}
+
+//
+// claimEvent is true for the events worker that first sees a version of an
+// event; without redis every worker records it
+//
+func (ew *eventsWorker) claimEvent(clusterName string, event *corev1.Event) bool {
+	if ew.redisClient == nil {
+		return true
+	}
+	key := fmt.Sprintf("event-%s-%s-%s", clusterName, event.UID, event.ResourceVersion)
+	claimed, err := ew.redisClient.SetNX(key, "1", time.Hour).Result()
+	if err != nil {
+		_ = ew.log.Log("message", "unable to claim kubernetes event", "error", fmt.Sprintf("%+v", err))
+		return true
+	}
+	return claimed
+}
+
+func kubernetesTimestamp(t metav1.Time, eventTime metav1.MicroTime) string {
+	if !t.IsZero() {
+		return t.UTC().Format(time.RFC3339)
+	}
+	if !eventTime.IsZero() {
+		return eventTime.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
@@ -35,8 +36,17 @@ type eventsWorker struct {
 	emrMetricsServer  string
 	eksMetricsServer  string
 	emrMaxPodEvents   int
+	maxPodEvents      int
 	eksEngine         engine.Engine
 	emrEngine         engine.Engine
+	exitClassifiers   *state.ExitClassifierCache
+	exceptions        *exceptionExtractor
+	eventSource       string
+	jobNamespace      string
+	emrJobNamespace   string
+	watchClients      map[string]kubernetes.Clientset
+	watched           chan state.KubernetesEvent
+	redisClient       *redis.Client
 }
 
 func (ew *eventsWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
@@ -53,13 +63,32 @@ func (ew *eventsWorker) Initialize(conf config.Config, sm state.Manager, eksEngi
 	ew.emrAppServer = conf.GetString("emr_app_server_uri")
 	ew.emrMetricsServer = conf.GetString("emr_metrics_server_uri")
 	ew.eksMetricsServer = conf.GetString("eks_metrics_server_uri")
-	if conf.IsSet("emr_max_attempt_count") {
+	if conf.IsSet("emr_max_pod_events") {
 		ew.emrMaxPodEvents = conf.GetInt("emr_max_pod_events")
 	} else {
 		ew.emrMaxPodEvents = 20000
 	}
+	ew.maxPodEvents = 500
+	if conf.IsSet("eks_max_pod_events") {
+		ew.maxPodEvents = conf.GetInt("eks_max_pod_events")
+	}
+	ew.eventSource = eventSourceQueue
+	if conf.IsSet("eks_events_source") {
+		ew.eventSource = conf.GetString("eks_events_source")
+	}
+	if ew.eventSource != eventSourceQueue && ew.eventSource != eventSourceWatch {
+		return errors.Errorf("eks_events_source must be one of [%s, %s]", eventSourceQueue, eventSourceWatch)
+	}
+	ew.jobNamespace = conf.GetString("eks_job_namespace")
+	ew.emrJobNamespace = conf.GetString("emr_job_namespace")
+	exitClassifiers, rulesErr := state.NewExitClassifierCache(conf)
+	if rulesErr != nil {
+		return rulesErr
+	}
+	ew.exitClassifiers = exitClassifiers
+	ew.exceptions = newExceptionExtractor(conf, sm, exitClassifiers, log)
 
-	if err != nil {
+	if err != nil && ew.eventSource == eventSourceQueue {
 		_ = ew.log.Log("message", "Error receiving Kubernetes Event queue", "error", fmt.Sprintf("%+v", err))
 		return nil
 	}
@@ -81,21 +110,37 @@ func (ew *eventsWorker) Initialize(conf config.Config, sm state.Manager, eksEngi
 		return err
 	}
 	ew.kClient = *kClient
+	if ew.eventSource == eventSourceWatch {
+		return ew.initializeWatchers(strings.Split(conf.GetString("eks_clusters"), ","))
+	}
 	return nil
 }
 
+func (ew *eventsWorker) setupRedisClient(conf config.Config) {
+	if conf.IsSet("redis_address") {
+		ew.redisClient = redis.NewClient(&redis.Options{Addr: conf.GetString("redis_address"), DB: conf.GetInt("redis_db")})
+	}
+}
+
 func (ew *eventsWorker) GetTomb() *tomb.Tomb {
 	return &ew.t
 }
 
 func (ew *eventsWorker) Run() error {
+	for clusterName, kClient := range ew.watchClients {
+		go ew.watchEvents(clusterName, kClient)
+	}
 	for {
 		select {
 		case <-ew.t.Dying():
 			_ = ew.log.Log("message", "A CloudTrail worker was terminated")
 			return nil
 		default:
-			ew.runOnce()
+			if ew.eventSource == eventSourceWatch {
+				ew.runOnceWatch()
+			} else {
+				ew.runOnce()
+			}
 			ew.runOnceEMR()
 			time.Sleep(ew.pollInterval)
 		}
@@ -119,66 +164,26 @@ func (ew *eventsWorker) processEventEMR(emrEvent state.EmrEvent) {
 	emrJobId := emrEvent.Detail.ID
 	run, err := ew.sm.GetRunByEMRJobId(*emrJobId)
 	if err == nil {
-		layout := "2020-08-31T17:27:50Z"
-		timestamp, err := time.Parse(layout, *emrEvent.Time)
-		if err != nil {
-			timestamp = time.Now()
+		timestamp := eventTimestamp(emrEvent.Time)
+		wasStopped := run.Status == state.StatusStopped
+		if emrEvent.Detail.State != nil {
+			run.ApplyEMRJobState(*emrEvent.Detail.State, emrEvent.Detail.StateDetails, emrEvent.Detail.FailureReason, timestamp)
 		}
-		switch *emrEvent.Detail.State {
-		case "COMPLETED":
-			run.ExitCode = aws.Int64(0)
-			run.Status = state.StatusStopped
-			run.FinishedAt = &timestamp
-			if run.StartedAt == nil || run.StartedAt.After(*run.FinishedAt) {
-				run.StartedAt = run.QueuedAt
-			}
-			run.ExitReason = emrEvent.Detail.StateDetails
-			// var events state.PodEvents
-			// Pod Events are verbose and should be only stored for failed or running jobs.
-			// run.PodEvents = &events
-		case "RUNNING":
-			run.Status = state.StatusRunning
-			run.StartedAt = &timestamp
-		case "FAILED":
-			run.ExitCode = aws.Int64(-1)
-			run.Status = state.StatusStopped
-			run.FinishedAt = &timestamp
-			if run.StartedAt == nil || run.StartedAt.After(*run.FinishedAt) {
-				run.StartedAt = run.QueuedAt
-			}
-
-			run.ExitReason = aws.String("Job failed, please look at Driver Init and/or Driver Stdout logs.")
-
-			if emrEvent.Detail != nil {
-				if emrEvent.Detail.StateDetails != nil && !strings.Contains(*emrEvent.Detail.StateDetails, "JobRun failed. Please refer logs uploaded") {
-					exitReason := strings.Replace(*emrEvent.Detail.StateDetails, "Please refer logs uploaded to S3/CloudWatch based on your monitoring configuration.", "", -1)
-					run.ExitReason = aws.String(exitReason)
-				} else {
-					if emrEvent.Detail.FailureReason != nil && !strings.Contains(*emrEvent.Detail.FailureReason, "USER_ERROR") {
-						exitReason := strings.Replace(*emrEvent.Detail.FailureReason, "Please refer logs uploaded to S3/CloudWatch based on your monitoring configuration.", "", -1)
-						run.ExitReason = aws.String(exitReason)
-					}
-				}
-			}
-
-			if run.SparkExtension.DriverOOM != nil && *run.SparkExtension.DriverOOM == true {
-				run.ExitReason = aws.String("Driver OOMKilled, retry with more driver memory.")
-				run.ExitCode = aws.Int64(137)
-			}
-
-			if run.SparkExtension.ExecutorOOM != nil && *run.SparkExtension.ExecutorOOM == true {
-				run.ExitReason = aws.String("Executor OOMKilled, retry with more executor memory.")
-				run.ExitCode = aws.Int64(137)
+		// Finished runs keep the last word of their pods and stages.
+		if run.Status == state.StatusStopped {
+			if updated, err := ew.emrEngine.FetchUpdateStatus(run); err == nil {
+				run = updated
 			}
-
-		case "SUBMITTED":
-			run.Status = state.StatusPending
+			classifyExit(ew.sm, ew.exitClassifiers, ew.log, &run)
 		}
 
 		ew.setEMRMetricsUri(&run)
 		_, err = ew.sm.UpdateRun(run.RunID, run)
 		if err == nil {
 			_ = emrEvent.Done()
+			if !wasStopped && run.Status == state.StatusStopped {
+				ew.exceptions.stopped(run)
+			}
 		}
 	}
 }
@@ -192,7 +197,8 @@ func (ew *eventsWorker) runOnce() {
 }
 func (ew *eventsWorker) processEMRPodEvents(kubernetesEvent state.KubernetesEvent) {
 	if kubernetesEvent.InvolvedObject.Kind == "Pod" {
-		pod, err := ew.kClient.CoreV1().Pods(kubernetesEvent.InvolvedObject.Namespace).Get(kubernetesEvent.InvolvedObject.Name, metav1.GetOptions{})
+		kClient := ew.kubeClient(kubernetesEvent)
+		pod, err := kClient.CoreV1().Pods(kubernetesEvent.InvolvedObject.Namespace).Get(kubernetesEvent.InvolvedObject.Name, metav1.GetOptions{})
 		var emrJobId *string = nil
 		var sparkAppId *string = nil
 		var driverServiceName *string = nil
@@ -245,27 +251,7 @@ func (ew *eventsWorker) processEMRPodEvents(kubernetesEvent state.KubernetesEven
 		if emrJobId != nil {
 			run, err := ew.sm.GetRunByEMRJobId(*emrJobId)
 			if err == nil {
-				layout := "2020-08-31T17:27:50Z"
-				timestamp, err := time.Parse(layout, kubernetesEvent.FirstTimestamp)
-				if err != nil {
-					timestamp = time.Now()
-				}
-
-				event := state.PodEvent{
-					Timestamp:    &timestamp,
-					EventType:    kubernetesEvent.Type,
-					Reason:       kubernetesEvent.Reason,
-					SourceObject: kubernetesEvent.InvolvedObject.Name,
-					Message:      kubernetesEvent.Message,
-				}
-
-				var events state.PodEvents
-				if run.PodEvents != nil {
-					events = append(*run.PodEvents, event)
-				} else {
-					events = state.PodEvents{event}
-				}
-				run.PodEvents = &events
+				ew.recordPodEvent(&run, kubernetesEvent)
 
 				if executorOOM != nil && *executorOOM == true {
 					run.SparkExtension.ExecutorOOM = executorOOM
@@ -274,6 +260,13 @@ func (ew *eventsWorker) processEMRPodEvents(kubernetesEvent state.KubernetesEven
 					run.SparkExtension.DriverOOM = driverOOM
 				}
 
+				if pod != nil && run.SparkExtension != nil {
+					if record, ok := engine.SparkExecutorRecord(pod); ok {
+						record.SpotLost = state.IsSpotInterruption(kubernetesEvent.Reason, kubernetesEvent.Message)
+						run.SparkExtension.RecordExecutor(record)
+					}
+				}
+
 				if sparkAppId != nil {
 					sparkHistoryUri := fmt.Sprintf("%s/%s/jobs/", ew.emrHistoryServer, *sparkAppId)
 
@@ -292,7 +285,7 @@ func (ew *eventsWorker) processEMRPodEvents(kubernetesEvent state.KubernetesEven
 					_ = ew.log.Log("message", "error saving kubernetes events", "emrJobId", emrJobId, "error", fmt.Sprintf("%+v", err))
 				}
 
-				if run.PodEvents != nil && len(*run.PodEvents) >= ew.emrMaxPodEvents {
+				if run.PodEvents != nil && run.PodEvents.Total() >= int64(ew.emrMaxPodEvents) {
 					_ = ew.emrEngine.Terminate(run)
 				}
 
@@ -353,35 +346,20 @@ func (ew *eventsWorker) setEKSMetricsUri(run *state.Run) {
 }
 
 func (ew *eventsWorker) processEvent(kubernetesEvent state.KubernetesEvent) {
+	if kubernetesEvent.InvolvedObject.Kind == "Node" {
+		ew.processNodeEvent(kubernetesEvent)
+		return
+	}
 	runId := kubernetesEvent.InvolvedObject.Labels.JobName
 	if !strings.HasPrefix(runId, "eks") {
 		ew.processEMRPodEvents(kubernetesEvent)
 	}
 
-	layout := "2020-08-31T17:27:50Z"
-	timestamp, err := time.Parse(layout, kubernetesEvent.FirstTimestamp)
-
-	if err != nil {
-		timestamp = time.Now()
-	}
+	timestamp := eventTimestamp(&kubernetesEvent.FirstTimestamp)
 
 	run, err := ew.sm.GetRun(runId)
 	if err == nil {
-		event := state.PodEvent{
-			Timestamp:    &timestamp,
-			EventType:    kubernetesEvent.Type,
-			Reason:       kubernetesEvent.Reason,
-			SourceObject: kubernetesEvent.InvolvedObject.Name,
-			Message:      kubernetesEvent.Message,
-		}
-
-		var events state.PodEvents
-		if run.PodEvents != nil {
-			events = append(*run.PodEvents, event)
-		} else {
-			events = state.PodEvents{event}
-		}
-		run.PodEvents = &events
+		ew.recordPodEvent(&run, kubernetesEvent)
 		if kubernetesEvent.Reason == "Scheduled" {
 			podName, err := ew.parsePodName(kubernetesEvent)
 			if err == nil {
@@ -396,6 +374,7 @@ func (ew *eventsWorker) processEvent(kubernetesEvent state.KubernetesEvent) {
 			run.Status = state.StatusStopped
 			run.StartedAt = run.QueuedAt
 			run.FinishedAt = &timestamp
+			classifyExit(ew.sm, ew.exitClassifiers, ew.log, &run)
 		}
 
 		if kubernetesEvent.Reason == "Completed" {
@@ -416,6 +395,95 @@ func (ew *eventsWorker) processEvent(kubernetesEvent state.KubernetesEvent) {
 	}
 }
 
+//
+// recordPodEvent adds a Kubernetes event to the pod events of a run, counting
+// repeats of recorded events and keeping at most `eks_max_pod_events`
+//
+func (ew *eventsWorker) recordPodEvent(run *state.Run, kubernetesEvent state.KubernetesEvent) {
+	timestamp := eventTimestamp(&kubernetesEvent.FirstTimestamp)
+	lastTimestamp := timestamp
+	if len(kubernetesEvent.LastTimestamp) > 0 {
+		lastTimestamp = eventTimestamp(&kubernetesEvent.LastTimestamp)
+	}
+	var events state.PodEvents
+	if run.PodEvents != nil {
+		events = *run.PodEvents
+	}
+	events = events.Record(state.PodEvent{
+		Timestamp:     &timestamp,
+		LastTimestamp: &lastTimestamp,
+		Count:         kubernetesEvent.Count,
+		EventType:     kubernetesEvent.Type,
+		Reason:        kubernetesEvent.Reason,
+		SourceObject:  kubernetesEvent.InvolvedObject.Name,
+		Message:       kubernetesEvent.Message,
+	}, ew.maxPodEvents)
+	run.PodEvents = &events
+}
+
+// eventTimestamp parses the RFC3339 timestamp of an event, which is now when
+// it is missing or malformed.
+func eventTimestamp(value *string) time.Time {
+	if value != nil {
+		if timestamp, err := time.Parse(time.RFC3339, *value); err == nil {
+			return timestamp
+		}
+	}
+	return time.Now()
+}
+
+// kubeClient returns the client of the cluster of a watched event, and the
+// client of the first cluster otherwise.
+func (ew *eventsWorker) kubeClient(kubernetesEvent state.KubernetesEvent) *kubernetes.Clientset {
+	if kClient, ok := ew.watchClients[kubernetesEvent.Cluster]; ok {
+		return &kClient
+	}
+	return &ew.kClient
+}
+
+//
+// processNodeEvent records node termination notices against the runs with
+// pods on the node, so that the status worker sees them as interrupted
+//
+func (ew *eventsWorker) processNodeEvent(kubernetesEvent state.KubernetesEvent) {
+	if !state.IsSpotInterruption(kubernetesEvent.Reason, kubernetesEvent.Message) {
+		_ = kubernetesEvent.Done()
+		return
+	}
+	pods, err := ew.kubeClient(kubernetesEvent).CoreV1().Pods(ew.conf.GetString("eks_job_namespace")).List(metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", kubernetesEvent.InvolvedObject.Name),
+		LabelSelector: "job-name",
+	})
+	if err != nil {
+		_ = ew.log.Log("message", "error listing pods on interrupted node", "node", kubernetesEvent.InvolvedObject.Name, "error", fmt.Sprintf("%+v", err))
+		return
+	}
+
+	timestamp := time.Now()
+	for _, pod := range pods.Items {
+		run, err := ew.sm.GetRun(pod.Labels["job-name"])
+		if err != nil || run.PodEvents.SpotInterrupted(pod.Name) {
+			continue
+		}
+		events := state.PodEvents{}
+		if run.PodEvents != nil {
+			events = *run.PodEvents
+		}
+		events = append(events, state.PodEvent{
+			Timestamp:    &timestamp,
+			EventType:    kubernetesEvent.Type,
+			Reason:       kubernetesEvent.Reason,
+			SourceObject: pod.Name,
+			Message:      kubernetesEvent.Message,
+		})
+		if _, err = ew.sm.UpdateRun(run.RunID, state.Run{PodEvents: &events}); err != nil {
+			_ = ew.log.Log("message", "error saving node event", "run", run.RunID, "error", fmt.Sprintf("%+v", err))
+			return
+		}
+	}
+	_ = kubernetesEvent.Done()
+}
+
 func (ew *eventsWorker) parsePodName(kubernetesEvent state.KubernetesEvent) (string, error) {
 	expression := regexp.MustCompile(`(eks-\w+-\w+-\w+-\w+-\w+-\w+)`)
 	matches := expression.FindStringSubmatch(kubernetesEvent.Message)
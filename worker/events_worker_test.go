@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	gklog "github.com/go-kit/kit/log"
+	flotillaLog "github.com/stitchfix/flotilla-os/log"
+	"github.com/stitchfix/flotilla-os/state"
+	"github.com/stitchfix/flotilla-os/testutils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func setUpEventsWorkerTest(t *testing.T) (*eventsWorker, *testutils.ImplementsAllTheThings) {
+	l := gklog.NewLogfmtLogger(gklog.NewSyncWriter(os.Stderr))
+	imp := testutils.ImplementsAllTheThings{
+		T: t,
+		Runs: map[string]state.Run{
+			"eks-run": {RunID: "eks-run", Status: state.StatusRunning},
+		},
+	}
+	return &eventsWorker{
+		sm:              &imp,
+		log:             flotillaLog.NewLogger(l, nil),
+		jobNamespace:    "flotilla",
+		emrJobNamespace: "spark",
+		maxPodEvents:    500,
+	}, &imp
+}
+
+func TestEventsWorker_ToKubernetesEvent(t *testing.T) {
+	ew, _ := setUpEventsWorkerTest(t)
+	first := metav1.NewTime(time.Date(2022, 1, 10, 9, 0, 0, 0, time.UTC))
+	event := &corev1.Event{
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "flotilla", Name: "eks-run-abcde"},
+		Reason:         "BackOff",
+		Type:           "Warning",
+		FirstTimestamp: first,
+		Count:          3,
+	}
+	kubernetesEvent, ok := ew.toKubernetesEvent("cluster-a", event)
+	if !ok || kubernetesEvent.InvolvedObject.Labels.JobName != "eks-run" {
+		t.Errorf("Expected the event of the pod of job eks-run, got %+v", kubernetesEvent.InvolvedObject)
+	}
+	if kubernetesEvent.FirstTimestamp != "2022-01-10T09:00:00Z" || kubernetesEvent.LastTimestamp != "" {
+		t.Errorf("Expected an RFC3339 first timestamp, got [%s, %s]", kubernetesEvent.FirstTimestamp, kubernetesEvent.LastTimestamp)
+	}
+
+	event.InvolvedObject = corev1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "eks-run-abcde"}
+	if _, ok := ew.toKubernetesEvent("cluster-a", event); ok {
+		t.Errorf("Expected events of other namespaces to be skipped")
+	}
+	event.InvolvedObject = corev1.ObjectReference{Kind: "Node", Name: "node-a"}
+	if _, ok := ew.toKubernetesEvent("cluster-a", event); ok {
+		t.Errorf("Expected node events other than interruptions to be skipped")
+	}
+	event.Reason = "NodeShutdown"
+	if _, ok := ew.toKubernetesEvent("cluster-a", event); !ok {
+		t.Errorf("Expected node interruptions to be kept")
+	}
+}
+
+func TestEventsWorker_ProcessEvent(t *testing.T) {
+	ew, imp := setUpEventsWorkerTest(t)
+	done := 0
+	kubernetesEvent := state.KubernetesEvent{
+		Reason:         "BackOff",
+		Type:           "Warning",
+		Message:        "Back-off pulling image",
+		FirstTimestamp: "2022-01-10T09:00:00Z",
+		LastTimestamp:  "2022-01-10T09:05:00Z",
+		Count:          2,
+		InvolvedObject: state.InvolvedObject{Kind: "Pod", Name: "eks-run-abcde", Labels: state.Labels{JobName: "eks-run"}},
+		Done:           func() error { done++; return nil },
+	}
+	ew.processEvent(kubernetesEvent)
+	kubernetesEvent.LastTimestamp = "2022-01-10T09:10:00Z"
+	kubernetesEvent.Count = 3
+	ew.processEvent(kubernetesEvent)
+
+	events := *imp.Runs["eks-run"].PodEvents
+	if len(events) != 1 || events[0].Count != 3 || done != 2 {
+		t.Fatalf("Expected one event repeated 3 times, got %+v", events)
+	}
+	if !events[0].Timestamp.Equal(time.Date(2022, 1, 10, 9, 0, 0, 0, time.UTC)) ||
+		!events[0].LastTimestamp.Equal(time.Date(2022, 1, 10, 9, 10, 0, 0, time.UTC)) {
+		t.Errorf("Expected the event's first and last timestamps, got [%v, %v]", events[0].Timestamp, events[0].LastTimestamp)
+	}
+}
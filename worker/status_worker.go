@@ -1,7 +1,6 @@
 package worker
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/go-redis/redis"
@@ -13,26 +12,26 @@ import (
 	"github.com/stitchfix/flotilla-os/queue"
 	"github.com/stitchfix/flotilla-os/state"
 	"gopkg.in/tomb.v2"
-	"io/ioutil"
 	"math/rand"
-	"net/http"
 	"strings"
 	"time"
 )
 
 type statusWorker struct {
-	sm                       state.Manager
-	ee                       engine.Engine
-	conf                     config.Config
-	log                      flotillaLog.Logger
-	pollInterval             time.Duration
-	t                        tomb.Tomb
-	engine                   *string
-	redisClient              *redis.Client
-	workerId                 string
-	exceptionExtractorClient *http.Client
-	exceptionExtractorUrl    string
-	emrEngine                engine.Engine
+	sm              state.Manager
+	ee              engine.Engine
+	conf            config.Config
+	log             flotillaLog.Logger
+	pollInterval    time.Duration
+	t               tomb.Tomb
+	engine          *string
+	redisClient     *redis.Client
+	workerId        string
+	exceptions      *exceptionExtractor
+	emrEngine       engine.Engine
+	spotPolicy      state.SpotInterruptionPolicy
+	emrSilence      time.Duration
+	exitClassifiers *state.ExitClassifierCache
 }
 
 func (sw *statusWorker) Initialize(conf config.Config, sm state.Manager, eksEngine engine.Engine, emrEngine engine.Engine, log flotillaLog.Logger, pollInterval time.Duration, qm queue.Manager) error {
@@ -44,12 +43,17 @@ func (sw *statusWorker) Initialize(conf config.Config, sm state.Manager, eksEngi
 	sw.workerId = fmt.Sprintf("workerid:%d", rand.Int())
 	sw.engine = &state.EKSEngine
 	sw.emrEngine = emrEngine
-	if sw.conf.IsSet("eks_exception_extractor_url") {
-		sw.exceptionExtractorClient = &http.Client{
-			Timeout: time.Second * 5,
-		}
-		sw.exceptionExtractorUrl = sw.conf.GetString("eks_exception_extractor_url")
+	sw.spotPolicy = state.NewSpotInterruptionPolicy(conf)
+	sw.emrSilence = 10 * time.Minute
+	if conf.IsSet("emr_reconcile_silence_minutes") {
+		sw.emrSilence = time.Duration(conf.GetInt("emr_reconcile_silence_minutes")) * time.Minute
+	}
+	exitClassifiers, err := state.NewExitClassifierCache(conf)
+	if err != nil {
+		return err
 	}
+	sw.exitClassifiers = exitClassifiers
+	sw.exceptions = newExceptionExtractor(conf, sm, exitClassifiers, log)
 	sw.setupRedisClient(conf)
 	_ = sw.log.Log("message", "initialized a status worker")
 	return nil
@@ -77,6 +81,8 @@ func (sw *statusWorker) Run() error {
 		default:
 			if *sw.engine == state.EKSEngine {
 				sw.runOnceEKS()
+				sw.runReconcileEMR()
+				sw.runOnceSparkNative()
 				sw.runTimeouts()
 				time.Sleep(sw.pollInterval)
 			}
@@ -106,7 +112,7 @@ func (sw *statusWorker) processTimeouts(runs []state.Run) {
 		if run.StartedAt != nil && run.ActiveDeadlineSeconds != nil {
 			runningDuration := time.Now().Sub(*run.StartedAt)
 			if int64(runningDuration.Seconds()) > *run.ActiveDeadlineSeconds {
-				if run.Engine != nil && *run.Engine == state.EKSSparkEngine {
+				if state.IsSparkEngine(run.Engine) {
 					_ = sw.emrEngine.Terminate(run)
 				} else {
 					_ = sw.ee.Terminate(run)
@@ -115,10 +121,11 @@ func (sw *statusWorker) processTimeouts(runs []state.Run) {
 				exitCode := int64(1)
 				finishedAt := time.Now()
 				_, _ = sw.sm.UpdateRun(run.RunID, state.Run{
-					Status:     state.StatusStopped,
-					ExitReason: aws.String(fmt.Sprintf("JobRun exceeded specified timeout of %v seconds", *run.ActiveDeadlineSeconds)),
-					ExitCode:   &exitCode,
-					FinishedAt: &finishedAt,
+					Status:       state.StatusStopped,
+					ExitReason:   aws.String(fmt.Sprintf("JobRun exceeded specified timeout of %v seconds", *run.ActiveDeadlineSeconds)),
+					ExitCategory: aws.String(state.ExitCategoryTimeout),
+					ExitCode:     &exitCode,
+					FinishedAt:   &finishedAt,
 				})
 			}
 		}
@@ -142,6 +149,118 @@ func (sw *statusWorker) runOnceEKS() {
 	sw.processEKSRuns(runs)
 }
 
+//
+// runReconcileEMR polls the job runs of Spark runs that haven't heard from
+// EMR in a while; the events worker misses updates when events are dropped.
+//
+func (sw *statusWorker) runReconcileEMR() {
+	rl, err := sw.sm.ListRuns(1000, 0, "started_at", "asc", map[string][]string{
+		"queued_at_since": {
+			time.Now().AddDate(0, 0, -30).Format(time.RFC3339),
+		},
+		"status": {state.StatusRunning, state.StatusQueued, state.StatusPending},
+	}, nil, []string{state.EKSSparkEngine})
+
+	if err != nil {
+		_ = sw.log.Log("message", "unable to receive runs", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	now := time.Now()
+	for _, run := range rl.Runs {
+		if !emrRunSilent(run, now, sw.emrSilence) {
+			continue
+		}
+		if sw.acquireLock(run, "emr-reconcile", sw.emrSilence) {
+			go sw.reconcileEMRRun(run)
+		}
+	}
+}
+
+//
+// runOnceSparkNative polls the SparkApplications of native Spark runs; unlike
+// EMR job runs they don't publish events, so each run is polled every cycle.
+//
+func (sw *statusWorker) runOnceSparkNative() {
+	rl, err := sw.sm.ListRuns(1000, 0, "started_at", "asc", map[string][]string{
+		"queued_at_since": {
+			time.Now().AddDate(0, 0, -30).Format(time.RFC3339),
+		},
+		"status": {state.StatusRunning, state.StatusQueued, state.StatusPending},
+	}, nil, []string{state.EKSSparkNativeEngine})
+
+	if err != nil {
+		_ = sw.log.Log("message", "unable to receive runs", "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	for _, run := range rl.Runs {
+		if run.SparkExtension == nil || run.SparkExtension.SparkApplication == nil {
+			continue
+		}
+		if sw.acquireLock(run, "status", time.Duration(45)*time.Second) {
+			go sw.reconcileEMRRun(run)
+		}
+	}
+}
+
+//
+// emrRunSilent is true for a run submitted to EMR whose last sign of life is
+// older than silence.
+//
+func emrRunSilent(run state.Run, now time.Time, silence time.Duration) bool {
+	if run.SparkExtension == nil || run.SparkExtension.EMRJobId == nil {
+		return false
+	}
+	var last time.Time
+	for _, t := range []*time.Time{run.QueuedAt, run.StartedAt} {
+		if t != nil && t.After(last) {
+			last = *t
+		}
+	}
+	if run.PodEvents != nil {
+		for _, e := range *run.PodEvents {
+			if e.Timestamp != nil && e.Timestamp.After(last) {
+				last = *e.Timestamp
+			}
+		}
+	}
+	return now.Sub(last) > silence
+}
+
+//
+// reconcileEMRRun saves the state of the run's job run, or SparkApplication,
+// and pods
+//
+func (sw *statusWorker) reconcileEMRRun(run state.Run) {
+	reloadRun, err := sw.sm.GetRun(run.RunID)
+	if err != nil || reloadRun.Status == state.StatusStopped {
+		return
+	}
+	start := time.Now()
+	updatedRun, err := sw.emrEngine.FetchUpdateStatus(reloadRun)
+	_ = metrics.Timing(metrics.StatusWorkerFetchUpdateStatus, time.Since(start), []string{sw.workerId}, 1)
+	if err != nil {
+		_ = sw.log.Log("message", "unable to reconcile emr run", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	if updatedRun.Status == state.StatusRunning {
+		if withMetrics, err := sw.emrEngine.FetchPodMetrics(updatedRun); err == nil {
+			updatedRun = withMetrics
+		}
+	}
+	_ = metrics.Increment(metrics.StatusWorkerEMRReconcile, []string{updatedRun.Status}, 1)
+	if updatedRun.Status != reloadRun.Status {
+		sw.logStatusUpdate(updatedRun)
+		if updatedRun.Status == state.StatusStopped {
+			classifyExit(sw.sm, sw.exitClassifiers, sw.log, &updatedRun)
+		}
+	}
+	if _, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun); err != nil {
+		_ = sw.log.Log("message", "unable to save emr run", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+	} else if updatedRun.Status == state.StatusStopped {
+		sw.exceptions.stopped(updatedRun)
+	}
+}
+
 func (sw *statusWorker) processEKSRuns(runs []state.Run) {
 	var lockedRuns []state.Run
 	for _, run := range runs {
@@ -201,22 +320,29 @@ func (sw *statusWorker) processEKSRun(run state.Run) {
 	}
 	if err != nil {
 		message := fmt.Sprintf("%+v", err)
-		minutesInQueue := time.Now().Sub(*run.QueuedAt).Minutes()
+		minutesInQueue := time.Now().Sub(*run.SubmittedAt()).Minutes()
 		if strings.Contains(message, "not found") && minutesInQueue > float64(30) {
 			stoppedAt := time.Now()
 			reason := "Job either timed out or not found on the EKS cluster."
 			updatedRun.Status = state.StatusStopped
 			updatedRun.FinishedAt = &stoppedAt
 			updatedRun.ExitReason = &reason
+			classifyExit(sw.sm, sw.exitClassifiers, sw.log, &updatedRun)
 			_, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun)
 		}
 
+	} else if sw.resubmitInterruptedRun(reloadRun, &updatedRun) {
+		return
 	} else {
 		if run.Status != updatedRun.Status && (updatedRun.PodName == run.PodName) {
 			sw.logStatusUpdate(updatedRun)
 			if updatedRun.ExitCode != nil {
 				go sw.cleanupRun(run.RunID)
 			}
+			if updatedRun.Status == state.StatusStopped {
+				classifyExit(sw.sm, sw.exitClassifiers, sw.log, &updatedRun)
+				sw.exceptions.stopped(updatedRun)
+			}
 			_, err = sw.sm.UpdateRun(updatedRun.RunID, updatedRun)
 			if err != nil {
 				_ = sw.log.Log("message", "unable to save eks runs", "error", fmt.Sprintf("%+v", err))
@@ -240,6 +366,62 @@ func (sw *statusWorker) processEKSRun(run state.Run) {
 	}
 }
 
+//
+// resubmitInterruptedRun sends a spot run whose pod lost its node back to the
+// retry queue, on ondemand once it has been interrupted often enough. It is
+// true when the update was handled and must not be saved.
+//
+func (sw *statusWorker) resubmitInterruptedRun(run state.Run, updatedRun *state.Run) bool {
+	if updatedRun.PodName == nil || updatedRun.NodeLifecycle == nil || *updatedRun.NodeLifecycle != state.SpotLifecycle {
+		return false
+	}
+	podName := *updatedRun.PodName
+	// The replaced job is still reporting while the resubmitted run waits.
+	if updatedRun.PodEvents.Resubmitted(podName) {
+		return run.Status != state.StatusStopped
+	}
+	if updatedRun.Status != state.StatusStopped ||
+		(updatedRun.ExitCode != nil && *updatedRun.ExitCode == 0) ||
+		!updatedRun.PodEvents.SpotInterrupted(podName) {
+		return false
+	}
+
+	interruptions := int64(1)
+	if updatedRun.SpotInterruptions != nil {
+		interruptions = *updatedRun.SpotInterruptions + 1
+	}
+	updatedRun.SpotInterruptions = &interruptions
+	lifecycle, ok := sw.spotPolicy.Resubmit(interruptions)
+	if !ok {
+		reason := state.SpotInterruptionReason(interruptions)
+		updatedRun.ExitReason = &reason
+		return false
+	}
+
+	_ = sw.ee.Terminate(*updatedRun)
+	now := time.Now()
+	events := append(*updatedRun.PodEvents, state.PodEvent{
+		Timestamp:    &now,
+		EventType:    "Normal",
+		Reason:       state.SpotResubmittedReason,
+		SourceObject: podName,
+		Message:      fmt.Sprintf("Spot interruption %d, resubmitted on %s", interruptions, lifecycle),
+	})
+	_, err := sw.sm.UpdateRun(updatedRun.RunID, state.Run{
+		Status:            state.StatusNeedsRetry,
+		NodeLifecycle:     &lifecycle,
+		SpotInterruptions: &interruptions,
+		PodEvents:         &events,
+	})
+	if err != nil {
+		_ = sw.log.Log("message", "unable to resubmit interrupted run", "run_id", updatedRun.RunID, "error", fmt.Sprintf("%+v", err))
+		return true
+	}
+	_ = metrics.Increment(metrics.StatusWorkerSpotResubmit, []string{fmt.Sprintf("node_lifecycle:%s", lifecycle)}, 1)
+	_ = sw.log.Log("message", "resubmitting interrupted run", "run_id", updatedRun.RunID, "interruptions", interruptions, "node_lifecycle", lifecycle)
+	return true
+}
+
 func (sw *statusWorker) cleanupRun(runID string) {
 	//Logs maybe delayed before being persisted to S3.
 	time.Sleep(120 * time.Second)
@@ -250,28 +432,6 @@ func (sw *statusWorker) cleanupRun(runID string) {
 	}
 }
 
-func (sw *statusWorker) extractExceptions(runID string) {
-	//Logs maybe delayed before being persisted to S3.
-	time.Sleep(60 * time.Second)
-	run, err := sw.sm.GetRun(runID)
-	if err == nil {
-		jobUrl := fmt.Sprintf("%s/extract/%s", sw.exceptionExtractorUrl, run.RunID)
-		res, err := sw.exceptionExtractorClient.Get(jobUrl)
-		if err == nil && res != nil && res.Body != nil {
-			body, err := ioutil.ReadAll(res.Body)
-			if body != nil {
-				defer res.Body.Close()
-				runExceptions := state.RunExceptions{}
-				err = json.Unmarshal(body, &runExceptions)
-				if err == nil {
-					run.RunExceptions = &runExceptions
-				}
-			}
-			_, _ = sw.sm.UpdateRun(run.RunID, run)
-		}
-	}
-}
-
 func (sw *statusWorker) processEKSRunMetrics(run state.Run) {
 	updatedRun, err := sw.ee.FetchPodMetrics(run)
 	if err == nil {
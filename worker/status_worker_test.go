@@ -7,7 +7,9 @@ import (
 	"github.com/stitchfix/flotilla-os/state"
 	"github.com/stitchfix/flotilla-os/testutils"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func setUpStatusWorkerTest(t *testing.T) (*statusWorker, *testutils.ImplementsAllTheThings) {
@@ -121,3 +123,168 @@ func setUpStatusWorkerTest2(t *testing.T) (*statusWorker, *testutils.ImplementsA
 		conf: c,
 	}, &imp
 }
+
+func TestStatusWorker_ResubmitInterruptedRun(t *testing.T) {
+	sw, imp := setUpStatusWorkerTest(t)
+	sw.spotPolicy = state.SpotInterruptionPolicy{OndemandAfter: 1, MaxResubmits: 1}
+	queuedAt := time.Now().Add(-time.Hour)
+	run := imp.Runs["somerun"]
+	run.QueuedAt = &queuedAt
+	imp.Runs["somerun"] = run
+	podName := "somerun-abcde"
+	exitCode := int64(1)
+	updated := state.Run{
+		RunID:         "somerun",
+		Status:        state.StatusStopped,
+		ExitCode:      &exitCode,
+		PodName:       &podName,
+		NodeLifecycle: &state.SpotLifecycle,
+		PodEvents:     &state.PodEvents{{Reason: "SpotInterruption", SourceObject: podName}},
+	}
+
+	if !sw.resubmitInterruptedRun(imp.Runs["somerun"], &updated) {
+		t.Fatalf("Expected interrupted run to be resubmitted")
+	}
+	resubmitted := imp.Runs["somerun"]
+	if resubmitted.Status != state.StatusNeedsRetry ||
+		*resubmitted.NodeLifecycle != state.OndemandLifecycle ||
+		*resubmitted.SpotInterruptions != 1 {
+		t.Errorf("Expected run resubmitted on ondemand, got %s on %s", resubmitted.Status, *resubmitted.NodeLifecycle)
+	}
+	if !resubmitted.QueuedAt.Equal(queuedAt) || !resubmitted.SubmittedAt().After(queuedAt) {
+		t.Errorf("Expected the run to keep its queued_at and be submitted again since, got %v and %v", resubmitted.QueuedAt, resubmitted.SubmittedAt())
+	}
+	if len(imp.Calls) != 2 || imp.Calls[0] != "Terminate" || imp.Calls[1] != "UpdateRun" {
+		t.Errorf("Expected Terminate and UpdateRun, got %v", imp.Calls)
+	}
+
+	// The replaced job reporting again is ignored.
+	updated.PodEvents = resubmitted.PodEvents
+	updated.SpotInterruptions = resubmitted.SpotInterruptions
+	if !sw.resubmitInterruptedRun(resubmitted, &updated) || len(imp.Calls) != 2 {
+		t.Errorf("Expected the stale status of the replaced job to be skipped, got %v", imp.Calls)
+	}
+
+	// Past the limit the run stops with a spot interruption exit reason.
+	otherPod := "somerun-fghij"
+	updated.PodName = &otherPod
+	updated.NodeLifecycle = &state.SpotLifecycle
+	events := append(*updated.PodEvents, state.PodEvent{Reason: "NodeShutdown", SourceObject: otherPod})
+	updated.PodEvents = &events
+	if sw.resubmitInterruptedRun(resubmitted, &updated) {
+		t.Errorf("Expected run past the resubmit limit to stop")
+	}
+	if updated.ExitReason == nil || *updated.ExitReason != state.SpotInterruptionReason(2) {
+		t.Errorf("Expected spot interruption exit reason, got %v", updated.ExitReason)
+	}
+}
+
+func TestStatusWorker_ReconcileEMRRun(t *testing.T) {
+	sw, imp := setUpStatusWorkerTest(t)
+	sw.emrEngine = imp
+	jobID := "emr-job"
+	queuedAt := time.Now().Add(-time.Hour)
+	run := state.Run{
+		RunID:          "somerun",
+		Status:         state.StatusPending,
+		QueuedAt:       &queuedAt,
+		SparkExtension: &state.SparkExtension{EMRJobId: &jobID},
+	}
+	imp.Runs["somerun"] = run
+
+	if !emrRunSilent(run, time.Now(), 10*time.Minute) {
+		t.Errorf("Expected run queued an hour ago to be silent")
+	}
+	recent := time.Now()
+	heard := run
+	heard.PodEvents = &state.PodEvents{{Timestamp: &recent}}
+	if emrRunSilent(heard, time.Now(), 10*time.Minute) {
+		t.Errorf("Expected run with a recent pod event not to be silent")
+	}
+	if emrRunSilent(state.Run{QueuedAt: &queuedAt}, time.Now(), 10*time.Minute) {
+		t.Errorf("Expected run never submitted to EMR not to be reconciled")
+	}
+
+	sw.reconcileEMRRun(run)
+	expected := []string{"GetRun", "FetchUpdateStatus", "UpdateRun"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, imp.Calls)
+	}
+	for i, call := range expected {
+		if imp.Calls[i] != call {
+			t.Errorf("Expected call %s, got %s", call, imp.Calls[i])
+		}
+	}
+}
+
+func TestStatusWorker_ExtractExceptions(t *testing.T) {
+	sw, imp := setUpStatusWorkerTest(t)
+	sw.exceptions = &exceptionExtractor{sm: imp, logsClient: imp, log: sw.log, tailBytes: 1024, max: 10}
+	exitCode := int64(1)
+	unclassified := state.UnclassifiedExitReason
+	imp.Definitions = map[string]state.Definition{"A": {DefinitionID: "A"}}
+	imp.Runs["somerun"] = state.Run{
+		RunID:        "somerun",
+		DefinitionID: "A",
+		Status:       state.StatusStopped,
+		ExitCode:     &exitCode,
+		ExitReason:   &unclassified,
+	}
+	imp.LogText = strings.Join([]string{
+		"Traceback (most recent call last):",
+		`  File "/app/main.py", line 3, in <module>`,
+		"    import pandas",
+		"ModuleNotFoundError: No module named 'pandas'",
+	}, "\n")
+
+	sw.exceptions.extract("somerun")
+	if len(imp.LoggedExceptions["somerun"]) != 1 {
+		t.Fatalf("Expected 1 saved exception, got %v", imp.LoggedExceptions["somerun"])
+	}
+	run := imp.Runs["somerun"]
+	if run.RunExceptions == nil || (*run.RunExceptions)[0] != "ModuleNotFoundError: No module named 'pandas'" {
+		t.Errorf("Expected the exception summary as run exception, got %v", run.RunExceptions)
+	}
+	if run.ExitCategory == nil || *run.ExitCategory != state.ExitCategoryDependency {
+		t.Errorf("Expected the run to be classified again as a dependency failure, got %v", run.ExitCategory)
+	}
+	if *run.ExitReason != "Python pip package installation error" {
+		t.Errorf("Expected the unclassified exit reason to be replaced, got %s", *run.ExitReason)
+	}
+}
+
+func TestStatusWorker_ReconcileEMRRunExtractsExceptions(t *testing.T) {
+	sw, imp := setUpStatusWorkerTest(t)
+	sw.emrEngine = imp
+	sw.exceptions = &exceptionExtractor{sm: imp, logsClient: imp, log: sw.log, tailBytes: 1024, max: 10}
+	engine := state.EKSSparkEngine
+	jobID := "job-1"
+	exitCode := int64(1)
+	run := state.Run{
+		RunID:          "somerun",
+		DefinitionID:   "A",
+		Engine:         &engine,
+		Status:         state.StatusRunning,
+		SparkExtension: &state.SparkExtension{EMRJobId: &jobID},
+	}
+	stopped := run
+	stopped.Status = state.StatusStopped
+	stopped.ExitCode = &exitCode
+	imp.Definitions = map[string]state.Definition{"A": {DefinitionID: "A"}}
+	imp.Runs["somerun"] = run
+	imp.FetchedRuns = map[string]state.Run{"somerun": stopped}
+	imp.LogText = strings.Join([]string{
+		"Exception in thread \"main\" java.lang.OutOfMemoryError: Java heap space",
+		"\tat org.apache.spark.Driver.run(Driver.scala:42)",
+	}, "\n")
+
+	sw.reconcileEMRRun(run)
+	sw.exceptions.pending.Wait()
+
+	if len(imp.LoggedExceptions["somerun"]) != 1 {
+		t.Fatalf("Expected the exception of the failed EMR run to be saved, got %v", imp.LoggedExceptions["somerun"])
+	}
+	if saved := imp.Runs["somerun"]; saved.RunExceptions == nil || len(*saved.RunExceptions) != 1 {
+		t.Errorf("Expected the exception summary as run exception, got %v", saved.RunExceptions)
+	}
+}
@@ -60,6 +60,14 @@ func (sw *submitWorker) runOnce() {
 	var run state.Run
 	var err error
 
+	// Runs stay on their queues while dispatch is paused.
+	dispatch, err := sw.sm.GetDispatchState()
+	if err != nil {
+		sw.log.Log("message", "Error getting dispatch state", "error", fmt.Sprintf("%+v", err))
+	} else if dispatch.Paused {
+		return
+	}
+
 	receipts, err = sw.eksEngine.PollRuns()
 	receiptsEMR, err := sw.emrEngine.PollRuns()
 	receipts = append(receipts, receiptsEMR...)
@@ -90,6 +98,8 @@ func (sw *submitWorker) runOnce() {
 			var (
 				launched  state.Run
 				retryable bool
+				claimed   bool
+				admitted  bool
 			)
 
 			// 1. Check for existence of run.ExecutableType; set to `task_definition`
@@ -120,6 +130,10 @@ func (sw *submitWorker) runOnce() {
 					continue
 				}
 
+				if claimed, admitted = sw.admit(run, d.Concurrency, runReceipt); !admitted {
+					continue
+				}
+
 				// Execute the run using the execution engine.
 				if run.Engine == nil || *run.Engine == state.EKSEngine {
 					launched, retryable, err = sw.eksEngine.Execute(d, run, sw.sm)
@@ -140,6 +154,10 @@ func (sw *submitWorker) runOnce() {
 					continue
 				}
 
+				if claimed, admitted = sw.admit(run, tpl.Concurrency, runReceipt); !admitted {
+					continue
+				}
+
 				// Execute the run using the execution engine.
 				sw.log.Log("message", "Submitting", "run_id", run.RunID)
 				launched, retryable, err = sw.eksEngine.Execute(tpl, run, sw.sm)
@@ -158,10 +176,23 @@ func (sw *submitWorker) runOnce() {
 					launched.Status = state.StatusStopped
 				} else {
 					// Don't change status, don't ack
+					if claimed {
+						if err = sw.sm.ReleaseConcurrencySlot(run.RunID); err != nil {
+							sw.log.Log("message", "Failed to release concurrency slot", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+						}
+					}
 					continue
 				}
 			} else {
 				sw.log.Log("message", "Task submitted from SQS to the cluster", "run_id", run.RunID)
+				// Spark engines report submitted runs as QUEUED; a run holding a
+				// concurrency slot stays PENDING so it keeps counting as active.
+				if claimed && launched.Status == state.StatusQueued {
+					launched.Status = state.StatusPending
+				}
+				if claimed {
+					launched = sw.keepCancelled(launched)
+				}
 			}
 
 			//
@@ -179,6 +210,9 @@ func (sw *submitWorker) runOnce() {
 			if _, err = sw.sm.UpdateRun(run.RunID, launched); err != nil {
 				sw.log.Log("message", "Failed to update run status", "run_id", run.RunID, "status", launched.Status, "error", fmt.Sprintf("%+v", err))
 			}
+		} else if run.Status == state.StatusHeld {
+			// Held runs are enqueued again when released.
+			sw.log.Log("message", "Received held run, dropping it from the queue", "run_id", run.RunID)
 		} else {
 			sw.log.Log("message", "Received run that is not runnable", "run_id", run.RunID, "status", run.Status)
 		}
@@ -189,6 +223,116 @@ func (sw *submitWorker) runOnce() {
 	}
 }
 
+//
+// admit enforces the executable's concurrency policy before a run is
+// submitted, returning whether the run claimed a slot and whether it may be
+// submitted. At the limit, replace stops the oldest active runs to make
+// room, skip stops and acks the run, and queue leaves it on the queue to be
+// received again.
+//
+func (sw *submitWorker) admit(run state.Run, policy *state.ConcurrencyPolicy, receipt engine.RunReceipt) (bool, bool) {
+	if policy == nil {
+		return false, true
+	}
+	active, claimed, err := sw.sm.ClaimConcurrencySlot(run, policy.MaxConcurrent)
+	if err != nil {
+		sw.log.Log("message", "Error claiming concurrency slot", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+		return false, false
+	}
+	if claimed {
+		return true, true
+	}
+
+	switch policy.GetMode() {
+	case state.ConcurrencySkip:
+		sw.log.Log("message", "Skipping run at the concurrency limit", "run_id", run.RunID, "active", len(active))
+		exitReason := state.ConcurrencySkippedReason(active)
+		finishedAt := time.Now()
+		if _, err = sw.sm.UpdateRun(run.RunID, state.Run{Status: state.StatusStopped, ExitReason: &exitReason, FinishedAt: &finishedAt}); err != nil {
+			sw.log.Log("message", "Failed to update run status", "run_id", run.RunID, "status", state.StatusStopped, "error", fmt.Sprintf("%+v", err))
+		}
+		if err = receipt.Done(); err != nil {
+			sw.log.Log("message", "Acking run failed", "run_id", run.RunID, "error", fmt.Sprintf("%+v", err))
+		}
+		return false, false
+	case state.ConcurrencyReplace:
+		for _, runID := range active[:len(active)-int(policy.MaxConcurrent)+1] {
+			sw.stopReplacedRun(runID, run.RunID)
+		}
+		if _, claimed, err = sw.sm.ClaimConcurrencySlot(run, policy.MaxConcurrent); err == nil && claimed {
+			return true, true
+		}
+	}
+
+	sw.log.Log("message", "Run waiting for a concurrency slot", "run_id", run.RunID, "active", len(active))
+	return false, false
+}
+
+// stopReplacedRun terminates an active run replaced by a newer run. A run
+// another worker is still submitting is cancelled instead; that worker
+// terminates what it submitted once it sees the run stopped.
+func (sw *submitWorker) stopReplacedRun(runID string, replacedBy string) {
+	exitReason := state.ConcurrencyReplacedReason(replacedBy)
+	run, err := sw.sm.GetRun(runID)
+	if err != nil {
+		sw.log.Log("message", "Error fetching replaced run", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	if !state.IsSubmitted(run) {
+		cancelled, err := sw.sm.CancelPendingRun(runID, exitReason)
+		if err != nil {
+			sw.log.Log("message", "Error cancelling replaced run", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+			return
+		}
+		if cancelled {
+			return
+		}
+		// The run was submitted in the meantime.
+		if run, err = sw.sm.GetRun(runID); err != nil || !state.IsSubmitted(run) {
+			return
+		}
+	}
+	if err = sw.terminate(run); err != nil {
+		sw.log.Log("message", "Error terminating replaced run", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+	}
+	exitCode := int64(1)
+	finishedAt := time.Now()
+	if _, err = sw.sm.UpdateRun(runID, state.Run{
+		Status:     state.StatusStopped,
+		ExitReason: &exitReason,
+		ExitCode:   &exitCode,
+		FinishedAt: &finishedAt,
+	}); err != nil {
+		sw.log.Log("message", "Failed to update run status", "run_id", runID, "status", state.StatusStopped, "error", fmt.Sprintf("%+v", err))
+	}
+}
+
+// keepCancelled terminates a run that was cancelled while it was being
+// submitted, keeping it stopped.
+func (sw *submitWorker) keepCancelled(launched state.Run) state.Run {
+	current, err := sw.sm.GetRun(launched.RunID)
+	if err != nil || current.Status != state.StatusStopped {
+		return launched
+	}
+	sw.log.Log("message", "Run was cancelled while being submitted, terminating it", "run_id", launched.RunID)
+	if err = sw.terminate(launched); err != nil {
+		sw.log.Log("message", "Error terminating cancelled run", "run_id", launched.RunID, "error", fmt.Sprintf("%+v", err))
+	}
+	launched.Status = state.StatusStopped
+	launched.ExitReason = current.ExitReason
+	launched.ExitCode = current.ExitCode
+	launched.FinishedAt = current.FinishedAt
+	return launched
+}
+
+// terminate stops the run on its engine.
+func (sw *submitWorker) terminate(run state.Run) error {
+	if state.IsSparkEngine(run.Engine) {
+		return sw.emrEngine.Terminate(run)
+	}
+	return sw.eksEngine.Terminate(run)
+}
+
 func (sw *submitWorker) logFailedToGetExecutableMessage(run state.Run, err error) {
 	sw.log.Log(
 		"message", "Error fetching executable for run",
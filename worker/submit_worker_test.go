@@ -114,7 +114,7 @@ func TestSubmitWorker_Run(t *testing.T) {
 	worker, imp := setUpSubmitWorkerTest1(t)
 	worker.runOnce()
 
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -132,7 +132,7 @@ func TestSubmitWorker_Run2(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute is NOT called and it -is- acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -151,7 +151,7 @@ func TestSubmitWorker_Run3(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute is NOT called and it -is- acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -173,7 +173,7 @@ func TestSubmitWorker_Run4(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute is called and it -is- acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute", "UpdateRun", "RunReceipt.Done"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -201,7 +201,7 @@ func TestSubmitWorker_Run5(t *testing.T) {
 	worker.runOnce()
 
 	// Importantly, execute it called but it is not updated nor is it acked
-	expected := []string{"PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute"}
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "Execute"}
 	if len(imp.Calls) != len(expected) {
 		t.Errorf("Unexpected number of run calls, expected %v but was %v", len(expected), len(imp.Calls))
 	}
@@ -212,3 +212,189 @@ func TestSubmitWorker_Run5(t *testing.T) {
 		}
 	}
 }
+
+func TestSubmitWorker_Run6(t *testing.T) {
+	// Test that held runs are not executed and are acked
+	worker, imp := setUpSubmitWorkerTest1(t)
+	held := imp.Runs["run:cupcake"]
+	held.Status = state.StatusHeld
+	imp.Runs["run:cupcake"] = held
+
+	worker.runOnce()
+
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "RunReceipt.Done"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of run calls, expected %v but was %v", expected, imp.Calls)
+	}
+
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+}
+
+func TestSubmitWorker_Run7(t *testing.T) {
+	// Test that nothing is polled while dispatch is paused
+	worker, imp := setUpSubmitWorkerTest1(t)
+	imp.Dispatch = state.DispatchState{Paused: true}
+
+	worker.runOnce()
+
+	if len(imp.Calls) != 1 || imp.Calls[0] != "GetDispatchState" {
+		t.Errorf("Expected only GetDispatchState while paused, got %v", imp.Calls)
+	}
+}
+
+// Set up situation with a runnable run of a definition at its concurrency limit
+func setUpSubmitWorkerConcurrencyTest(t *testing.T, mode string) (*submitWorker, *testutils.ImplementsAllTheThings) {
+	worker, imp := setUpSubmitWorkerTest1(t)
+	executableID := "def:cupcake"
+	imp.Definitions["def:cupcake"] = state.Definition{
+		DefinitionID: "def:cupcake",
+		ExecutableResources: state.ExecutableResources{
+			Concurrency: &state.ConcurrencyPolicy{MaxConcurrent: 1, Mode: mode},
+		},
+	}
+	imp.Runs["run:active"] = state.Run{
+		RunID:        "run:active",
+		DefinitionID: "def:cupcake",
+		ExecutableID: &executableID,
+		Status:       state.StatusRunning,
+	}
+	return worker, imp
+}
+
+func TestSubmitWorker_ConcurrencyQueue(t *testing.T) {
+	// Test that runs at the limit are left on the queue
+	worker, imp := setUpSubmitWorkerConcurrencyTest(t, state.ConcurrencyQueue)
+
+	worker.runOnce()
+
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "ClaimConcurrencySlot"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of run calls, expected %v but was %v", expected, imp.Calls)
+	}
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+	if run := imp.Runs["run:cupcake"]; run.Status != state.StatusQueued || run.QueuedAt != nil {
+		t.Errorf("Expected run to stay queued as it was, got %s and %v", run.Status, run.QueuedAt)
+	}
+}
+
+func TestSubmitWorker_ConcurrencySkip(t *testing.T) {
+	// Test that runs at the limit are stopped and acked
+	worker, imp := setUpSubmitWorkerConcurrencyTest(t, state.ConcurrencySkip)
+
+	worker.runOnce()
+
+	expected := []string{"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "ClaimConcurrencySlot", "UpdateRun", "RunReceipt.Done"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of run calls, expected %v but was %v", expected, imp.Calls)
+	}
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+	run := imp.Runs["run:cupcake"]
+	if run.Status != state.StatusStopped || run.ExitReason == nil || *run.ExitReason != state.ConcurrencySkippedReason([]string{"run:active"}) {
+		t.Errorf("Expected run to be skipped, got %s and %v", run.Status, run.ExitReason)
+	}
+}
+
+func TestSubmitWorker_ConcurrencyReplace(t *testing.T) {
+	// Test that the active run is stopped to make room
+	worker, imp := setUpSubmitWorkerConcurrencyTest(t, state.ConcurrencyReplace)
+
+	worker.runOnce()
+
+	expected := []string{
+		"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "ClaimConcurrencySlot",
+		"GetRun", "Terminate", "UpdateRun", "ClaimConcurrencySlot", "Execute", "GetRun", "UpdateRun", "RunReceipt.Done"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of run calls, expected %v but was %v", expected, imp.Calls)
+	}
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+	if run := imp.Runs["run:active"]; run.Status != state.StatusStopped {
+		t.Errorf("Expected the active run to be replaced, got %s", run.Status)
+	}
+}
+
+func TestSubmitWorker_ConcurrencyReplaceUnsubmitted(t *testing.T) {
+	// Test that a run another worker is still submitting is cancelled, not terminated
+	worker, imp := setUpSubmitWorkerConcurrencyTest(t, state.ConcurrencyReplace)
+	active := imp.Runs["run:active"]
+	active.Status = state.StatusPending
+	imp.Runs["run:active"] = active
+
+	worker.runOnce()
+
+	expected := []string{
+		"GetDispatchState", "PollRuns", "PollRuns", "GetRun", "GetDefinition", "ClaimConcurrencySlot",
+		"GetRun", "CancelPendingRun", "ClaimConcurrencySlot", "Execute", "GetRun", "UpdateRun", "RunReceipt.Done"}
+	if len(imp.Calls) != len(expected) {
+		t.Fatalf("Unexpected number of run calls, expected %v but was %v", expected, imp.Calls)
+	}
+	for i, call := range imp.Calls {
+		if expected[i] != call {
+			t.Errorf("Expected call %v to be %s but was %s", i, expected[i], call)
+		}
+	}
+	if run := imp.Runs["run:active"]; run.Status != state.StatusStopped || run.ExitReason == nil {
+		t.Errorf("Expected the unsubmitted run to be cancelled, got %s", run.Status)
+	}
+
+	// The worker submitting the cancelled run terminates what it submitted
+	imp.Calls = nil
+	launched := worker.keepCancelled(state.Run{RunID: "run:active", Status: state.StatusRunning})
+	if launched.Status != state.StatusStopped || launched.ExitReason == nil ||
+		*launched.ExitReason != state.ConcurrencyReplacedReason("run:cupcake") {
+		t.Errorf("Expected the cancelled run to stay stopped, got %s", launched.Status)
+	}
+	if len(imp.Calls) != 2 || imp.Calls[1] != "Terminate" {
+		t.Errorf("Expected the submitted job to be terminated, got %v", imp.Calls)
+	}
+}
+
+func TestSubmitWorker_ConcurrencyEMR(t *testing.T) {
+	// Test that a Spark run submitted QUEUED keeps its slot
+	worker, imp := setUpSubmitWorkerConcurrencyTest(t, state.ConcurrencyQueue)
+	engine := state.EKSSparkEngine
+	executableID := "def:cupcake"
+	run := imp.Runs["run:cupcake"]
+	run.Engine = &engine
+	imp.Runs["run:cupcake"] = run
+	delete(imp.Runs, "run:active")
+	imp.ExecuteStatus = state.StatusQueued
+
+	worker.runOnce()
+
+	if run := imp.Runs["run:cupcake"]; run.Status != state.StatusPending {
+		t.Fatalf("Expected the submitted run to stay pending, got %s", run.Status)
+	}
+	next := state.Run{RunID: "run:next", DefinitionID: "def:cupcake", ExecutableID: &executableID, Status: state.StatusQueued}
+	if active, claimed, _ := imp.ClaimConcurrencySlot(next, 1); claimed || len(active) != 1 {
+		t.Errorf("Expected the submitted run to hold the only slot, got %v", active)
+	}
+
+	// A run already QUEUED on its engine counts as active too
+	jobID := "job-1"
+	imp.Runs["run:cupcake"] = state.Run{
+		RunID:          "run:cupcake",
+		ExecutableID:   &executableID,
+		Engine:         &engine,
+		Status:         state.StatusQueued,
+		SparkExtension: &state.SparkExtension{EMRJobId: &jobID},
+	}
+	if active, claimed, _ := imp.ClaimConcurrencySlot(next, 1); claimed || len(active) != 1 {
+		t.Errorf("Expected the queued EMR run to hold the only slot, got %v", active)
+	}
+}
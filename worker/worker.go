@@ -3,9 +3,12 @@ package worker
 import (
 	"fmt"
 	"github.com/stitchfix/flotilla-os/queue"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/stitchfix/flotilla-os/clients/logs"
+	"github.com/stitchfix/flotilla-os/clients/metrics"
 	"github.com/stitchfix/flotilla-os/config"
 	"github.com/stitchfix/flotilla-os/execution/engine"
 	flotillaLog "github.com/stitchfix/flotilla-os/log"
@@ -63,3 +66,131 @@ func GetPollInterval(workerType string, conf config.Config) (time.Duration, erro
 	}
 	return time.ParseDuration(pollIntervalString)
 }
+
+//
+// classifyExit sets the exit category of a failed run from the stored exit
+// rules, the configured ones and the defaults.
+//
+func classifyExit(sm state.Manager, classifiers *state.ExitClassifierCache, log flotillaLog.Logger, run *state.Run) {
+	classifier, err := classifiers.Get(sm)
+	if err != nil {
+		_ = log.Log("message", "unable to load stored exit rules", "error", fmt.Sprintf("%+v", err))
+	}
+	classifier.Apply(run)
+}
+
+//
+// exceptionExtractor finds the exceptions in the logs of failed runs once
+// they stop; a nil extractor, or one without a logs client, does nothing.
+//
+type exceptionExtractor struct {
+	sm          state.Manager
+	logsClient  logs.Client
+	classifiers *state.ExitClassifierCache
+	log         flotillaLog.Logger
+	delay       time.Duration
+	tailBytes   int
+	max         int
+	pending     sync.WaitGroup
+}
+
+//
+// newExceptionExtractor reads the `exception_analyzer_*` settings; without a
+// logs client, exceptions aren't extracted.
+//
+func newExceptionExtractor(conf config.Config, sm state.Manager, classifiers *state.ExitClassifierCache, log flotillaLog.Logger) *exceptionExtractor {
+	ex := &exceptionExtractor{sm: sm, classifiers: classifiers, log: log}
+	ex.delay = 60 * time.Second
+	if conf.IsSet("exception_analyzer_delay_seconds") {
+		ex.delay = time.Duration(conf.GetInt("exception_analyzer_delay_seconds")) * time.Second
+	}
+	ex.tailBytes = 512 * 1024
+	if conf.IsSet("exception_analyzer_tail_kb") {
+		ex.tailBytes = conf.GetInt("exception_analyzer_tail_kb") * 1024
+	}
+	ex.max = 10
+	if conf.IsSet("exception_analyzer_max_exceptions") {
+		ex.max = conf.GetInt("exception_analyzer_max_exceptions")
+	}
+	if logsClient, err := logs.NewLogsClient(conf, log, state.EKSEngine); err == nil {
+		ex.logsClient = logsClient
+	} else {
+		_ = log.Log("message", "unable to initialize logs client, exceptions won't be extracted", "error", fmt.Sprintf("%+v", err))
+	}
+	return ex
+}
+
+// stopped extracts the exceptions of a run that stopped with a failure in
+// the background.
+func (ex *exceptionExtractor) stopped(run state.Run) {
+	if ex == nil || ex.logsClient == nil || !state.RunFailed(run) {
+		return
+	}
+	ex.pending.Add(1)
+	go func() {
+		defer ex.pending.Done()
+		ex.extract(run.RunID)
+	}()
+}
+
+//
+// extract finds the exceptions in the tail of a failed run's logs, stores
+// them and classifies the run again with their summaries as its run
+// exceptions
+//
+func (ex *exceptionExtractor) extract(runID string) {
+	//Logs maybe delayed before being persisted to S3.
+	time.Sleep(ex.delay)
+	run, err := ex.sm.GetRun(runID)
+	if err != nil {
+		return
+	}
+	if run.ExecutableType == nil {
+		defaultExecutableType := state.ExecutableTypeDefinition
+		run.ExecutableType = &defaultExecutableType
+	}
+	if run.ExecutableID == nil {
+		run.ExecutableID = &run.DefinitionID
+	}
+	executable, err := ex.sm.GetExecutableByTypeAndID(*run.ExecutableType, *run.ExecutableID)
+	if err != nil {
+		_ = ex.log.Log("message", "unable to get executable of run", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	text, err := logs.Tail(ex.logsClient, executable, run, ex.tailBytes)
+	if err != nil {
+		_ = ex.log.Log("message", "unable to read logs of run", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+		return
+	}
+	found := state.ExtractExceptions(text, ex.max)
+	if len(found) == 0 {
+		return
+	}
+	seenAt := time.Now()
+	if run.FinishedAt != nil {
+		seenAt = *run.FinishedAt
+	}
+	runExceptions := state.RunExceptions{}
+	for i := range found {
+		found[i].CreatedAt = &seenAt
+		runExceptions = append(runExceptions, found[i].Summary())
+	}
+	if err = ex.sm.SaveLoggedExceptions(runID, found); err != nil {
+		_ = ex.log.Log("message", "unable to save exceptions of run", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+	}
+	_ = metrics.Increment(metrics.StatusWorkerExtractedExceptions, []string{fmt.Sprintf("kind:%s", found[len(found)-1].Kind)}, 1)
+
+	run.RunExceptions = &runExceptions
+	if run.ExitReason != nil && *run.ExitReason == state.UnclassifiedExitReason {
+		run.ExitReason = nil
+	}
+	classifyExit(ex.sm, ex.classifiers, ex.log, &run)
+	_, err = ex.sm.UpdateRun(runID, state.Run{
+		RunExceptions: run.RunExceptions,
+		ExitReason:    run.ExitReason,
+		ExitCategory:  run.ExitCategory,
+	})
+	if err != nil {
+		_ = ex.log.Log("message", "unable to save run exceptions", "run_id", runID, "error", fmt.Sprintf("%+v", err))
+	}
+}